@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/certs"
+	"github.com/boyd/pocket_agent/server/internal/config"
+)
+
+// runCommand executes an administrative subcommand and returns the process exit code
+func runCommand(cfg *config.Config, args []string) int {
+	switch args[0] {
+	case "token":
+		return runTokenCommand(cfg, args[1:])
+	case "pair":
+		return runPairCommand(cfg, args[1:])
+	case "devices":
+		return runDevicesCommand(cfg, args[1:])
+	case "fingerprint":
+		return runFingerprintCommand(cfg, args[1:])
+	case "rotate-cert":
+		return runRotateCertCommand(cfg, args[1:])
+	case "audit":
+		return runAuditCommand(cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		return 2
+	}
+}
+
+// runTokenCommand manages access tokens: issue, revoke and list
+func runTokenCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: pocket-agent-server token <issue|revoke|list> [options]")
+		return 2
+	}
+
+	store, err := auth.NewTokenStore(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open token store: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "issue":
+		fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+		name := fs.String("name", "", "Human readable name for the token (e.g. device name)")
+		ttl := fs.Duration("ttl", 0, "Token lifetime (0 for no expiry)")
+		admin := fs.Bool("admin", false, "Grant access to all projects, bypassing project ACLs")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		issue := store.Issue
+		if *admin {
+			issue = store.IssueAdmin
+		}
+		secret, token, err := issue(*name, *ttl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to issue token: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("Token ID: %s\n", token.ID)
+		fmt.Printf("Name:     %s\n", token.Name)
+		if token.Admin {
+			fmt.Println("Admin:    yes")
+		}
+		if !token.ExpiresAt.IsZero() {
+			fmt.Printf("Expires:  %s\n", token.ExpiresAt.Format(time.RFC3339))
+		}
+		fmt.Printf("Token:    %s\n", secret)
+		fmt.Println()
+		fmt.Println("Store this token now; it cannot be shown again.")
+		return 0
+
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: pocket-agent-server token revoke <id|name>")
+			return 2
+		}
+		if err := store.Revoke(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revoke token: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Token %s revoked\n", args[1])
+		return 0
+
+	case "list":
+		tokens, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list tokens: %v\n", err)
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tADMIN\tCREATED\tEXPIRES\tLAST USED\tSTATUS")
+		now := time.Now()
+		for _, t := range tokens {
+			status := "active"
+			switch {
+			case !t.RevokedAt.IsZero():
+				status = "revoked"
+			case !t.IsActive(now):
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\t%s\t%s\n",
+				t.ID, t.Name, t.Admin, formatTime(t.CreatedAt), formatTime(t.ExpiresAt), formatTime(t.LastUsedAt), status)
+		}
+		w.Flush()
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token command: %s\n", args[0])
+		return 2
+	}
+}
+
+// runPairCommand creates a one-time pairing code and prints the QR payload
+func runPairCommand(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("pair", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", auth.DefaultPairingTTL, "How long the pairing code stays valid")
+	host := fs.String("host", "", "Host name or address clients should connect to (defaults to a local address)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	store, err := auth.NewPairingStore(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open pairing store: %v\n", err)
+		return 1
+	}
+
+	code, pc, err := store.Create(*ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create pairing code: %v\n", err)
+		return 1
+	}
+
+	payload := auth.PairingPayload{
+		Version: 1,
+		Host:    advertisedHost(cfg, *host),
+		Port:    cfg.Port,
+		TLS:     cfg.TLSEnabled,
+		Code:    code,
+	}
+	if cfg.TLSEnabled {
+		// Generate the certificate now so the fingerprint can be pinned
+		// before the server first starts
+		if _, err := certs.Ensure(cfg.TLSCertFile, cfg.TLSKeyFile, certs.DefaultHosts(cfg.Host)); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not generate TLS certificate: %v\n", err)
+		}
+		fingerprint, err := certs.Fingerprint(cfg.TLSCertFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not compute TLS fingerprint: %v\n", err)
+		}
+		payload.Fingerprint = fingerprint
+		if rotation, err := certs.PendingRotation(cfg.TLSCertFile); err == nil && rotation != nil && rotation.Previous == fingerprint {
+			payload.NextFingerprint = rotation.Fingerprint
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode pairing payload: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Pairing code: %s\n", code)
+	fmt.Printf("Expires:      %s\n", pc.ExpiresAt.Local().Format(time.RFC3339))
+	if payload.Fingerprint != "" {
+		fmt.Printf("Fingerprint:  %s\n", payload.Fingerprint)
+	}
+	if payload.NextFingerprint != "" {
+		fmt.Printf("Next:         %s\n", payload.NextFingerprint)
+	}
+	fmt.Println()
+	fmt.Println("QR payload:")
+	fmt.Println(string(data))
+	return 0
+}
+
+// runDevicesCommand manages paired devices: list, rename and revoke
+func runDevicesCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	store, err := auth.NewDeviceStore(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open device store: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		devices, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list devices: %v\n", err)
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPAIRED\tLAST SEEN\tSTATUS")
+		for _, d := range devices {
+			status := "active"
+			if !d.IsActive() {
+				status = "revoked"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				d.ID, d.Name, formatTime(d.PairedAt), formatTime(d.LastSeenAt), status)
+		}
+		w.Flush()
+		return 0
+
+	case "rename":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: pocket-agent-server devices rename <id> <name>")
+			return 2
+		}
+		device, err := store.Rename(args[1], args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rename device: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Device %s renamed to %q\n", device.ID, device.Name)
+		return 0
+
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: pocket-agent-server devices revoke <id>")
+			return 2
+		}
+		device, err := store.Revoke(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revoke device: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Device %s (%s) revoked\n", device.ID, device.Name)
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown devices command: %s\n", args[0])
+		return 2
+	}
+}
+
+// runFingerprintCommand prints the TLS certificate fingerprint clients pin,
+// generating the certificate if it does not exist yet
+func runFingerprintCommand(cfg *config.Config, args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: pocket-agent-server fingerprint")
+		return 2
+	}
+
+	if !cfg.TLSEnabled {
+		fmt.Fprintln(os.Stderr, "TLS is disabled; enable tls_enabled to use a certificate")
+		return 1
+	}
+
+	if _, err := certs.Ensure(cfg.TLSCertFile, cfg.TLSKeyFile, certs.DefaultHosts(cfg.Host)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate TLS certificate: %v\n", err)
+		return 1
+	}
+
+	fingerprint, err := certs.Fingerprint(cfg.TLSCertFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to compute TLS fingerprint: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Fingerprint: %s\n", fingerprint)
+	if rotation, err := certs.PendingRotation(cfg.TLSCertFile); err == nil && rotation != nil && rotation.Previous == fingerprint {
+		fmt.Printf("Next:        %s\n", rotation.Fingerprint)
+		fmt.Printf("Rotates at:  %s\n", rotation.ActivateAt.Local().Format(time.RFC3339))
+	}
+	return 0
+}
+
+// runRotateCertCommand replaces the self-signed TLS certificate. The old
+// certificate stays in service for the grace period while clients pin the
+// new fingerprint.
+func runRotateCertCommand(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("rotate-cert", flag.ContinueOnError)
+	grace := fs.Duration("grace", certs.DefaultRotationGrace, "How long the old certificate stays in service (0 to switch immediately)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !cfg.TLSEnabled {
+		fmt.Fprintln(os.Stderr, "TLS is disabled; enable tls_enabled to use a certificate")
+		return 1
+	}
+
+	rotation, err := certs.Rotate(cfg.TLSCertFile, cfg.TLSKeyFile, certs.DefaultHosts(cfg.Host), *grace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to rotate TLS certificate: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Previous:    %s\n", rotation.Previous)
+	fmt.Printf("Fingerprint: %s\n", rotation.Fingerprint)
+	if *grace > 0 {
+		fmt.Printf("Activates:   %s\n", rotation.ActivateAt.Local().Format(time.RFC3339))
+		fmt.Println()
+		fmt.Println("The previous certificate stays in service until then; clients")
+		fmt.Println("should pin the new fingerprint before it activates.")
+	} else {
+		fmt.Println()
+		fmt.Println("The new certificate is active; clients pinning the previous")
+		fmt.Println("fingerprint must re-pair.")
+	}
+	return 0
+}
+
+// runAuditCommand inspects the audit log: verify
+func runAuditCommand(cfg *config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: pocket-agent-server audit verify")
+		return 2
+	}
+
+	result, err := audit.Verify(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log verification FAILED after %d valid entries: %v\n", result.Entries, err)
+		return 1
+	}
+
+	fmt.Printf("Audit log OK: %d entries\n", result.Entries)
+	fmt.Printf("Head hash:    %s\n", result.LastHash)
+	fmt.Println()
+	fmt.Println("Record the head hash elsewhere to detect the log being rewritten later.")
+	return 0
+}
+
+// advertisedHost picks the host clients should use to reach the server
+func advertisedHost(cfg *config.Config, override string) string {
+	if override != "" {
+		return override
+	}
+
+	if ip := net.ParseIP(cfg.Host); cfg.Host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return cfg.Host
+	}
+
+	// Listening on all interfaces: prefer the first private IPv4 address
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+				continue
+			}
+			if ipNet.IP.IsPrivate() {
+				return ipNet.IP.String()
+			}
+		}
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "localhost"
+}
+
+// formatTime formats a timestamp for CLI output, rendering zero values as "-"
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
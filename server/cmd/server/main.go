@@ -9,6 +9,7 @@ import (
 	"github.com/boyd/pocket_agent/server/internal"
 	"github.com/boyd/pocket_agent/server/internal/config"
 	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/platform"
 )
 
 var (
@@ -19,6 +20,11 @@ var (
 )
 
 func main() {
+	// Sandboxed executions re-execute the server to set up their sandbox
+	if len(os.Args) > 1 && os.Args[1] == platform.SandboxInitCommand {
+		os.Exit(platform.RunSandboxInit(os.Args[2:]))
+	}
+
 	// Command line flags
 	var (
 		rootDir     = flag.String("root-dir", "", "Root directory for all server files (defaults to ~/.pocket_agent)")
@@ -41,11 +47,6 @@ func main() {
 
 	// Initialize logger
 	log := logger.New(*logLevel)
-	log.Info("Starting Pocket Agent Server",
-		"version", Version,
-		"build_time", BuildTime,
-		"git_commit", GitCommit,
-	)
 
 	// Log root directory if specified
 	if *rootDir != "" {
@@ -75,6 +76,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Run administrative subcommand if one was given (e.g. "token issue")
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(cfg, flag.Args()))
+	}
+
+	log.Info("Starting Pocket Agent Server",
+		"version", Version,
+		"build_time", BuildTime,
+		"git_commit", GitCommit,
+	)
+
 	// Create server configuration
 	serverConfig := internal.ServerConfig{
 		Config:                cfg,
@@ -0,0 +1,455 @@
+// Package audit records security-relevant actions in an append-only,
+// hash-chained log. Every entry carries the hash of its predecessor, so
+// editing, reordering or deleting entries breaks the chain and is detected
+// by Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+const (
+	// DirName is the name of the audit directory under the data directory
+	DirName = "audit"
+	// FileName is the name of the audit log file
+	FileName = "audit.log"
+	// HeadFileName records the sequence number and hash of the last entry,
+	// so truncating the log is detected as well
+	HeadFileName = "head.json"
+
+	// DefaultQueryLimit is the number of entries Query returns by default
+	DefaultQueryLimit = 100
+	// MaxQueryLimit is the largest number of entries Query returns
+	MaxQueryLimit = 1000
+
+	// maxLineSize bounds a single entry (prompts can be large)
+	maxLineSize = 16 * 1024 * 1024
+)
+
+// genesisHash is the previous hash of the first entry
+var genesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// errStopScan ends a scan early without reporting an error
+var errStopScan = fmt.Errorf("stop scan")
+
+// Entry is a single record in the audit log
+type Entry struct {
+	Seq        uint64          `json:"seq"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Action     string          `json:"action"`
+	Principal  string          `json:"principal"`
+	SessionID  string          `json:"session_id,omitempty"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+	ProjectID  string          `json:"project_id,omitempty"`
+	Outcome    Outcome         `json:"outcome"`
+	Error      string          `json:"error,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// computeHash returns the hash of the entry with its Hash field cleared
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// head is the persisted position of the end of the chain
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Log is an append-only, hash-chained audit log
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	headPath string
+	file     *os.File
+	seq      uint64
+	lastHash string
+}
+
+// NewLog opens the audit log under dataDir, creating it if needed, and
+// resumes the chain from its last entry
+func NewLog(dataDir string) (*Log, error) {
+	dir := filepath.Join(dataDir, DirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.NewFileOperationError("create audit directory", err)
+	}
+
+	l := &Log{
+		path:     filepath.Join(dir, FileName),
+		headPath: filepath.Join(dir, HeadFileName),
+		lastHash: genesisHash,
+	}
+
+	// Resume from the last entry
+	err := scan(l.path, func(entry Entry) error {
+		l.seq = entry.Seq
+		l.lastHash = entry.Hash
+		return nil
+	})
+	if torn, ok := err.(*tornEntryError); ok {
+		err = dropTornEntry(dir, torn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.NewFileOperationError("open audit log", err)
+	}
+	l.file = file
+
+	return l, nil
+}
+
+// dropTornEntry truncates the log under dir after its last complete entry,
+// removing the incomplete one a crash in the middle of an append left
+// behind. The complete entries must still verify and end at the recorded
+// head.
+func dropTornEntry(dir string, torn *tornEntryError) error {
+	path := filepath.Join(dir, FileName)
+	result, err := verifyChain(path)
+	if _, ok := err.(*tornEntryError); !ok {
+		if err == nil {
+			err = fmt.Errorf("audit log changed while reading it")
+		}
+		return err
+	}
+	if err := checkHead(filepath.Join(dir, HeadFileName), result); err != nil {
+		return err
+	}
+
+	if err := os.Truncate(path, torn.offset); err != nil {
+		return errors.NewFileOperationError("truncate audit log", err)
+	}
+	logger.New("info").Warn("Dropped incomplete audit log entry left by a crash",
+		"path", path,
+		"line", torn.line,
+		"last_seq", result.Entries)
+	return nil
+}
+
+// Path returns the location of the audit log file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Close closes the audit log
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Record appends an entry for the event and returns it. A nil log records
+// nothing.
+func (l *Log) Record(event *Event) (*Entry, error) {
+	if l == nil || event == nil {
+		return nil, nil
+	}
+
+	var details json.RawMessage
+	if len(event.Details) > 0 {
+		data, err := json.Marshal(event.Details)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal audit details: %w", err)
+		}
+		details = data
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil, errors.New(errors.CodeInternalError, "audit log is closed")
+	}
+
+	entry := Entry{
+		Seq:        l.seq + 1,
+		Timestamp:  time.Now().UTC(),
+		Action:     event.Action,
+		Principal:  event.Principal,
+		SessionID:  event.SessionID,
+		RemoteAddr: event.RemoteAddr,
+		ProjectID:  event.ProjectID,
+		Outcome:    event.Outcome,
+		Error:      event.Error,
+		Details:    details,
+		PrevHash:   l.lastHash,
+	}
+
+	hash, err := entry.computeHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash audit entry: %w", err)
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return nil, errors.NewFileOperationError("append audit log", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return nil, errors.NewFileOperationError("sync audit log", err)
+	}
+
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+
+	headData, err := json.Marshal(head{Seq: entry.Seq, Hash: entry.Hash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit head: %w", err)
+	}
+	if err := storage.WriteFileAtomic(l.headPath, headData, 0o600); err != nil {
+		return nil, errors.NewFileOperationError("write audit head", err)
+	}
+
+	return &entry, nil
+}
+
+// Filter selects entries returned by Query
+type Filter struct {
+	ProjectID string
+	Principal string
+	Action    string
+	Since     time.Time
+	Until     time.Time
+	// AfterSeq skips entries up to and including this sequence number
+	AfterSeq uint64
+	// Limit caps the number of entries; 0 uses DefaultQueryLimit
+	Limit int
+}
+
+// matches reports whether the entry passes the filter
+func (f Filter) matches(entry Entry) bool {
+	switch {
+	case entry.Seq <= f.AfterSeq:
+		return false
+	case f.ProjectID != "" && entry.ProjectID != f.ProjectID:
+		return false
+	case f.Principal != "" && entry.Principal != f.Principal:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case !f.Since.IsZero() && entry.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && entry.Timestamp.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Query returns matching entries in log order, and whether more entries
+// match beyond the limit
+func (l *Log) Query(filter Filter) ([]Entry, bool, error) {
+	if filter.Limit <= 0 || filter.Limit > MaxQueryLimit {
+		filter.Limit = DefaultQueryLimit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0)
+	hasMore := false
+
+	err := scan(l.path, func(entry Entry) error {
+		if !filter.matches(entry) {
+			return nil
+		}
+		if len(entries) == filter.Limit {
+			hasMore = true
+			return errStopScan
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil && err != errStopScan {
+		return nil, false, errors.Wrap(err, errors.CodeInternalError, "failed to read audit log")
+	}
+
+	return entries, hasMore, nil
+}
+
+// VerifyResult summarizes a verified audit log
+type VerifyResult struct {
+	Entries  uint64
+	LastHash string
+}
+
+// Verify checks the hash chain of the audit log under dataDir and that it
+// ends at the recorded head. The returned error names the first entry that
+// does not verify.
+func Verify(dataDir string) (*VerifyResult, error) {
+	dir := filepath.Join(dataDir, DirName)
+	result, err := verifyChain(filepath.Join(dir, FileName))
+	if err != nil {
+		return result, err
+	}
+	return result, checkHead(filepath.Join(dir, HeadFileName), result)
+}
+
+// verifyChain checks the hash chain of the log file at path
+func verifyChain(path string) (*VerifyResult, error) {
+	result := &VerifyResult{LastHash: genesisHash}
+
+	err := scan(path, func(entry Entry) error {
+		if entry.Seq != result.Entries+1 {
+			return fmt.Errorf("entry %d: expected sequence number %d", entry.Seq, result.Entries+1)
+		}
+		if entry.PrevHash != result.LastHash {
+			return fmt.Errorf("entry %d: previous hash does not match entry %d", entry.Seq, result.Entries)
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", entry.Seq, err)
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("entry %d: content does not match its hash", entry.Seq)
+		}
+
+		result.Entries = entry.Seq
+		result.LastHash = entry.Hash
+		return nil
+	})
+	return result, err
+}
+
+// checkHead checks that the chain in result ends at the head recorded at
+// path
+func checkHead(path string, result *VerifyResult) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if result.Entries > 0 {
+			return fmt.Errorf("audit head is missing")
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit head: %w", err)
+	}
+
+	var h head
+	if err := json.Unmarshal(data, &h); err != nil {
+		return fmt.Errorf("failed to parse audit head: %w", err)
+	}
+	if h.Seq != result.Entries || h.Hash != result.LastHash {
+		return fmt.Errorf("log ends at entry %d but head records entry %d; entries were removed", result.Entries, h.Seq)
+	}
+	return nil
+}
+
+// tornEntryError reports a last line without a newline, as a crash in the
+// middle of an append leaves behind
+type tornEntryError struct {
+	// line is the number of the incomplete line
+	line int
+	// offset is where the complete entries before it end
+	offset int64
+}
+
+func (e *tornEntryError) Error() string {
+	return fmt.Sprintf("line %d: incomplete entry", e.line)
+}
+
+// scan calls fn for each entry in the log file. A missing file has no
+// entries. A trailing line without a newline ends the scan with a
+// *tornEntryError once fn saw the entries before it.
+func scan(path string, fn func(Entry) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := readLine(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err == errIncompleteEntry {
+			return &tornEntryError{line: line, offset: offset}
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		offset += int64(len(data)) + 1
+
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// errIncompleteEntry reports a trailing line without a newline
+var errIncompleteEntry = fmt.Errorf("incomplete entry")
+
+// readLine reads one newline terminated line. A trailing line without a
+// newline is reported as errIncompleteEntry.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLineSize {
+			return nil, fmt.Errorf("entry exceeds %d bytes", maxLineSize)
+		}
+
+		switch err {
+		case nil:
+			return line[:len(line)-1], nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+			return nil, errIncompleteEntry
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordEvents appends n events alternating between two projects
+func recordEvents(t *testing.T, l *Log, n int) {
+	for i := 0; i < n; i++ {
+		event := &Event{
+			Action:    "execute",
+			Principal: "device:dev-1",
+			SessionID: "session-1",
+			Outcome:   OutcomeSuccess,
+		}
+		event.SetProject([]string{"project-a", "project-b"}[i%2])
+		event.Set("prompt", "run <tests> & report")
+		event.Set("index", i)
+		_, err := l.Record(event)
+		require.NoError(t, err)
+	}
+}
+
+// auditLines returns the lines of the audit log file
+func auditLines(t *testing.T, dataDir string) [][]byte {
+	data, err := os.ReadFile(filepath.Join(dataDir, DirName, FileName))
+	require.NoError(t, err)
+	return bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+// writeLines replaces the audit log file with lines
+func writeLines(t *testing.T, dataDir string, lines [][]byte) {
+	data := append(bytes.Join(lines, []byte("\n")), '\n')
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, DirName, FileName), data, 0o600))
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	dataDir := t.TempDir()
+	l, err := NewLog(dataDir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	recordEvents(t, l, 3)
+
+	entries, _, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, genesisHash, entries[0].PrevHash)
+	for i, entry := range entries {
+		assert.Equal(t, uint64(i+1), entry.Seq)
+		assert.Len(t, entry.Hash, 64)
+		if i > 0 {
+			assert.Equal(t, entries[i-1].Hash, entry.PrevHash)
+		}
+	}
+	assert.JSONEq(t, `{"prompt":"run <tests> & report","index":0}`, string(entries[0].Details))
+
+	// The log is private to the owner
+	info, err := os.Stat(l.Path())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	result, err := Verify(dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), result.Entries)
+	assert.Equal(t, entries[2].Hash, result.LastHash)
+}
+
+func TestNewLogResumesChain(t *testing.T) {
+	dataDir := t.TempDir()
+	l, err := NewLog(dataDir)
+	require.NoError(t, err)
+	recordEvents(t, l, 2)
+	require.NoError(t, l.Close())
+
+	l, err = NewLog(dataDir)
+	require.NoError(t, err)
+	defer l.Close()
+	recordEvents(t, l, 1)
+
+	entries, _, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, uint64(3), entries[2].Seq)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+
+	_, err = Verify(dataDir)
+	assert.NoError(t, err)
+}
+
+func TestNewLogDropsTornEntry(t *testing.T) {
+	dataDir := t.TempDir()
+	l, err := NewLog(dataDir)
+	require.NoError(t, err)
+	recordEvents(t, l, 2)
+	require.NoError(t, l.Close())
+
+	// A crash in the middle of an append leaves a line without a newline
+	complete, err := os.ReadFile(l.Path())
+	require.NoError(t, err)
+	torn := append(append([]byte{}, complete...), []byte(`{"seq":3,"action":"exe`)...)
+	require.NoError(t, os.WriteFile(l.Path(), torn, 0o600))
+
+	l, err = NewLog(dataDir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	data, err := os.ReadFile(l.Path())
+	require.NoError(t, err)
+	assert.Equal(t, complete, data)
+
+	// The chain continues from the last complete entry
+	recordEvents(t, l, 1)
+	entries, _, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, uint64(3), entries[2].Seq)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+
+	result, err := Verify(dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), result.Entries)
+}
+
+func TestNewLogRejectsTornEntryOnBrokenChain(t *testing.T) {
+	dataDir := t.TempDir()
+	l, err := NewLog(dataDir)
+	require.NoError(t, err)
+	recordEvents(t, l, 3)
+	require.NoError(t, l.Close())
+
+	// A torn entry after a removed one is not recovered
+	lines := auditLines(t, dataDir)
+	writeLines(t, dataDir, lines[:2])
+	f, err := os.OpenFile(l.Path(), os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`{"seq":4`))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = NewLog(dataDir)
+	assert.ErrorContains(t, err, "entries were removed")
+
+	// The log is left as found
+	data, err := os.ReadFile(l.Path())
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(data, []byte(`{"seq":4`)))
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(lines [][]byte) [][]byte
+		wantErr string
+	}{
+		{
+			name: "edited entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("device:dev-1"), []byte("device:dev-2"), 1)
+				return lines
+			},
+			wantErr: "entry 2: content does not match its hash",
+		},
+		{
+			name: "removed entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			wantErr: "entry 3: expected sequence number 2",
+		},
+		{
+			name: "reordered entries",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			wantErr: "entry 3: expected sequence number 2",
+		},
+		{
+			name: "truncated log",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:3]
+			},
+			wantErr: "entries were removed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			l, err := NewLog(dataDir)
+			require.NoError(t, err)
+			recordEvents(t, l, 4)
+			require.NoError(t, l.Close())
+
+			writeLines(t, dataDir, tt.tamper(auditLines(t, dataDir)))
+
+			_, err = Verify(dataDir)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestVerifyEmptyLog(t *testing.T) {
+	result, err := Verify(t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), result.Entries)
+}
+
+func TestQueryFilters(t *testing.T) {
+	l, err := NewLog(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	recordEvents(t, l, 5)
+	_, err = l.Record(&Event{Action: "project_delete", Principal: "token:tok-1", ProjectID: "project-a", Outcome: OutcomeDenied})
+	require.NoError(t, err)
+
+	entries, hasMore, err := l.Query(Filter{ProjectID: "project-a"})
+	require.NoError(t, err)
+	assert.False(t, hasMore)
+	assert.Len(t, entries, 4)
+
+	entries, _, err = l.Query(Filter{Principal: "token:tok-1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, OutcomeDenied, entries[0].Outcome)
+
+	entries, _, err = l.Query(Filter{Action: "execute", Until: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+
+	entries, _, err = l.Query(Filter{Since: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Paging with a cursor
+	entries, hasMore, err = l.Query(Filter{Limit: 2})
+	require.NoError(t, err)
+	assert.True(t, hasMore)
+	require.Len(t, entries, 2)
+
+	entries, hasMore, err = l.Query(Filter{Limit: 2, AfterSeq: entries[1].Seq})
+	require.NoError(t, err)
+	assert.True(t, hasMore)
+	require.Len(t, entries, 2)
+	assert.Equal(t, uint64(3), entries[0].Seq)
+}
+
+func TestNilLogAndEvent(t *testing.T) {
+	var l *Log
+	entry, err := l.Record(&Event{Action: "execute"})
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+	assert.NoError(t, l.Close())
+
+	// Handlers may annotate without an event in their context
+	event := EventFromContext(context.Background())
+	assert.Nil(t, event)
+	event.SetProject("project-a")
+	event.Set("key", "value")
+	event.SetDigest("prompt", "secret")
+	event.SetResult(nil)
+}
+
+func TestSetDigest(t *testing.T) {
+	event := &Event{}
+	event.SetDigest("prompt", "hello")
+
+	assert.Equal(t, map[string]interface{}{
+		"prompt_sha256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"prompt_length": 5,
+	}, event.Details)
+}
+
+func TestOutcomeFor(t *testing.T) {
+	assert.Equal(t, OutcomeSuccess, OutcomeFor(nil))
+	assert.Equal(t, OutcomeDenied, OutcomeFor(errors.New(errors.CodePermissionDenied, "denied")))
+	assert.Equal(t, OutcomeDenied, OutcomeFor(errors.New(errors.CodePolicyViolation, "violation")))
+	assert.Equal(t, OutcomeFailure, OutcomeFor(errors.New(errors.CodeValidationFailed, "invalid")))
+	assert.Equal(t, OutcomeFailure, OutcomeFor(os.ErrNotExist))
+
+	event := &Event{}
+	event.SetResult(errors.New(errors.CodeUnauthorized, "revoked"))
+	assert.Equal(t, OutcomeDenied, event.Outcome)
+	assert.Contains(t, event.Error, "revoked")
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+)
+
+// Outcome is the result of an audited action
+type Outcome string
+
+const (
+	// OutcomeSuccess means the action completed
+	OutcomeSuccess Outcome = "success"
+	// OutcomeDenied means authorization or policy rejected the action
+	OutcomeDenied Outcome = "denied"
+	// OutcomeFailure means the action failed for another reason
+	OutcomeFailure Outcome = "failure"
+)
+
+// Event describes an action to record. Handlers fill in the project and
+// details while the action runs; methods are safe to call on a nil event.
+type Event struct {
+	Action     string
+	Principal  string
+	SessionID  string
+	RemoteAddr string
+	ProjectID  string
+	Outcome    Outcome
+	Error      string
+	Details    map[string]interface{}
+}
+
+// SetProject sets the project the action applies to
+func (e *Event) SetProject(projectID string) {
+	if e != nil {
+		e.ProjectID = projectID
+	}
+}
+
+// Set adds a detail to the event
+func (e *Event) Set(key string, value interface{}) {
+	if e == nil {
+		return
+	}
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+}
+
+// SetDigest adds text such as a prompt, which may hold secrets, as its
+// SHA-256 hash and length rather than verbatim
+func (e *Event) SetDigest(key string, text string) {
+	sum := sha256.Sum256([]byte(text))
+	e.Set(key+"_sha256", hex.EncodeToString(sum[:]))
+	e.Set(key+"_length", len(text))
+}
+
+// SetResult sets the outcome and error from the action's result
+func (e *Event) SetResult(err error) {
+	if e == nil {
+		return
+	}
+
+	e.Outcome = OutcomeFor(err)
+	e.Error = ""
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+// OutcomeFor classifies an action's error
+func OutcomeFor(err error) Outcome {
+	if err == nil {
+		return OutcomeSuccess
+	}
+
+	switch errors.GetCode(err) {
+	case errors.CodePermissionDenied, errors.CodeUnauthorized, errors.CodePolicyViolation:
+		return OutcomeDenied
+	}
+	return OutcomeFailure
+}
+
+// eventKey is the context key carrying the event being recorded
+type eventKey struct{}
+
+// WithEvent returns a context carrying the event
+func WithEvent(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, event)
+}
+
+// EventFromContext returns the event carried by ctx, or nil
+func EventFromContext(ctx context.Context) *Event {
+	event, _ := ctx.Value(eventKey{}).(*Event)
+	return event
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+const (
+	// Subprotocol is the application subprotocol negotiated on /ws
+	Subprotocol = "pocket-agent"
+	// BearerSubprotocolPrefix carries a bearer token in Sec-WebSocket-Protocol
+	// for clients (browsers) that cannot set an Authorization header
+	BearerSubprotocolPrefix = Subprotocol + ".bearer."
+	// PairSubprotocolPrefix carries a one-time pairing code in Sec-WebSocket-Protocol
+	PairSubprotocolPrefix = Subprotocol + ".pair."
+)
+
+// Service authenticates WebSocket upgrade requests against issued access
+// tokens, paired device credentials, outstanding pairing codes and verified
+// TLS client certificates
+type Service struct {
+	tokens      *TokenStore
+	devices     *DeviceStore
+	pairing     *PairingStore
+	revocations *RevocationList
+}
+
+// NewService opens all credential stores under dataDir
+func NewService(dataDir string) (*Service, error) {
+	tokens, err := NewTokenStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := NewDeviceStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pairing, err := NewPairingStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		tokens:  tokens,
+		devices: devices,
+		pairing: pairing,
+	}, nil
+}
+
+// Tokens returns the access token store
+func (s *Service) Tokens() *TokenStore {
+	return s.tokens
+}
+
+// Devices returns the paired device store
+func (s *Service) Devices() *DeviceStore {
+	return s.devices
+}
+
+// Pairing returns the pairing code store
+func (s *Service) Pairing() *PairingStore {
+	return s.pairing
+}
+
+// SetRevocationList sets the list used to reject revoked client certificates
+func (s *Service) SetRevocationList(revocations *RevocationList) {
+	s.revocations = revocations
+}
+
+// Authenticate validates the credential presented on the request and returns
+// the identity it belongs to. A valid pairing code yields a pairing identity
+// that may only be used to complete pairing.
+func (s *Service) Authenticate(r *http.Request) (*models.Identity, error) {
+	if secret := ExtractBearerToken(r); secret != "" {
+		if strings.HasPrefix(secret, DeviceCredentialPrefix) {
+			device, err := s.devices.Validate(secret)
+			if err != nil {
+				return nil, err
+			}
+			return &models.Identity{
+				ID:     device.ID,
+				Name:   device.Name,
+				Method: models.AuthMethodDevice,
+			}, nil
+		}
+
+		token, err := s.tokens.Validate(secret)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Identity{
+			ID:     token.ID,
+			Name:   token.Name,
+			Method: models.AuthMethodToken,
+			Admin:  token.Admin,
+		}, nil
+	}
+
+	if code := ExtractPairingCode(r); code != "" {
+		if err := s.pairing.Check(code); err != nil {
+			return nil, err
+		}
+		return &models.Identity{
+			Name:   "pairing",
+			Method: models.AuthMethodPairing,
+		}, nil
+	}
+
+	// A verified client certificate identifies the client on its own
+	if cert := ClientCertificate(r); cert != nil {
+		if err := checkCertificate(s.revocations, cert); err != nil {
+			return nil, err
+		}
+		return CertificateIdentity(cert), nil
+	}
+
+	return nil, errors.New(errors.CodeUnauthorized, "missing access token")
+}
+
+// Verify reports whether a previously authenticated identity is still valid,
+// so revoked credentials stop working on connections that are already open
+func (s *Service) Verify(identity *models.Identity) error {
+	if identity == nil {
+		return nil
+	}
+
+	switch identity.Method {
+	case models.AuthMethodToken:
+		if !s.tokens.IsActive(identity.ID) {
+			return errors.New(errors.CodeUnauthorized, "access token is revoked or expired")
+		}
+	case models.AuthMethodDevice:
+		if !s.devices.IsActive(identity.ID) {
+			return errors.New(errors.CodeUnauthorized, "device has been revoked")
+		}
+	case models.AuthMethodCertificate:
+		return verifySerial(s.revocations, identity.Serial)
+	}
+
+	return nil
+}
+
+// ExtractBearerToken returns the bearer token from the Authorization header,
+// falling back to a pocket-agent.bearer.<token> entry in Sec-WebSocket-Protocol
+func ExtractBearerToken(r *http.Request) string {
+	if value := authorizationValue(r, "Bearer"); value != "" {
+		return value
+	}
+	return subprotocolValue(r, BearerSubprotocolPrefix)
+}
+
+// ExtractPairingCode returns the pairing code from an "Authorization: Pair <code>"
+// header or a pocket-agent.pair.<code> entry in Sec-WebSocket-Protocol
+func ExtractPairingCode(r *http.Request) string {
+	if value := authorizationValue(r, "Pair"); value != "" {
+		return value
+	}
+	return subprotocolValue(r, PairSubprotocolPrefix)
+}
+
+// authorizationValue returns the credential of the Authorization header if it
+// uses the given scheme
+func authorizationValue(r *http.Request, scheme string) string {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return ""
+	}
+
+	got, value, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(got, scheme) {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// subprotocolValue returns the suffix of the first requested subprotocol
+// carrying the given prefix
+func subprotocolValue(r *http.Request, prefix string) string {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, proto := range strings.Split(header, ",") {
+			proto = strings.TrimSpace(proto)
+			if strings.HasPrefix(proto, prefix) {
+				return strings.TrimPrefix(proto, prefix)
+			}
+		}
+	}
+	return ""
+}
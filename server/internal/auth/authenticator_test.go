@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractBearerToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "authorization_header",
+			headers:  map[string]string{"Authorization": "Bearer pat_abc"},
+			expected: "pat_abc",
+		},
+		{
+			name:     "case_insensitive_scheme",
+			headers:  map[string]string{"Authorization": "bearer pat_abc"},
+			expected: "pat_abc",
+		},
+		{
+			name:     "subprotocol",
+			headers:  map[string]string{"Sec-WebSocket-Protocol": "pocket-agent, pocket-agent.bearer.pat_xyz"},
+			expected: "pat_xyz",
+		},
+		{
+			name: "header_takes_precedence",
+			headers: map[string]string{
+				"Authorization":          "Bearer pat_header",
+				"Sec-WebSocket-Protocol": "pocket-agent.bearer.pat_proto",
+			},
+			expected: "pat_header",
+		},
+		{
+			name:     "other_scheme_ignored",
+			headers:  map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			expected: "",
+		},
+		{
+			name:     "no_credentials",
+			headers:  map[string]string{},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ws", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.expected, ExtractBearerToken(req))
+		})
+	}
+}
+
+func TestExtractPairingCode(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Authorization", "Pair ABCD-EFGH")
+	assert.Equal(t, "ABCD-EFGH", ExtractPairingCode(req))
+	assert.Empty(t, ExtractBearerToken(req))
+
+	req = httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Sec-WebSocket-Protocol", "pocket-agent, pocket-agent.pair.ABCDEFGH")
+	assert.Equal(t, "ABCDEFGH", ExtractPairingCode(req))
+}
+
+func TestServiceAuthenticate(t *testing.T) {
+	service, err := NewService(t.TempDir())
+	require.NoError(t, err)
+
+	secret, token, err := service.Tokens().Issue("phone", 0)
+	require.NoError(t, err)
+
+	credential, device, err := service.Devices().Register("pixel")
+	require.NoError(t, err)
+
+	code, _, err := service.Pairing().Create(time.Minute)
+	require.NoError(t, err)
+
+	t.Run("valid_token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+
+		identity, err := service.Authenticate(req)
+		require.NoError(t, err)
+		assert.Equal(t, token.ID, identity.ID)
+		assert.Equal(t, "phone", identity.Name)
+		assert.Equal(t, models.AuthMethodToken, identity.Method)
+	})
+
+	t.Run("valid_device", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", BearerSubprotocolPrefix+credential)
+
+		identity, err := service.Authenticate(req)
+		require.NoError(t, err)
+		assert.Equal(t, device.ID, identity.ID)
+		assert.Equal(t, models.AuthMethodDevice, identity.Method)
+	})
+
+	t.Run("valid_pairing_code", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Authorization", "Pair "+code)
+
+		identity, err := service.Authenticate(req)
+		require.NoError(t, err)
+		assert.True(t, identity.IsPairing())
+
+		// Authenticating does not consume the code
+		assert.NoError(t, service.Pairing().Check(code))
+	})
+
+	t.Run("missing_token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+
+		_, err := service.Authenticate(req)
+		assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	})
+
+	t.Run("invalid_token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", BearerSubprotocolPrefix+"pat_wrong")
+
+		_, err := service.Authenticate(req)
+		assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	})
+
+	t.Run("invalid_pairing_code", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Authorization", "Pair ZZZZ-ZZZZ")
+
+		_, err := service.Authenticate(req)
+		assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	})
+}
+
+func TestServiceVerify(t *testing.T) {
+	service, err := NewService(t.TempDir())
+	require.NoError(t, err)
+
+	_, token, err := service.Tokens().Issue("phone", 0)
+	require.NoError(t, err)
+	_, device, err := service.Devices().Register("pixel")
+	require.NoError(t, err)
+
+	tokenIdentity := &models.Identity{ID: token.ID, Method: models.AuthMethodToken}
+	deviceIdentity := &models.Identity{ID: device.ID, Method: models.AuthMethodDevice}
+
+	assert.NoError(t, service.Verify(nil))
+	assert.NoError(t, service.Verify(tokenIdentity))
+	assert.NoError(t, service.Verify(deviceIdentity))
+
+	require.NoError(t, service.Tokens().Revoke(token.ID))
+	_, err = service.Devices().Revoke(device.ID)
+	require.NoError(t, err)
+
+	assert.True(t, errors.IsCode(service.Verify(tokenIdentity), errors.CodeUnauthorized))
+	assert.True(t, errors.IsCode(service.Verify(deviceIdentity), errors.CodeUnauthorized))
+}
+
+func TestServiceAuthenticateCertificate(t *testing.T) {
+	dataDir := t.TempDir()
+	service, err := NewService(dataDir)
+	require.NoError(t, err)
+
+	revocationFile := filepath.Join(dataDir, "revoked.txt")
+	revocations, err := NewRevocationList(revocationFile)
+	require.NoError(t, err)
+	service.SetRevocationList(revocations)
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(0xbeef),
+		Subject:      pkix.Name{CommonName: "laptop", Organization: []string{"Team"}},
+	}
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	identity, err := service.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "laptop", identity.ID)
+	assert.Equal(t, models.AuthMethodCertificate, identity.Method)
+	assert.Equal(t, "beef", identity.Serial)
+	assert.Equal(t, "certificate:laptop", identity.Principal())
+	assert.NoError(t, service.Verify(identity))
+
+	// A bearer token takes precedence over the certificate
+	secret, token, err := service.Tokens().Issue("cli", 0)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	tokenIdentity, err := service.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, tokenIdentity.ID)
+	req.Header.Del("Authorization")
+
+	// Revoking the serial rejects new and open connections
+	require.NoError(t, os.WriteFile(revocationFile, []byte("BE:EF\n"), 0o600))
+
+	_, err = service.Authenticate(req)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	assert.True(t, errors.IsCode(service.Verify(identity), errors.CodeUnauthorized))
+
+	// Unverified connections carry no certificate identity
+	_, err = service.Authenticate(httptest.NewRequest("GET", "/ws", nil))
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+}
+
+func TestCertificateAuthenticator(t *testing.T) {
+	revocationFile := filepath.Join(t.TempDir(), "revoked.txt")
+	revocations, err := NewRevocationList(revocationFile)
+	require.NoError(t, err)
+	authenticator := NewCertificateAuthenticator(revocations)
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(0xcafe),
+		Subject:      pkix.Name{CommonName: "laptop"},
+	}
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	identity, err := authenticator.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "certificate:laptop", identity.Principal())
+	assert.NoError(t, authenticator.Verify(identity))
+
+	// Clients without a certificate connect anonymously
+	identity, err = authenticator.Authenticate(httptest.NewRequest("GET", "/ws", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, identity)
+
+	// Revoking the serial rejects new and open connections
+	identity, _ = authenticator.Authenticate(req)
+	require.NoError(t, os.WriteFile(revocationFile, []byte("CA:FE\n"), 0o600))
+
+	_, err = authenticator.Authenticate(req)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	assert.True(t, errors.IsCode(authenticator.Verify(identity), errors.CodeUnauthorized))
+
+	// Identities of other methods are left to the auth service
+	assert.NoError(t, authenticator.Verify(&models.Identity{ID: "tok", Method: models.AuthMethodToken}))
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// CertificateAuthenticator identifies clients by their verified TLS client
+// certificates alone. It serves servers running without authentication, so
+// certificate holders still get their own identity, project roles and
+// revocation checks. Clients without a certificate connect anonymously.
+type CertificateAuthenticator struct {
+	revocations *RevocationList
+}
+
+// NewCertificateAuthenticator creates a certificate authenticator; a nil
+// revocation list revokes no certificates
+func NewCertificateAuthenticator(revocations *RevocationList) *CertificateAuthenticator {
+	return &CertificateAuthenticator{revocations: revocations}
+}
+
+// Authenticate returns the identity of the request's client certificate, or
+// nil if the client did not present one
+func (a *CertificateAuthenticator) Authenticate(r *http.Request) (*models.Identity, error) {
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return nil, nil
+	}
+	if err := checkCertificate(a.revocations, cert); err != nil {
+		return nil, err
+	}
+	return CertificateIdentity(cert), nil
+}
+
+// Verify reports whether the certificate of a connected client was revoked
+// since it connected
+func (a *CertificateAuthenticator) Verify(identity *models.Identity) error {
+	if identity == nil || identity.Method != models.AuthMethodCertificate {
+		return nil
+	}
+	return verifySerial(a.revocations, identity.Serial)
+}
+
+// checkCertificate rejects a revoked client certificate
+func checkCertificate(revocations *RevocationList, cert *x509.Certificate) error {
+	if revocations == nil {
+		return nil
+	}
+	return revocations.Check(cert)
+}
+
+// verifySerial rejects the serial of a revoked client certificate
+func verifySerial(revocations *RevocationList, serial string) error {
+	if revocations == nil {
+		return nil
+	}
+	revoked, err := revocations.IsRevoked(serial)
+	if err != nil {
+		return errors.Wrap(err, errors.CodeUnauthorized, "client certificate revocation list is unreadable")
+	}
+	if revoked {
+		return errors.New(errors.CodeUnauthorized, "client certificate has been revoked")
+	}
+	return nil
+}
+
+// ClientCertificate returns the verified client certificate of a TLS request,
+// or nil if the client did not present one
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// CertificateIdentity maps a client certificate to a session identity. The
+// subject common name is the stable ID, so renewed certificates keep their
+// project roles; the serial is kept for revocation checks.
+func CertificateIdentity(cert *x509.Certificate) *models.Identity {
+	id := cert.Subject.CommonName
+	if id == "" {
+		id = cert.Subject.String()
+	}
+
+	return &models.Identity{
+		ID:     id,
+		Name:   cert.Subject.String(),
+		Method: models.AuthMethodCertificate,
+		Serial: SerialString(cert.SerialNumber),
+	}
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// DevicesFileName is the name of the file holding paired devices
+	DevicesFileName = "devices.json"
+	// DeviceCredentialPrefix is prepended to every device credential
+	DeviceCredentialPrefix = "dev_"
+
+	// maxDeviceNameLength bounds user supplied device names
+	maxDeviceNameLength = 64
+)
+
+// Device is a paired client device. Only a hash of its credential is stored.
+type Device struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	PairedAt   time.Time `json:"paired_at"`
+	LastSeenAt time.Time `json:"last_seen_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+// IsActive reports whether the device can currently authenticate
+func (d *Device) IsActive() bool {
+	return d.RevokedAt.IsZero()
+}
+
+// DeviceInfo is the client-facing view of a device, without its credential hash
+type DeviceInfo struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	PairedAt   time.Time `json:"paired_at"`
+	LastSeenAt time.Time `json:"last_seen_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+	Active     bool      `json:"active"`
+}
+
+// Info returns the client-facing view of the device
+func (d *Device) Info() DeviceInfo {
+	return DeviceInfo{
+		ID:         d.ID,
+		Name:       d.Name,
+		PairedAt:   d.PairedAt,
+		LastSeenAt: d.LastSeenAt,
+		RevokedAt:  d.RevokedAt,
+		Active:     d.IsActive(),
+	}
+}
+
+// DeviceStore manages paired devices persisted under the data directory
+type DeviceStore struct {
+	file    jsonFile
+	mu      sync.Mutex
+	devices map[string]*Device // keyed by device ID
+	byHash  map[string]*Device
+	logger  *logger.Logger
+}
+
+// NewDeviceStore opens (or creates) the device store under dataDir
+func NewDeviceStore(dataDir string) (*DeviceStore, error) {
+	dir, err := ensureDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &DeviceStore{
+		file:    jsonFile{path: filepath.Join(dir, DevicesFileName)},
+		devices: make(map[string]*Device),
+		byHash:  make(map[string]*Device),
+		logger:  logger.New("info"),
+	}
+
+	if err := ds.load(); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// Register creates a new device and returns its credential. The credential is
+// only available at registration time.
+func (ds *DeviceStore) Register(name string) (string, *Device, error) {
+	name, err := normalizeDeviceName(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := GenerateSecret(DeviceCredentialPrefix)
+	if err != nil {
+		return "", nil, errors.Wrap(err, errors.CodeInternalError, "failed to generate device credential")
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		return "", nil, err
+	}
+
+	device := &Device{
+		ID:       uuid.New().String(),
+		Name:     name,
+		Hash:     HashSecret(secret),
+		PairedAt: time.Now().UTC(),
+	}
+
+	ds.devices[device.ID] = device
+	ds.byHash[device.Hash] = device
+
+	if err := ds.save(); err != nil {
+		delete(ds.devices, device.ID)
+		delete(ds.byHash, device.Hash)
+		return "", nil, err
+	}
+
+	ds.logger.Info("Device paired", "device_id", device.ID, "name", device.Name)
+
+	copied := *device
+	return secret, &copied, nil
+}
+
+// Validate checks a presented device credential and returns the matching device
+func (ds *DeviceStore) Validate(secret string) (*Device, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		ds.logger.Error("Failed to reload device store", "error", err)
+	}
+
+	device, ok := ds.byHash[HashSecret(secret)]
+	if !ok {
+		return nil, errors.New(errors.CodeUnauthorized, "invalid device credential")
+	}
+	if !device.IsActive() {
+		return nil, errors.New(errors.CodeUnauthorized, "device has been revoked")
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(device.LastSeenAt) > time.Minute {
+		device.LastSeenAt = now
+		if err := ds.save(); err != nil {
+			ds.logger.Warn("Failed to record device activity", "device_id", device.ID, "error", err)
+		}
+	}
+
+	copied := *device
+	return &copied, nil
+}
+
+// IsActive reports whether the device with the given ID exists and is not revoked
+func (ds *DeviceStore) IsActive(id string) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		ds.logger.Error("Failed to reload device store", "error", err)
+	}
+
+	device, ok := ds.devices[id]
+	return ok && device.IsActive()
+}
+
+// ActiveCount returns the number of devices that are not revoked
+func (ds *DeviceStore) ActiveCount() int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		ds.logger.Error("Failed to reload device store", "error", err)
+	}
+
+	count := 0
+	for _, d := range ds.devices {
+		if d.IsActive() {
+			count++
+		}
+	}
+	return count
+}
+
+// Get returns a snapshot of the device with the given ID
+func (ds *DeviceStore) Get(id string) (*Device, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	device, err := ds.find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *device
+	return &copied, nil
+}
+
+// List returns a snapshot of all devices sorted by pairing time
+func (ds *DeviceStore) List() ([]Device, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, len(ds.devices))
+	for _, d := range ds.devices {
+		devices = append(devices, *d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].PairedAt.Before(devices[j].PairedAt)
+	})
+
+	return devices, nil
+}
+
+// Rename changes the display name of a device
+func (ds *DeviceStore) Rename(id, name string) (*Device, error) {
+	name, err := normalizeDeviceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	device, err := ds.find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := device.Name
+	device.Name = name
+	if err := ds.save(); err != nil {
+		device.Name = previous
+		return nil, err
+	}
+
+	copied := *device
+	return &copied, nil
+}
+
+// Revoke revokes a device so its credential can no longer be used
+func (ds *DeviceStore) Revoke(id string) (*Device, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if err := ds.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	device, err := ds.find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if device.IsActive() {
+		device.RevokedAt = time.Now().UTC()
+		if err := ds.save(); err != nil {
+			device.RevokedAt = time.Time{}
+			return nil, err
+		}
+		ds.logger.Info("Device revoked", "device_id", device.ID, "name", device.Name)
+	}
+
+	copied := *device
+	return &copied, nil
+}
+
+// find looks up a device by ID. Caller must hold ds.mu.
+func (ds *DeviceStore) find(id string) (*Device, error) {
+	device, ok := ds.devices[id]
+	if !ok {
+		return nil, errors.New(errors.CodeValidationFailed, "device not found: %s", id).
+			WithDetail("device_id", id)
+	}
+	return device, nil
+}
+
+// load reads the device file from disk
+func (ds *DeviceStore) load() error {
+	var devices []*Device
+	if err := ds.file.read(&devices); err != nil {
+		return err
+	}
+
+	ds.devices = make(map[string]*Device, len(devices))
+	ds.byHash = make(map[string]*Device, len(devices))
+	for _, d := range devices {
+		ds.devices[d.ID] = d
+		ds.byHash[d.Hash] = d
+	}
+
+	return nil
+}
+
+// reloadIfChanged reloads the device file if it was modified externally
+func (ds *DeviceStore) reloadIfChanged() error {
+	changed, err := ds.file.changed()
+	if err != nil || !changed {
+		return err
+	}
+	return ds.load()
+}
+
+// save persists all devices atomically. Caller must hold ds.mu.
+func (ds *DeviceStore) save() error {
+	devices := make([]*Device, 0, len(ds.devices))
+	for _, d := range ds.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].PairedAt.Before(devices[j].PairedAt)
+	})
+
+	return ds.file.write(devices)
+}
+
+// normalizeDeviceName trims and validates a device name
+func normalizeDeviceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.NewValidationError("device name cannot be empty")
+	}
+	if len(name) > maxDeviceNameLength {
+		return "", errors.NewValidationError("device name cannot exceed %d characters", maxDeviceNameLength)
+	}
+	return name, nil
+}
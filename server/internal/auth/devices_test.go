@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceStoreRegisterAndValidate(t *testing.T) {
+	store, err := NewDeviceStore(t.TempDir())
+	require.NoError(t, err)
+
+	credential, device, err := store.Register("  Pixel 8  ")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(credential, DeviceCredentialPrefix))
+	assert.Equal(t, "Pixel 8", device.Name)
+
+	validated, err := store.Validate(credential)
+	require.NoError(t, err)
+	assert.Equal(t, device.ID, validated.ID)
+
+	_, err = store.Validate(DeviceCredentialPrefix + "wrong")
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+}
+
+func TestDeviceStoreRegisterValidatesName(t *testing.T) {
+	store, err := NewDeviceStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, _, err = store.Register("")
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	_, _, err = store.Register(strings.Repeat("x", maxDeviceNameLength+1))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
+
+func TestDeviceStoreRenameAndRevoke(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewDeviceStore(dataDir)
+	require.NoError(t, err)
+
+	credential, device, err := store.Register("tablet")
+	require.NoError(t, err)
+
+	renamed, err := store.Rename(device.ID, "kitchen tablet")
+	require.NoError(t, err)
+	assert.Equal(t, "kitchen tablet", renamed.Name)
+
+	revoked, err := store.Revoke(device.ID)
+	require.NoError(t, err)
+	assert.False(t, revoked.IsActive())
+	assert.False(t, store.IsActive(device.ID))
+
+	_, err = store.Validate(credential)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+
+	// Changes survive a reopen
+	reopened, err := NewDeviceStore(dataDir)
+	require.NoError(t, err)
+	devices, err := reopened.List()
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "kitchen tablet", devices[0].Name)
+	assert.False(t, devices[0].RevokedAt.IsZero())
+
+	_, err = store.Rename("missing", "name")
+	assert.Error(t, err)
+	_, err = store.Revoke("missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+// jsonFile persists a JSON document and remembers the on-disk version it last
+// saw, so stores can pick up changes made by another process (e.g. the CLI).
+type jsonFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// changed reports whether the file differs from the version last read or written
+func (f *jsonFile) changed() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat %s: %w", f.path, err)
+	}
+	return !info.ModTime().Equal(f.modTime) || info.Size() != f.size, nil
+}
+
+// read decodes the file into v. A missing file leaves v untouched.
+func (f *jsonFile) read(v interface{}) error {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.path, err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", f.path, err)
+		}
+	}
+
+	f.remember()
+	return nil
+}
+
+// write atomically replaces the file with the JSON encoding of v
+func (f *jsonFile) write(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", f.path, err)
+	}
+
+	if err := storage.WriteFileAtomic(f.path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write "+f.path, err)
+	}
+
+	f.remember()
+	return nil
+}
+
+// remember records the current on-disk version of the file
+func (f *jsonFile) remember() {
+	if info, err := os.Stat(f.path); err == nil {
+		f.modTime = info.ModTime()
+		f.size = info.Size()
+	}
+}
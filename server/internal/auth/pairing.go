@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/rand"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+)
+
+const (
+	// PairingFileName is the name of the file holding outstanding pairing codes
+	PairingFileName = "pairing.json"
+	// DefaultPairingTTL is how long a pairing code stays valid
+	DefaultPairingTTL = 5 * time.Minute
+
+	// pairingCodeAlphabet omits characters that are easily confused (0/O, 1/I/L)
+	pairingCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	// pairingCodeLength is the number of characters in a pairing code
+	pairingCodeLength = 8
+)
+
+// PairingCode is an outstanding one-time pairing code. Only its hash is stored.
+type PairingCode struct {
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PairingPayload is the data encoded in the pairing QR code
+type PairingPayload struct {
+	Version     int    `json:"v"`
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	TLS         bool   `json:"tls"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// NextFingerprint is the certificate staged by a rotation; clients
+	// should pin it alongside Fingerprint
+	NextFingerprint string `json:"next_fingerprint,omitempty"`
+	Code            string `json:"code"`
+}
+
+// PairingStore manages short-lived one-time pairing codes. Codes are created
+// by the CLI and consumed by the running server, so they live on disk.
+type PairingStore struct {
+	file  jsonFile
+	mu    sync.Mutex
+	codes map[string]*PairingCode // keyed by code hash
+}
+
+// NewPairingStore opens (or creates) the pairing store under dataDir
+func NewPairingStore(dataDir string) (*PairingStore, error) {
+	dir, err := ensureDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &PairingStore{
+		file:  jsonFile{path: filepath.Join(dir, PairingFileName)},
+		codes: make(map[string]*PairingCode),
+	}
+
+	if err := ps.load(); err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// Create generates a new pairing code valid for ttl
+func (ps *PairingStore) Create(ttl time.Duration) (string, *PairingCode, error) {
+	if ttl <= 0 {
+		ttl = DefaultPairingTTL
+	}
+
+	code, err := generatePairingCode()
+	if err != nil {
+		return "", nil, errors.Wrap(err, errors.CodeInternalError, "failed to generate pairing code")
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if err := ps.reloadIfChanged(); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	pc := &PairingCode{
+		Hash:      HashSecret(NormalizePairingCode(code)),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	ps.codes[pc.Hash] = pc
+
+	if err := ps.save(); err != nil {
+		delete(ps.codes, pc.Hash)
+		return "", nil, err
+	}
+
+	copied := *pc
+	return code, &copied, nil
+}
+
+// Check verifies that a code is outstanding without consuming it
+func (ps *PairingStore) Check(code string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	_, err := ps.lookup(code)
+	return err
+}
+
+// Consume verifies a code and removes it so it cannot be used again
+func (ps *PairingStore) Consume(code string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	pc, err := ps.lookup(code)
+	if err != nil {
+		return err
+	}
+
+	delete(ps.codes, pc.Hash)
+	if err := ps.save(); err != nil {
+		ps.codes[pc.Hash] = pc
+		return err
+	}
+
+	return nil
+}
+
+// lookup finds an unexpired code. Caller must hold ps.mu.
+func (ps *PairingStore) lookup(code string) (*PairingCode, error) {
+	code = NormalizePairingCode(code)
+	if code == "" {
+		return nil, errors.New(errors.CodeUnauthorized, "missing pairing code")
+	}
+
+	if err := ps.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	pc, ok := ps.codes[HashSecret(code)]
+	if !ok || !time.Now().Before(pc.ExpiresAt) {
+		return nil, errors.New(errors.CodeUnauthorized, "invalid or expired pairing code")
+	}
+
+	return pc, nil
+}
+
+// load reads the pairing file from disk
+func (ps *PairingStore) load() error {
+	var codes []*PairingCode
+	if err := ps.file.read(&codes); err != nil {
+		return err
+	}
+
+	ps.codes = make(map[string]*PairingCode, len(codes))
+	for _, pc := range codes {
+		ps.codes[pc.Hash] = pc
+	}
+
+	return nil
+}
+
+// reloadIfChanged reloads the pairing file if it was modified externally
+func (ps *PairingStore) reloadIfChanged() error {
+	changed, err := ps.file.changed()
+	if err != nil || !changed {
+		return err
+	}
+	return ps.load()
+}
+
+// save persists outstanding codes, dropping expired ones. Caller must hold ps.mu.
+func (ps *PairingStore) save() error {
+	now := time.Now()
+	codes := make([]*PairingCode, 0, len(ps.codes))
+	for hash, pc := range ps.codes {
+		if !now.Before(pc.ExpiresAt) {
+			delete(ps.codes, hash)
+			continue
+		}
+		codes = append(codes, pc)
+	}
+
+	return ps.file.write(codes)
+}
+
+// generatePairingCode returns a random code formatted as XXXX-XXXX
+func generatePairingCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(pairingCodeAlphabet)))
+	for i := 0; i < pairingCodeLength; i++ {
+		if i == pairingCodeLength/2 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(pairingCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// NormalizePairingCode uppercases a code and strips separators so codes typed
+// by hand compare equal to the printed form
+func NormalizePairingCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPairingCodeFormat(t *testing.T) {
+	code, err := generatePairingCode()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[A-Z2-9]{4}-[A-Z2-9]{4}$`), code)
+}
+
+func TestNormalizePairingCode(t *testing.T) {
+	assert.Equal(t, "ABCDEFGH", NormalizePairingCode(" abcd-efgh "))
+	assert.Equal(t, "ABCDEFGH", NormalizePairingCode("ABCD EFGH"))
+}
+
+func TestPairingStoreConsumeOnce(t *testing.T) {
+	store, err := NewPairingStore(t.TempDir())
+	require.NoError(t, err)
+
+	code, _, err := store.Create(time.Minute)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Check(strings.ToLower(code)))
+	require.NoError(t, store.Consume(code))
+
+	err = store.Consume(code)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	assert.True(t, errors.IsCode(store.Check(code), errors.CodeUnauthorized))
+}
+
+func TestPairingStoreExpiry(t *testing.T) {
+	store, err := NewPairingStore(t.TempDir())
+	require.NoError(t, err)
+
+	code, _, err := store.Create(time.Millisecond)
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	assert.True(t, errors.IsCode(store.Consume(code), errors.CodeUnauthorized))
+}
+
+func TestPairingStoreSharedAcrossProcesses(t *testing.T) {
+	dataDir := t.TempDir()
+
+	server, err := NewPairingStore(dataDir)
+	require.NoError(t, err)
+
+	// The CLI creates codes in its own process
+	cli, err := NewPairingStore(dataDir)
+	require.NoError(t, err)
+
+	code, _, err := cli.Create(time.Minute)
+	require.NoError(t, err)
+
+	assert.NoError(t, server.Consume(code))
+
+	// The code is not stored in plain text
+	data, err := os.ReadFile(filepath.Join(dataDir, DirName, PairingFileName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), NormalizePairingCode(code))
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+)
+
+// RevocationList holds the serial numbers of revoked client certificates. It
+// is read from a text file with one hexadecimal serial per line (colons and
+// "#" comments are allowed) and re-read whenever the file changes.
+type RevocationList struct {
+	mu      sync.Mutex
+	file    jsonFile
+	serials map[string]bool
+	loadErr error
+}
+
+// NewRevocationList loads the revocation list at path. A missing file is
+// treated as an empty list.
+func NewRevocationList(path string) (*RevocationList, error) {
+	l := &RevocationList{
+		file:    jsonFile{path: path},
+		serials: make(map[string]bool),
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Check returns an error if the certificate has been revoked. If the file can
+// no longer be parsed, every certificate is rejected until it is fixed.
+func (l *RevocationList) Check(cert *x509.Certificate) error {
+	revoked, err := l.IsRevoked(SerialString(cert.SerialNumber))
+	if err != nil {
+		return errors.Wrap(err, errors.CodeUnauthorized, "client certificate revocation list is unreadable")
+	}
+	if revoked {
+		return errors.New(errors.CodeUnauthorized, "client certificate has been revoked").
+			WithDetail("serial", SerialString(cert.SerialNumber))
+	}
+	return nil
+}
+
+// IsRevoked reports whether a serial (as returned by SerialString) is revoked
+func (l *RevocationList) IsRevoked(serial string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.reloadIfChanged(); err != nil {
+		return false, err
+	}
+
+	return l.serials[serial], nil
+}
+
+// Count returns the number of revoked serials
+func (l *RevocationList) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.serials)
+}
+
+// reloadIfChanged re-reads the file when it was modified. Callers must hold l.mu.
+func (l *RevocationList) reloadIfChanged() error {
+	changed, err := l.file.changed()
+	if err != nil {
+		return err
+	}
+	if changed || l.loadErr != nil {
+		l.loadErr = l.load()
+	}
+	return l.loadErr
+}
+
+// load parses the file, replacing the current list only on success
+func (l *RevocationList) load() error {
+	data, err := os.ReadFile(l.file.path)
+	if os.IsNotExist(err) {
+		l.serials = make(map[string]bool)
+		l.file.remember()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", l.file.path, err)
+	}
+
+	serials := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		serial, err := ParseSerial(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", l.file.path, lineNo, err)
+		}
+		serials[serial] = true
+	}
+
+	l.serials = serials
+	l.file.remember()
+	return nil
+}
+
+// ParseSerial normalizes a hexadecimal certificate serial such as
+// "0A:1B:2C" or "0x0a1b2c" to the form returned by SerialString
+func ParseSerial(s string) (string, error) {
+	hex := strings.ReplaceAll(strings.TrimSpace(s), ":", "")
+	hex = strings.TrimPrefix(strings.TrimPrefix(hex, "0x"), "0X")
+
+	n, ok := new(big.Int).SetString(hex, 16)
+	if !ok || n.Sign() < 0 {
+		return "", fmt.Errorf("invalid certificate serial %q", s)
+	}
+	return SerialString(n), nil
+}
+
+// SerialString formats a certificate serial as lowercase hexadecimal
+func SerialString(serial *big.Int) string {
+	return serial.Text(16)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/x509"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSerial(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0a1b2c", "a1b2c"},
+		{"0A:1B:2C", "a1b2c"},
+		{"0x0A1B2C", "a1b2c"},
+		{" ff ", "ff"},
+	}
+
+	for _, tt := range tests {
+		serial, err := ParseSerial(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, serial, tt.input)
+	}
+
+	_, err := ParseSerial("not-hex")
+	assert.Error(t, err)
+}
+
+func TestRevocationList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.txt")
+	cert := &x509.Certificate{SerialNumber: big.NewInt(0x1234)}
+
+	// A missing file is an empty list
+	list, err := NewRevocationList(path)
+	require.NoError(t, err)
+	assert.NoError(t, list.Check(cert))
+
+	// Give each rewrite a distinct modification time on filesystems with coarse timestamps
+	modTime := time.Now()
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		modTime = modTime.Add(time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	t.Run("reloads on change", func(t *testing.T) {
+		write("# stolen laptop\n12:34\n\nabcd # old phone\n")
+
+		err := list.Check(cert)
+		assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+		assert.Equal(t, 2, list.Count())
+
+		revoked, err := list.IsRevoked("abcd")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("invalid file fails closed", func(t *testing.T) {
+		write("zzzz\n")
+
+		other := &x509.Certificate{SerialNumber: big.NewInt(99)}
+		assert.True(t, errors.IsCode(list.Check(other), errors.CodeUnauthorized))
+
+		// Fixing the file restores access
+		write("abcd\n")
+		assert.NoError(t, list.Check(other))
+		assert.NoError(t, list.Check(cert))
+	})
+
+	t.Run("invalid file at startup", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "bad.txt")
+		require.NoError(t, os.WriteFile(bad, []byte("zzzz\n"), 0o600))
+		_, err := NewRevocationList(bad)
+		assert.Error(t, err)
+	})
+}
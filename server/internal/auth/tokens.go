@@ -0,0 +1,343 @@
+// Package auth provides authentication primitives for the Pocket Agent server.
+//
+// Access tokens are random bearer secrets issued from the server CLI. Only a
+// SHA-256 hash of each secret is persisted under the data directory, so a
+// leaked tokens file cannot be replayed against the server.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// DirName is the name of the auth directory under the data directory
+	DirName = "auth"
+	// TokensFileName is the name of the file holding issued tokens
+	TokensFileName = "tokens.json"
+	// TokenPrefix is prepended to every issued token secret
+	TokenPrefix = "pat_"
+
+	// tokenSecretBytes is the amount of randomness in a token secret
+	tokenSecretBytes = 32
+)
+
+// Token is a persisted access token record. The secret itself is never stored.
+type Token struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	Admin      bool      `json:"admin,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+// IsActive reports whether the token can currently be used
+func (t *Token) IsActive(now time.Time) bool {
+	if !t.RevokedAt.IsZero() {
+		return false
+	}
+	return t.ExpiresAt.IsZero() || now.Before(t.ExpiresAt)
+}
+
+// TokenStore manages issued access tokens persisted under the data directory.
+// The store reloads its file when it changes on disk, so tokens issued or
+// revoked from the CLI take effect on a running server.
+type TokenStore struct {
+	file   jsonFile
+	mu     sync.Mutex
+	tokens map[string]*Token // keyed by token ID
+	byHash map[string]*Token
+	logger *logger.Logger
+}
+
+// NewTokenStore opens (or creates) the token store under dataDir
+func NewTokenStore(dataDir string) (*TokenStore, error) {
+	dir, err := ensureDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &TokenStore{
+		file:   jsonFile{path: filepath.Join(dir, TokensFileName)},
+		tokens: make(map[string]*Token),
+		byHash: make(map[string]*Token),
+		logger: logger.New("info"),
+	}
+
+	if err := ts.load(); err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// Issue creates a new token and returns its secret. The secret is only
+// available at issue time.
+func (ts *TokenStore) Issue(name string, ttl time.Duration) (string, *Token, error) {
+	return ts.issue(name, ttl, false)
+}
+
+// IssueAdmin creates a new admin token, which bypasses per-project access control
+func (ts *TokenStore) IssueAdmin(name string, ttl time.Duration) (string, *Token, error) {
+	return ts.issue(name, ttl, true)
+}
+
+// issue creates and persists a new token
+func (ts *TokenStore) issue(name string, ttl time.Duration, admin bool) (string, *Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.NewValidationError("token name cannot be empty")
+	}
+
+	secret, err := GenerateSecret(TokenPrefix)
+	if err != nil {
+		return "", nil, errors.Wrap(err, errors.CodeInternalError, "failed to generate token")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.reloadIfChanged(); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	token := &Token{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Hash:      HashSecret(secret),
+		Admin:     admin,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		token.ExpiresAt = now.Add(ttl)
+	}
+
+	ts.tokens[token.ID] = token
+	ts.byHash[token.Hash] = token
+
+	if err := ts.save(); err != nil {
+		delete(ts.tokens, token.ID)
+		delete(ts.byHash, token.Hash)
+		return "", nil, err
+	}
+
+	copied := *token
+	return secret, &copied, nil
+}
+
+// Revoke revokes the token matching the given ID or name
+func (ts *TokenStore) Revoke(idOrName string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.reloadIfChanged(); err != nil {
+		return err
+	}
+
+	token, err := ts.find(idOrName)
+	if err != nil {
+		return err
+	}
+
+	if !token.RevokedAt.IsZero() {
+		return nil
+	}
+
+	token.RevokedAt = time.Now().UTC()
+	if err := ts.save(); err != nil {
+		token.RevokedAt = time.Time{}
+		return err
+	}
+
+	ts.logger.Info("Access token revoked", "token_id", token.ID, "name", token.Name)
+	return nil
+}
+
+// List returns a snapshot of all tokens sorted by creation time
+func (ts *TokenStore) List() ([]Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]Token, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		tokens = append(tokens, *t)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+// ActiveCount returns the number of tokens that are currently usable
+func (ts *TokenStore) ActiveCount() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.reloadIfChanged(); err != nil {
+		ts.logger.Error("Failed to reload token store", "error", err)
+	}
+
+	now := time.Now()
+	count := 0
+	for _, t := range ts.tokens {
+		if t.IsActive(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// IsActive reports whether the token with the given ID exists and is usable
+func (ts *TokenStore) IsActive(id string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.reloadIfChanged(); err != nil {
+		ts.logger.Error("Failed to reload token store", "error", err)
+	}
+
+	token, ok := ts.tokens[id]
+	return ok && token.IsActive(time.Now())
+}
+
+// Validate checks a presented secret and returns the matching active token
+func (ts *TokenStore) Validate(secret string) (*Token, error) {
+	if secret == "" {
+		return nil, errors.New(errors.CodeUnauthorized, "missing access token")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := ts.reloadIfChanged(); err != nil {
+		ts.logger.Error("Failed to reload token store", "error", err)
+	}
+
+	token, ok := ts.byHash[HashSecret(secret)]
+	if !ok {
+		return nil, errors.New(errors.CodeUnauthorized, "invalid access token")
+	}
+
+	now := time.Now().UTC()
+	if !token.IsActive(now) {
+		return nil, errors.New(errors.CodeUnauthorized, "access token is revoked or expired")
+	}
+
+	// Last-used is informational; avoid rewriting the file on every connection
+	if now.Sub(token.LastUsedAt) > time.Minute {
+		token.LastUsedAt = now
+		if err := ts.save(); err != nil {
+			ts.logger.Warn("Failed to record token usage", "token_id", token.ID, "error", err)
+		}
+	}
+
+	copied := *token
+	return &copied, nil
+}
+
+// find looks up a token by ID or name. Caller must hold ts.mu.
+func (ts *TokenStore) find(idOrName string) (*Token, error) {
+	if token, ok := ts.tokens[idOrName]; ok {
+		return token, nil
+	}
+
+	var match *Token
+	for _, t := range ts.tokens {
+		if t.Name == idOrName {
+			if match != nil {
+				return nil, errors.NewValidationError("token name %q is ambiguous, use the token ID", idOrName)
+			}
+			match = t
+		}
+	}
+
+	if match == nil {
+		return nil, errors.New(errors.CodeValidationFailed, "token not found: %s", idOrName)
+	}
+	return match, nil
+}
+
+// load reads the token file from disk. Caller must hold ts.mu or be the constructor.
+func (ts *TokenStore) load() error {
+	var tokens []*Token
+	if err := ts.file.read(&tokens); err != nil {
+		return err
+	}
+
+	ts.tokens = make(map[string]*Token, len(tokens))
+	ts.byHash = make(map[string]*Token, len(tokens))
+	for _, t := range tokens {
+		ts.tokens[t.ID] = t
+		ts.byHash[t.Hash] = t
+	}
+
+	return nil
+}
+
+// reloadIfChanged reloads the token file if it was modified externally
+func (ts *TokenStore) reloadIfChanged() error {
+	changed, err := ts.file.changed()
+	if err != nil || !changed {
+		return err
+	}
+	return ts.load()
+}
+
+// save persists all tokens atomically. Caller must hold ts.mu.
+func (ts *TokenStore) save() error {
+	tokens := make([]*Token, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return ts.file.write(tokens)
+}
+
+// ensureDir creates the auth directory under dataDir and returns its path
+func ensureDir(dataDir string) (string, error) {
+	dir := filepath.Join(dataDir, DirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create auth directory: %w", err)
+	}
+	return dir, nil
+}
+
+// GenerateSecret returns a random URL-safe secret with the given prefix
+func GenerateSecret(prefix string) (string, error) {
+	buf := make([]byte, tokenSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashSecret returns the hex-encoded SHA-256 hash of a secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenStoreIssueAndValidate(t *testing.T) {
+	store, err := NewTokenStore(t.TempDir())
+	require.NoError(t, err)
+
+	secret, token, err := store.Issue("laptop", 0)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, TokenPrefix))
+	assert.Equal(t, "laptop", token.Name)
+	assert.NotEmpty(t, token.ID)
+	assert.True(t, token.ExpiresAt.IsZero())
+
+	validated, err := store.Validate(secret)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, validated.ID)
+	assert.False(t, validated.LastUsedAt.IsZero())
+
+	_, err = store.Validate(secret + "x")
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+
+	_, err = store.Validate("")
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+}
+
+func TestTokenStoreIssueRequiresName(t *testing.T) {
+	store, err := NewTokenStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, _, err = store.Issue("  ", 0)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
+
+func TestTokenStoreSecretNotPersisted(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewTokenStore(dataDir)
+	require.NoError(t, err)
+
+	secret, _, err := store.Issue("phone", 0)
+	require.NoError(t, err)
+
+	path := filepath.Join(dataDir, DirName, TokensFileName)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+	assert.Contains(t, string(data), HashSecret(secret))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestTokenStoreRevoke(t *testing.T) {
+	store, err := NewTokenStore(t.TempDir())
+	require.NoError(t, err)
+
+	secret, token, err := store.Issue("tablet", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Revoke(token.ID))
+
+	_, err = store.Validate(secret)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+
+	// Revoking twice is a no-op
+	assert.NoError(t, store.Revoke(token.ID))
+
+	// Unknown tokens cannot be revoked
+	assert.Error(t, store.Revoke("does-not-exist"))
+}
+
+func TestTokenStoreRevokeByName(t *testing.T) {
+	store, err := NewTokenStore(t.TempDir())
+	require.NoError(t, err)
+
+	secret, _, err := store.Issue("ci", 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Revoke("ci"))
+
+	_, err = store.Validate(secret)
+	assert.Error(t, err)
+
+	// Ambiguous names require the token ID
+	_, _, err = store.Issue("dup", 0)
+	require.NoError(t, err)
+	_, _, err = store.Issue("dup", 0)
+	require.NoError(t, err)
+	assert.True(t, errors.IsCode(store.Revoke("dup"), errors.CodeValidationFailed))
+}
+
+func TestTokenStoreExpiry(t *testing.T) {
+	store, err := NewTokenStore(t.TempDir())
+	require.NoError(t, err)
+
+	secret, token, err := store.Issue("short-lived", time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, token.ExpiresAt.IsZero())
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = store.Validate(secret)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	assert.Equal(t, 0, store.ActiveCount())
+}
+
+func TestTokenStoreSeesExternalChanges(t *testing.T) {
+	dataDir := t.TempDir()
+
+	server, err := NewTokenStore(dataDir)
+	require.NoError(t, err)
+
+	// A second store simulates the CLI running alongside the server
+	cli, err := NewTokenStore(dataDir)
+	require.NoError(t, err)
+
+	secret, token, err := cli.Issue("remote", 0)
+	require.NoError(t, err)
+
+	_, err = server.Validate(secret)
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Revoke(token.ID))
+
+	// Ensure the modification time differs on filesystems with coarse timestamps
+	path := filepath.Join(dataDir, DirName, TokensFileName)
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	_, err = server.Validate(secret)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+}
+
+func TestTokenStoreList(t *testing.T) {
+	store, err := NewTokenStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, first, err := store.Issue("first", 0)
+	require.NoError(t, err)
+	_, second, err := store.Issue("second", 0)
+	require.NoError(t, err)
+
+	tokens, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, first.ID, tokens[0].ID)
+	assert.Equal(t, second.ID, tokens[1].ID)
+	assert.Equal(t, 2, store.ActiveCount())
+}
@@ -0,0 +1,194 @@
+// Package certs generates, fingerprints and rotates the server's self-signed
+// TLS certificate. Mobile clients pin the certificate's SHA-256 fingerprint
+// instead of relying on a certificate authority.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+// DefaultValidity is the lifetime of generated certificates
+const DefaultValidity = 825 * 24 * time.Hour
+
+// Generate creates an ECDSA P-256 self-signed certificate for hosts and
+// writes it to certFile and keyFile. The key file is only readable by the
+// owner. It returns the SHA-256 fingerprint of the new certificate.
+func Generate(certFile, keyFile string, hosts []string) (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "Pocket Agent Server",
+			Organization: []string{"Pocket Agent"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(DefaultValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal key: %w", err)
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return "", errors.NewFileOperationError("create certificate directory", err)
+		}
+	}
+
+	// Write the key first so a certificate never exists without its key
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := storage.WriteFileAtomic(keyFile, keyPEM, 0o600); err != nil {
+		return "", errors.NewFileOperationError("write "+keyFile, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := storage.WriteFileAtomic(certFile, certPEM, 0o644); err != nil {
+		return "", errors.NewFileOperationError("write "+certFile, err)
+	}
+
+	return fingerprintDER(der), nil
+}
+
+// Ensure generates a self-signed certificate when neither certFile nor
+// keyFile exists. It reports whether a certificate was generated; a cert
+// without its key (or the reverse) is an error rather than overwritten.
+func Ensure(certFile, keyFile string, hosts []string) (bool, error) {
+	certExists, err := exists(certFile)
+	if err != nil {
+		return false, err
+	}
+	keyExists, err := exists(keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case certExists && keyExists:
+		return false, nil
+	case certExists:
+		return false, fmt.Errorf("TLS key file not found: %s", keyFile)
+	case keyExists:
+		return false, fmt.Errorf("TLS cert file not found: %s", certFile)
+	}
+
+	if _, err := Generate(certFile, keyFile, hosts); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Fingerprint returns the colon separated SHA-256 fingerprint of the first
+// certificate in a PEM file
+func Fingerprint(certFile string) (string, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate found in %s", certFile)
+	}
+
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return "", fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return fingerprintDER(block.Bytes), nil
+}
+
+// DefaultHosts returns the names and addresses a generated certificate is
+// issued for: localhost, the machine's host name and its non-loopback
+// interface addresses, plus any extra hosts
+func DefaultHosts(extra ...string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+		if !strings.Contains(name, ".") {
+			hosts = append(hosts, name+".local")
+		}
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+
+	for _, host := range extra {
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// fingerprintDER formats the SHA-256 digest of a DER certificate as
+// upper-case hex pairs separated by colons
+func fingerprintDER(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// exists reports whether path exists
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to stat %s: %w", path, err)
+}
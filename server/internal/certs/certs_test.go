@@ -0,0 +1,306 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fingerprintPattern = regexp.MustCompile(`^([0-9A-F]{2}:){31}[0-9A-F]{2}$`)
+
+// certPaths returns cert and key paths inside a fresh directory that does
+// not exist yet
+func certPaths(t *testing.T) (string, string) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	return filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+}
+
+func TestGenerate(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+
+	fingerprint, err := Generate(certFile, keyFile, []string{"localhost", "127.0.0.1", "my-mac.local"})
+	require.NoError(t, err)
+	assert.Regexp(t, fingerprintPattern, fingerprint)
+
+	// The key is private to the owner
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// The pair loads and is an ECDSA self-signed server certificate
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	assert.IsType(t, &ecdsa.PublicKey{}, cert.PublicKey)
+	assert.NoError(t, cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature))
+	assert.Contains(t, cert.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	assert.ElementsMatch(t, []string{"localhost", "my-mac.local"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	assert.Equal(t, "127.0.0.1", cert.IPAddresses[0].String())
+
+	got, err := Fingerprint(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, got)
+}
+
+func TestEnsure(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+
+	generated, err := Ensure(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+	assert.True(t, generated)
+
+	fingerprint, err := Fingerprint(certFile)
+	require.NoError(t, err)
+
+	// Existing files are kept
+	generated, err = Ensure(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+	assert.False(t, generated)
+
+	got, err := Fingerprint(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, got)
+
+	// A certificate without its key is not silently replaced
+	require.NoError(t, os.Remove(keyFile))
+	_, err = Ensure(certFile, keyFile, []string{"localhost"})
+	assert.ErrorContains(t, err, "TLS key file not found")
+}
+
+func TestFingerprint(t *testing.T) {
+	_, err := Fingerprint(filepath.Join(t.TempDir(), "missing.crt"))
+	assert.Error(t, err)
+
+	notCert := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(notCert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte{1}}), 0o600))
+	_, err = Fingerprint(notCert)
+	assert.ErrorContains(t, err, "no certificate found")
+}
+
+func TestRotateImmediately(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	previous, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+
+	rotation, err := Rotate(certFile, keyFile, []string{"localhost"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, previous, rotation.Previous)
+	assert.NotEqual(t, previous, rotation.Fingerprint)
+
+	current, err := Fingerprint(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, rotation.Fingerprint, current)
+
+	pending, err := PendingRotation(certFile)
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+}
+
+func TestRotateWithGrace(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	previous, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+
+	rotation, err := Rotate(certFile, keyFile, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, previous, rotation.Previous)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), rotation.ActivateAt, time.Minute)
+
+	// The old certificate stays active during the grace period
+	current, err := Fingerprint(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, previous, current)
+
+	pending, err := PendingRotation(certFile)
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	assert.Equal(t, rotation.Fingerprint, pending.Fingerprint)
+
+	activated, err := Activate(certFile, keyFile, time.Now())
+	require.NoError(t, err)
+	assert.False(t, activated)
+
+	// Once the grace period has passed the staged certificate takes over
+	activated, err = Activate(certFile, keyFile, rotation.ActivateAt)
+	require.NoError(t, err)
+	assert.True(t, activated)
+
+	current, err = Fingerprint(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, rotation.Fingerprint, current)
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+
+	pending, err = PendingRotation(certFile)
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+	assert.NoFileExists(t, certFile+".next")
+	assert.NoFileExists(t, keyFile+".next")
+}
+
+func TestRotateRequiresCertificate(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	_, err := Rotate(certFile, keyFile, []string{"localhost"}, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestManager(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	original, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+
+	m, err := NewManager(certFile, keyFile, logger.New("error"))
+	require.NoError(t, err)
+
+	leafFingerprint := func() string {
+		cert, err := m.GetCertificate(nil)
+		require.NoError(t, err)
+		return fingerprintDER(cert.Certificate[0])
+	}
+
+	status := m.Status()
+	assert.Equal(t, original, status.Fingerprint)
+	assert.Empty(t, status.NextFingerprint)
+	assert.Nil(t, status.RotatesAt)
+	assert.Equal(t, original, leafFingerprint())
+
+	// A staged rotation is advertised while the old certificate is served
+	rotation, err := Rotate(certFile, keyFile, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+	m.refresh()
+
+	status = m.Status()
+	assert.Equal(t, original, status.Fingerprint)
+	assert.Equal(t, rotation.Fingerprint, status.NextFingerprint)
+	require.NotNil(t, status.RotatesAt)
+	assert.True(t, rotation.ActivateAt.Equal(*status.RotatesAt))
+	assert.Equal(t, original, leafFingerprint())
+
+	// When the grace period ends the new certificate is served
+	rotation.ActivateAt = time.Now().Add(-time.Second)
+	data, err := json.Marshal(rotation)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(rotationPath(certFile), data, 0o600))
+
+	// Handshakes do not touch the files; the next check activates it
+	assert.Equal(t, original, leafFingerprint())
+	m.refresh()
+	assert.Equal(t, rotation.Fingerprint, leafFingerprint())
+	status = m.Status()
+	assert.Equal(t, rotation.Fingerprint, status.Fingerprint)
+	assert.Empty(t, status.NextFingerprint)
+
+	// An immediate rotation by another process is picked up
+	immediate, err := Rotate(certFile, keyFile, []string{"localhost"}, 0)
+	require.NoError(t, err)
+	m.refresh()
+	assert.Equal(t, immediate.Fingerprint, leafFingerprint())
+}
+
+func TestManagerChecksInBackground(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	_, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+
+	m, err := NewManager(certFile, keyFile, logger.New("error"))
+	require.NoError(t, err)
+	m.interval = 10 * time.Millisecond
+	m.Start()
+	defer m.Stop()
+
+	rotation, err := Rotate(certFile, keyFile, []string{"localhost"}, 0)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return m.Status().Fingerprint == rotation.Fingerprint
+	}, time.Second, 10*time.Millisecond)
+}
+
+// stageDueRotation stages a rotation whose grace period has passed
+func stageDueRotation(t *testing.T, certFile, keyFile string) *Rotation {
+	rotation, err := Rotate(certFile, keyFile, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+	rotation.ActivateAt = time.Now().Add(-time.Minute)
+	data, err := json.Marshal(rotation)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(rotationPath(certFile), data, 0o600))
+	return rotation
+}
+
+func TestActivateChecksStagedPair(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	original, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+	stageDueRotation(t, certFile, keyFile)
+
+	// A staged key that does not belong to the staged certificate
+	otherCert, otherKey := certPaths(t)
+	_, err = Generate(otherCert, otherKey, []string{"localhost"})
+	require.NoError(t, err)
+	mismatched, err := os.ReadFile(otherKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile+".next", mismatched, 0o600))
+
+	activated, err := Activate(certFile, keyFile, time.Now())
+	assert.Error(t, err)
+	assert.False(t, activated)
+
+	// The active pair is untouched and still loads
+	current, err := Fingerprint(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, original, current)
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.FileExists(t, certFile+".next")
+}
+
+func TestActivateRestoresKey(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	_, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+	stageDueRotation(t, certFile, keyFile)
+
+	activeKey, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	stagedKey, err := os.ReadFile(keyFile + ".next")
+	require.NoError(t, err)
+
+	// A non-empty directory in place of the certificate fails its rename
+	require.NoError(t, os.Remove(certFile))
+	require.NoError(t, os.MkdirAll(filepath.Join(certFile, "blocked"), 0o755))
+
+	activated, err := Activate(certFile, keyFile, time.Now())
+	assert.Error(t, err)
+	assert.False(t, activated)
+
+	// Both keys are back where they were
+	key, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, activeKey, key)
+	key, err = os.ReadFile(keyFile + ".next")
+	require.NoError(t, err)
+	assert.Equal(t, stagedKey, key)
+}
+
+func TestNewManagerActivatesDueRotation(t *testing.T) {
+	certFile, keyFile := certPaths(t)
+	_, err := Generate(certFile, keyFile, []string{"localhost"})
+	require.NoError(t, err)
+	rotation := stageDueRotation(t, certFile, keyFile)
+
+	m, err := NewManager(certFile, keyFile, logger.New("error"))
+	require.NoError(t, err)
+	assert.Equal(t, rotation.Fingerprint, m.Status().Fingerprint)
+}
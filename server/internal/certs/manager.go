@@ -0,0 +1,215 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/logger"
+)
+
+// reloadInterval is how often the manager checks the certificate files
+const reloadInterval = 5 * time.Second
+
+// Status reports the fingerprints clients should pin
+type Status struct {
+	Fingerprint     string     `json:"fingerprint"`
+	NotAfter        time.Time  `json:"not_after"`
+	NextFingerprint string     `json:"next_fingerprint,omitempty"`
+	RotatesAt       *time.Time `json:"rotates_at,omitempty"`
+}
+
+// Manager serves the active certificate to TLS handshakes. Once started,
+// it activates a staged rotation when its grace period ends and reloads the
+// certificate when the files change on disk (e.g. after "rotate-cert
+// -grace 0"), so rotations take effect without a restart. Handshakes never
+// touch the files.
+type Manager struct {
+	certFile string
+	keyFile  string
+	log      *logger.Logger
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	status  Status
+	modTime time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewManager loads the certificate, first activating a staged rotation
+// whose grace period has already passed
+func NewManager(certFile, keyFile string, log *logger.Logger) (*Manager, error) {
+	m := &Manager{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      log,
+		interval: reloadInterval,
+	}
+
+	if _, err := Activate(certFile, keyFile, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Start begins checking the certificate files in the background
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+
+	go m.loop(m.stop, m.done)
+}
+
+// Stop stops checking the certificate files; the active certificate stays
+// in service
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// loop checks the certificate files until stopped
+func (m *Manager) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.refresh()
+		}
+	}
+}
+
+// GetCertificate returns the active certificate; it is meant for
+// tls.Config.GetCertificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert, nil
+}
+
+// Status returns the active fingerprint and any staged rotation
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// refresh activates a due rotation and reloads changed files. Failures are
+// logged and the current certificate stays in service.
+func (m *Manager) refresh() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	activated, err := Activate(m.certFile, m.keyFile, time.Now())
+	if err != nil {
+		m.log.Error("Failed to activate rotated TLS certificate", "error", err)
+	}
+
+	info, err := os.Stat(m.certFile)
+	if err != nil {
+		m.log.Error("Failed to stat TLS certificate", "error", err)
+		return
+	}
+
+	if activated || !info.ModTime().Equal(m.modTime) {
+		previous := m.status.Fingerprint
+		if err := m.loadLocked(); err != nil {
+			m.log.Error("Failed to reload TLS certificate", "error", err)
+			return
+		}
+		if m.status.Fingerprint != previous {
+			m.log.Info("TLS certificate changed",
+				"fingerprint", m.status.Fingerprint,
+				"previous_fingerprint", previous,
+			)
+		}
+		return
+	}
+
+	// The rotation record may change without touching the active certificate
+	m.loadRotation()
+}
+
+// load reads the certificate, key and rotation record
+func (m *Manager) load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.loadLocked()
+}
+
+// loadLocked reads the certificate, key and rotation record. Must hold m.mu.
+func (m *Manager) loadLocked() error {
+	info, err := os.Stat(m.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat certificate: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	m.cert = &cert
+	m.modTime = info.ModTime()
+	m.status = Status{
+		Fingerprint: fingerprintDER(leaf.Raw),
+		NotAfter:    leaf.NotAfter,
+	}
+	m.loadRotation()
+	return nil
+}
+
+// loadRotation updates the status with the staged rotation. Must hold m.mu.
+func (m *Manager) loadRotation() {
+	m.status.NextFingerprint = ""
+	m.status.RotatesAt = nil
+
+	rotation, err := PendingRotation(m.certFile)
+	if err != nil {
+		m.log.Warn("Failed to read TLS certificate rotation", "error", err)
+		return
+	}
+	if rotation == nil || rotation.Previous != m.status.Fingerprint {
+		return
+	}
+
+	activateAt := rotation.ActivateAt
+	m.status.NextFingerprint = rotation.Fingerprint
+	m.status.RotatesAt = &activateAt
+}
@@ -0,0 +1,182 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+// DefaultRotationGrace is how long the old certificate stays in service
+// after a rotation, giving clients time to pin the new fingerprint
+const DefaultRotationGrace = 7 * 24 * time.Hour
+
+// Rotation describes a certificate waiting to replace the active one. Until
+// ActivateAt the server keeps presenting the previous certificate and
+// advertises Fingerprint so clients can pin both.
+type Rotation struct {
+	Fingerprint string    `json:"fingerprint"`
+	Previous    string    `json:"previous_fingerprint"`
+	ActivateAt  time.Time `json:"activate_at"`
+}
+
+// Due reports whether the pending certificate should be activated
+func (r *Rotation) Due(now time.Time) bool {
+	return !now.Before(r.ActivateAt)
+}
+
+// Rotate generates a new certificate for hosts. With a positive grace the
+// new certificate is staged next to the active one and activated once the
+// grace period has passed; otherwise it replaces the active one immediately.
+func Rotate(certFile, keyFile string, hosts []string, grace time.Duration) (*Rotation, error) {
+	previous, err := Fingerprint(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if grace <= 0 {
+		fingerprint, err := Generate(certFile, keyFile, hosts)
+		if err != nil {
+			return nil, err
+		}
+		if err := clearRotation(certFile, keyFile); err != nil {
+			return nil, err
+		}
+		return &Rotation{
+			Fingerprint: fingerprint,
+			Previous:    previous,
+			ActivateAt:  time.Now(),
+		}, nil
+	}
+
+	nextCert, nextKey := pendingPaths(certFile, keyFile)
+	fingerprint, err := Generate(nextCert, nextKey, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &Rotation{
+		Fingerprint: fingerprint,
+		Previous:    previous,
+		ActivateAt:  time.Now().Add(grace).UTC(),
+	}
+
+	data, err := json.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rotation: %w", err)
+	}
+	if err := storage.WriteFileAtomic(rotationPath(certFile), data, 0o600); err != nil {
+		return nil, errors.NewFileOperationError("write certificate rotation", err)
+	}
+
+	return rotation, nil
+}
+
+// PendingRotation returns the staged rotation for certFile, or nil if none
+func PendingRotation(certFile string) (*Rotation, error) {
+	data, err := os.ReadFile(rotationPath(certFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate rotation: %w", err)
+	}
+
+	var rotation Rotation
+	if err := json.Unmarshal(data, &rotation); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate rotation: %w", err)
+	}
+	return &rotation, nil
+}
+
+// Activate replaces the active certificate with the staged one if its grace
+// period has passed. It reports whether the certificate was replaced. The
+// staged pair is checked first, and if the certificate cannot be moved into
+// place after the key, the key is moved back, so the active pair on disk
+// always matches.
+func Activate(certFile, keyFile string, now time.Time) (bool, error) {
+	rotation, err := PendingRotation(certFile)
+	if err != nil || rotation == nil || !rotation.Due(now) {
+		return false, err
+	}
+
+	nextCert, nextKey := pendingPaths(certFile, keyFile)
+	if err := checkStaged(nextCert, nextKey, rotation, now); err != nil {
+		return false, err
+	}
+
+	activeKey, err := os.ReadFile(keyFile)
+	if err != nil {
+		return false, errors.NewFileOperationError("read active key", err)
+	}
+	if err := os.Rename(nextKey, keyFile); err != nil {
+		return false, errors.NewFileOperationError("activate rotated key", err)
+	}
+	if err := os.Rename(nextCert, certFile); err != nil {
+		if rollbackErr := restoreKey(keyFile, nextKey, activeKey); rollbackErr != nil {
+			return false, errors.NewFileOperationError("restore active key", rollbackErr)
+		}
+		return false, errors.NewFileOperationError("activate rotated certificate", err)
+	}
+	if err := os.Remove(rotationPath(certFile)); err != nil && !os.IsNotExist(err) {
+		return false, errors.NewFileOperationError("remove certificate rotation", err)
+	}
+
+	return true, nil
+}
+
+// checkStaged verifies that the staged files form a usable pair and hold
+// the certificate the rotation announced
+func checkStaged(certFile, keyFile string, rotation *Rotation, now time.Time) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load staged TLS key pair: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse staged certificate: %w", err)
+	}
+
+	if fingerprint := fingerprintDER(leaf.Raw); fingerprint != rotation.Fingerprint {
+		return fmt.Errorf("staged certificate %s does not match the announced %s", fingerprint, rotation.Fingerprint)
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("staged certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// restoreKey moves a staged key that was moved into place back, and
+// restores the active key it replaced
+func restoreKey(keyFile, nextKey string, activeKey []byte) error {
+	if err := os.Rename(keyFile, nextKey); err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(keyFile, activeKey, 0o600)
+}
+
+// clearRotation discards a staged certificate and its rotation record
+func clearRotation(certFile, keyFile string) error {
+	nextCert, nextKey := pendingPaths(certFile, keyFile)
+	for _, path := range []string{rotationPath(certFile), nextCert, nextKey} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.NewFileOperationError("remove "+path, err)
+		}
+	}
+	return nil
+}
+
+// pendingPaths returns where a staged certificate and key are stored
+func pendingPaths(certFile, keyFile string) (string, string) {
+	return certFile + ".next", keyFile + ".next"
+}
+
+// rotationPath returns where the rotation record for certFile is stored
+func rotationPath(certFile string) string {
+	return certFile + ".rotation.json"
+}
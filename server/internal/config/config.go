@@ -9,6 +9,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/netutil"
+	"github.com/boyd/pocket_agent/server/internal/redact"
 )
 
 // Config represents the server configuration.
@@ -20,6 +23,12 @@ type Config struct {
 	TLSCertFile string `json:"tls_cert_file"`
 	TLSKeyFile  string `json:"tls_key_file"`
 
+	// Mutual TLS: verify client certificates against this CA bundle,
+	// optionally requiring one, and reject serials listed in the revocation file
+	TLSClientCAFile         string `json:"tls_client_ca_file"`
+	TLSClientCertRequired   bool   `json:"tls_client_cert_required"`
+	TLSClientRevocationFile string `json:"tls_client_revocation_file"`
+
 	// Data storage
 	DataDir string `json:"data_dir"`
 
@@ -29,6 +38,24 @@ type Config struct {
 	// Execution settings
 	Execution ExecutionConfig `json:"execution"`
 
+	// Authentication settings
+	Auth AuthConfig `json:"auth"`
+
+	// Message rate limits and quotas
+	RateLimits RateLimitConfig `json:"rate_limits"`
+
+	// Secret redaction in message logs and broadcasts
+	Redaction RedactionConfig `json:"redaction"`
+
+	// Interactive tool permission prompts
+	Permissions PermissionConfig `json:"permissions"`
+
+	// Token and cost accounting and budgets
+	Usage UsageConfig `json:"usage"`
+
+	// Per-project environment variables and secrets
+	Environment EnvironmentConfig `json:"environment"`
+
 	// Logging
 	LogLevel string `json:"log_level"`
 	LogFile  string `json:"log_file"`
@@ -43,6 +70,15 @@ type WebSocketConfig struct {
 	MaxMessageSize  int64    `json:"max_message_size"`
 	WriteBufferSize int      `json:"write_buffer_size"`
 	ReadBufferSize  int      `json:"read_buffer_size"`
+
+	// AllowedOrigins lists the browser origins that may open a connection:
+	// "*", scheme://host[:port] or scheme://*.domain[:port]. Requests
+	// without an Origin header (native clients) are always allowed; the
+	// empty default admits no browser origins.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers are honored
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
 // ExecutionConfig contains Claude execution configuration.
@@ -52,6 +88,205 @@ type ExecutionConfig struct {
 	MaxLogSize        int64    `json:"max_log_size"`
 	MaxMessagesPerLog int      `json:"max_messages_per_log"`
 	ClaudeBinaryPath  string   `json:"claude_binary_path"`
+
+	// MaxTimeout caps the timeout_seconds clients request, and
+	// command_timeout, for projects whose policy sets no max_timeout;
+	// 0 leaves them uncapped
+	MaxTimeout Duration `json:"max_timeout"`
+
+	// IdleTimeout ends interactive executions that received no input for
+	// this long after their last turn
+	IdleTimeout Duration `json:"idle_timeout"`
+
+	// StallTimeout warns the subscribers of an execution whose process wrote
+	// nothing to stdout for this long, and StallGrace kills it if it stays
+	// silent for that much longer. 0, the default, disables the watchdog.
+	StallTimeout Duration `json:"stall_timeout"`
+	StallGrace   Duration `json:"stall_grace"`
+
+	// MaxQueueLength caps the prompts queued per project while it is
+	// executing; 0 disables queuing so such prompts are rejected
+	MaxQueueLength int `json:"max_queue_length"`
+
+	// MaxRecords caps the execution records kept per project; the oldest
+	// are removed first. 0 disables execution records.
+	MaxRecords int `json:"max_records"`
+
+	// MaxSchedules caps the scheduled prompts per project; 0 disables
+	// scheduled prompts
+	MaxSchedules int `json:"max_schedules"`
+
+	// MaxWorktrees caps the git worktrees executions may keep per project
+	// until they are merged or discarded; 0 disables worktree executions
+	MaxWorktrees int `json:"max_worktrees"`
+
+	// MaxPatchSize caps the patch of the changes kept per execution, in
+	// bytes; longer patches are cut. 0 disables change capture.
+	MaxPatchSize int64 `json:"max_patch_size"`
+
+	// MaxSessions caps the Claude sessions catalogued per project; the least
+	// recently used are dropped first. 0 disables the session catalogue.
+	MaxSessions int `json:"max_sessions"`
+
+	// DefaultPolicy names the policy profile for projects without one;
+	// empty leaves execution options unrestricted
+	DefaultPolicy string                   `json:"default_policy"`
+	Policies      map[string]PolicyProfile `json:"policies"`
+
+	// SandboxCgroup is a cgroup v2 directory the server may create cgroups
+	// in, with the memory, cpu and pids controllers enabled. It is required
+	// by policy sandboxes with resource limits.
+	SandboxCgroup string `json:"sandbox_cgroup"`
+
+	// Backends are the agents projects may run instead of the Claude CLI,
+	// by name
+	Backends map[string]BackendConfig `json:"backends"`
+}
+
+// BackendConfig describes an agent that reads the prompt on stdin and writes
+// JSON lines to stdout.
+type BackendConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// ResumeArgs resume the session "{session_id}" and ModelArgs select the
+	// model "{model}"; omitted, the agent supports neither
+	ResumeArgs []string `json:"resume_args"`
+	ModelArgs  []string `json:"model_args"`
+	// TypeField and SessionIDField name the fields of each line holding its
+	// message type and session ID
+	TypeField      string `json:"type_field"`
+	SessionIDField string `json:"session_id_field"`
+	// ResultTypes end a turn and ErrorTypes report failures
+	ResultTypes []string `json:"result_types"`
+	ErrorTypes  []string `json:"error_types"`
+}
+
+// PolicyProfile restricts the Claude options clients may request.
+type PolicyProfile struct {
+	// Mode is "reject" (default) to fail violating executions or "clamp"
+	// to drop violating options and report them
+	Mode                 string   `json:"mode"`
+	AllowedTools         []string `json:"allowed_tools"`
+	DeniedTools          []string `json:"denied_tools"`
+	AllowedModels        []string `json:"allowed_models"`
+	AllowSkipPermissions bool     `json:"allow_skip_permissions"`
+	AllowMCPConfig       bool     `json:"allow_mcp_config"`
+	AddDirRoots          []string `json:"add_dir_roots"`
+	// MaxTimeout caps the timeout of each execution, including timeouts
+	// clients request; 0 leaves it to the execution max_timeout
+	MaxTimeout Duration `json:"max_timeout"`
+
+	// Sandbox runs the policy's executions in a Linux sandbox; omitted
+	// runs them unconfined
+	Sandbox *SandboxProfile `json:"sandbox"`
+}
+
+// SandboxProfile confines executions to a read-only view of the file system
+// except the project directory, the temporary directory, Claude's
+// configuration and WritablePaths.
+type SandboxProfile struct {
+	// IsolateNetwork gives executions a network namespace with only a
+	// loopback interface
+	IsolateNetwork bool     `json:"isolate_network"`
+	WritablePaths  []string `json:"writable_paths"`
+	// MemoryMB, CPUs and MaxPids limit each execution; 0 is unlimited
+	MemoryMB int64   `json:"memory_mb"`
+	CPUs     float64 `json:"cpus"`
+	MaxPids  int64   `json:"max_pids"`
+}
+
+// AuthConfig contains client authentication configuration.
+type AuthConfig struct {
+	// Enabled requires clients to present a valid access token on /ws
+	Enabled bool `json:"enabled"`
+}
+
+// RateLimitConfig limits the messages clients send once connected.
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// Default applies to message types without their own entry in Messages
+	Default MessageRateLimit `json:"default"`
+	// Messages sets the limits of individual message types, by type name
+	Messages map[string]MessageRateLimit `json:"messages"`
+
+	// DailyExecutions caps executions per identity and UTC day; 0 is unlimited
+	DailyExecutions int `json:"daily_executions"`
+	// DailyExecutionOverrides sets the cap of specific principals
+	// ("token:<id>", "device:<id>", "certificate:<cn>")
+	DailyExecutionOverrides map[string]int `json:"daily_execution_overrides"`
+}
+
+// MessageRateLimit limits a message type per connection and per identity;
+// the identity limit is shared by all connections of the same principal.
+type MessageRateLimit struct {
+	Session  RateLimit `json:"session"`
+	Identity RateLimit `json:"identity"`
+}
+
+// RateLimit is a token bucket refilled at PerMinute messages per minute,
+// allowing bursts of up to Burst messages. A zero PerMinute is unlimited.
+type RateLimit struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+}
+
+// RedactionConfig controls the removal of secrets from Claude output before
+// it is persisted or broadcast.
+type RedactionConfig struct {
+	Enabled bool `json:"enabled"`
+	// DisabledRules turns off built-in detectors by name
+	DisabledRules []string `json:"disabled_rules"`
+	// Patterns adds detectors for deployment-specific secrets
+	Patterns []RedactionPattern `json:"patterns"`
+}
+
+// RedactionPattern is a user-configured detector. If Pattern has a capture
+// group named "secret" only that group is replaced.
+type RedactionPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// PermissionConfig controls relaying Claude's tool permission prompts to
+// clients. Executions that skip permissions are never prompted.
+type PermissionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Timeout is how long a prompt waits for a client to answer
+	Timeout Duration `json:"timeout"`
+	// Default answers prompts that time out: "approve" or "deny"
+	Default string `json:"default"`
+}
+
+// UsageConfig controls accounting the tokens and cost of executions.
+type UsageConfig struct {
+	Enabled bool `json:"enabled"`
+	// RetentionDays is the number of UTC days whose usage is kept
+	RetentionDays int `json:"retention_days"`
+	// Budgets block new executions once they are spent
+	Budgets BudgetConfig `json:"budgets"`
+}
+
+// BudgetConfig caps the cost, in USD, of the executions of each project and
+// of each identity per UTC day and month. 0 is unlimited.
+type BudgetConfig struct {
+	ProjectDailyUSD    float64 `json:"project_daily_usd"`
+	ProjectMonthlyUSD  float64 `json:"project_monthly_usd"`
+	IdentityDailyUSD   float64 `json:"identity_daily_usd"`
+	IdentityMonthlyUSD float64 `json:"identity_monthly_usd"`
+}
+
+// EnvironmentConfig controls the environment variables projects define for
+// their executions.
+type EnvironmentConfig struct {
+	Enabled bool `json:"enabled"`
+	// KeyFile holds the key that encrypts secret values; empty keeps it in
+	// env.key under the data directory. It is created on first start.
+	KeyFile string `json:"key_file"`
+	// Allowlist names the server variables that projects in allowlist mode
+	// keep; empty uses a minimal set such as PATH and HOME
+	Allowlist []string `json:"allowlist"`
+	// MaxVariables caps the variables per project; 0 is unlimited
+	MaxVariables int `json:"max_variables"`
 }
 
 // Options represents configuration options passed via command line.
@@ -84,14 +319,67 @@ func DefaultConfig() *Config {
 			MaxMessageSize:  1024 * 1024, // 1MB
 			WriteBufferSize: 1024,
 			ReadBufferSize:  1024,
+			AllowedOrigins:  []string{},
 		},
 
 		Execution: ExecutionConfig{
-			CommandTimeout:    Duration{0}, // No timeout by default
+			CommandTimeout:    Duration{5 * time.Minute},
+			MaxTimeout:        Duration{time.Hour},
 			MaxProjects:       100,
 			MaxLogSize:        100 * 1024 * 1024, // 100MB
 			MaxMessagesPerLog: 10000,
 			ClaudeBinaryPath:  "claude",
+			MaxQueueLength:    20,
+			IdleTimeout:       Duration{10 * time.Minute},
+			StallTimeout:      Duration{0}, // No stall watchdog by default
+			StallGrace:        Duration{5 * time.Minute},
+			MaxRecords:        200,
+			MaxSchedules:      20,
+			MaxWorktrees:      10,
+			MaxPatchSize:      1024 * 1024, // 1MB
+			MaxSessions:       100,
+		},
+
+		Auth: AuthConfig{
+			Enabled: true,
+		},
+
+		RateLimits: RateLimitConfig{
+			Enabled: true,
+			Default: MessageRateLimit{
+				Session:  RateLimit{PerMinute: 120, Burst: 30},
+				Identity: RateLimit{PerMinute: 300, Burst: 60},
+			},
+			Messages: map[string]MessageRateLimit{
+				"execute": {
+					Session:  RateLimit{PerMinute: 10, Burst: 5},
+					Identity: RateLimit{PerMinute: 20, Burst: 10},
+				},
+				"project_create": {
+					Session:  RateLimit{PerMinute: 10, Burst: 5},
+					Identity: RateLimit{PerMinute: 20, Burst: 10},
+				},
+			},
+		},
+
+		Redaction: RedactionConfig{
+			Enabled: true,
+		},
+
+		Permissions: PermissionConfig{
+			Enabled: true,
+			Timeout: Duration{2 * time.Minute},
+			Default: "deny",
+		},
+
+		Usage: UsageConfig{
+			Enabled:       true,
+			RetentionDays: 90,
+		},
+
+		Environment: EnvironmentConfig{
+			Enabled:      true,
+			MaxVariables: 100,
 		},
 	}
 }
@@ -237,19 +525,30 @@ func (c *Config) Validate() error {
 		if c.TLSCertFile == "" || c.TLSKeyFile == "" {
 			return fmt.Errorf("TLS is enabled but cert/key files not specified")
 		}
-		// Check if TLS files exist only if paths are provided
-		if c.TLSCertFile != "" {
-			if _, err := os.Stat(c.TLSCertFile); err != nil {
-				return fmt.Errorf("TLS cert file not found: %s", c.TLSCertFile)
-			}
+		// A self-signed certificate is generated on start when both files
+		// are missing; one without the other is a misconfiguration
+		_, certErr := os.Stat(c.TLSCertFile)
+		_, keyErr := os.Stat(c.TLSKeyFile)
+		if certErr != nil && keyErr == nil {
+			return fmt.Errorf("TLS cert file not found: %s", c.TLSCertFile)
 		}
-		if c.TLSKeyFile != "" {
-			if _, err := os.Stat(c.TLSKeyFile); err != nil {
-				return fmt.Errorf("TLS key file not found: %s", c.TLSKeyFile)
-			}
+		if keyErr != nil && certErr == nil {
+			return fmt.Errorf("TLS key file not found: %s", c.TLSKeyFile)
 		}
 	}
 
+	if c.TLSClientCAFile != "" {
+		if !c.TLSEnabled {
+			return fmt.Errorf("tls_client_ca_file requires TLS to be enabled")
+		}
+		if _, err := os.Stat(c.TLSClientCAFile); err != nil {
+			return fmt.Errorf("TLS client CA file not found: %s", c.TLSClientCAFile)
+		}
+	}
+	if c.TLSClientCAFile == "" && (c.TLSClientCertRequired || c.TLSClientRevocationFile != "") {
+		return fmt.Errorf("tls_client_cert_required and tls_client_revocation_file require tls_client_ca_file")
+	}
+
 	if c.DataDir == "" {
 		return fmt.Errorf("data_dir cannot be empty")
 	}
@@ -270,6 +569,12 @@ func (c *Config) Validate() error {
 	if c.WebSocket.PongTimeout.Get() < time.Second {
 		return fmt.Errorf("pong_timeout must be at least 1 second")
 	}
+	if _, err := netutil.ParseOriginPatterns(c.WebSocket.AllowedOrigins); err != nil {
+		return fmt.Errorf("allowed_origins: %w", err)
+	}
+	if _, err := netutil.ParseCIDRs(c.WebSocket.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
 
 	// Validate Execution settings
 	if c.Execution.MaxProjects < 1 {
@@ -281,15 +586,154 @@ func (c *Config) Validate() error {
 	if c.Execution.CommandTimeout.Get() < 0 {
 		return fmt.Errorf("command_timeout cannot be negative")
 	}
+	if c.Execution.MaxTimeout.Get() < 0 {
+		return fmt.Errorf("max_timeout cannot be negative")
+	}
+	if max := c.Execution.MaxTimeout.Get(); max > 0 && c.Execution.CommandTimeout.Get() > max {
+		return fmt.Errorf("command_timeout cannot exceed max_timeout")
+	}
 	if c.Execution.MaxLogSize < 1024*1024 {
 		return fmt.Errorf("max_log_size must be at least 1MB")
 	}
 	if c.Execution.MaxMessagesPerLog < 100 {
 		return fmt.Errorf("max_messages_per_log must be at least 100")
 	}
+	if c.Execution.IdleTimeout.Get() < 0 {
+		return fmt.Errorf("idle_timeout cannot be negative")
+	}
+	if c.Execution.StallTimeout.Get() < 0 {
+		return fmt.Errorf("stall_timeout cannot be negative")
+	}
+	if c.Execution.StallGrace.Get() < 0 {
+		return fmt.Errorf("stall_grace cannot be negative")
+	}
+	if c.Execution.MaxQueueLength < 0 {
+		return fmt.Errorf("max_queue_length cannot be negative")
+	}
+	if c.Execution.MaxRecords < 0 {
+		return fmt.Errorf("max_records cannot be negative")
+	}
+	if c.Execution.MaxSchedules < 0 {
+		return fmt.Errorf("max_schedules cannot be negative")
+	}
+	if c.Execution.MaxWorktrees < 0 {
+		return fmt.Errorf("max_worktrees cannot be negative")
+	}
+	if c.Execution.MaxPatchSize < 0 {
+		return fmt.Errorf("max_patch_size cannot be negative")
+	}
+	if c.Execution.MaxSessions < 0 {
+		return fmt.Errorf("max_sessions cannot be negative")
+	}
 	if c.Execution.ClaudeBinaryPath == "" {
 		return fmt.Errorf("claude_binary_path cannot be empty")
 	}
+	for name, profile := range c.Execution.Policies {
+		switch profile.Mode {
+		case "", "reject", "clamp":
+		default:
+			return fmt.Errorf("invalid mode for policy %s: %s (must be reject or clamp)", name, profile.Mode)
+		}
+		for _, root := range profile.AddDirRoots {
+			if !filepath.IsAbs(root) {
+				return fmt.Errorf("add_dir_roots for policy %s must be absolute: %s", name, root)
+			}
+		}
+		if profile.MaxTimeout.Get() < 0 {
+			return fmt.Errorf("max_timeout for policy %s cannot be negative", name)
+		}
+		if sandbox := profile.Sandbox; sandbox != nil {
+			if sandbox.MemoryMB < 0 || sandbox.CPUs < 0 || sandbox.MaxPids < 0 {
+				return fmt.Errorf("sandbox limits for policy %s cannot be negative", name)
+			}
+			for _, path := range sandbox.WritablePaths {
+				if !filepath.IsAbs(path) {
+					return fmt.Errorf("sandbox writable_paths for policy %s must be absolute: %s", name, path)
+				}
+			}
+			limited := sandbox.MemoryMB > 0 || sandbox.CPUs > 0 || sandbox.MaxPids > 0
+			if limited && c.Execution.SandboxCgroup == "" {
+				return fmt.Errorf("sandbox limits for policy %s require sandbox_cgroup", name)
+			}
+		}
+	}
+	if c.Execution.DefaultPolicy != "" {
+		if _, ok := c.Execution.Policies[c.Execution.DefaultPolicy]; !ok {
+			return fmt.Errorf("default_policy %s is not defined in policies", c.Execution.DefaultPolicy)
+		}
+	}
+	for name, backend := range c.Execution.Backends {
+		if name == "claude" {
+			return fmt.Errorf("backend name claude is reserved for the Claude CLI")
+		}
+		if backend.Command == "" {
+			return fmt.Errorf("command for backend %s cannot be empty", name)
+		}
+	}
+
+	// Validate rate limits
+	limits := map[string]MessageRateLimit{"default": c.RateLimits.Default}
+	for msgType, limit := range c.RateLimits.Messages {
+		limits[msgType] = limit
+	}
+	for name, limit := range limits {
+		for _, rate := range []RateLimit{limit.Session, limit.Identity} {
+			if rate.PerMinute < 0 || rate.Burst < 0 {
+				return fmt.Errorf("rate limit for %s cannot be negative", name)
+			}
+		}
+	}
+	if c.RateLimits.DailyExecutions < 0 {
+		return fmt.Errorf("daily_executions cannot be negative")
+	}
+	for principal, limit := range c.RateLimits.DailyExecutionOverrides {
+		if limit < 0 {
+			return fmt.Errorf("daily execution override for %s cannot be negative", principal)
+		}
+	}
+
+	// Validate redaction rules
+	for _, rule := range c.Redaction.DisabledRules {
+		if !redact.IsBuiltin(rule) {
+			return fmt.Errorf("unknown redaction rule in disabled_rules: %s", rule)
+		}
+	}
+	for _, p := range c.Redaction.Patterns {
+		if _, err := redact.NewRegexDetector(p.Name, p.Pattern); err != nil {
+			return fmt.Errorf("redaction pattern: %w", err)
+		}
+	}
+
+	// Validate permission prompts
+	if c.Permissions.Timeout.Get() < 0 {
+		return fmt.Errorf("permissions timeout cannot be negative")
+	}
+	switch c.Permissions.Default {
+	case "approve", "deny":
+	default:
+		return fmt.Errorf("invalid permissions default: %s (must be approve or deny)", c.Permissions.Default)
+	}
+
+	// Validate usage accounting
+	if c.Usage.Enabled {
+		budgets := c.Usage.Budgets
+		if c.Usage.RetentionDays < 1 {
+			return fmt.Errorf("usage retention_days must be at least 1")
+		}
+		if budgets.ProjectDailyUSD < 0 || budgets.ProjectMonthlyUSD < 0 ||
+			budgets.IdentityDailyUSD < 0 || budgets.IdentityMonthlyUSD < 0 {
+			return fmt.Errorf("usage budgets cannot be negative")
+		}
+		// Monthly budgets need the whole month's usage
+		if (budgets.ProjectMonthlyUSD > 0 || budgets.IdentityMonthlyUSD > 0) && c.Usage.RetentionDays < 31 {
+			return fmt.Errorf("monthly usage budgets require retention_days of at least 31")
+		}
+	}
+
+	// Validate project environments
+	if c.Environment.MaxVariables < 0 {
+		return fmt.Errorf("environment max_variables cannot be negative")
+	}
 
 	// Validate log level
 	validLogLevels := map[string]bool{
@@ -338,6 +782,22 @@ func (c *Config) loadFromEnv() error {
 		c.TLSKeyFile = val
 	}
 
+	if val := os.Getenv("POCKET_AGENT_TLS_CLIENT_CA_FILE"); val != "" {
+		c.TLSClientCAFile = val
+	}
+
+	if val := os.Getenv("POCKET_AGENT_TLS_CLIENT_CERT_REQUIRED"); val != "" {
+		required, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_TLS_CLIENT_CERT_REQUIRED: %w", err)
+		}
+		c.TLSClientCertRequired = required
+	}
+
+	if val := os.Getenv("POCKET_AGENT_TLS_CLIENT_REVOCATION_FILE"); val != "" {
+		c.TLSClientRevocationFile = val
+	}
+
 	if val := os.Getenv("POCKET_AGENT_DATA_DIR"); val != "" {
 		c.DataDir = val
 	}
@@ -407,6 +867,14 @@ func (c *Config) loadFromEnv() error {
 		c.WebSocket.ReadBufferSize = size
 	}
 
+	if val := os.Getenv("POCKET_AGENT_WEBSOCKET_ALLOWED_ORIGINS"); val != "" {
+		c.WebSocket.AllowedOrigins = splitList(val)
+	}
+
+	if val := os.Getenv("POCKET_AGENT_WEBSOCKET_TRUSTED_PROXIES"); val != "" {
+		c.WebSocket.TrustedProxies = splitList(val)
+	}
+
 	// Execution settings
 	if val := os.Getenv("POCKET_AGENT_EXECUTION_COMMAND_TIMEOUT"); val != "" {
 		dur, err := time.ParseDuration(val)
@@ -416,6 +884,14 @@ func (c *Config) loadFromEnv() error {
 		c.Execution.CommandTimeout = Duration{dur}
 	}
 
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_TIMEOUT"); val != "" {
+		dur, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_TIMEOUT: %w", err)
+		}
+		c.Execution.MaxTimeout = Duration{dur}
+	}
+
 	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_PROJECTS"); val != "" {
 		max, err := strconv.Atoi(val)
 		if err != nil {
@@ -444,9 +920,166 @@ func (c *Config) loadFromEnv() error {
 		c.Execution.ClaudeBinaryPath = val
 	}
 
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_IDLE_TIMEOUT"); val != "" {
+		dur, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_IDLE_TIMEOUT: %w", err)
+		}
+		c.Execution.IdleTimeout = Duration{dur}
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_STALL_TIMEOUT"); val != "" {
+		dur, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_STALL_TIMEOUT: %w", err)
+		}
+		c.Execution.StallTimeout = Duration{dur}
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_STALL_GRACE"); val != "" {
+		dur, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_STALL_GRACE: %w", err)
+		}
+		c.Execution.StallGrace = Duration{dur}
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_QUEUE_LENGTH"); val != "" {
+		max, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_QUEUE_LENGTH: %w", err)
+		}
+		c.Execution.MaxQueueLength = max
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_RECORDS"); val != "" {
+		max, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_RECORDS: %w", err)
+		}
+		c.Execution.MaxRecords = max
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_SCHEDULES"); val != "" {
+		max, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_SCHEDULES: %w", err)
+		}
+		c.Execution.MaxSchedules = max
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_WORKTREES"); val != "" {
+		max, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_WORKTREES: %w", err)
+		}
+		c.Execution.MaxWorktrees = max
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_PATCH_SIZE"); val != "" {
+		size, err := parseSize(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_PATCH_SIZE: %w", err)
+		}
+		c.Execution.MaxPatchSize = size
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_MAX_SESSIONS"); val != "" {
+		max, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_EXECUTION_MAX_SESSIONS: %w", err)
+		}
+		c.Execution.MaxSessions = max
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_DEFAULT_POLICY"); val != "" {
+		c.Execution.DefaultPolicy = val
+	}
+
+	if val := os.Getenv("POCKET_AGENT_EXECUTION_SANDBOX_CGROUP"); val != "" {
+		c.Execution.SandboxCgroup = val
+	}
+
+	// Auth settings
+	if val := os.Getenv("POCKET_AGENT_AUTH_ENABLED"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_AUTH_ENABLED: %w", err)
+		}
+		c.Auth.Enabled = enabled
+	}
+
+	// Rate limit settings
+	if val := os.Getenv("POCKET_AGENT_RATE_LIMITS_ENABLED"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_RATE_LIMITS_ENABLED: %w", err)
+		}
+		c.RateLimits.Enabled = enabled
+	}
+
+	if val := os.Getenv("POCKET_AGENT_RATE_LIMITS_DAILY_EXECUTIONS"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_RATE_LIMITS_DAILY_EXECUTIONS: %w", err)
+		}
+		c.RateLimits.DailyExecutions = limit
+	}
+
+	// Redaction settings
+	if val := os.Getenv("POCKET_AGENT_REDACTION_ENABLED"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_REDACTION_ENABLED: %w", err)
+		}
+		c.Redaction.Enabled = enabled
+	}
+
+	// Permission prompt settings
+	if val := os.Getenv("POCKET_AGENT_PERMISSIONS_ENABLED"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_PERMISSIONS_ENABLED: %w", err)
+		}
+		c.Permissions.Enabled = enabled
+	}
+
+	// Usage accounting settings
+	if val := os.Getenv("POCKET_AGENT_USAGE_ENABLED"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_USAGE_ENABLED: %w", err)
+		}
+		c.Usage.Enabled = enabled
+	}
+
+	// Project environment settings
+	if val := os.Getenv("POCKET_AGENT_ENVIRONMENT_ENABLED"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid POCKET_AGENT_ENVIRONMENT_ENABLED: %w", err)
+		}
+		c.Environment.Enabled = enabled
+	}
+
+	if val := os.Getenv("POCKET_AGENT_ENVIRONMENT_KEY_FILE"); val != "" {
+		c.Environment.KeyFile = val
+	}
+
 	return nil
 }
 
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // parseSize parses size strings like "1MB", "100KB", "1024" (bytes).
 func parseSize(s string) (int64, error) {
 	s = strings.TrimSpace(strings.ToUpper(s))
@@ -41,10 +41,19 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.WebSocket.MaxMessageSize != 1024*1024 {
 		t.Errorf("expected max message size 1MB, got %d", cfg.WebSocket.MaxMessageSize)
 	}
+	if len(cfg.WebSocket.AllowedOrigins) != 0 {
+		t.Errorf("expected no allowed origins, got %v", cfg.WebSocket.AllowedOrigins)
+	}
 
 	// Execution defaults
-	if cfg.Execution.CommandTimeout.Get() != 0 {
-		t.Errorf("expected command timeout 0 (no timeout), got %v", cfg.Execution.CommandTimeout.Get())
+	if cfg.Execution.CommandTimeout.Get() != 5*time.Minute {
+		t.Errorf("expected command timeout 5m, got %v", cfg.Execution.CommandTimeout.Get())
+	}
+	if cfg.Execution.MaxTimeout.Get() != time.Hour {
+		t.Errorf("expected max timeout 1h, got %v", cfg.Execution.MaxTimeout.Get())
+	}
+	if cfg.Execution.StallTimeout.Get() != 0 {
+		t.Errorf("expected stall timeout 0 (no watchdog), got %v", cfg.Execution.StallTimeout.Get())
 	}
 	if cfg.Execution.MaxProjects != 100 {
 		t.Errorf("expected max projects 100, got %d", cfg.Execution.MaxProjects)
@@ -52,6 +61,11 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.Execution.ClaudeBinaryPath != "claude" {
 		t.Errorf("expected claude binary path 'claude', got %s", cfg.Execution.ClaudeBinaryPath)
 	}
+
+	// Auth defaults
+	if !cfg.Auth.Enabled {
+		t.Error("expected auth to be enabled by default")
+	}
 }
 
 func TestLoadFromFile(t *testing.T) {
@@ -131,6 +145,17 @@ func TestLoadFromEnv(t *testing.T) {
 	os.Setenv("POCKET_AGENT_WEBSOCKET_PING_INTERVAL", "1m")
 	os.Setenv("POCKET_AGENT_EXECUTION_MAX_PROJECTS", "200")
 	os.Setenv("POCKET_AGENT_EXECUTION_COMMAND_TIMEOUT", "10m")
+	os.Setenv("POCKET_AGENT_EXECUTION_MAX_TIMEOUT", "2h")
+	os.Setenv("POCKET_AGENT_EXECUTION_MAX_QUEUE_LENGTH", "0")
+	os.Setenv("POCKET_AGENT_AUTH_ENABLED", "false")
+	os.Setenv("POCKET_AGENT_WEBSOCKET_ALLOWED_ORIGINS", "https://app.example.com, https://*.example.org")
+	os.Setenv("POCKET_AGENT_WEBSOCKET_TRUSTED_PROXIES", "10.0.0.0/8")
+	os.Setenv("POCKET_AGENT_RATE_LIMITS_ENABLED", "false")
+	os.Setenv("POCKET_AGENT_RATE_LIMITS_DAILY_EXECUTIONS", "25")
+	os.Setenv("POCKET_AGENT_REDACTION_ENABLED", "false")
+	os.Setenv("POCKET_AGENT_PERMISSIONS_ENABLED", "false")
+	os.Setenv("POCKET_AGENT_USAGE_ENABLED", "false")
+	os.Setenv("POCKET_AGENT_ENVIRONMENT_KEY_FILE", "/etc/pocket-agent/env.key")
 
 	tmpDir := t.TempDir()
 	cfg, err := Load("", Options{DataDir: tmpDir})
@@ -162,6 +187,39 @@ func TestLoadFromEnv(t *testing.T) {
 	if cfg.Execution.CommandTimeout.Get() != 10*time.Minute {
 		t.Errorf("expected command timeout 10m, got %v", cfg.Execution.CommandTimeout.Get())
 	}
+	if cfg.Execution.MaxTimeout.Get() != 2*time.Hour {
+		t.Errorf("expected max timeout 2h, got %v", cfg.Execution.MaxTimeout.Get())
+	}
+	if cfg.Execution.MaxQueueLength != 0 {
+		t.Errorf("expected queuing to be disabled, got max queue length %d", cfg.Execution.MaxQueueLength)
+	}
+	if cfg.Auth.Enabled {
+		t.Error("expected auth to be disabled")
+	}
+	if len(cfg.WebSocket.AllowedOrigins) != 2 || cfg.WebSocket.AllowedOrigins[1] != "https://*.example.org" {
+		t.Errorf("expected two allowed origins, got %v", cfg.WebSocket.AllowedOrigins)
+	}
+	if len(cfg.WebSocket.TrustedProxies) != 1 || cfg.WebSocket.TrustedProxies[0] != "10.0.0.0/8" {
+		t.Errorf("expected trusted proxy 10.0.0.0/8, got %v", cfg.WebSocket.TrustedProxies)
+	}
+	if cfg.RateLimits.Enabled {
+		t.Error("expected rate limits to be disabled")
+	}
+	if cfg.RateLimits.DailyExecutions != 25 {
+		t.Errorf("expected 25 daily executions, got %d", cfg.RateLimits.DailyExecutions)
+	}
+	if cfg.Redaction.Enabled {
+		t.Error("expected redaction to be disabled")
+	}
+	if cfg.Permissions.Enabled {
+		t.Error("expected permission prompts to be disabled")
+	}
+	if cfg.Usage.Enabled {
+		t.Error("expected usage accounting to be disabled")
+	}
+	if cfg.Environment.KeyFile != "/etc/pocket-agent/env.key" {
+		t.Errorf("expected environment key file /etc/pocket-agent/env.key, got %s", cfg.Environment.KeyFile)
+	}
 }
 
 func TestValidation(t *testing.T) {
@@ -248,6 +306,238 @@ func TestValidation(t *testing.T) {
 			},
 			wantErr: "claude_binary_path cannot be empty",
 		},
+		{
+			name: "client CA without TLS",
+			modify: func(c *Config) {
+				c.TLSClientCAFile = "/path/to/ca.pem"
+			},
+			wantErr: "tls_client_ca_file requires TLS to be enabled",
+		},
+		{
+			name: "client cert required without CA",
+			modify: func(c *Config) {
+				c.TLSClientCertRequired = true
+			},
+			wantErr: "require tls_client_ca_file",
+		},
+		{
+			name: "undefined default policy",
+			modify: func(c *Config) {
+				c.Execution.DefaultPolicy = "strict"
+			},
+			wantErr: "default_policy strict is not defined",
+		},
+		{
+			name: "invalid policy mode",
+			modify: func(c *Config) {
+				c.Execution.Policies = map[string]PolicyProfile{"strict": {Mode: "warn"}}
+			},
+			wantErr: "invalid mode for policy strict",
+		},
+		{
+			name: "relative add_dir root",
+			modify: func(c *Config) {
+				c.Execution.Policies = map[string]PolicyProfile{"strict": {AddDirRoots: []string{"shared"}}}
+			},
+			wantErr: "must be absolute",
+		},
+		{
+			name: "relative sandbox writable path",
+			modify: func(c *Config) {
+				c.Execution.Policies = map[string]PolicyProfile{"strict": {Sandbox: &SandboxProfile{WritablePaths: []string{"cache"}}}}
+			},
+			wantErr: "must be absolute",
+		},
+		{
+			name: "sandbox limits without cgroup",
+			modify: func(c *Config) {
+				c.Execution.Policies = map[string]PolicyProfile{"strict": {Sandbox: &SandboxProfile{MemoryMB: 2048}}}
+			},
+			wantErr: "require sandbox_cgroup",
+		},
+		{
+			name: "allowed origin with path",
+			modify: func(c *Config) {
+				c.WebSocket.AllowedOrigins = []string{"https://good.com/app"}
+			},
+			wantErr: "allowed_origins",
+		},
+		{
+			name: "invalid trusted proxy",
+			modify: func(c *Config) {
+				c.WebSocket.TrustedProxies = []string{"10.0.0.0/40"}
+			},
+			wantErr: "trusted_proxies",
+		},
+		{
+			name: "negative rate limit",
+			modify: func(c *Config) {
+				c.RateLimits.Messages["execute"] = MessageRateLimit{Session: RateLimit{PerMinute: -1}}
+			},
+			wantErr: "rate limit for execute cannot be negative",
+		},
+		{
+			name: "negative daily executions",
+			modify: func(c *Config) {
+				c.RateLimits.DailyExecutions = -1
+			},
+			wantErr: "daily_executions cannot be negative",
+		},
+		{
+			name: "negative max timeout",
+			modify: func(c *Config) {
+				c.Execution.MaxTimeout = Duration{-time.Second}
+			},
+			wantErr: "max_timeout cannot be negative",
+		},
+		{
+			name: "command timeout above max timeout",
+			modify: func(c *Config) {
+				c.Execution.CommandTimeout = Duration{2 * time.Hour}
+			},
+			wantErr: "command_timeout cannot exceed max_timeout",
+		},
+		{
+			name: "negative idle timeout",
+			modify: func(c *Config) {
+				c.Execution.IdleTimeout = Duration{-time.Second}
+			},
+			wantErr: "idle_timeout cannot be negative",
+		},
+		{
+			name: "negative stall timeout",
+			modify: func(c *Config) {
+				c.Execution.StallTimeout = Duration{-time.Second}
+			},
+			wantErr: "stall_timeout cannot be negative",
+		},
+		{
+			name: "negative stall grace",
+			modify: func(c *Config) {
+				c.Execution.StallGrace = Duration{-time.Second}
+			},
+			wantErr: "stall_grace cannot be negative",
+		},
+		{
+			name: "negative policy max timeout",
+			modify: func(c *Config) {
+				c.Execution.Policies = map[string]PolicyProfile{"strict": {MaxTimeout: Duration{-time.Second}}}
+			},
+			wantErr: "max_timeout for policy strict cannot be negative",
+		},
+		{
+			name: "negative max records",
+			modify: func(c *Config) {
+				c.Execution.MaxRecords = -1
+			},
+			wantErr: "max_records cannot be negative",
+		},
+		{
+			name: "negative max schedules",
+			modify: func(c *Config) {
+				c.Execution.MaxSchedules = -1
+			},
+			wantErr: "max_schedules cannot be negative",
+		},
+		{
+			name: "negative max worktrees",
+			modify: func(c *Config) {
+				c.Execution.MaxWorktrees = -1
+			},
+			wantErr: "max_worktrees cannot be negative",
+		},
+		{
+			name: "negative max patch size",
+			modify: func(c *Config) {
+				c.Execution.MaxPatchSize = -1
+			},
+			wantErr: "max_patch_size cannot be negative",
+		},
+		{
+			name: "negative max sessions",
+			modify: func(c *Config) {
+				c.Execution.MaxSessions = -1
+			},
+			wantErr: "max_sessions cannot be negative",
+		},
+		{
+			name: "reserved backend name",
+			modify: func(c *Config) {
+				c.Execution.Backends = map[string]BackendConfig{"claude": {Command: "claude"}}
+			},
+			wantErr: "backend name claude is reserved",
+		},
+		{
+			name: "backend without command",
+			modify: func(c *Config) {
+				c.Execution.Backends = map[string]BackendConfig{"aider": {}}
+			},
+			wantErr: "command for backend aider cannot be empty",
+		},
+		{
+			name: "usage retention too short",
+			modify: func(c *Config) {
+				c.Usage.RetentionDays = 0
+			},
+			wantErr: "retention_days must be at least 1",
+		},
+		{
+			name: "negative usage budget",
+			modify: func(c *Config) {
+				c.Usage.Budgets.IdentityDailyUSD = -1
+			},
+			wantErr: "usage budgets cannot be negative",
+		},
+		{
+			name: "monthly budget with short retention",
+			modify: func(c *Config) {
+				c.Usage.RetentionDays = 7
+				c.Usage.Budgets.ProjectMonthlyUSD = 50
+			},
+			wantErr: "monthly usage budgets require retention_days of at least 31",
+		},
+		{
+			name: "negative environment max variables",
+			modify: func(c *Config) {
+				c.Environment.MaxVariables = -1
+			},
+			wantErr: "environment max_variables cannot be negative",
+		},
+		{
+			name: "negative max queue length",
+			modify: func(c *Config) {
+				c.Execution.MaxQueueLength = -1
+			},
+			wantErr: "max_queue_length cannot be negative",
+		},
+		{
+			name: "unknown disabled redaction rule",
+			modify: func(c *Config) {
+				c.Redaction.DisabledRules = []string{"no_such_rule"}
+			},
+			wantErr: "unknown redaction rule",
+		},
+		{
+			name: "invalid redaction pattern",
+			modify: func(c *Config) {
+				c.Redaction.Patterns = []RedactionPattern{{Name: "broken", Pattern: "("}}
+			},
+			wantErr: "invalid pattern for broken",
+		},
+		{
+			name: "invalid permissions default",
+			modify: func(c *Config) {
+				c.Permissions.Default = "always_allow"
+			},
+			wantErr: "invalid permissions default",
+		},
+		{
+			name: "negative permissions timeout",
+			modify: func(c *Config) {
+				c.Permissions.Timeout = Duration{-time.Second}
+			},
+			wantErr: "permissions timeout cannot be negative",
+		},
 	}
 
 	for _, tt := range tests {
@@ -266,6 +556,36 @@ func TestValidation(t *testing.T) {
 	}
 }
 
+func TestValidateTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.TLSEnabled = true
+	cfg.TLSCertFile = filepath.Join(dir, "server.crt")
+	cfg.TLSKeyFile = filepath.Join(dir, "server.key")
+
+	// Both missing: a self-signed certificate is generated on start
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected missing cert and key to be valid, got %v", err)
+	}
+
+	// Only the certificate present
+	if err := os.WriteFile(cfg.TLSCertFile, []byte("cert"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg.Validate()
+	if err == nil || !contains(err.Error(), "TLS key file not found") {
+		t.Errorf("expected missing key error, got %v", err)
+	}
+
+	// Both present
+	if err := os.WriteFile(cfg.TLSKeyFile, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected cert and key to be valid, got %v", err)
+	}
+}
+
 func TestParseSize(t *testing.T) {
 	tests := []struct {
 		input    string
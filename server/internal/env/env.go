@@ -0,0 +1,493 @@
+// Package env keeps the environment variables of each project's executions.
+// A project's definition is persisted in its data directory next to
+// metadata.json. Secret values are encrypted with AES-256-GCM under a key
+// kept in a separate file, so the definitions alone do not reveal them, and
+// they are never returned to clients.
+package env
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+const (
+	// FileName is the name of the environment file in a project's data
+	// directory
+	FileName = "env.json"
+	// KeyFileName is the default name of the encryption key file under the
+	// data directory
+	KeyFileName = "env.key"
+
+	// keySize is the size of the AES-256 key
+	keySize = 32
+	// maxValueSize caps the size of a value
+	maxValueSize = 32 * 1024
+)
+
+// Mode controls which of the server's environment variables executions see
+type Mode string
+
+const (
+	// ModeInherit passes the server's whole environment (default)
+	ModeInherit Mode = "inherit"
+	// ModeAllowlist passes only the allowlisted server variables
+	ModeAllowlist Mode = "allowlist"
+)
+
+// DefaultAllowlist are the server variables executions see in allowlist mode
+var DefaultAllowlist = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "LC_CTYPE", "TERM", "TMPDIR", "TZ",
+}
+
+// namePattern matches valid variable names
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Config configures a Manager
+type Config struct {
+	// KeyFile holds the hex encoded encryption key. It is created on first
+	// use; empty uses KeyFileName under the data directory.
+	KeyFile string
+	// Allowlist names the server variables kept in allowlist mode; empty
+	// uses DefaultAllowlist
+	Allowlist []string
+	// MaxVariables caps the variables per project; 0 is unlimited
+	MaxVariables int
+}
+
+// Variable is an environment variable as clients see it. The values of
+// secrets are left out.
+type Variable struct {
+	Name      string    `json:"name"`
+	Value     string    `json:"value,omitempty"`
+	Secret    bool      `json:"secret"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Environment is a project's environment definition as clients see it
+type Environment struct {
+	Mode      Mode       `json:"mode"`
+	Variables []Variable `json:"variables"`
+}
+
+// variable is a variable with its value in clear
+type variable struct {
+	Variable
+	value string
+}
+
+// definition is a project's environment with secrets decrypted
+type definition struct {
+	mode      Mode
+	variables []variable
+}
+
+// storedVariable is a variable as persisted. Secrets keep only Encrypted.
+type storedVariable struct {
+	Name      string    `json:"name"`
+	Value     string    `json:"value,omitempty"`
+	Encrypted string    `json:"encrypted,omitempty"`
+	Secret    bool      `json:"secret"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// storedEnvironment is the persisted form of a definition
+type storedEnvironment struct {
+	Mode      Mode             `json:"mode"`
+	Variables []storedVariable `json:"variables"`
+}
+
+// Manager owns the environments of all projects
+type Manager struct {
+	dataDir   string
+	aead      cipher.AEAD
+	allowlist map[string]bool
+	maxVars   int
+
+	mu   sync.Mutex
+	envs map[string]*definition
+	now  func() time.Time
+}
+
+// NewManager creates a manager persisting environments under dataDir
+func NewManager(dataDir string, config Config) (*Manager, error) {
+	keyFile := config.KeyFile
+	if keyFile == "" {
+		keyFile = filepath.Join(dataDir, KeyFileName)
+	}
+	key, err := loadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+
+	names := config.Allowlist
+	if len(names) == 0 {
+		names = DefaultAllowlist
+	}
+	allowlist := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowlist[name] = true
+	}
+
+	return &Manager{
+		dataDir:   dataDir,
+		aead:      aead,
+		allowlist: allowlist,
+		maxVars:   config.MaxVariables,
+		envs:      make(map[string]*definition),
+		now:       time.Now,
+	}, nil
+}
+
+// loadKey reads the encryption key, creating it if it does not exist
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != keySize {
+			return nil, errors.New(errors.CodeValidationFailed,
+				"environment key file %s must hold %d hex encoded bytes", path, keySize)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.NewFileOperationError("read environment key", err)
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, errors.NewFileOperationError("create environment key directory", err)
+	}
+	if err := storage.WriteFileAtomic(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, errors.NewFileOperationError("write environment key", err)
+	}
+	return key, nil
+}
+
+// Get returns the project's environment without secret values
+func (m *Manager) Get(projectID string) (Environment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	def, err := m.load(projectID)
+	if err != nil {
+		return Environment{}, err
+	}
+	return def.view(), nil
+}
+
+// Set defines or replaces a variable of the project
+func (m *Manager) Set(projectID, name, value string, secret bool, by string) (Variable, error) {
+	if !namePattern.MatchString(name) {
+		return Variable{}, errors.NewValidationError("invalid environment variable name: %s", name)
+	}
+	if len(value) > maxValueSize {
+		return Variable{}, errors.NewValidationError("environment variable value exceeds %d bytes", maxValueSize)
+	}
+	if strings.ContainsRune(value, 0) {
+		return Variable{}, errors.NewValidationError("environment variable value cannot contain NUL")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	def, err := m.load(projectID)
+	if err != nil {
+		return Variable{}, err
+	}
+
+	v := variable{
+		Variable: Variable{Name: name, Secret: secret, UpdatedBy: by, UpdatedAt: m.now()},
+		value:    value,
+	}
+	if !secret {
+		v.Value = value
+	}
+
+	variables := make([]variable, 0, len(def.variables)+1)
+	replaced := false
+	for _, existing := range def.variables {
+		if existing.Name == name {
+			variables = append(variables, v)
+			replaced = true
+			continue
+		}
+		variables = append(variables, existing)
+	}
+	if !replaced {
+		if m.maxVars > 0 && len(def.variables) >= m.maxVars {
+			return Variable{}, errors.NewResourceLimitError("environment variables", m.maxVars, len(def.variables))
+		}
+		variables = append(variables, v)
+		sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	}
+
+	if err := m.save(projectID, &definition{mode: def.mode, variables: variables}); err != nil {
+		return Variable{}, err
+	}
+	return v.Variable, nil
+}
+
+// Unset removes a variable of the project
+func (m *Manager) Unset(projectID, name string) (Variable, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	def, err := m.load(projectID)
+	if err != nil {
+		return Variable{}, err
+	}
+
+	for i, v := range def.variables {
+		if v.Name != name {
+			continue
+		}
+		variables := append(append([]variable{}, def.variables[:i]...), def.variables[i+1:]...)
+		if err := m.save(projectID, &definition{mode: def.mode, variables: variables}); err != nil {
+			return Variable{}, err
+		}
+		return v.Variable, nil
+	}
+
+	return Variable{}, errors.New(errors.CodeEnvVarNotFound, "environment variable not found").
+		WithDetail("project_id", projectID).
+		WithDetail("name", name)
+}
+
+// SetMode changes which server variables the project's executions see
+func (m *Manager) SetMode(projectID string, mode Mode) (Environment, error) {
+	switch mode {
+	case ModeInherit, ModeAllowlist:
+	default:
+		return Environment{}, errors.NewValidationError("invalid environment mode %q (must be inherit or allowlist)", mode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	def, err := m.load(projectID)
+	if err != nil {
+		return Environment{}, err
+	}
+
+	updated := &definition{mode: mode, variables: def.variables}
+	if err := m.save(projectID, updated); err != nil {
+		return Environment{}, err
+	}
+	return updated.view(), nil
+}
+
+// Resolve returns the environment of an execution of the project: base,
+// reduced to the allowlist in allowlist mode, with the project's variables
+// set over it
+func (m *Manager) Resolve(projectID string, base []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	def, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	defined := make(map[string]bool, len(def.variables))
+	for _, v := range def.variables {
+		defined[v.Name] = true
+	}
+
+	environ := make([]string, 0, len(base)+len(def.variables))
+	for _, kv := range base {
+		name, _, _ := strings.Cut(kv, "=")
+		if defined[name] || (def.mode == ModeAllowlist && !m.allowlist[name]) {
+			continue
+		}
+		environ = append(environ, kv)
+	}
+	for _, v := range def.variables {
+		environ = append(environ, v.Name+"="+v.value)
+	}
+	return environ, nil
+}
+
+// Secrets returns the values of the project's secrets, for redaction
+func (m *Manager) Secrets(projectID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	def, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []string
+	for _, v := range def.variables {
+		if v.Secret && v.value != "" {
+			secrets = append(secrets, v.value)
+		}
+	}
+	return secrets, nil
+}
+
+// Forget drops the cached environment of a deleted project. The file is
+// removed with the project's data directory.
+func (m *Manager) Forget(projectID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.envs, projectID)
+}
+
+// view returns the definition as clients see it
+func (d *definition) view() Environment {
+	variables := make([]Variable, 0, len(d.variables))
+	for _, v := range d.variables {
+		variables = append(variables, v.Variable)
+	}
+	return Environment{Mode: d.mode, Variables: variables}
+}
+
+// path returns the environment file of a project
+func (m *Manager) path(projectID string) string {
+	return filepath.Join(m.dataDir, storage.ProjectsDirName, projectID, FileName)
+}
+
+// load returns the project's environment, reading it from disk on first
+// use. Callers hold m.mu.
+func (m *Manager) load(projectID string) (*definition, error) {
+	if def, ok := m.envs[projectID]; ok {
+		return def, nil
+	}
+
+	def := &definition{mode: ModeInherit}
+	data, err := os.ReadFile(m.path(projectID))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.NewFileOperationError("read environment", err)
+	default:
+		var stored storedEnvironment
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, errors.NewJSONParsingError(err)
+		}
+		if stored.Mode != "" {
+			def.mode = stored.Mode
+		}
+		for _, sv := range stored.Variables {
+			v := variable{Variable: Variable{
+				Name:      sv.Name,
+				Secret:    sv.Secret,
+				UpdatedBy: sv.UpdatedBy,
+				UpdatedAt: sv.UpdatedAt,
+			}}
+			if sv.Secret {
+				if v.value, err = m.decrypt(projectID, sv.Name, sv.Encrypted); err != nil {
+					return nil, err
+				}
+			} else {
+				v.Value = sv.Value
+				v.value = sv.Value
+			}
+			def.variables = append(def.variables, v)
+		}
+	}
+
+	m.envs[projectID] = def
+	return def, nil
+}
+
+// save persists the project's environment. Callers hold m.mu.
+func (m *Manager) save(projectID string, def *definition) error {
+	stored := storedEnvironment{Mode: def.mode, Variables: make([]storedVariable, 0, len(def.variables))}
+	for _, v := range def.variables {
+		sv := storedVariable{
+			Name:      v.Name,
+			Secret:    v.Secret,
+			UpdatedBy: v.UpdatedBy,
+			UpdatedAt: v.UpdatedAt,
+		}
+		if v.Secret {
+			encrypted, err := m.encrypt(projectID, v.Name, v.value)
+			if err != nil {
+				return err
+			}
+			sv.Encrypted = encrypted
+		} else {
+			sv.Value = v.value
+		}
+		stored.Variables = append(stored.Variables, sv)
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+
+	path := m.path(projectID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.NewFileOperationError("create environment directory", err)
+	}
+	if err := storage.WriteFileAtomic(path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write environment", err)
+	}
+
+	m.envs[projectID] = def
+	return nil
+}
+
+// encrypt seals a secret value. The project and variable name are bound to
+// the ciphertext so it cannot be moved to another variable.
+func (m *Manager) encrypt(projectID, name, value string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.NewInternalError(err)
+	}
+	sealed := m.aead.Seal(nonce, nonce, []byte(value), additionalData(projectID, name))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a secret value sealed by encrypt
+func (m *Manager) decrypt(projectID, name, encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return "", errors.New(errors.CodeInternalError, "malformed secret %s", name)
+	}
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	value, err := m.aead.Open(nil, nonce, ciphertext, additionalData(projectID, name))
+	if err != nil {
+		return "", errors.New(errors.CodeInternalError,
+			"failed to decrypt secret %s; the environment key may have changed", name).
+			WithDetail("project_id", projectID)
+	}
+	return string(value), nil
+}
+
+// additionalData binds a ciphertext to its project and variable
+func additionalData(projectID, name string) []byte {
+	return []byte(fmt.Sprintf("%s/%s", projectID, name))
+}
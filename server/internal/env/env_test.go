@@ -0,0 +1,165 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T, dataDir string, config Config) *Manager {
+	m, err := NewManager(dataDir, config)
+	require.NoError(t, err)
+	return m
+}
+
+func TestManager_SetAndGet(t *testing.T) {
+	m := newTestManager(t, t.TempDir(), Config{})
+
+	_, err := m.Set("p1", "API_TOKEN", "tok-123456", true, "device:phone")
+	require.NoError(t, err)
+	_, err = m.Set("p1", "REGION", "eu-west-1", false, "device:phone")
+	require.NoError(t, err)
+
+	env, err := m.Get("p1")
+	require.NoError(t, err)
+	assert.Equal(t, ModeInherit, env.Mode)
+	require.Len(t, env.Variables, 2)
+
+	// Secrets are listed without their values
+	assert.Equal(t, "API_TOKEN", env.Variables[0].Name)
+	assert.True(t, env.Variables[0].Secret)
+	assert.Empty(t, env.Variables[0].Value)
+	assert.Equal(t, "eu-west-1", env.Variables[1].Value)
+	assert.Equal(t, "device:phone", env.Variables[1].UpdatedBy)
+
+	// Setting a variable again replaces it
+	v, err := m.Set("p1", "REGION", "us-east-1", false, "device:tablet")
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", v.Value)
+	env, err = m.Get("p1")
+	require.NoError(t, err)
+	assert.Len(t, env.Variables, 2)
+
+	// Environments are per project
+	env, err = m.Get("p2")
+	require.NoError(t, err)
+	assert.Empty(t, env.Variables)
+}
+
+func TestManager_Validation(t *testing.T) {
+	m := newTestManager(t, t.TempDir(), Config{MaxVariables: 1})
+
+	for _, name := range []string{"", "1ABC", "A-B", "A=B"} {
+		_, err := m.Set("p1", name, "value", false, "device:phone")
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), name)
+	}
+	_, err := m.Set("p1", "NUL", "a\x00b", false, "device:phone")
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	_, err = m.Set("p1", "ONE", "1", false, "device:phone")
+	require.NoError(t, err)
+	_, err = m.Set("p1", "TWO", "2", false, "device:phone")
+	assert.True(t, errors.IsCode(err, errors.CodeResourceLimit))
+
+	// Replacing does not count against the limit
+	_, err = m.Set("p1", "ONE", "one", false, "device:phone")
+	assert.NoError(t, err)
+
+	_, err = m.SetMode("p1", "minimal")
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	_, err = m.Unset("p1", "TWO")
+	assert.True(t, errors.IsCode(err, errors.CodeEnvVarNotFound))
+}
+
+func TestManager_EncryptsSecrets(t *testing.T) {
+	dataDir := t.TempDir()
+	m := newTestManager(t, dataDir, Config{})
+
+	_, err := m.Set("p1", "API_TOKEN", "tok-very-secret", true, "device:phone")
+	require.NoError(t, err)
+	_, err = m.Set("p1", "REGION", "eu-west-1", false, "device:phone")
+	require.NoError(t, err)
+
+	// The secret is not stored in clear
+	data, err := os.ReadFile(filepath.Join(dataDir, storage.ProjectsDirName, "p1", FileName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "tok-very-secret")
+	assert.Contains(t, string(data), "eu-west-1")
+
+	// A restarted manager decrypts it with the same key
+	reloaded := newTestManager(t, dataDir, Config{})
+	secrets, err := reloaded.Secrets("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tok-very-secret"}, secrets)
+
+	// Another key cannot
+	otherKey := filepath.Join(t.TempDir(), "other.key")
+	other := newTestManager(t, dataDir, Config{KeyFile: otherKey})
+	_, err = other.Secrets("p1")
+	assert.Error(t, err)
+
+	// Neither can the ciphertext of another variable
+	stored := strings.Replace(string(data), `"API_TOKEN"`, `"OTHER_TOKEN"`, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, storage.ProjectsDirName, "p1", FileName), []byte(stored), 0o600))
+	_, err = newTestManager(t, dataDir, Config{}).Secrets("p1")
+	assert.Error(t, err)
+
+	info, err := os.Stat(filepath.Join(dataDir, KeyFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestManager_Resolve(t *testing.T) {
+	m := newTestManager(t, t.TempDir(), Config{Allowlist: []string{"PATH", "HOME"}})
+	base := []string{"PATH=/usr/bin", "HOME=/home/agent", "AWS_SECRET_ACCESS_KEY=server", "REGION=server"}
+
+	// Without variables the server environment is passed through
+	environ, err := m.Resolve("p1", base)
+	require.NoError(t, err)
+	assert.Equal(t, base, environ)
+
+	_, err = m.Set("p1", "REGION", "eu-west-1", false, "device:phone")
+	require.NoError(t, err)
+	_, err = m.Set("p1", "API_TOKEN", "tok-123456", true, "device:phone")
+	require.NoError(t, err)
+
+	// Project variables override the server's
+	environ, err = m.Resolve("p1", base)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"PATH=/usr/bin", "HOME=/home/agent", "AWS_SECRET_ACCESS_KEY=server",
+		"API_TOKEN=tok-123456", "REGION=eu-west-1",
+	}, environ)
+
+	// Allowlist mode drops the rest of the server environment
+	_, err = m.SetMode("p1", ModeAllowlist)
+	require.NoError(t, err)
+	environ, err = m.Resolve("p1", base)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"PATH=/usr/bin", "HOME=/home/agent",
+		"API_TOKEN=tok-123456", "REGION=eu-west-1",
+	}, environ)
+
+	removed, err := m.Unset("p1", "API_TOKEN")
+	require.NoError(t, err)
+	assert.Empty(t, removed.Value)
+	secrets, err := m.Secrets("p1")
+	require.NoError(t, err)
+	assert.Empty(t, secrets)
+}
+
+func TestNewManager_InvalidKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "env.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("not-a-key"), 0o600))
+
+	_, err := NewManager(t.TempDir(), Config{KeyFile: keyFile})
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
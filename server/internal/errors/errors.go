@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // ErrorCode represents standardized error codes for the WebSocket API
@@ -22,16 +23,29 @@ const (
 	CodeProcessActive   ErrorCode = "PROCESS_ACTIVE"
 
 	// Execution errors
-	CodeExecutionTimeout ErrorCode = "EXECUTION_TIMEOUT"
-	CodeClaudeNotFound   ErrorCode = "CLAUDE_NOT_FOUND"
-	CodeExecutionFailed  ErrorCode = "EXECUTION_FAILED"
-	CodeProcessNotFound  ErrorCode = "PROCESS_NOT_FOUND"
+	CodeExecutionTimeout          ErrorCode = "EXECUTION_TIMEOUT"
+	CodeExecutionStalled          ErrorCode = "EXECUTION_STALLED"
+	CodeClaudeNotFound            ErrorCode = "CLAUDE_NOT_FOUND"
+	CodeExecutionFailed           ErrorCode = "EXECUTION_FAILED"
+	CodeProcessNotFound           ErrorCode = "PROCESS_NOT_FOUND"
+	CodeQueueItemNotFound         ErrorCode = "QUEUE_ITEM_NOT_FOUND"
+	CodePermissionRequestNotFound ErrorCode = "PERMISSION_REQUEST_NOT_FOUND"
+	CodeExecutionNotFound         ErrorCode = "EXECUTION_NOT_FOUND"
+	CodeScheduleNotFound          ErrorCode = "SCHEDULE_NOT_FOUND"
+	CodeSandboxLimitExceeded      ErrorCode = "SANDBOX_LIMIT_EXCEEDED"
+	CodeEnvVarNotFound            ErrorCode = "ENV_VAR_NOT_FOUND"
+	CodeWorktreeNotFound          ErrorCode = "WORKTREE_NOT_FOUND"
+	CodeWorktreeMergeFailed       ErrorCode = "WORKTREE_MERGE_FAILED"
+	CodeSessionNotFound           ErrorCode = "SESSION_NOT_FOUND"
 
 	// Resource errors
 	CodeResourceLimit    ErrorCode = "RESOURCE_LIMIT"
 	CodeDiskSpaceLow     ErrorCode = "DISK_SPACE_LOW"
 	CodeConnectionLimit  ErrorCode = "CONNECTION_LIMIT"
 	CodeMessageSizeLimit ErrorCode = "MESSAGE_SIZE_LIMIT"
+	CodeRateLimited      ErrorCode = "RATE_LIMITED"
+	CodeQuotaExceeded    ErrorCode = "QUOTA_EXCEEDED"
+	CodeBudgetExceeded   ErrorCode = "BUDGET_EXCEEDED"
 
 	// System errors
 	CodeInternalError  ErrorCode = "INTERNAL_ERROR"
@@ -42,6 +56,7 @@ const (
 	// Permission errors
 	CodePermissionDenied ErrorCode = "PERMISSION_DENIED"
 	CodeUnauthorized     ErrorCode = "UNAUTHORIZED"
+	CodePolicyViolation  ErrorCode = "POLICY_VIOLATION"
 )
 
 // AppError represents a structured application error
@@ -278,6 +293,35 @@ func NewResourceLimitError(resource string, limit int, current int) *AppError {
 		WithDetail("current", current)
 }
 
+// NewRateLimitError creates a rate limit error with a retry hint
+func NewRateLimitError(scope string, retryAfter time.Duration) *AppError {
+	return New(CodeRateLimited, "rate limit exceeded").
+		WithDetail("scope", scope).
+		WithDetail("retry_after_ms", retryAfterMillis(retryAfter))
+}
+
+// NewQuotaExceededError creates a quota error with a retry hint
+func NewQuotaExceededError(quota string, limit int, retryAfter time.Duration) *AppError {
+	return New(CodeQuotaExceeded, "quota exceeded for %s", quota).
+		WithDetail("quota", quota).
+		WithDetail("limit", limit).
+		WithDetail("retry_after_ms", retryAfterMillis(retryAfter))
+}
+
+// NewBudgetExceededError creates a budget error with a retry hint
+func NewBudgetExceededError(budget string, limitUSD, spentUSD float64, retryAfter time.Duration) *AppError {
+	return New(CodeBudgetExceeded, "budget exceeded for %s", budget).
+		WithDetail("budget", budget).
+		WithDetail("limit_usd", limitUSD).
+		WithDetail("spent_usd", spentUSD).
+		WithDetail("retry_after_ms", retryAfterMillis(retryAfter))
+}
+
+// retryAfterMillis rounds a retry hint up to whole milliseconds
+func retryAfterMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
 // NewExecutionTimeoutError creates an execution timeout error
 func NewExecutionTimeoutError(projectID string, duration string) *AppError {
 	return New(CodeExecutionTimeout, "execution timed out after %s", duration).
@@ -285,6 +329,22 @@ func NewExecutionTimeoutError(projectID string, duration string) *AppError {
 		WithDetail("timeout", duration)
 }
 
+// NewExecutionStalledError creates an error for an execution the watchdog
+// killed after its process wrote nothing for the given duration
+func NewExecutionStalledError(projectID string, duration string) *AppError {
+	return New(CodeExecutionStalled, "execution produced no output for %s", duration).
+		WithDetail("project_id", projectID).
+		WithDetail("silent_for", duration)
+}
+
+// NewSandboxLimitError creates an error for an execution that ran into a
+// resource limit of its sandbox
+func NewSandboxLimitError(projectID string, limit string) *AppError {
+	return New(CodeSandboxLimitExceeded, "execution exceeded its sandbox %s limit", limit).
+		WithDetail("project_id", projectID).
+		WithDetail("limit", limit)
+}
+
 // NewInternalError creates an internal error with sanitized message
 func NewInternalError(cause error) *AppError {
 	// Never expose internal error details to clients
@@ -3,6 +3,7 @@ package errors
 import (
 	"errors"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -241,6 +242,33 @@ func TestCommonConstructors(t *testing.T) {
 		t.Errorf("expected limit 100, got %v", resErr.Details["limit"])
 	}
 
+	// Test NewRateLimitError
+	rateErr := NewRateLimitError("session", 1500*time.Microsecond)
+	if rateErr.Code != CodeRateLimited {
+		t.Errorf("expected code %s, got %s", CodeRateLimited, rateErr.Code)
+	}
+	if rateErr.Details["retry_after_ms"] != int64(2) {
+		t.Errorf("expected retry_after_ms rounded up to 2, got %v", rateErr.Details["retry_after_ms"])
+	}
+
+	// Test NewQuotaExceededError
+	quotaErr := NewQuotaExceededError("daily_executions", 10, time.Hour)
+	if quotaErr.Code != CodeQuotaExceeded {
+		t.Errorf("expected code %s, got %s", CodeQuotaExceeded, quotaErr.Code)
+	}
+	if quotaErr.Details["limit"] != 10 || quotaErr.Details["retry_after_ms"] != int64(3600000) {
+		t.Errorf("unexpected quota details: %v", quotaErr.Details)
+	}
+
+	// Test NewBudgetExceededError
+	budgetErr := NewBudgetExceededError("project_daily", 5, 5.25, time.Minute)
+	if budgetErr.Code != CodeBudgetExceeded {
+		t.Errorf("expected code %s, got %s", CodeBudgetExceeded, budgetErr.Code)
+	}
+	if budgetErr.Details["limit_usd"] != 5.0 || budgetErr.Details["spent_usd"] != 5.25 || budgetErr.Details["retry_after_ms"] != int64(60000) {
+		t.Errorf("unexpected budget details: %v", budgetErr.Details)
+	}
+
 	// Test NewExecutionTimeoutError
 	timeErr := NewExecutionTimeoutError("proj-123", "5m")
 	if timeErr.Code != CodeExecutionTimeout {
@@ -0,0 +1,277 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// ClaudeBackendName names the Claude CLI backend, which projects without a
+// backend use
+const ClaudeBackendName = "claude"
+
+// AgentBackend adapts a coding agent's command line interface to the
+// executor. The executor starts the command in the project directory, sends
+// the prompt on stdin and reads one JSON object per line of stdout.
+type AgentBackend interface {
+	// Command returns the executable to run
+	Command() string
+	// Args builds the command line arguments of an execution
+	Args(project *models.Project, options ExecuteOptions) []string
+	// ParseEvent interprets a line of output; ok is false for lines that
+	// are not messages, which are dropped
+	ParseEvent(obj map[string]interface{}) (event Event, ok bool)
+	// Capabilities reports the features the agent supports
+	Capabilities() Capabilities
+}
+
+// Event is what the executor needs to know about a line of agent output
+type Event struct {
+	// Type is the type of the message the line is stored and streamed as
+	Type string
+	// SessionID is the agent session the line reports, if any
+	SessionID string
+	// TurnEnd marks the last line of a turn, after which an interactive
+	// agent waits for input. Usage is then the usage of the whole turn.
+	TurnEnd bool
+	Usage   models.Usage
+	// UsageDelta is usage of a turn in progress, superseded by the turn's
+	// usage once it ends
+	UsageDelta *models.Usage
+	// Err reports that the agent failed; the execution ends with it
+	Err error
+}
+
+// Capabilities are the features of an agent backend. Executions that request
+// a feature their project's backend lacks are rejected.
+type Capabilities struct {
+	// Sessions resumes the project's session in the next execution
+	Sessions bool `json:"sessions"`
+	// ForkSessions continues a session in a new one
+	ForkSessions bool `json:"fork_sessions"`
+	// Interactive keeps stdin open for further turns
+	Interactive bool `json:"interactive"`
+	// PermissionPrompts relays tool permission prompts to clients
+	PermissionPrompts bool `json:"permission_prompts"`
+	// Models selects the model of an execution
+	Models bool `json:"models"`
+	// ToolOptions honors the tool, permission, MCP, system prompt and
+	// directory options
+	ToolOptions bool `json:"tool_options"`
+}
+
+// ClaudeBackend runs the Claude CLI with stream-json output
+type ClaudeBackend struct {
+	path string
+}
+
+// NewClaudeBackend creates the backend of the Claude CLI at path
+func NewClaudeBackend(path string) *ClaudeBackend {
+	return &ClaudeBackend{path: path}
+}
+
+// Command returns the path of the Claude CLI
+func (b *ClaudeBackend) Command() string {
+	return b.path
+}
+
+// Args builds the command line arguments for Claude
+func (b *ClaudeBackend) Args(project *models.Project, options ExecuteOptions) []string {
+	args := []string{}
+
+	// Add session ID if exists (Requirement 3.2). Worktree executions
+	// start a conversation of their own.
+	if project.SessionID != "" && options.Worktree == nil {
+		args = append(args, "-c", project.SessionID)
+		if options.ForkSession {
+			args = append(args, "--fork-session")
+		}
+	}
+
+	// Add -p flag to print response and exit (non-interactive mode)
+	args = append(args, "-p")
+
+	// Add options
+	if options.DangerouslySkipPermissions {
+		args = append(args, "--dangerously-skip-permissions")
+	}
+
+	if len(options.AllowedTools) > 0 {
+		args = append(args, "--allowed-tools", strings.Join(options.AllowedTools, ","))
+	}
+
+	if len(options.DisallowedTools) > 0 {
+		args = append(args, "--disallowed-tools", strings.Join(options.DisallowedTools, ","))
+	}
+
+	if options.MCPConfig != "" {
+		args = append(args, "--mcp-config", options.MCPConfig)
+	}
+
+	if options.AppendSystemPrompt != "" {
+		args = append(args, "--append-system-prompt", options.AppendSystemPrompt)
+	}
+
+	if options.PermissionMode != "" {
+		args = append(args, "--permission-mode", options.PermissionMode)
+	}
+
+	if options.Model != "" {
+		args = append(args, "--model", options.Model)
+	}
+
+	if options.FallbackModel != "" {
+		args = append(args, "--fallback-model", options.FallbackModel)
+	}
+
+	for _, dir := range options.AddDirs {
+		args = append(args, "--add-dir", dir)
+	}
+
+	if options.StrictMCPConfig {
+		args = append(args, "--strict-mcp-config")
+	}
+
+	// Interactive executions read user turns as JSON lines
+	if options.Interactive {
+		args = append(args, "--input-format", "stream-json")
+	}
+
+	// Add required flags for JSON output
+	args = append(args, "--verbose", "--output-format", "stream-json")
+
+	// Prompt will be sent via stdin, not as argument
+
+	return args
+}
+
+// ParseEvent interprets a stream-json event of the Claude CLI
+func (b *ClaudeBackend) ParseEvent(obj map[string]interface{}) (Event, bool) {
+	msgType, ok := obj["type"].(string)
+	if !ok {
+		return Event{}, false
+	}
+	event := Event{Type: msgType}
+
+	switch msgType {
+	case "system", "assistant", "user":
+		event.SessionID, _ = obj["session_id"].(string)
+
+	case "result":
+		event.SessionID, _ = obj["session_id"].(string)
+		event.TurnEnd = true
+		event.Usage = parseUsage(obj)
+
+	case "message_start", "content_block_start", "content_block_delta",
+		"content_block_stop", "message_stop":
+		// Streaming message events are passed on as they are
+
+	case "message_delta":
+		usage := parseUsage(obj)
+		event.UsageDelta = &usage
+
+	case "error":
+		// Check both 'message' and 'error' fields
+		if errMsg, ok := obj["message"].(string); ok {
+			event.Err = fmt.Errorf("Claude error: %s", errMsg)
+		} else if errMsg, ok := obj["error"].(string); ok {
+			event.Err = fmt.Errorf("Claude error: %s", errMsg)
+		} else {
+			event.Err = fmt.Errorf("Claude error: %v", obj)
+		}
+
+	default:
+		return Event{}, false
+	}
+
+	return event, true
+}
+
+// Capabilities reports that the Claude CLI supports every feature
+func (b *ClaudeBackend) Capabilities() Capabilities {
+	return Capabilities{
+		Sessions:          true,
+		ForkSessions:      true,
+		Interactive:       true,
+		PermissionPrompts: true,
+		Models:            true,
+		ToolOptions:       true,
+	}
+}
+
+// BackendFor returns the name and backend that run a project's executions
+func (ce *ClaudeExecutor) BackendFor(project *models.Project) (string, AgentBackend, error) {
+	name := project.GetBackend()
+	if name == "" || name == ClaudeBackendName {
+		return ClaudeBackendName, NewClaudeBackend(ce.config.ClaudePath), nil
+	}
+
+	backend, ok := ce.config.Backends[name]
+	if !ok {
+		return name, nil, errors.New(errors.CodeValidationFailed, "unknown agent backend: %s", name).
+			WithDetail("backend", name)
+	}
+	return name, backend, nil
+}
+
+// HasBackend reports whether an agent backend with the given name exists
+func (ce *ClaudeExecutor) HasBackend(name string) bool {
+	if name == ClaudeBackendName {
+		return true
+	}
+	_, ok := ce.config.Backends[name]
+	return ok
+}
+
+// Backends returns the capabilities of the agent backends by name
+func (ce *ClaudeExecutor) Backends() map[string]Capabilities {
+	backends := make(map[string]Capabilities, len(ce.config.Backends)+1)
+	backends[ClaudeBackendName] = NewClaudeBackend(ce.config.ClaudePath).Capabilities()
+	for name, backend := range ce.config.Backends {
+		backends[name] = backend.Capabilities()
+	}
+	return backends
+}
+
+// CheckCapabilities rejects options that need features the backend lacks
+func CheckCapabilities(name string, caps Capabilities, options ExecuteOptions) error {
+	var unsupported []string
+	if options.ForkSession && !caps.ForkSessions {
+		unsupported = append(unsupported, "fork_session")
+	}
+	if options.Interactive && !caps.Interactive {
+		unsupported = append(unsupported, "interactive")
+	}
+	if options.Model != "" && !caps.Models {
+		unsupported = append(unsupported, "model")
+	}
+	if !caps.ToolOptions {
+		for option, set := range map[string]bool{
+			"dangerously_skip_permissions": options.DangerouslySkipPermissions,
+			"allowed_tools":                len(options.AllowedTools) > 0,
+			"disallowed_tools":             len(options.DisallowedTools) > 0,
+			"mcp_config":                   options.MCPConfig != "",
+			"append_system_prompt":         options.AppendSystemPrompt != "",
+			"permission_mode":              options.PermissionMode != "",
+			"fallback_model":               options.FallbackModel != "",
+			"add_dirs":                     len(options.AddDirs) > 0,
+			"strict_mcp_config":            options.StrictMCPConfig,
+		} {
+			if set {
+				unsupported = append(unsupported, option)
+			}
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	sort.Strings(unsupported)
+	return errors.New(errors.CodeValidationFailed, "agent backend %s does not support %s",
+		name, strings.Join(unsupported, ", ")).
+		WithDetail("backend", name).
+		WithDetail("options", unsupported)
+}
@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+func TestJSONLBackendExecution(t *testing.T) {
+	// The mock reports its arguments, then ends its turn in a new session
+	mockPath := createMockClaude(t, `cat > /dev/null
+echo '{"kind":"status","args":"'"$*"'"}'
+echo 'not json'
+echo '{"text":"no kind"}'
+echo '{"kind":"done","conversation":"c2","usage":{"input_tokens":3,"output_tokens":4},"total_cost_usd":0.5}'
+`)
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	backend, err := NewJSONLBackend(JSONLConfig{
+		Command:        mockPath,
+		Args:           []string{"--json"},
+		ResumeArgs:     []string{"--resume={session_id}"},
+		ModelArgs:      []string{"-m", "{model}"},
+		TypeField:      "kind",
+		SessionIDField: "conversation",
+		ResultTypes:    []string{"done"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor, err := NewClaudeExecutor(Config{
+		ClaudePath: mockPath,
+		Backends:   map[string]AgentBackend{"agent": backend},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "agent-project", Path: t.TempDir(), SessionID: "c1", Backend: "agent"}
+
+	result, err := executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello", Model: "small"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SessionID != "c2" {
+		t.Errorf("expected session c2, got %q", result.SessionID)
+	}
+	if len(result.Messages) != 2 || result.Messages[0].Type != "status" || result.Messages[1].Type != "done" {
+		t.Fatalf("unexpected messages %+v", result.Messages)
+	}
+	var status map[string]string
+	if err := json.Unmarshal(result.Messages[0].Content, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status["args"] != "--json --resume=c1 -m small" {
+		t.Errorf("unexpected arguments %q", status["args"])
+	}
+	if result.Usage.InputTokens != 3 || result.Usage.OutputTokens != 4 || result.Usage.CostUSD != 0.5 {
+		t.Errorf("unexpected usage %+v", result.Usage)
+	}
+
+	// Features the agent lacks are rejected before it starts
+	_, err = executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello", Interactive: true}, nil)
+	if !errors.IsCode(err, errors.CodeValidationFailed) || !strings.Contains(err.Error(), "interactive") {
+		t.Errorf("expected interactive executions to be rejected, got %v", err)
+	}
+
+	project.Backend = "missing"
+	_, err = executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello"}, nil)
+	if !errors.IsCode(err, errors.CodeValidationFailed) {
+		t.Errorf("expected an unknown backend to be rejected, got %v", err)
+	}
+}
+
+func TestJSONLBackendParseEvent(t *testing.T) {
+	backend, err := NewJSONLBackend(JSONLConfig{Command: "agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, ok := backend.ParseEvent(map[string]interface{}{"type": "error", "message": "boom", "session_id": "s1"})
+	if !ok || event.Err == nil || event.Err.Error() != "agent error: boom" || event.SessionID != "s1" {
+		t.Errorf("unexpected error event %+v", event)
+	}
+	if _, ok := backend.ParseEvent(map[string]interface{}{"type": 1}); ok {
+		t.Error("expected a line without a string type to be dropped")
+	}
+	if caps := backend.Capabilities(); caps != (Capabilities{}) {
+		t.Errorf("expected no capabilities without templates, got %+v", caps)
+	}
+
+	if _, err := NewJSONLBackend(JSONLConfig{}); err == nil {
+		t.Error("expected a backend without command to be rejected")
+	}
+	if _, err := NewClaudeExecutor(Config{ClaudePath: "sh", Backends: map[string]AgentBackend{ClaudeBackendName: backend}}); err == nil {
+		t.Error("expected the claude backend name to be reserved")
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	options := ExecuteOptions{
+		Prompt:       "hello",
+		ForkSession:  true,
+		AllowedTools: []string{"Read"},
+	}
+
+	if err := CheckCapabilities(ClaudeBackendName, NewClaudeBackend("claude").Capabilities(), options); err != nil {
+		t.Errorf("expected Claude to support all options, got %v", err)
+	}
+
+	err := CheckCapabilities("agent", Capabilities{Sessions: true}, options)
+	if err == nil || !strings.Contains(err.Error(), "agent backend agent does not support allowed_tools, fork_session") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package executor
+
+import (
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/workspace"
+)
+
+// snapshotWorkspace records the state of an execution's working directory
+// before it starts. It returns nil when changes are not captured or the
+// snapshot failed; the execution runs regardless.
+func (ce *ClaudeExecutor) snapshotWorkspace(project *models.Project, dir string) *workspace.Snapshot {
+	if ce.config.Workspace == nil {
+		return nil
+	}
+	snapshot, err := ce.config.Workspace.Snapshot(dir)
+	if err != nil {
+		ce.logger.Warn("Failed to snapshot workspace, changes will not be captured",
+			"project_id", project.ID,
+			"dir", dir,
+			"error", err)
+		return nil
+	}
+	return snapshot
+}
+
+// captureChanges adds what an execution changed since before to its
+// result. The patch is redacted like the message log.
+func (ce *ClaudeExecutor) captureChanges(project *models.Project, before *workspace.Snapshot, result *ExecuteResult) {
+	if before == nil {
+		return
+	}
+	changes, patch, err := ce.config.Workspace.Changes(before)
+	if err != nil {
+		ce.logger.Warn("Failed to capture workspace changes",
+			"project_id", project.ID,
+			"error", err)
+		return
+	}
+	result.Changes = &changes
+	result.Patch, _ = ce.redact(project, patch)
+}
@@ -20,7 +20,9 @@ import (
 
 // ExecuteOptions contains options for Claude execution
 type ExecuteOptions struct {
-	Prompt                     string
+	Prompt string
+	// Timeout ends the execution; 0 uses the default timeout. It does not
+	// apply to interactive executions.
 	Timeout                    time.Duration
 	DangerouslySkipPermissions bool
 	AllowedTools               []string
@@ -32,6 +34,29 @@ type ExecuteOptions struct {
 	FallbackModel              string
 	AddDirs                    []string
 	StrictMCPConfig            bool
+
+	// Interactive keeps stdin open for follow-up turns sent with SendInput.
+	// The process exits after IdleTimeout without input, or when its input
+	// is closed or it is killed.
+	Interactive bool
+	IdleTimeout time.Duration
+
+	// OnStall is called when the process wrote nothing to stdout for the
+	// stall timeout; it is killed after the stall grace period unless it
+	// writes again
+	OnStall func(Stall)
+
+	// Worktree runs the execution in a git worktree of the project instead
+	// of its directory. Such executions start a new conversation and may
+	// run alongside the project's other executions.
+	Worktree *models.Worktree
+
+	// ForkSession continues the project's session in a new session instead
+	// of appending to it, leaving the original to be resumed later
+	ForkSession bool
+
+	// ExecutionID identifies the execution's record, if one is kept
+	ExecutionID string
 }
 
 // ExecuteResult contains the result of a Claude execution
@@ -42,6 +67,15 @@ type ExecuteResult struct {
 	Stdout        string
 	Stderr        string
 	ExecutionTime time.Duration
+	// Logged is the part of the message log the execution wrote
+	Logged models.MessageRange
+	// Usage is the tokens and cost the execution reported, including those
+	// of turns a kill or timeout cut short
+	Usage models.Usage
+	// Changes is what the execution changed in its working directory and
+	// Patch their unified diff; Changes is nil when they were not captured
+	Changes *models.WorkspaceChanges
+	Patch   string
 }
 
 // executeInternalWithStreaming runs Claude with streaming output support
@@ -58,24 +92,71 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 		return nil, errors.NewValidationError("prompt cannot be empty")
 	}
 
+	// Enforce the project's policy profile before anything is started
+	if _, err := ce.ApplyPolicy(project, &options); err != nil {
+		return nil, err
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = ce.config.DefaultTimeout
+	}
+
 	// Use the project's existing message log
 	messageLog := project.MessageLog
 
-	// Create timeout context (Requirement 3.5)
-	ctx, cancel := ce.createTimeoutContext(options.Timeout)
+	// Cancel the execution once it ran for its timeout (Requirement 3.5).
+	// Interactive executions run until their input ends instead.
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	timeout := options.Timeout
+	if options.Interactive {
+		timeout = 0
+	}
+	deadline := newDeadline(ce.config.Clock, timeout, cancel)
+	defer deadline.stop()
 
-	// Build Claude command arguments
-	args := ce.buildCommandArgs(project, options)
+	// Build the command arguments of the project's agent
+	_, backend, err := ce.BackendFor(project)
+	if err != nil {
+		return nil, err
+	}
+	args := backend.Args(project, options)
+
+	// The project's policy may confine the process to a sandbox
+	sandbox, err := ce.sandboxFor(project, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relay permission prompts to the project's clients, unless the agent
+	// cannot ask, permission checks are skipped altogether or the sandbox
+	// cannot reach the relay
+	if ce.config.Permissions != nil && backend.Capabilities().PermissionPrompts && !skipsPermissions(options) &&
+		(sandbox == nil || !sandbox.IsolateNetwork) {
+		grant, err := ce.config.Permissions.Grant(project.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer grant.Close()
+		args = append(args, grant.Args()...)
+	}
 
 	// Create command
-	cmd := exec.CommandContext(ctx, ce.config.ClaudePath, args...)
+	cmd := exec.CommandContext(ctx, backend.Command(), args...)
 
-	// Set working directory to project path
+	// Set working directory to project path, or its worktree
 	cmd.Dir = project.Path
+	var worktreeID string
+	if options.Worktree != nil {
+		cmd.Dir = options.Worktree.Dir
+		worktreeID = options.Worktree.ID
+	}
 
-	// Set environment
-	cmd.Env = append(os.Environ(),
+	// Set environment: the project's variables over the server's
+	environ, err := ce.environment(project)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(environ,
 		"NO_COLOR=1", // Disable color output for cleaner parsing
 	)
 
@@ -92,6 +173,19 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 		platform.SetupLinuxProcess(cmd)
 	}
 
+	var cgroup *platform.SandboxCgroup
+	if sandbox != nil {
+		cgroup, err = ce.setupSandbox(cmd, project, sandbox)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := cgroup.Close(); err != nil {
+				ce.logger.Warn("Failed to remove sandbox cgroup", "project_id", project.ID, "error", err)
+			}
+		}()
+	}
+
 	// Get stdout pipe for streaming
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -110,54 +204,98 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 
 	// Create process info
 	processInfo := &ProcessInfo{
-		Cmd:       cmd,
-		ProjectID: project.ID,
-		StartTime: time.Now(),
-		Context:   ctx,
-		Cancel:    cancel,
+		Cmd:        cmd,
+		ProjectID:  project.ID,
+		WorktreeID: worktreeID,
+		StartTime:  time.Now(),
+		Context:    ctx,
+		Cancel:     cancel,
+		logged:     &messageRange{},
+		usage:      &usageMeter{},
+		deadline:   deadline,
+		exited:     make(chan struct{}),
+	}
+
+	// Interactive executions keep stdin open for later turns
+	if options.Interactive {
+		idleTimeout := options.IdleTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = ce.config.IdleTimeout
+		}
+		processInfo.input = newInputStream(stdin, idleTimeout, func() {
+			ce.logger.Info("Closing idle Claude execution",
+				"project_id", project.ID,
+				"idle_timeout", idleTimeout)
+			processInfo.input.close()
+		})
+		defer processInfo.input.close()
 	}
 
+	// Warn about a process that stops writing output, then kill it
+	key := ProcessKey(project.ID, worktreeID)
+	processInfo.watchdog = newWatchdog(ce.config.Clock, ce.config.StallTimeout, ce.config.StallGrace,
+		func(since, killAt time.Time) {
+			ce.logger.Warn("Claude execution stalled",
+				"project_id", project.ID,
+				"worktree_id", worktreeID,
+				"silent_since", since,
+				"kill_at", killAt)
+			if options.OnStall != nil {
+				options.OnStall(Stall{ProjectID: project.ID, WorktreeID: worktreeID, Since: since, KillAt: killAt})
+			}
+		},
+		func() {
+			ce.logger.Warn("Killing stalled Claude execution",
+				"project_id", project.ID,
+				"worktree_id", worktreeID)
+			// The process is reaped by the Wait below
+			if err := platform.KillProcessGroup(cmd); err != nil {
+				ce.logger.Error("Failed to kill stalled Claude execution", "project_id", project.ID, "error", err)
+			}
+		})
+	defer processInfo.watchdog.stop()
+
 	// Register process (Requirement 3.1)
-	if err := ce.registerProcess(project.ID, processInfo); err != nil {
+	if err := ce.registerProcess(key, processInfo); err != nil {
 		return nil, err
 	}
 
 	// Ensure cleanup happens
-	defer ce.cleanupProcess(project.ID, processInfo)
+	defer ce.cleanupProcess(key, processInfo)
 
 	ce.logger.Info("Starting Claude execution",
 		"project_id", project.ID,
+		"worktree_id", worktreeID,
 		"session_id", project.SessionID,
 		"prompt_length", len(options.Prompt))
 
+	// Remember the working directory to tell what the execution changed
+	snapshot := ce.snapshotWorkspace(project, cmd.Dir)
+
 	// Start the process
 	startTime := time.Now()
 	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start Claude: %w", err)
 	}
+	ce.markRunning(project, options, worktreeID, cmd.Process.Pid)
+	defer ce.clearRunning(project, worktreeID)
 
-	// Log the user prompt first
-	if messageLog != nil {
-		userMsg := models.TimestampedMessage{
-			Timestamp: time.Now(),
-			Message: models.ClaudeMessage{
-				Type:    "user",
-				Content: json.RawMessage(fmt.Sprintf(`{"text":%q}`, options.Prompt)),
-			},
-			Direction: "client",
-		}
-		if err := messageLog.Append(userMsg); err != nil {
-			ce.logger.Error("Failed to log user prompt", "error", err)
-		}
-	}
+	// Log the user prompt first; Claude receives it unredacted
+	ce.logPrompt(project, options.Prompt, processInfo)
 
 	// Send prompt via stdin
-	go func() {
-		defer stdin.Close()
-		if _, err := stdin.Write([]byte(options.Prompt)); err != nil {
+	if processInfo.input != nil {
+		if err := processInfo.input.send(options.Prompt); err != nil {
 			ce.logger.Error("Failed to write prompt to stdin", "error", err)
 		}
-	}()
+	} else {
+		go func() {
+			defer stdin.Close()
+			if _, err := stdin.Write([]byte(options.Prompt)); err != nil {
+				ce.logger.Error("Failed to write prompt to stdin", "error", err)
+			}
+		}()
+	}
 
 	// Channels for streaming results
 	messagesChan := make(chan models.ClaudeMessage, 100)
@@ -170,6 +308,7 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 		defer close(messagesChan)
 		scanner := bufio.NewScanner(stdoutPipe)
 		for scanner.Scan() {
+			processInfo.watchdog.output()
 			line := scanner.Text()
 			if line == "" {
 				continue
@@ -178,137 +317,71 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 			// Parse each line as a JSON object
 			var obj map[string]interface{}
 			if err := json.Unmarshal([]byte(line), &obj); err != nil {
+				redactedLine, _ := ce.redact(project, line)
 				ce.logger.Debug("Failed to parse line as JSON",
-					"line", line,
+					"line", redactedLine,
 					"error", err)
 				continue
 			}
 
-			// Extract message type
-			msgType, ok := obj["type"].(string)
+			// Remove secrets before the message is stored or streamed
+			obj, redactions := ce.redactObject(project, obj)
+
+			// Let the agent's backend interpret the line
+			event, ok := backend.ParseEvent(obj)
 			if !ok {
+				// Log unknown message types for debugging
+				ce.logger.Debug("Unknown message type", "type", obj["type"], "object", obj)
 				continue
 			}
 
-			// Handle different message types
-			switch msgType {
-			case "system":
-				// Extract session ID from system messages
-				if sid, ok := obj["session_id"].(string); ok && sid != "" {
-					sessionIDMutex.Lock()
-					sessionID = sid
-					sessionIDMutex.Unlock()
-				}
-				// Store and stream system messages
-				content, _ := json.Marshal(obj)
-				msg := models.ClaudeMessage{
-					Type:    msgType,
-					Content: json.RawMessage(content),
-				}
-				messagesChan <- msg
-				if callback != nil {
-					callback(msg)
-				}
-				// Log the message
-				if messageLog != nil {
-					timestampedMsg := models.TimestampedMessage{
-						Timestamp: time.Now(),
-						Message:   msg,
-						Direction: "claude",
-					}
-					if err := messageLog.Append(timestampedMsg); err != nil {
-						ce.logger.Error("Failed to log Claude message", "error", err, "type", msgType)
-					}
-				}
+			if event.SessionID != "" {
+				sessionIDMutex.Lock()
+				sessionID = event.SessionID
+				sessionIDMutex.Unlock()
+			}
+			if event.UsageDelta != nil {
+				processInfo.usage.delta(*event.UsageDelta)
+			}
 
-			case "assistant", "user", "result":
-				// Store and stream these message types
-				content, _ := json.Marshal(obj)
-				msg := models.ClaudeMessage{
-					Type:    msgType,
-					Content: json.RawMessage(content),
-				}
-				messagesChan <- msg
-				if callback != nil {
-					callback(msg)
-				}
-				// Log the message
-				if messageLog != nil {
-					timestampedMsg := models.TimestampedMessage{
-						Timestamp: time.Now(),
-						Message:   msg,
-						Direction: "claude",
-					}
-					if err := messageLog.Append(timestampedMsg); err != nil {
-						ce.logger.Error("Failed to log Claude message", "error", err, "type", msgType)
-					}
+			// Store and stream the message
+			content, _ := json.Marshal(obj)
+			msg := models.ClaudeMessage{
+				Type:       event.Type,
+				Content:    json.RawMessage(content),
+				Redactions: redactions,
+				WorktreeID: worktreeID,
+			}
+			messagesChan <- msg
+			if callback != nil {
+				callback(msg)
+			}
+			// Log the message
+			if messageLog != nil {
+				timestampedMsg := models.TimestampedMessage{
+					Timestamp: time.Now(),
+					Message:   msg,
+					Direction: "claude",
 				}
-				// Also check for session_id in any message
-				if sid, ok := obj["session_id"].(string); ok && sid != "" {
-					sessionIDMutex.Lock()
-					sessionID = sid
-					sessionIDMutex.Unlock()
+				if err := messageLog.Append(timestampedMsg); err != nil {
+					ce.logger.Error("Failed to log Claude message", "error", err, "type", event.Type)
+				} else {
+					processInfo.logged.add(timestampedMsg.Timestamp)
 				}
+			}
 
-			case "message_start", "content_block_start", "content_block_delta",
-				"content_block_stop", "message_delta", "message_stop":
-				// These are streaming message events - store and stream the raw JSON
-				content, _ := json.Marshal(obj)
-				msg := models.ClaudeMessage{
-					Type:    msgType,
-					Content: json.RawMessage(content),
-				}
-				messagesChan <- msg
-				if callback != nil {
-					callback(msg)
-				}
-				// Log the message
-				if messageLog != nil {
-					timestampedMsg := models.TimestampedMessage{
-						Timestamp: time.Now(),
-						Message:   msg,
-						Direction: "claude",
-					}
-					if err := messageLog.Append(timestampedMsg); err != nil {
-						ce.logger.Error("Failed to log Claude message", "error", err, "type", msgType)
-					}
+			if event.TurnEnd {
+				processInfo.usage.result(event.Usage)
+				// An interactive execution waits for the next turn, silently
+				if processInfo.input != nil {
+					processInfo.input.idle()
+					processInfo.watchdog.pause()
 				}
+			}
 
-			case "error":
-				// Store error message
-				content, _ := json.Marshal(obj)
-				msg := models.ClaudeMessage{
-					Type:    msgType,
-					Content: json.RawMessage(content),
-				}
-				messagesChan <- msg
-				if callback != nil {
-					callback(msg)
-				}
-				// Log the error message
-				if messageLog != nil {
-					timestampedMsg := models.TimestampedMessage{
-						Timestamp: time.Now(),
-						Message:   msg,
-						Direction: "claude",
-					}
-					if err := messageLog.Append(timestampedMsg); err != nil {
-						ce.logger.Error("Failed to log Claude error", "error", err)
-					}
-				}
-				// Also send to error channel - check both 'message' and 'error' fields
-				if errMsg, ok := obj["message"].(string); ok {
-					errorChan <- fmt.Errorf("Claude error: %s", errMsg)
-				} else if errMsg, ok := obj["error"].(string); ok {
-					errorChan <- fmt.Errorf("Claude error: %s", errMsg)
-				} else {
-					errorChan <- fmt.Errorf("Claude error: %v", obj)
-				}
+			if event.Err != nil {
+				errorChan <- event.Err
 				return
-
-			default:
-				// Log unknown message types for debugging
-				ce.logger.Debug("Unknown message type", "type", msgType, "object", obj)
 			}
 		}
 
@@ -317,23 +390,34 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 		}
 	}()
 
+	// Collect all messages until stdout is drained. This must happen before
+	// cmd.Wait, which closes the stdout pipe once the process exits.
+	var messages []models.ClaudeMessage
+	for msg := range messagesChan {
+		messages = append(messages, msg)
+	}
+
 	// Wait for completion
 	err = cmd.Wait()
+	close(processInfo.exited)
 	executionTime := time.Since(startTime)
 
-	// Capture stderr
-	stderr := stderrBuf.String()
+	// Capture stderr, which is logged and returned to callers
+	stderr, _ := ce.redact(project, stderrBuf.String())
 
 	// Build result
 	result := &ExecuteResult{
 		Stderr:        stderr,
 		ExecutionTime: executionTime,
+		Logged:        processInfo.logged.get(),
+		Usage:         processInfo.usage.get(),
 	}
+	ce.captureChanges(project, snapshot, result)
 
-	// Collect all messages
-	var messages []models.ClaudeMessage
-	for msg := range messagesChan {
-		messages = append(messages, msg)
+	// A killed process breaks the stream, so the stall is checked first
+	if processInfo.watchdog.killed() {
+		result.ExitCode = -1
+		return result, errors.NewExecutionStalledError(project.ID, processInfo.watchdog.silence().String())
 	}
 
 	// Check for streaming errors
@@ -348,8 +432,9 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 
 	// Handle execution errors
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, errors.NewExecutionTimeoutError(project.ID, options.Timeout.String())
+		if deadline.exceeded() {
+			result.ExitCode = -1
+			return result, errors.NewExecutionTimeoutError(project.ID, options.Timeout.String())
 		}
 
 		// Get exit code if available
@@ -359,6 +444,15 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 			result.ExitCode = -1
 		}
 
+		// Report executions the sandbox stopped apart from ordinary failures
+		if limit := cgroup.LimitExceeded(); limit != "" {
+			ce.logger.Warn("Claude execution exceeded its sandbox limit",
+				"project_id", project.ID,
+				"limit", limit,
+				"exit_code", result.ExitCode)
+			return result, errors.NewSandboxLimitError(project.ID, limit)
+		}
+
 		ce.logger.Error("Claude execution failed",
 			"project_id", project.ID,
 			"error", err,
@@ -381,70 +475,24 @@ func (ce *ClaudeExecutor) executeInternalWithStreaming(
 		"project_id", project.ID,
 		"session_id", result.SessionID,
 		"message_count", len(messages),
+		"cost_usd", result.Usage.CostUSD,
 		"execution_time", executionTime)
 
 	return result, nil
 }
 
-// buildCommandArgs builds the command line arguments for Claude
-func (ce *ClaudeExecutor) buildCommandArgs(project *models.Project, options ExecuteOptions) []string {
-	args := []string{}
-
-	// Add session ID if exists (Requirement 3.2)
-	if project.SessionID != "" {
-		args = append(args, "-c", project.SessionID)
-	}
-
-	// Add -p flag to print response and exit (non-interactive mode)
-	args = append(args, "-p")
-
-	// Add options
-	if options.DangerouslySkipPermissions {
-		args = append(args, "--dangerously-skip-permissions")
-	}
-
-	if len(options.AllowedTools) > 0 {
-		args = append(args, "--allowed-tools", strings.Join(options.AllowedTools, ","))
+// environment returns the environment of an execution of project
+func (ce *ClaudeExecutor) environment(project *models.Project) ([]string, error) {
+	if ce.config.Environment == nil {
+		return os.Environ(), nil
 	}
+	return ce.config.Environment.Resolve(project.ID, os.Environ())
+}
 
-	if len(options.DisallowedTools) > 0 {
-		args = append(args, "--disallowed-tools", strings.Join(options.DisallowedTools, ","))
-	}
-
-	if options.MCPConfig != "" {
-		args = append(args, "--mcp-config", options.MCPConfig)
-	}
-
-	if options.AppendSystemPrompt != "" {
-		args = append(args, "--append-system-prompt", options.AppendSystemPrompt)
-	}
-
-	if options.PermissionMode != "" {
-		args = append(args, "--permission-mode", options.PermissionMode)
-	}
-
-	if options.Model != "" {
-		args = append(args, "--model", options.Model)
-	}
-
-	if options.FallbackModel != "" {
-		args = append(args, "--fallback-model", options.FallbackModel)
-	}
-
-	for _, dir := range options.AddDirs {
-		args = append(args, "--add-dir", dir)
-	}
-
-	if options.StrictMCPConfig {
-		args = append(args, "--strict-mcp-config")
-	}
-
-	// Add required flags for JSON output
-	args = append(args, "--verbose", "--output-format", "stream-json")
-
-	// Prompt will be sent via stdin, not as argument
-
-	return args
+// skipsPermissions reports whether the options turn off permission checks,
+// leaving no prompts to relay
+func skipsPermissions(options ExecuteOptions) bool {
+	return options.DangerouslySkipPermissions || options.PermissionMode == bypassPermissionMode
 }
 
 // ClaudeOutput represents the JSON output from Claude CLI
@@ -465,6 +513,7 @@ func (ce *ClaudeExecutor) parseClaudeOutput(output string) ([]models.ClaudeMessa
 
 	var messages []models.ClaudeMessage
 	var sessionID string
+	backend := NewClaudeBackend(ce.config.ClaudePath)
 
 	// Parse JSONL format - each line is a separate JSON object
 	lines := strings.Split(output, "\n")
@@ -484,57 +533,25 @@ func (ce *ClaudeExecutor) parseClaudeOutput(output string) ([]models.ClaudeMessa
 			continue
 		}
 
-		// Extract message type
-		msgType, ok := obj["type"].(string)
+		event, ok := backend.ParseEvent(obj)
 		if !ok {
+			// Log unknown message types for debugging
+			ce.logger.Debug("Unknown message type", "type", obj["type"], "object", obj)
 			continue
 		}
-
-		// Handle different message types
-		switch msgType {
-		case "system":
-			// Extract session ID from system messages
-			if sid, ok := obj["session_id"].(string); ok && sid != "" {
-				sessionID = sid
-			}
-			// Also store system messages
-			content, _ := json.Marshal(obj)
-			messages = append(messages, models.ClaudeMessage{
-				Type:    msgType,
-				Content: json.RawMessage(content),
-			})
-		case "assistant", "user", "result":
-			// Store these message types
-			content, _ := json.Marshal(obj)
-			messages = append(messages, models.ClaudeMessage{
-				Type:    msgType,
-				Content: json.RawMessage(content),
-			})
-			// Also check for session_id in any message
-			if sid, ok := obj["session_id"].(string); ok && sid != "" {
-				sessionID = sid
-			}
-		case "message_start", "content_block_start", "content_block_delta",
-			"content_block_stop", "message_delta", "message_stop":
-			// These are streaming message events - store the raw JSON
-			content, _ := json.Marshal(obj)
-			messages = append(messages, models.ClaudeMessage{
-				Type:    msgType,
-				Content: json.RawMessage(content),
-			})
-		case "error":
-			// Handle error messages - check both 'message' and 'error' fields
-			if errMsg, ok := obj["message"].(string); ok {
-				return nil, "", fmt.Errorf("Claude error: %s", errMsg)
-			}
-			if errMsg, ok := obj["error"].(string); ok {
-				return nil, "", fmt.Errorf("Claude error: %s", errMsg)
-			}
-			return nil, "", fmt.Errorf("Claude error: %v", obj)
-		default:
-			// Log unknown message types for debugging
-			ce.logger.Debug("Unknown message type", "type", msgType, "object", obj)
+		if event.Err != nil {
+			return nil, "", event.Err
+		}
+		if event.SessionID != "" {
+			sessionID = event.SessionID
 		}
+
+		// Store the raw JSON
+		content, _ := json.Marshal(obj)
+		messages = append(messages, models.ClaudeMessage{
+			Type:    event.Type,
+			Content: json.RawMessage(content),
+		})
 	}
 
 	if len(messages) == 0 && sessionID == "" {
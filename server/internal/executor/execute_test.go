@@ -165,7 +165,7 @@ echo '{"type": "assistant", "content": {"text": "Hello"}}'
 }
 
 func TestBuildCommandArgs(t *testing.T) {
-	ce := &ClaudeExecutor{}
+	backend := NewClaudeBackend("claude")
 
 	project := &models.Project{
 		ID:        "test-project",
@@ -195,6 +195,15 @@ func TestBuildCommandArgs(t *testing.T) {
 			},
 			expected: []string{"-c", "test-session", "-p", "--verbose", "--output-format", "stream-json"},
 		},
+		{
+			name:    "forking the session",
+			project: project,
+			options: ExecuteOptions{
+				Prompt:      "Hello",
+				ForkSession: true,
+			},
+			expected: []string{"-c", "test-session", "--fork-session", "-p", "--verbose", "--output-format", "stream-json"},
+		},
 		{
 			name:    "with all options",
 			project: project,
@@ -233,7 +242,7 @@ func TestBuildCommandArgs(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			args := ce.buildCommandArgs(tt.project, tt.options)
+			args := backend.Args(tt.project, tt.options)
 
 			if len(args) != len(tt.expected) {
 				t.Errorf("expected %d args, got %d\nExpected: %v\nGot: %v",
@@ -470,3 +479,58 @@ func TestExecuteProcessCleanup(t *testing.T) {
 		t.Error("expected process to be cleaned up after execution")
 	}
 }
+
+func TestExecuteInWorktree(t *testing.T) {
+	// Mock that reports its directory and arguments
+	script := `
+sleep 0.3
+echo "{\"type\": \"system\", \"session_id\": \"wt\", \"cwd\": \"$(pwd)\", \"args\": \"$*\"}"
+`
+	mockPath := createMockClaude(t, script)
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	ce := &ClaudeExecutor{
+		activeProcesses: make(map[string]*ProcessInfo),
+		config: Config{
+			ClaudePath:              mockPath,
+			DefaultTimeout:          5 * time.Second,
+			MaxConcurrentExecutions: 10,
+		},
+		logger: logger.New("info"),
+	}
+
+	project := &models.Project{ID: "p1", Path: t.TempDir(), SessionID: "main-session"}
+	worktree := &models.Worktree{ID: "wt-1", Dir: t.TempDir()}
+
+	// The project's own execution and one in a worktree run side by side
+	done := make(chan error, 1)
+	go func() {
+		_, err := ce.ExecuteWithOptions(project, ExecuteOptions{Prompt: "main"})
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	result, err := ce.ExecuteWithOptions(project, ExecuteOptions{Prompt: "experiment", Worktree: worktree})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(result.Messages))
+	}
+	msg := result.Messages[0]
+	if msg.WorktreeID != "wt-1" {
+		t.Errorf("expected message of worktree wt-1, got %q", msg.WorktreeID)
+	}
+	content := string(msg.Content)
+	if !strings.Contains(content, `"cwd":"`+worktree.Dir+`"`) {
+		t.Errorf("expected execution in %s, got %s", worktree.Dir, content)
+	}
+	// Worktree executions start a conversation of their own
+	if strings.Contains(content, "main-session") {
+		t.Errorf("expected no resumed session, got %s", content)
+	}
+}
@@ -7,9 +7,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/boyd/pocket_agent/server/internal/env"
 	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+	"github.com/boyd/pocket_agent/server/internal/redact"
 	"github.com/boyd/pocket_agent/server/internal/storage"
+	"github.com/boyd/pocket_agent/server/internal/workspace"
 )
 
 // StorageFactory interface for creating storage components
@@ -19,7 +25,7 @@ type StorageFactory interface {
 
 // ClaudeExecutor manages Claude CLI process execution
 type ClaudeExecutor struct {
-	// activeProcesses tracks running processes by project ID
+	// activeProcesses tracks running processes by their ProcessKey
 	activeProcesses map[string]*ProcessInfo
 	// mu protects concurrent access to activeProcesses
 	mu sync.Mutex
@@ -35,9 +41,52 @@ type ClaudeExecutor struct {
 type ProcessInfo struct {
 	Cmd       *exec.Cmd
 	ProjectID string
-	StartTime time.Time
-	Context   context.Context
-	Cancel    context.CancelFunc
+	// WorktreeID identifies the worktree the process runs in, if any
+	WorktreeID string
+	StartTime  time.Time
+	Context    context.Context
+	Cancel     context.CancelFunc
+
+	// input is the open stdin of an interactive execution
+	input *inputStream
+	// logged tracks the messages the execution wrote to the message log
+	logged *messageRange
+	// usage accumulates the tokens and cost the execution reported
+	usage *usageMeter
+	// watchdog kills the process once it stalls
+	watchdog *watchdog
+	// deadline ends the execution once it ran for its timeout
+	deadline *deadline
+	// exited is closed once the process was waited for
+	exited chan struct{}
+	// paused is set while the process is stopped by PauseExecution, since
+	// pausedAt. Guarded by the executor's mu.
+	paused   bool
+	pausedAt time.Time
+}
+
+// messageRange accumulates the message log range of an execution
+type messageRange struct {
+	mu sync.Mutex
+	r  models.MessageRange
+}
+
+// add records a message logged at ts
+func (m *messageRange) add(ts time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.r.Count == 0 {
+		m.r.From = ts
+	}
+	m.r.To = ts
+	m.r.Count++
+}
+
+// get returns the range so far
+func (m *messageRange) get() models.MessageRange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.r
 }
 
 // Config contains configuration for the ClaudeExecutor
@@ -46,10 +95,49 @@ type Config struct {
 	ClaudePath string
 	// DefaultTimeout is the default execution timeout
 	DefaultTimeout time.Duration
+	// MaxTimeout caps the timeout of executions in projects whose policy
+	// does not cap it; 0 leaves them uncapped
+	MaxTimeout time.Duration
+	// IdleTimeout ends interactive executions that received no input for
+	// this long after their last turn
+	IdleTimeout time.Duration
+	// StallTimeout reports executions whose process wrote nothing to stdout
+	// for this long, and StallGrace kills them if they stay silent for that
+	// much longer; 0 disables the watchdog
+	StallTimeout time.Duration
+	StallGrace   time.Duration
 	// MaxConcurrentExecutions limits concurrent executions
 	MaxConcurrentExecutions int
 	// StorageFactory for creating message logs
 	StorageFactory StorageFactory
+	// Policies are the named execution policy profiles
+	Policies map[string]PolicyProfile
+	// DefaultPolicy applies to projects without a policy; empty means unrestricted
+	DefaultPolicy string
+	// Redactor removes secrets from messages before they are logged or
+	// streamed; nil disables redaction
+	Redactor *redact.Pipeline
+	// Permissions relays tool permission prompts to the project's clients;
+	// nil leaves them to the CLI's permission mode
+	Permissions *permission.Broker
+	// Environment supplies the projects' environment variables; nil passes
+	// the server's environment to every execution
+	Environment *env.Manager
+	// SandboxCgroup is the cgroup v2 directory in which the cgroups of
+	// sandboxes with resource limits are created
+	SandboxCgroup string
+	// Workspace captures what each execution changed in its working
+	// directory; nil disables change capture
+	Workspace *workspace.Capturer
+	// Recovery records the running processes, so they can be stopped
+	// after a crash; nil keeps no records
+	Recovery *recovery.Store
+	// Backends are the agent backends projects may choose besides the
+	// Claude CLI, by name
+	Backends map[string]AgentBackend
+	// Clock times the executions' timeouts and stall watches; nil uses the
+	// system clock
+	Clock Clock
 }
 
 // DefaultConfig returns default executor configuration
@@ -57,6 +145,7 @@ func DefaultConfig() Config {
 	return Config{
 		ClaudePath:              "claude", // Assumes claude is in PATH
 		DefaultTimeout:          5 * time.Minute,
+		IdleTimeout:             10 * time.Minute,
 		MaxConcurrentExecutions: 10,
 	}
 }
@@ -72,10 +161,37 @@ func NewClaudeExecutor(config Config) (*ClaudeExecutor, error) {
 		config.DefaultTimeout = 5 * time.Minute
 	}
 
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 10 * time.Minute
+	}
+
 	if config.MaxConcurrentExecutions <= 0 {
 		config.MaxConcurrentExecutions = 10
 	}
 
+	// Validate policy profiles
+	for name, profile := range config.Policies {
+		if err := profile.Validate(); err != nil {
+			return nil, errors.New(errors.CodeValidationFailed, "invalid policy profile %s: %v", name, err)
+		}
+		if profile.Sandbox != nil && profile.Sandbox.limited() && config.SandboxCgroup == "" {
+			return nil, errors.New(errors.CodeValidationFailed,
+				"policy profile %s limits sandbox resources but no sandbox cgroup is configured", name)
+		}
+	}
+	if config.DefaultPolicy != "" {
+		if _, ok := config.Policies[config.DefaultPolicy]; !ok {
+			return nil, errors.New(errors.CodeValidationFailed,
+				"default policy profile not defined: %s", config.DefaultPolicy)
+		}
+	}
+
+	// The Claude CLI's backend name is taken
+	if _, ok := config.Backends[ClaudeBackendName]; ok {
+		return nil, errors.New(errors.CodeValidationFailed,
+			"agent backend name is reserved: %s", ClaudeBackendName)
+	}
+
 	// Verify Claude CLI is available
 	if _, err := exec.LookPath(config.ClaudePath); err != nil {
 		return nil, errors.New(errors.CodeClaudeNotFound,
@@ -97,12 +213,18 @@ func (ce *ClaudeExecutor) GetActiveProcessCount() int {
 	return len(ce.activeProcesses)
 }
 
+// MaxConcurrentExecutions returns the limit on concurrent executions
+func (ce *ClaudeExecutor) MaxConcurrentExecutions() int {
+	return ce.config.MaxConcurrentExecutions
+}
+
 // DefaultTimeout returns the default timeout for executions
 func (ce *ClaudeExecutor) DefaultTimeout() time.Duration {
 	return ce.config.DefaultTimeout
 }
 
-// IsProjectExecuting checks if a project has an active execution
+// IsProjectExecuting checks if a project has an active execution in its
+// directory; executions in worktrees are not counted
 func (ce *ClaudeExecutor) IsProjectExecuting(projectID string) bool {
 	ce.mu.Lock()
 	defer ce.mu.Unlock()
@@ -110,6 +232,16 @@ func (ce *ClaudeExecutor) IsProjectExecuting(projectID string) bool {
 	return exists
 }
 
+// ProcessKey identifies an execution: by its project for executions in the
+// project's directory, and by project and worktree for those in a worktree.
+// A project runs at most one execution per key.
+func ProcessKey(projectID, worktreeID string) string {
+	if worktreeID == "" {
+		return projectID
+	}
+	return projectID + "/" + worktreeID
+}
+
 // registerProcess registers a new process for tracking
 func (ce *ClaudeExecutor) registerProcess(projectID string, info *ProcessInfo) error {
 	ce.mu.Lock()
@@ -152,15 +284,6 @@ func (ce *ClaudeExecutor) getProcess(projectID string) (*ProcessInfo, error) {
 	return info, nil
 }
 
-// createTimeoutContext creates a context with timeout for process execution
-func (ce *ClaudeExecutor) createTimeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
-	if timeout <= 0 {
-		timeout = ce.config.DefaultTimeout
-	}
-
-	return context.WithTimeout(context.Background(), timeout)
-}
-
 // cleanupProcess performs cleanup after process termination
 func (ce *ClaudeExecutor) cleanupProcess(projectID string, info *ProcessInfo) {
 	// Cancel context if not already cancelled
@@ -200,6 +323,7 @@ func (ce *ClaudeExecutor) Shutdown(ctx context.Context) error {
 	processes := make([]*ProcessInfo, 0, len(ce.activeProcesses))
 	for _, info := range ce.activeProcesses {
 		processes = append(processes, info)
+		ce.killPaused(info)
 	}
 	ce.mu.Unlock()
 
@@ -13,6 +13,7 @@ import (
 	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/test/mocks"
 )
 
 func TestNewClaudeExecutor(t *testing.T) {
@@ -803,35 +804,67 @@ func TestIsProjectExecuting(t *testing.T) {
 	}
 }
 
-func TestCreateTimeoutContext(t *testing.T) {
-	ce := &ClaudeExecutor{
-		config: Config{
-			DefaultTimeout: 5 * time.Second,
-		},
-	}
+func TestDeadlineTimeout(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
 
-	// Test with custom timeout
-	ctx, cancel := ce.createTimeoutContext(2 * time.Second)
+	// The deadline cancels the execution's context once its time ran out
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	d := newDeadline(clock, 2*time.Second, cancel)
+	defer d.stop()
 
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		t.Error("expected context to have deadline")
+	clock.Advance(2*time.Second - time.Nanosecond)
+	if ctx.Err() != nil || d.exceeded() {
+		t.Fatal("expected the deadline to wait for its timeout")
+	}
+	clock.Advance(time.Nanosecond)
+	if ctx.Err() == nil || !d.exceeded() {
+		t.Fatal("expected the deadline to expire after its timeout")
 	}
 
-	timeUntilDeadline := time.Until(deadline)
-	if timeUntilDeadline > 2*time.Second || timeUntilDeadline < 1*time.Second {
-		t.Errorf("unexpected deadline: %v", timeUntilDeadline)
+	// Each pause subtracts only the time run before it
+	var expiries int
+	paused := newDeadline(clock, 2*time.Second, func() { expiries++ })
+	defer paused.stop()
+	for i := 0; i < 3; i++ {
+		clock.Advance(500 * time.Millisecond)
+		paused.pause()
+		clock.Advance(time.Hour)
+		paused.resume()
+	}
+	clock.Advance(499 * time.Millisecond)
+	if paused.exceeded() {
+		t.Fatal("expected the time left before the pauses to remain")
+	}
+	clock.Advance(time.Millisecond)
+	if !paused.exceeded() || expiries != 1 {
+		t.Fatalf("expected the deadline to expire once, got %d", expiries)
 	}
 
-	// Test with zero timeout (should use default)
-	ctx2, cancel2 := ce.createTimeoutContext(0)
-	defer cancel2()
+	// An expired deadline stays expired and does not restart
+	paused.pause()
+	paused.resume()
+	clock.Advance(time.Hour)
+	if !paused.exceeded() || expiries != 1 {
+		t.Errorf("expected no further expiry, got %d", expiries)
+	}
+
+	// A timeout of zero never expires
+	never := newDeadline(clock, 0, func() { t.Error("unexpected expiry") })
+	never.pause()
+	never.resume()
+	clock.Advance(24 * time.Hour)
+	if never.exceeded() {
+		t.Error("expected no deadline without timeout")
+	}
 
-	deadline2, _ := ctx2.Deadline()
-	timeUntilDeadline2 := time.Until(deadline2)
-	if timeUntilDeadline2 > 5*time.Second || timeUntilDeadline2 < 4*time.Second {
-		t.Errorf("expected default timeout, got: %v", timeUntilDeadline2)
+	// A stopped deadline does not expire, even when resumed
+	stopped := newDeadline(clock, time.Second, func() { t.Error("unexpected expiry") })
+	stopped.stop()
+	stopped.resume()
+	clock.Advance(time.Hour)
+	if stopped.exceeded() {
+		t.Error("expected a stopped deadline not to expire")
 	}
 }
 
@@ -0,0 +1,180 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// inputStream is the open stdin of an interactive execution. User turns are
+// written as stream-json messages; closing it lets the CLI finish the
+// current turn and exit.
+type inputStream struct {
+	mu          sync.Mutex
+	w           io.WriteCloser
+	closed      bool
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	onIdle      func()
+}
+
+// newInputStream wraps stdin of an interactive execution. onIdle runs when
+// no input arrived for idleTimeout after a turn finished.
+func newInputStream(w io.WriteCloser, idleTimeout time.Duration, onIdle func()) *inputStream {
+	return &inputStream{
+		w:           w,
+		idleTimeout: idleTimeout,
+		onIdle:      onIdle,
+	}
+}
+
+// userMessage encodes a prompt in the CLI's stream-json input format
+func userMessage(text string) ([]byte, error) {
+	line, err := json.Marshal(map[string]interface{}{
+		"type": "user",
+		"message": map[string]interface{}{
+			"role": "user",
+			"content": []map[string]string{
+				{"type": "text", "text": text},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// send writes a user turn and stops the idle timer
+func (s *inputStream) send(text string) error {
+	line, err := userMessage(text)
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New(errors.CodeProcessNotFound, "execution no longer accepts input")
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	if _, err := s.w.Write(line); err != nil {
+		return errors.Wrap(err, errors.CodeExecutionFailed, "failed to send input")
+	}
+	return nil
+}
+
+// idle starts the idle timer once a turn finished
+func (s *inputStream) idle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.idleTimeout <= 0 {
+		return
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	s.idleTimer = time.AfterFunc(s.idleTimeout, s.onIdle)
+}
+
+// close ends the input; later sends fail
+func (s *inputStream) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	return s.w.Close()
+}
+
+// interactiveProcess returns a project's interactive execution
+func (ce *ClaudeExecutor) interactiveProcess(projectID string) (*ProcessInfo, error) {
+	info, err := ce.getProcess(projectID)
+	if err != nil {
+		return nil, errors.New(errors.CodeProcessNotFound,
+			"no active execution found for project %s", projectID)
+	}
+	if info.input == nil {
+		return nil, errors.New(errors.CodeValidationFailed,
+			"the active execution is not interactive")
+	}
+	return info, nil
+}
+
+// SendInput sends a user turn to the project's interactive execution
+func (ce *ClaudeExecutor) SendInput(project *models.Project, text string) error {
+	if text == "" {
+		return errors.NewValidationError("input cannot be empty")
+	}
+
+	info, err := ce.interactiveProcess(project.ID)
+	if err != nil {
+		return err
+	}
+	if err := info.input.send(text); err != nil {
+		return err
+	}
+	info.watchdog.resume()
+
+	ce.logPrompt(project, text, info)
+	ce.logger.Info("Sent input to Claude execution",
+		"project_id", project.ID,
+		"input_length", len(text))
+	return nil
+}
+
+// CloseInput ends the input of the project's interactive execution, which
+// then exits once the current turn finished
+func (ce *ClaudeExecutor) CloseInput(projectID string) error {
+	info, err := ce.interactiveProcess(projectID)
+	if err != nil {
+		return err
+	}
+	if err := info.input.close(); err != nil {
+		return errors.Wrap(err, errors.CodeExecutionFailed, "failed to close input")
+	}
+
+	ce.logger.Info("Closed input of Claude execution", "project_id", projectID)
+	return nil
+}
+
+// logPrompt appends a user turn to the project's message log; Claude
+// receives it unredacted
+func (ce *ClaudeExecutor) logPrompt(project *models.Project, text string, info *ProcessInfo) {
+	if project.MessageLog == nil {
+		return
+	}
+
+	prompt, findings := ce.redact(project, text)
+	userMsg := models.TimestampedMessage{
+		Timestamp: time.Now(),
+		Message: models.ClaudeMessage{
+			Type:       "user",
+			Content:    json.RawMessage(fmt.Sprintf(`{"text":%q}`, prompt)),
+			Redactions: findings.Rules(),
+			WorktreeID: info.WorktreeID,
+		},
+		Direction: "client",
+	}
+	if err := project.MessageLog.Append(userMsg); err != nil {
+		ce.logger.Error("Failed to log user prompt", "error", err)
+	} else {
+		info.logged.add(userMsg.Timestamp)
+	}
+}
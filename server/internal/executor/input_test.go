@@ -0,0 +1,193 @@
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// interactiveScript answers every stream-json input line with a result and
+// records its arguments and input next to the mock
+const interactiveScript = `
+dir=$(dirname "$0")
+echo "$@" > "$dir/args"
+n=0
+while read -r line; do
+  n=$((n+1))
+  echo "$line" >> "$dir/input"
+  echo "{\"type\":\"result\",\"session_id\":\"interactive-session\",\"turn\":$n}"
+done
+`
+
+type interactiveRun struct {
+	results chan models.ClaudeMessage
+	done    chan error
+}
+
+func startInteractive(executor *ClaudeExecutor, project *models.Project, idleTimeout time.Duration) *interactiveRun {
+	run := &interactiveRun{
+		results: make(chan models.ClaudeMessage, 10),
+		done:    make(chan error, 1),
+	}
+	go func() {
+		_, err := executor.ExecuteWithCallback(project, ExecuteOptions{
+			Prompt:      "first",
+			Interactive: true,
+			IdleTimeout: idleTimeout,
+		}, func(msg models.ClaudeMessage) {
+			if msg.Type == "result" {
+				run.results <- msg
+			}
+		})
+		run.done <- err
+	}()
+	return run
+}
+
+func (r *interactiveRun) waitResult(t *testing.T) models.ClaudeMessage {
+	t.Helper()
+	select {
+	case msg := <-r.results:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a result")
+		return models.ClaudeMessage{}
+	}
+}
+
+func (r *interactiveRun) waitDone(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("interactive execution did not exit")
+		return nil
+	}
+}
+
+func newInteractiveExecutor(t *testing.T) (*ClaudeExecutor, string) {
+	mockPath := createMockClaude(t, interactiveScript)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(mockPath)) })
+
+	executor, err := NewClaudeExecutor(Config{ClaudePath: mockPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	return executor, filepath.Dir(mockPath)
+}
+
+func TestInteractiveExecution(t *testing.T) {
+	executor, dir := newInteractiveExecutor(t)
+	project := &models.Project{ID: "interactive-project", Path: t.TempDir()}
+
+	run := startInteractive(executor, project, time.Minute)
+	run.waitResult(t)
+
+	// The process keeps running between turns
+	if !executor.IsProjectExecuting(project.ID) {
+		t.Fatal("expected the interactive execution to stay active")
+	}
+
+	if err := executor.SendInput(project, "second"); err != nil {
+		t.Fatalf("SendInput failed: %v", err)
+	}
+	var turn struct {
+		Turn int `json:"turn"`
+	}
+	if err := json.Unmarshal(run.waitResult(t).Content, &turn); err != nil || turn.Turn != 2 {
+		t.Errorf("expected turn 2, got %d (%v)", turn.Turn, err)
+	}
+
+	if err := executor.CloseInput(project.ID); err != nil {
+		t.Fatalf("CloseInput failed: %v", err)
+	}
+	if err := run.waitDone(t); err != nil {
+		t.Fatalf("interactive execution failed: %v", err)
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if !strings.Contains(string(args), "--input-format stream-json") {
+		t.Errorf("expected stream-json input, got args %q", args)
+	}
+
+	// Each turn is a stream-json user message
+	input, _ := os.ReadFile(filepath.Join(dir, "input"))
+	lines := strings.Split(strings.TrimSpace(string(input)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 input lines, got %q", input)
+	}
+	var msg struct {
+		Type    string `json:"type"`
+		Message struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "user" || msg.Message.Role != "user" || msg.Message.Content[0].Text != "second" {
+		t.Errorf("unexpected input message %s", lines[1])
+	}
+
+	// Input after the process exited is rejected
+	err := executor.SendInput(project, "third")
+	if !errors.IsCode(err, errors.CodeProcessNotFound) {
+		t.Errorf("expected PROCESS_NOT_FOUND, got %v", err)
+	}
+}
+
+func TestInteractiveExecutionIdleTimeout(t *testing.T) {
+	executor, _ := newInteractiveExecutor(t)
+	project := &models.Project{ID: "idle-project", Path: t.TempDir()}
+
+	run := startInteractive(executor, project, 100*time.Millisecond)
+	run.waitResult(t)
+
+	if err := run.waitDone(t); err != nil {
+		t.Fatalf("interactive execution failed: %v", err)
+	}
+	if executor.IsProjectExecuting(project.ID) {
+		t.Error("expected the idle execution to be unregistered")
+	}
+}
+
+func TestSendInputNotInteractive(t *testing.T) {
+	mockPath := createMockClaude(t, "sleep 1")
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	executor, err := NewClaudeExecutor(Config{ClaudePath: mockPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "oneshot-project", Path: t.TempDir()}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello"}, nil)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !executor.IsProjectExecuting(project.ID) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = executor.SendInput(project, "more")
+	if !errors.IsCode(err, errors.CodeValidationFailed) {
+		t.Errorf("expected VALIDATION_FAILED, got %v", err)
+	}
+	<-done
+}
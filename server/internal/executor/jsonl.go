@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// JSONLConfig describes an agent that reads the prompt on stdin and writes
+// one JSON object per line of stdout
+type JSONLConfig struct {
+	// Command is the executable and Args its fixed arguments
+	Command string
+	Args    []string
+	// ResumeArgs are added when the project has a session, with
+	// "{session_id}" replaced by its ID; empty never resumes sessions
+	ResumeArgs []string
+	// ModelArgs are added when an execution selects a model, with "{model}"
+	// replaced by its name; empty rejects model selection
+	ModelArgs []string
+	// TypeField names the field holding the message type; default "type"
+	TypeField string
+	// SessionIDField names the field holding the session ID; default
+	// "session_id"
+	SessionIDField string
+	// ResultTypes are the message types that end a turn; their "usage" and
+	// "total_cost_usd" fields are counted as in Claude's. Default "result".
+	ResultTypes []string
+	// ErrorTypes are the message types that report a failure, described by
+	// their "message" or "error" field; default "error"
+	ErrorTypes []string
+}
+
+// JSONLBackend runs an agent described by a JSONLConfig. Every line that is
+// an object with a string type field is a message of that type.
+type JSONLBackend struct {
+	config JSONLConfig
+}
+
+// NewJSONLBackend creates a JSON lines backend, filling in defaults
+func NewJSONLBackend(config JSONLConfig) (*JSONLBackend, error) {
+	if config.Command == "" {
+		return nil, errors.New(errors.CodeValidationFailed, "agent backend command cannot be empty")
+	}
+	if config.TypeField == "" {
+		config.TypeField = "type"
+	}
+	if config.SessionIDField == "" {
+		config.SessionIDField = "session_id"
+	}
+	if len(config.ResultTypes) == 0 {
+		config.ResultTypes = []string{"result"}
+	}
+	if len(config.ErrorTypes) == 0 {
+		config.ErrorTypes = []string{"error"}
+	}
+	return &JSONLBackend{config: config}, nil
+}
+
+// Command returns the agent's executable
+func (b *JSONLBackend) Command() string {
+	return b.config.Command
+}
+
+// Args builds the agent's arguments from the configured templates
+func (b *JSONLBackend) Args(project *models.Project, options ExecuteOptions) []string {
+	args := append([]string{}, b.config.Args...)
+
+	// Worktree executions start a conversation of their own
+	if project.SessionID != "" && options.Worktree == nil {
+		args = appendTemplate(args, b.config.ResumeArgs, "{session_id}", project.SessionID)
+	}
+	if options.Model != "" {
+		args = appendTemplate(args, b.config.ModelArgs, "{model}", options.Model)
+	}
+
+	return args
+}
+
+// appendTemplate appends arguments with a placeholder replaced
+func appendTemplate(args, template []string, placeholder, value string) []string {
+	for _, arg := range template {
+		args = append(args, strings.ReplaceAll(arg, placeholder, value))
+	}
+	return args
+}
+
+// ParseEvent interprets a line of the agent's output
+func (b *JSONLBackend) ParseEvent(obj map[string]interface{}) (Event, bool) {
+	msgType, ok := obj[b.config.TypeField].(string)
+	if !ok || msgType == "" {
+		return Event{}, false
+	}
+	event := Event{Type: msgType}
+	event.SessionID, _ = obj[b.config.SessionIDField].(string)
+
+	if slices.Contains(b.config.ResultTypes, msgType) {
+		event.TurnEnd = true
+		event.Usage = parseUsage(obj)
+	}
+
+	if slices.Contains(b.config.ErrorTypes, msgType) {
+		if errMsg, ok := obj["message"].(string); ok {
+			event.Err = fmt.Errorf("agent error: %s", errMsg)
+		} else if errMsg, ok := obj["error"].(string); ok {
+			event.Err = fmt.Errorf("agent error: %s", errMsg)
+		} else {
+			event.Err = fmt.Errorf("agent error: %v", obj)
+		}
+	}
+
+	return event, true
+}
+
+// Capabilities derives the agent's features from its configuration
+func (b *JSONLBackend) Capabilities() Capabilities {
+	return Capabilities{
+		Sessions: len(b.config.ResumeArgs) > 0,
+		Models:   len(b.config.ModelArgs) > 0,
+	}
+}
@@ -26,6 +26,11 @@ func (ce *ClaudeExecutor) KillExecution(projectID string) error {
 		"project_id", projectID,
 		"process_pid", processInfo.Cmd.Process.Pid)
 
+	// A paused execution cannot terminate gracefully
+	ce.mu.Lock()
+	ce.killPaused(processInfo)
+	ce.mu.Unlock()
+
 	// First, try graceful termination by canceling context
 	if processInfo.Cancel != nil {
 		processInfo.Cancel()
@@ -36,6 +41,13 @@ func (ce *ClaudeExecutor) KillExecution(projectID string) error {
 	done := make(chan error, 1)
 
 	go func() {
+		// Executions are waited for where they run, which may not happen
+		// twice
+		if processInfo.exited != nil {
+			<-processInfo.exited
+			done <- nil
+			return
+		}
 		err := processInfo.Cmd.Wait()
 		done <- err
 	}()
@@ -0,0 +1,200 @@
+package executor
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/platform"
+)
+
+// Clock tells the time for the timeouts of executions
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d passed. The returned
+	// stop function cancels the call, reporting whether it was still
+	// pending.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// systemClock is the Clock of the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// deadline ends an execution that ran for longer than its timeout. Time the
+// execution spends paused does not count.
+type deadline struct {
+	mu       sync.Mutex
+	clock    Clock
+	onExpire func()
+
+	// left is the time remaining when the timer last started
+	left    time.Duration
+	started time.Time
+	// stopTimer stops the running timer; nil while the clock is stopped
+	stopTimer func() bool
+	expired   bool
+}
+
+// newDeadline starts the timeout of an execution; a timeout of zero never
+// expires. A nil clock is the system clock.
+func newDeadline(clock Clock, timeout time.Duration, onExpire func()) *deadline {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	d := &deadline{clock: clock, onExpire: onExpire, left: timeout}
+	d.mu.Lock()
+	d.start()
+	d.mu.Unlock()
+	return d
+}
+
+// start runs the timer for the time left. Callers hold d.mu.
+func (d *deadline) start() {
+	if d.left <= 0 {
+		return
+	}
+	d.started = d.clock.Now()
+	d.stopTimer = d.clock.AfterFunc(d.left, d.expire)
+}
+
+// expire ends the execution once its time ran out
+func (d *deadline) expire() {
+	d.mu.Lock()
+	d.expired = true
+	d.mu.Unlock()
+
+	d.onExpire()
+}
+
+// pause stops the clock until resume
+func (d *deadline) pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopTimer == nil {
+		return
+	}
+	if d.stopTimer() {
+		d.left -= d.clock.Now().Sub(d.started)
+	} else {
+		// The timer fired already
+		d.left = 0
+	}
+	d.stopTimer = nil
+}
+
+// resume restarts the clock with the time that was left
+func (d *deadline) resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopTimer == nil {
+		d.start()
+	}
+}
+
+// stop ends the clock once the process exited
+func (d *deadline) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopTimer != nil {
+		d.stopTimer()
+		d.stopTimer = nil
+	}
+	d.left = 0
+}
+
+// exceeded reports whether the execution ran out of time
+func (d *deadline) exceeded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
+
+// PauseExecution stops the processes of an active execution with SIGSTOP
+// until ResumeExecution. Its timeout and stall watch wait meanwhile.
+func (ce *ClaudeExecutor) PauseExecution(key string) error {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	info, err := ce.startedProcess(key)
+	if err != nil {
+		return err
+	}
+	if info.paused {
+		return errors.New(errors.CodeValidationFailed, "execution is already paused")
+	}
+
+	// The process leads its own group, whose ID is its PID
+	if err := platform.SignalProcessGroup(info.Cmd.Process.Pid, syscall.SIGSTOP); err != nil {
+		return errors.Wrap(err, errors.CodeExecutionFailed, "failed to pause execution")
+	}
+	info.paused = true
+	info.pausedAt = time.Now()
+	info.deadline.pause()
+	info.watchdog.hold()
+
+	ce.logger.Info("Paused Claude execution",
+		"project_id", info.ProjectID,
+		"worktree_id", info.WorktreeID)
+	return nil
+}
+
+// ResumeExecution continues an execution stopped by PauseExecution
+func (ce *ClaudeExecutor) ResumeExecution(key string) error {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	info, err := ce.startedProcess(key)
+	if err != nil {
+		return err
+	}
+	if !info.paused {
+		return errors.New(errors.CodeValidationFailed, "execution is not paused")
+	}
+
+	if err := platform.SignalProcessGroup(info.Cmd.Process.Pid, syscall.SIGCONT); err != nil {
+		return errors.Wrap(err, errors.CodeExecutionFailed, "failed to resume execution")
+	}
+	info.paused = false
+	info.deadline.resume()
+	info.watchdog.release()
+
+	ce.logger.Info("Resumed Claude execution",
+		"project_id", info.ProjectID,
+		"worktree_id", info.WorktreeID,
+		"paused_for", time.Since(info.pausedAt))
+	return nil
+}
+
+// killPaused kills every process of a paused execution, since stopped
+// processes do not exit on their own. Callers hold ce.mu.
+func (ce *ClaudeExecutor) killPaused(info *ProcessInfo) {
+	if !info.paused {
+		return
+	}
+	if err := platform.SignalProcessGroup(info.Cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		ce.logger.Warn("Failed to kill paused Claude execution",
+			"project_id", info.ProjectID,
+			"error", err)
+	}
+}
+
+// startedProcess returns the active execution of key once its process
+// started. Callers hold ce.mu.
+func (ce *ClaudeExecutor) startedProcess(key string) (*ProcessInfo, error) {
+	info, exists := ce.activeProcesses[key]
+	if !exists || info.Cmd.Process == nil {
+		return nil, errors.New(errors.CodeProcessNotFound,
+			"no active execution found for project %s", key)
+	}
+	return info, nil
+}
@@ -0,0 +1,160 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/test/mocks"
+)
+
+func TestDeadline(t *testing.T) {
+	var expired atomic.Int32
+	clock := mocks.NewClock(time.Now())
+	d := newDeadline(clock, 100*time.Millisecond, func() { expired.Add(1) })
+	defer d.stop()
+
+	// Time spent paused does not count
+	clock.Advance(50 * time.Millisecond)
+	d.pause()
+	clock.Advance(150 * time.Millisecond)
+	if d.exceeded() || expired.Load() != 0 {
+		t.Fatal("expected no expiry while paused")
+	}
+
+	d.resume()
+	clock.Advance(40 * time.Millisecond)
+	if d.exceeded() {
+		t.Fatal("expected the time left before the pause to remain")
+	}
+	clock.Advance(10 * time.Millisecond)
+	if !d.exceeded() || expired.Load() != 1 {
+		t.Errorf("expected the deadline to expire once, got %d", expired.Load())
+	}
+
+	// A timeout of zero never expires
+	never := newDeadline(nil, 0, func() { t.Error("unexpected expiry") })
+	never.pause()
+	never.resume()
+	if never.exceeded() {
+		t.Error("expected no deadline without timeout")
+	}
+}
+
+func TestPauseExecution(t *testing.T) {
+	mockPath := createMockClaude(t, "cat > /dev/null\necho '{\"type\":\"system\",\"session_id\":\"s1\"}'\nsleep 0.3\necho '{\"type\":\"result\",\"session_id\":\"s1\"}'\n")
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	clock := mocks.NewClock(time.Now())
+	executor, err := NewClaudeExecutor(Config{
+		ClaudePath:     mockPath,
+		DefaultTimeout: 600 * time.Millisecond,
+		StallTimeout:   100 * time.Millisecond,
+		StallGrace:     100 * time.Millisecond,
+		Clock:          clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "paused-project", Path: t.TempDir()}
+
+	if err := executor.PauseExecution(project.ID); !errors.IsCode(err, errors.CodeProcessNotFound) {
+		t.Errorf("expected no execution to pause, got %v", err)
+	}
+
+	var received, stalls atomic.Int32
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		options := ExecuteOptions{
+			Prompt:  "hello",
+			OnStall: func(Stall) { stalls.Add(1) },
+		}
+		_, err := executor.ExecuteWithCallback(project, options, func(models.ClaudeMessage) {
+			if received.Add(1) == 1 {
+				close(started)
+			}
+		})
+		done <- err
+	}()
+	<-started
+
+	if err := executor.PauseExecution(project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := executor.PauseExecution(project.ID); !errors.IsCode(err, errors.CodeValidationFailed) {
+		t.Errorf("expected a paused execution to stay paused, got %v", err)
+	}
+
+	// The pause outlasts the timeout and the stall watch
+	clock.Advance(time.Second)
+	if stalls.Load() != 0 {
+		t.Errorf("expected no stall while paused, got %d", stalls.Load())
+	}
+
+	// The stopped process writes nothing past its own sleep
+	time.Sleep(500 * time.Millisecond)
+	if received.Load() != 1 {
+		t.Errorf("expected no output while paused, got %d messages", received.Load())
+	}
+
+	if err := executor.ResumeExecution(project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := executor.ResumeExecution(project.ID); !errors.IsCode(err, errors.CodeValidationFailed) {
+		t.Errorf("expected a running execution not to resume, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("expected the resumed execution to finish, got %v", err)
+	}
+	if received.Load() != 2 {
+		t.Errorf("expected the result after resuming, got %d messages", received.Load())
+	}
+}
+
+func TestKillPausedExecution(t *testing.T) {
+	mockPath := createMockClaude(t, "cat > /dev/null\necho '{\"type\":\"system\",\"session_id\":\"s1\"}'\nsleep 30\n")
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	executor, err := NewClaudeExecutor(Config{
+		ClaudePath:     mockPath,
+		DefaultTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "paused-project", Path: t.TempDir()}
+
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello"}, func(models.ClaudeMessage) {
+			close(started)
+		})
+		done <- err
+	}()
+	<-started
+
+	if err := executor.PauseExecution(project.ID); err != nil {
+		t.Fatal(err)
+	}
+	killed := time.Now()
+	if err := executor.KillExecution(project.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the paused execution to end")
+	}
+	if time.Since(killed) > 5*time.Second {
+		t.Errorf("killing the paused execution took %s", time.Since(killed))
+	}
+}
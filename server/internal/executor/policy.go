@@ -0,0 +1,390 @@
+package executor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// PolicyMode controls how a policy profile handles violating options
+type PolicyMode string
+
+const (
+	// PolicyModeReject fails the execution before the process starts
+	PolicyModeReject PolicyMode = "reject"
+	// PolicyModeClamp removes or replaces violating options and reports them
+	PolicyModeClamp PolicyMode = "clamp"
+)
+
+// bypassPermissionMode is the permission mode equivalent to skipping permissions
+const bypassPermissionMode = "bypassPermissions"
+
+// PolicyProfile restricts the Claude options clients may request
+type PolicyProfile struct {
+	// Mode is reject (default) or clamp
+	Mode PolicyMode `json:"mode"`
+	// AllowedTools limits the tools clients may allow; empty allows any
+	AllowedTools []string `json:"allowed_tools,omitempty"`
+	// DeniedTools are never allowed and are always passed as disallowed
+	DeniedTools []string `json:"denied_tools,omitempty"`
+	// AllowedModels limits model and fallback model; empty allows any
+	AllowedModels []string `json:"allowed_models,omitempty"`
+	// AllowSkipPermissions permits dangerously_skip_permissions and the
+	// bypassPermissions permission mode
+	AllowSkipPermissions bool `json:"allow_skip_permissions"`
+	// AllowMCPConfig permits client supplied MCP configuration
+	AllowMCPConfig bool `json:"allow_mcp_config"`
+	// AddDirRoots are the directories add_dirs must be inside; the project
+	// directory is always allowed
+	AddDirRoots []string `json:"add_dir_roots,omitempty"`
+	// MaxTimeoutSeconds caps the timeout of executions, whether requested
+	// or the default; 0 leaves it uncapped
+	MaxTimeoutSeconds int `json:"max_timeout_seconds,omitempty"`
+	// Sandbox runs executions in a Linux sandbox; nil runs them unconfined
+	Sandbox *SandboxProfile `json:"sandbox,omitempty"`
+}
+
+// PolicyViolation describes an option that a policy profile rejected or clamped
+type PolicyViolation struct {
+	Option string `json:"option"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Validate checks that the profile is well formed
+func (p PolicyProfile) Validate() error {
+	switch p.Mode {
+	case "", PolicyModeReject, PolicyModeClamp:
+	default:
+		return fmt.Errorf("invalid mode %q (must be reject or clamp)", p.Mode)
+	}
+
+	for _, root := range p.AddDirRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("add_dir root must be absolute: %s", root)
+		}
+	}
+
+	if p.MaxTimeoutSeconds < 0 {
+		return fmt.Errorf("max timeout cannot be negative")
+	}
+
+	if p.Sandbox != nil {
+		if err := p.Sandbox.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Apply checks options against the profile. In reject mode any violation
+// returns a POLICY_VIOLATION error; in clamp mode the options are adjusted in
+// place and the violations are returned for reporting.
+func (p PolicyProfile) Apply(project *models.Project, options *ExecuteOptions) ([]PolicyViolation, error) {
+	clamp := p.Mode == PolicyModeClamp
+	var violations []PolicyViolation
+
+	// Skip permissions
+	if !p.AllowSkipPermissions {
+		if options.DangerouslySkipPermissions {
+			violations = append(violations, PolicyViolation{
+				Option: "dangerously_skip_permissions",
+				Value:  "true",
+				Reason: "skipping permissions is not allowed",
+			})
+			options.DangerouslySkipPermissions = false
+		}
+		if options.PermissionMode == bypassPermissionMode {
+			violations = append(violations, PolicyViolation{
+				Option: "permission_mode",
+				Value:  options.PermissionMode,
+				Reason: "bypassing permissions is not allowed",
+			})
+			options.PermissionMode = ""
+		}
+	}
+
+	// Tools
+	allowed := make([]string, 0, len(options.AllowedTools))
+	for _, tool := range options.AllowedTools {
+		switch {
+		case matchTool(p.DeniedTools, tool):
+			violations = append(violations, PolicyViolation{
+				Option: "allowed_tools",
+				Value:  tool,
+				Reason: "tool is denied",
+			})
+		case len(p.AllowedTools) > 0 && !matchTool(p.AllowedTools, tool):
+			violations = append(violations, PolicyViolation{
+				Option: "allowed_tools",
+				Value:  tool,
+				Reason: "tool is not in the allowed list",
+			})
+		default:
+			allowed = append(allowed, tool)
+		}
+	}
+	options.AllowedTools = allowed
+	options.DisallowedTools = mergeTools(options.DisallowedTools, p.DeniedTools)
+
+	// Models
+	if len(p.AllowedModels) > 0 {
+		if options.Model != "" && !hasString(p.AllowedModels, options.Model) {
+			violations = append(violations, PolicyViolation{
+				Option: "model",
+				Value:  options.Model,
+				Reason: "model is not allowed",
+			})
+			options.Model = p.AllowedModels[0]
+		}
+		if options.Model == "" {
+			options.Model = p.AllowedModels[0]
+		}
+		if options.FallbackModel != "" && !hasString(p.AllowedModels, options.FallbackModel) {
+			violations = append(violations, PolicyViolation{
+				Option: "fallback_model",
+				Value:  options.FallbackModel,
+				Reason: "model is not allowed",
+			})
+			options.FallbackModel = ""
+		}
+	}
+
+	// MCP configuration
+	if !p.AllowMCPConfig && options.MCPConfig != "" {
+		violations = append(violations, PolicyViolation{
+			Option: "mcp_config",
+			Reason: "client MCP configuration is not allowed",
+		})
+		options.MCPConfig = ""
+		options.StrictMCPConfig = false
+	}
+
+	// Additional directories
+	roots := append([]string{project.Path}, p.AddDirRoots...)
+	dirs := make([]string, 0, len(options.AddDirs))
+	for _, dir := range options.AddDirs {
+		resolved := resolvePath(project.Path, dir)
+		if !withinAny(roots, resolved) {
+			violations = append(violations, PolicyViolation{
+				Option: "add_dirs",
+				Value:  dir,
+				Reason: "directory is outside the allowed roots",
+			})
+			continue
+		}
+		dirs = append(dirs, resolved)
+	}
+	options.AddDirs = dirs
+
+	// Timeout
+	if max := p.maxTimeout(); max > 0 && options.Timeout > max {
+		violations = append(violations, PolicyViolation{
+			Option: "timeout_seconds",
+			Value:  strconv.Itoa(int(options.Timeout / time.Second)),
+			Reason: "timeout exceeds the maximum",
+		})
+		options.Timeout = max
+	}
+
+	if len(violations) > 0 && !clamp {
+		return nil, errors.New(errors.CodePolicyViolation, "execution options violate the project policy").
+			WithDetail("violations", violations)
+	}
+
+	return violations, nil
+}
+
+// maxTimeout returns the longest timeout the profile allows; 0 is uncapped
+func (p PolicyProfile) maxTimeout() time.Duration {
+	return time.Duration(p.MaxTimeoutSeconds) * time.Second
+}
+
+// PolicyFor returns the name and profile that apply to a project. A project
+// without a policy uses the default; ok is false when no policy applies.
+func (ce *ClaudeExecutor) PolicyFor(project *models.Project) (name string, profile PolicyProfile, ok bool, err error) {
+	name = project.GetPolicy()
+	if name == "" {
+		name = ce.config.DefaultPolicy
+	}
+	if name == "" {
+		return "", PolicyProfile{}, false, nil
+	}
+
+	profile, exists := ce.config.Policies[name]
+	if !exists {
+		return name, PolicyProfile{}, false, errors.New(errors.CodePolicyViolation, "unknown policy profile: %s", name).
+			WithDetail("policy", name)
+	}
+
+	return name, profile, true, nil
+}
+
+// HasPolicy reports whether a policy profile with the given name is configured
+func (ce *ClaudeExecutor) HasPolicy(name string) bool {
+	_, ok := ce.config.Policies[name]
+	return ok
+}
+
+// Policies returns a copy of the configured policy profiles and the default
+func (ce *ClaudeExecutor) Policies() (map[string]PolicyProfile, string) {
+	profiles := make(map[string]PolicyProfile, len(ce.config.Policies))
+	for name, profile := range ce.config.Policies {
+		profiles[name] = profile
+	}
+	return profiles, ce.config.DefaultPolicy
+}
+
+// ApplyPolicy enforces the project's policy profile on the options, then
+// checks that the project's agent backend supports what is left of them
+func (ce *ClaudeExecutor) ApplyPolicy(project *models.Project, options *ExecuteOptions) ([]PolicyViolation, error) {
+	if options.Timeout < 0 {
+		return nil, errors.NewValidationError("timeout cannot be negative")
+	}
+
+	violations, err := ce.applyProfile(project, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := ce.capTimeout(project, options); err != nil {
+		return nil, err
+	}
+
+	name, backend, err := ce.BackendFor(project)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckCapabilities(name, backend.Capabilities(), *options); err != nil {
+		ce.logger.Warn("Execution rejected by agent backend",
+			"project_id", project.ID,
+			"backend", name,
+			"error", err)
+		return nil, err
+	}
+
+	return violations, nil
+}
+
+// capTimeout holds the options to the server's maximum timeout, unless the
+// project's policy caps timeouts itself
+func (ce *ClaudeExecutor) capTimeout(project *models.Project, options *ExecuteOptions) error {
+	max := ce.config.MaxTimeout
+	if max <= 0 {
+		return nil
+	}
+	if _, profile, ok, _ := ce.PolicyFor(project); ok && profile.maxTimeout() > 0 {
+		return nil
+	}
+
+	if options.Timeout > max {
+		return errors.NewValidationError("timeout exceeds the maximum of %s", max).
+			WithDetail("timeout_seconds", int(options.Timeout/time.Second)).
+			WithDetail("max_timeout_seconds", int(max/time.Second))
+	}
+	if options.Timeout == 0 && ce.config.DefaultTimeout > max {
+		options.Timeout = max
+	}
+	return nil
+}
+
+// applyProfile enforces the project's policy profile on the options
+func (ce *ClaudeExecutor) applyProfile(project *models.Project, options *ExecuteOptions) ([]PolicyViolation, error) {
+	name, profile, ok, err := ce.PolicyFor(project)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	violations, err := profile.Apply(project, options)
+	if err != nil {
+		if appErr, isApp := err.(*errors.AppError); isApp {
+			appErr.WithDetail("policy", name)
+		}
+		ce.logger.Warn("Execution rejected by policy",
+			"project_id", project.ID,
+			"policy", name,
+			"error", err)
+		return nil, err
+	}
+
+	// The default timeout is capped without a violation, since clients did
+	// not ask for it
+	if max := profile.maxTimeout(); max > 0 && options.Timeout == 0 && ce.config.DefaultTimeout > max {
+		options.Timeout = max
+	}
+
+	if len(violations) > 0 {
+		ce.logger.Warn("Execution options clamped by policy",
+			"project_id", project.ID,
+			"policy", name,
+			"violations", len(violations))
+	}
+
+	return violations, nil
+}
+
+// matchTool reports whether a tool specification matches any entry. Entries
+// without a specifier (e.g. "Bash") match every specifier of that tool
+// (e.g. "Bash(git:*)").
+func matchTool(entries []string, tool string) bool {
+	base, _, _ := strings.Cut(tool, "(")
+	for _, entry := range entries {
+		if entry == tool || entry == base {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTools appends tools not already present
+func mergeTools(tools, extra []string) []string {
+	for _, tool := range extra {
+		if !hasString(tools, tool) {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// hasString reports whether values contains s
+func hasString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath makes a directory absolute relative to the project and
+// resolves symlinks where possible
+func resolvePath(base, dir string) string {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	dir = filepath.Clean(dir)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return dir
+}
+
+// withinAny reports whether path is one of the roots or inside one
+func withinAny(roots []string, path string) bool {
+	for _, root := range roots {
+		root = resolvePath("/", root)
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+			return true
+		}
+	}
+	return false
+}
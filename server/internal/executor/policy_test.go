@@ -0,0 +1,300 @@
+package executor
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+func newPolicyTestExecutor(policies map[string]PolicyProfile, defaultPolicy string) *ClaudeExecutor {
+	return &ClaudeExecutor{
+		activeProcesses: make(map[string]*ProcessInfo),
+		logger:          logger.New("error"),
+		config: Config{
+			ClaudePath:     "/nonexistent/claude",
+			Policies:       policies,
+			DefaultPolicy:  defaultPolicy,
+			DefaultTimeout: DefaultConfig().DefaultTimeout,
+		},
+	}
+}
+
+func TestPolicyProfileApply(t *testing.T) {
+	projectDir := t.TempDir()
+	sharedDir := t.TempDir()
+	project := models.NewProject("test-project", projectDir)
+
+	profile := PolicyProfile{
+		AllowedTools:  []string{"Read", "Bash"},
+		DeniedTools:   []string{"WebFetch"},
+		AllowedModels: []string{"sonnet", "haiku"},
+		AddDirRoots:   []string{sharedDir},
+	}
+
+	t.Run("compliant options pass", func(t *testing.T) {
+		options := ExecuteOptions{
+			AllowedTools:  []string{"Read", "Bash(git:*)"},
+			Model:         "haiku",
+			FallbackModel: "sonnet",
+			AddDirs:       []string{sharedDir, "sub"},
+		}
+
+		violations, err := profile.Apply(project, &options)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(violations) != 0 {
+			t.Errorf("expected no violations, got %v", violations)
+		}
+
+		// Denied tools are always passed as disallowed
+		if !reflect.DeepEqual(options.DisallowedTools, []string{"WebFetch"}) {
+			t.Errorf("expected denied tools to be disallowed, got %v", options.DisallowedTools)
+		}
+		// Relative directories resolve inside the project
+		if want := filepath.Join(projectDir, "sub"); options.AddDirs[1] != want {
+			t.Errorf("expected %s, got %s", want, options.AddDirs[1])
+		}
+	})
+
+	t.Run("model defaults to first allowed", func(t *testing.T) {
+		options := ExecuteOptions{}
+		if _, err := profile.Apply(project, &options); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if options.Model != "sonnet" {
+			t.Errorf("expected sonnet, got %s", options.Model)
+		}
+	})
+
+	violating := func() ExecuteOptions {
+		return ExecuteOptions{
+			DangerouslySkipPermissions: true,
+			PermissionMode:             "bypassPermissions",
+			AllowedTools:               []string{"Read", "WebFetch", "Write"},
+			Model:                      "opus",
+			FallbackModel:              "opus",
+			MCPConfig:                  "/tmp/mcp.json",
+			AddDirs:                    []string{"/etc", "../outside"},
+		}
+	}
+
+	t.Run("reject mode", func(t *testing.T) {
+		options := violating()
+		_, err := profile.Apply(project, &options)
+		if !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Fatalf("expected policy violation, got %v", err)
+		}
+
+		violations := err.(*errors.AppError).Details["violations"].([]PolicyViolation)
+		if len(violations) != 9 {
+			t.Errorf("expected 9 violations, got %d: %v", len(violations), violations)
+		}
+	})
+
+	t.Run("clamp mode", func(t *testing.T) {
+		clamp := profile
+		clamp.Mode = PolicyModeClamp
+
+		options := violating()
+		violations, err := clamp.Apply(project, &options)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(violations) != 9 {
+			t.Errorf("expected 9 violations, got %d: %v", len(violations), violations)
+		}
+
+		if options.DangerouslySkipPermissions || options.PermissionMode != "" {
+			t.Error("expected permission bypass to be removed")
+		}
+		if !reflect.DeepEqual(options.AllowedTools, []string{"Read"}) {
+			t.Errorf("expected only Read to remain allowed, got %v", options.AllowedTools)
+		}
+		if options.Model != "sonnet" || options.FallbackModel != "" {
+			t.Errorf("expected model sonnet without fallback, got %s/%s", options.Model, options.FallbackModel)
+		}
+		if options.MCPConfig != "" {
+			t.Error("expected mcp config to be removed")
+		}
+		if len(options.AddDirs) != 0 {
+			t.Errorf("expected add dirs to be removed, got %v", options.AddDirs)
+		}
+	})
+
+	t.Run("symlink out of root", func(t *testing.T) {
+		link := filepath.Join(projectDir, "escape")
+		if err := os.Symlink(t.TempDir(), link); err != nil {
+			t.Skip("symlinks not supported")
+		}
+
+		options := ExecuteOptions{AddDirs: []string{"escape"}}
+		if _, err := profile.Apply(project, &options); !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Errorf("expected policy violation, got %v", err)
+		}
+	})
+}
+
+func TestTimeoutFromSeconds(t *testing.T) {
+	if timeout, err := TimeoutFromSeconds(600); err != nil || timeout != 10*time.Minute {
+		t.Errorf("expected 10m, got %v, %v", timeout, err)
+	}
+	if _, err := TimeoutFromSeconds(math.MaxInt); !errors.IsCode(err, errors.CodeValidationFailed) {
+		t.Errorf("expected validation error, got %v", err)
+	}
+}
+
+func TestPolicyProfileValidate(t *testing.T) {
+	if err := (PolicyProfile{Mode: "ignore"}).Validate(); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+	if err := (PolicyProfile{AddDirRoots: []string{"relative"}}).Validate(); err == nil {
+		t.Error("expected error for relative root")
+	}
+	if err := (PolicyProfile{MaxTimeoutSeconds: -1}).Validate(); err == nil {
+		t.Error("expected error for negative max timeout")
+	}
+	if err := (PolicyProfile{Mode: PolicyModeClamp, AddDirRoots: []string{"/srv"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	policies := map[string]PolicyProfile{
+		"strict":     {},
+		"permissive": {AllowSkipPermissions: true},
+	}
+	project := models.NewProject("test-project", t.TempDir())
+	skip := ExecuteOptions{DangerouslySkipPermissions: true}
+
+	t.Run("no policy configured", func(t *testing.T) {
+		ce := newPolicyTestExecutor(nil, "")
+		options := skip
+		if _, err := ce.ApplyPolicy(project, &options); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("default policy", func(t *testing.T) {
+		ce := newPolicyTestExecutor(policies, "strict")
+		options := skip
+		_, err := ce.ApplyPolicy(project, &options)
+		if !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Fatalf("expected policy violation, got %v", err)
+		}
+		if err.(*errors.AppError).Details["policy"] != "strict" {
+			t.Errorf("expected policy detail, got %v", err.(*errors.AppError).Details)
+		}
+	})
+
+	t.Run("project policy overrides default", func(t *testing.T) {
+		ce := newPolicyTestExecutor(policies, "strict")
+		p := project.Copy()
+		p.SetPolicy("permissive")
+		options := skip
+		if _, err := ce.ApplyPolicy(p, &options); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unknown project policy", func(t *testing.T) {
+		ce := newPolicyTestExecutor(policies, "")
+		p := project.Copy()
+		p.SetPolicy("removed")
+		options := ExecuteOptions{}
+		if _, err := ce.ApplyPolicy(p, &options); !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Errorf("expected policy violation, got %v", err)
+		}
+	})
+
+	t.Run("timeouts are capped", func(t *testing.T) {
+		ce := newPolicyTestExecutor(map[string]PolicyProfile{
+			"short":   {MaxTimeoutSeconds: 60},
+			"clamped": {Mode: PolicyModeClamp, MaxTimeoutSeconds: 60},
+		}, "short")
+
+		// The longer default is capped quietly
+		options := ExecuteOptions{}
+		violations, err := ce.ApplyPolicy(project, &options)
+		if err != nil || len(violations) != 0 || options.Timeout != time.Minute {
+			t.Errorf("expected the default to be capped, got %v, %v, %v", options.Timeout, violations, err)
+		}
+
+		options = ExecuteOptions{Timeout: 2 * time.Minute}
+		if _, err := ce.ApplyPolicy(project, &options); !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Errorf("expected policy violation, got %v", err)
+		}
+
+		p := project.Copy()
+		p.SetPolicy("clamped")
+		options = ExecuteOptions{Timeout: 2 * time.Minute}
+		violations, err = ce.ApplyPolicy(p, &options)
+		if err != nil || len(violations) != 1 || options.Timeout != time.Minute {
+			t.Errorf("expected the timeout to be clamped, got %v, %v, %v", options.Timeout, violations, err)
+		}
+
+		options = ExecuteOptions{Timeout: -time.Second}
+		if _, err := ce.ApplyPolicy(project, &options); !errors.IsCode(err, errors.CodeValidationFailed) {
+			t.Errorf("expected validation error, got %v", err)
+		}
+	})
+
+	t.Run("server maximum applies without a policy cap", func(t *testing.T) {
+		ce := newPolicyTestExecutor(map[string]PolicyProfile{
+			"open":  {},
+			"short": {MaxTimeoutSeconds: 60},
+		}, "")
+		ce.config.MaxTimeout = 2 * time.Minute
+
+		// The longer default is capped quietly
+		options := ExecuteOptions{}
+		if _, err := ce.ApplyPolicy(project, &options); err != nil || options.Timeout != 2*time.Minute {
+			t.Errorf("expected the default to be capped, got %v, %v", options.Timeout, err)
+		}
+
+		options = ExecuteOptions{Timeout: 90 * time.Second}
+		if _, err := ce.ApplyPolicy(project, &options); err != nil || options.Timeout != 90*time.Second {
+			t.Errorf("expected the timeout to be kept, got %v, %v", options.Timeout, err)
+		}
+
+		options = ExecuteOptions{Timeout: time.Hour}
+		if _, err := ce.ApplyPolicy(project, &options); !errors.IsCode(err, errors.CodeValidationFailed) {
+			t.Errorf("expected validation error, got %v", err)
+		}
+
+		// A policy without max_timeout leaves the server's maximum in place
+		p := project.Copy()
+		p.SetPolicy("open")
+		options = ExecuteOptions{Timeout: time.Hour}
+		if _, err := ce.ApplyPolicy(p, &options); !errors.IsCode(err, errors.CodeValidationFailed) {
+			t.Errorf("expected validation error, got %v", err)
+		}
+
+		// A policy's own maximum replaces it
+		p.SetPolicy("short")
+		options = ExecuteOptions{Timeout: time.Hour}
+		if _, err := ce.ApplyPolicy(p, &options); !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Errorf("expected policy violation, got %v", err)
+		}
+	})
+
+	t.Run("rejected before the process starts", func(t *testing.T) {
+		ce := newPolicyTestExecutor(policies, "strict")
+		options := skip
+		options.Prompt = "hello"
+		_, err := ce.ExecuteWithOptions(project, options)
+		if !errors.IsCode(err, errors.CodePolicyViolation) {
+			t.Errorf("expected policy violation, got %v", err)
+		}
+		if ce.GetActiveProcessCount() != 0 {
+			t.Error("expected no process to be registered")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/platform"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+)
+
+// markRunning records a started process, so that a server that crashes
+// before the process ends can stop it on its next start
+func (ce *ClaudeExecutor) markRunning(project *models.Project, options ExecuteOptions, worktreeID string, pid int) {
+	if ce.config.Recovery == nil {
+		return
+	}
+
+	marker := recovery.Marker{
+		ExecutionID: options.ExecutionID,
+		WorktreeID:  worktreeID,
+		PID:         pid,
+		StartedAt:   time.Now().UTC(),
+	}
+	if worktreeID == "" {
+		marker.SessionID = project.SessionID
+	}
+	start, err := platform.ProcessStartTime(pid)
+	if err != nil {
+		ce.logger.Warn("Failed to read process start time",
+			"project_id", project.ID,
+			"pid", pid,
+			"error", err)
+	}
+	marker.ProcessStart = start
+
+	if err := ce.config.Recovery.Mark(project.ID, marker); err != nil {
+		ce.logger.Warn("Failed to record running process",
+			"project_id", project.ID,
+			"pid", pid,
+			"error", err)
+	}
+}
+
+// clearRunning removes the record of a process that ended
+func (ce *ClaudeExecutor) clearRunning(project *models.Project, worktreeID string) {
+	if ce.config.Recovery == nil {
+		return
+	}
+	if err := ce.config.Recovery.Clear(project.ID, worktreeID); err != nil {
+		ce.logger.Warn("Failed to clear running process record",
+			"project_id", project.ID,
+			"error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+func TestExecutionMarksRunningProcess(t *testing.T) {
+	dataDir := t.TempDir()
+	markerPath := filepath.Join(dataDir, storage.ProjectsDirName, "marked-project", recovery.FileName)
+	seenPath := filepath.Join(t.TempDir(), "seen.json")
+
+	// The mock copies the marker of its own run
+	mockPath := createMockClaude(t, "cat > /dev/null\ncp "+markerPath+" "+seenPath+"\necho '{\"type\":\"result\",\"result\":\"done\"}'\n")
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	executor, err := NewClaudeExecutor(Config{ClaudePath: mockPath, Recovery: recovery.NewStore(dataDir)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "marked-project", Path: t.TempDir(), SessionID: "s1"}
+
+	if _, err := executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello", ExecutionID: "e1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(seenPath)
+	if err != nil {
+		t.Fatalf("the process was not marked: %v", err)
+	}
+	var markers []recovery.Marker
+	if err := json.Unmarshal(data, &markers); err != nil {
+		t.Fatal(err)
+	}
+	if len(markers) != 1 || markers[0].ExecutionID != "e1" || markers[0].SessionID != "s1" ||
+		markers[0].PID <= 0 || markers[0].ProcessStart.IsZero() {
+		t.Errorf("unexpected markers %+v", markers)
+	}
+
+	// The marker is cleared once the process ends
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("expected the marker to be cleared, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/redact"
+)
+
+// secretRule names the redactions of a project's secret environment values
+const secretRule = "project_secret"
+
+// redactor returns the pipeline for a project's output: the configured
+// detectors and the values of the project's secrets
+func (ce *ClaudeExecutor) redactor(project *models.Project) *redact.Pipeline {
+	if ce.config.Environment == nil {
+		return ce.config.Redactor
+	}
+	secrets, err := ce.config.Environment.Secrets(project.ID)
+	if err != nil {
+		ce.logger.Warn("Failed to load project secrets for redaction",
+			"project_id", project.ID,
+			"error", err)
+	}
+	if len(secrets) == 0 {
+		return ce.config.Redactor
+	}
+	return ce.config.Redactor.With(redact.NewLiteralDetector(secretRule, secrets...))
+}
+
+// redact removes secrets from text and logs which rules fired
+func (ce *ClaudeExecutor) redact(project *models.Project, text string) (string, redact.Findings) {
+	redacted, findings := ce.redactor(project).Redact(text)
+	ce.logRedactions(project, findings)
+	return redacted, findings
+}
+
+// RedactText removes secrets from text stored outside the message log,
+// such as prompts in execution records
+func (ce *ClaudeExecutor) RedactText(project *models.Project, text string) string {
+	redacted, _ := ce.redact(project, text)
+	return redacted
+}
+
+// RedactValue removes secrets from a decoded JSON value sent outside the
+// message log, such as the tool input of a permission prompt
+func (ce *ClaudeExecutor) RedactValue(project *models.Project, value interface{}) interface{} {
+	redacted, findings := ce.redactor(project).RedactValue(value)
+	ce.logRedactions(project, findings)
+	return redacted
+}
+
+// redactObject removes secrets from a decoded Claude message and returns the
+// rules that fired
+func (ce *ClaudeExecutor) redactObject(project *models.Project, obj map[string]interface{}) (map[string]interface{}, []string) {
+	redacted, findings := ce.redactor(project).RedactValue(obj)
+	ce.logRedactions(project, findings)
+	return redacted.(map[string]interface{}), findings.Rules()
+}
+
+// logRedactions records that secrets were removed, without the secrets
+func (ce *ClaudeExecutor) logRedactions(project *models.Project, findings redact.Findings) {
+	if len(findings) == 0 {
+		return
+	}
+	ce.logger.Info("Redacted secrets from Claude output",
+		"project_id", project.ID,
+		"rules", findings.Rules(),
+	)
+}
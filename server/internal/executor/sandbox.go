@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/platform"
+)
+
+// SandboxProfile runs a policy's executions in a Linux sandbox. Everything
+// but the project directory or worktree, the temporary directory, Claude's
+// configuration and the writable paths is read-only to them.
+type SandboxProfile struct {
+	// IsolateNetwork leaves executions only a loopback interface of their
+	// own. Claude then reaches its API and the permission prompt tool only
+	// through means that need no network.
+	IsolateNetwork bool `json:"isolate_network"`
+	// WritablePaths are further absolute paths executions may write to
+	WritablePaths []string `json:"writable_paths,omitempty"`
+	// MemoryMB, CPUs and MaxPids limit an execution and the processes it
+	// starts; 0 leaves the resource unlimited
+	MemoryMB int64   `json:"memory_mb,omitempty"`
+	CPUs     float64 `json:"cpus,omitempty"`
+	MaxPids  int64   `json:"max_pids,omitempty"`
+}
+
+// Validate checks that the sandbox is well formed
+func (s SandboxProfile) Validate() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("sandbox is only supported on Linux")
+	}
+	if s.MemoryMB < 0 || s.CPUs < 0 || s.MaxPids < 0 {
+		return fmt.Errorf("sandbox limits cannot be negative")
+	}
+	for _, path := range s.WritablePaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("sandbox writable path must be absolute: %s", path)
+		}
+	}
+	return nil
+}
+
+// limited reports whether the sandbox limits resources
+func (s SandboxProfile) limited() bool {
+	return s.MemoryMB > 0 || s.CPUs > 0 || s.MaxPids > 0
+}
+
+// sandboxFor returns the sandbox of an execution of project with options, or
+// nil when the project's policy does not sandbox executions
+func (ce *ClaudeExecutor) sandboxFor(project *models.Project, options ExecuteOptions) (*platform.Sandbox, error) {
+	_, profile, ok, err := ce.PolicyFor(project)
+	if err != nil || !ok || profile.Sandbox == nil {
+		return nil, err
+	}
+	s := profile.Sandbox
+
+	writable := []string{project.Path, os.TempDir()}
+	if options.Worktree != nil {
+		writable = append(writable, options.Worktree.Path)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		writable = append(writable,
+			filepath.Join(home, ".claude"),
+			filepath.Join(home, ".claude.json"))
+	}
+	writable = append(writable, options.AddDirs...)
+	writable = append(writable, s.WritablePaths...)
+
+	return &platform.Sandbox{
+		WritablePaths:  writable,
+		IsolateNetwork: s.IsolateNetwork,
+		CgroupRoot:     ce.config.SandboxCgroup,
+		MemoryBytes:    s.MemoryMB * 1024 * 1024,
+		CPUs:           s.CPUs,
+		MaxPids:        s.MaxPids,
+	}, nil
+}
+
+// setupSandbox makes cmd run in sandbox. The returned cgroup, which may be
+// nil, must be closed once the process has exited.
+func (ce *ClaudeExecutor) setupSandbox(cmd *exec.Cmd, project *models.Project, sandbox *platform.Sandbox) (*platform.SandboxCgroup, error) {
+	cgroup, err := platform.SetupSandbox(cmd, *sandbox)
+	if err != nil {
+		ce.logger.Error("Failed to set up sandbox",
+			"project_id", project.ID,
+			"error", err)
+		return nil, fmt.Errorf("failed to set up sandbox: %w", err)
+	}
+
+	ce.logger.Debug("Sandboxing Claude execution",
+		"project_id", project.ID,
+		"isolate_network", sandbox.IsolateNetwork,
+		"memory_bytes", sandbox.MemoryBytes,
+		"cpus", sandbox.CPUs,
+		"max_pids", sandbox.MaxPids)
+
+	return cgroup, nil
+}
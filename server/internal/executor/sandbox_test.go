@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+func TestSandboxProfileValidate(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if err := (SandboxProfile{}).Validate(); err == nil {
+			t.Error("expected sandboxes to be rejected outside Linux")
+		}
+		return
+	}
+
+	if err := (SandboxProfile{MemoryMB: -1}).Validate(); err == nil {
+		t.Error("expected error for negative memory limit")
+	}
+	if err := (SandboxProfile{WritablePaths: []string{"cache"}}).Validate(); err == nil {
+		t.Error("expected error for relative writable path")
+	}
+	if err := (SandboxProfile{MemoryMB: 512, CPUs: 1.5, WritablePaths: []string{"/srv/cache"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Limits need a cgroup to enforce them
+	profiles := map[string]PolicyProfile{"sandboxed": {Sandbox: &SandboxProfile{MaxPids: 64}}}
+	if _, err := NewClaudeExecutor(Config{ClaudePath: "/bin/sh", Policies: profiles}); err == nil {
+		t.Error("expected error for sandbox limits without a cgroup")
+	}
+}
+
+func TestSandboxFor(t *testing.T) {
+	project := models.NewProject("test-project", t.TempDir())
+	ce := newPolicyTestExecutor(map[string]PolicyProfile{
+		"open": {},
+		"sandboxed": {Sandbox: &SandboxProfile{
+			IsolateNetwork: true,
+			WritablePaths:  []string{"/srv/cache"},
+			MemoryMB:       512,
+		}},
+	}, "open")
+	ce.config.SandboxCgroup = "/sys/fs/cgroup/pocket-agent"
+
+	// Policies without a sandbox run executions unconfined
+	sandbox, err := ce.sandboxFor(project, ExecuteOptions{})
+	if err != nil || sandbox != nil {
+		t.Fatalf("expected no sandbox, got %+v, %v", sandbox, err)
+	}
+
+	project.SetPolicy("sandboxed")
+	sandbox, err = ce.sandboxFor(project, ExecuteOptions{AddDirs: []string{"/srv/shared"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sandbox == nil {
+		t.Fatal("expected a sandbox")
+	}
+	if !sandbox.IsolateNetwork || sandbox.MemoryBytes != 512*1024*1024 || sandbox.CgroupRoot != ce.config.SandboxCgroup {
+		t.Errorf("unexpected sandbox: %+v", sandbox)
+	}
+	for _, path := range []string{project.Path, "/srv/shared", "/srv/cache"} {
+		if !hasString(sandbox.WritablePaths, path) {
+			t.Errorf("expected %s to be writable, got %v", path, sandbox.WritablePaths)
+		}
+	}
+}
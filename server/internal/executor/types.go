@@ -1,14 +1,31 @@
 package executor
 
 import (
+	"math"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/models"
 )
 
 // ExecuteCommand represents a command to execute Claude
 // This matches the models.ExecuteCommand structure for WebSocket messages
 type ExecuteCommand struct {
-	Prompt  string                `json:"prompt"`
-	Options *models.ClaudeOptions `json:"options,omitempty"`
+	Prompt         string                `json:"prompt"`
+	Options        *models.ClaudeOptions `json:"options,omitempty"`
+	TimeoutSeconds int                   `json:"timeout_seconds,omitempty"`
+}
+
+// maxTimeoutSeconds is the longest timeout a time.Duration holds
+const maxTimeoutSeconds = math.MaxInt64 / int64(time.Second)
+
+// TimeoutFromSeconds converts a timeout requested in seconds, rejecting
+// values too large to represent
+func TimeoutFromSeconds(seconds int) (time.Duration, error) {
+	if int64(seconds) > maxTimeoutSeconds {
+		return 0, errors.NewValidationError("timeout_seconds cannot exceed %d", maxTimeoutSeconds)
+	}
+	return time.Duration(seconds) * time.Second, nil
 }
 
 // Execute runs Claude with the specified command for a project
@@ -19,6 +36,13 @@ func (ce *ClaudeExecutor) Execute(project *models.Project, cmd ExecuteCommand) (
 		Prompt:  cmd.Prompt,
 		Timeout: ce.config.DefaultTimeout,
 	}
+	if cmd.TimeoutSeconds != 0 {
+		timeout, err := TimeoutFromSeconds(cmd.TimeoutSeconds)
+		if err != nil {
+			return nil, err
+		}
+		options.Timeout = timeout
+	}
 
 	// Apply optional settings if provided
 	if cmd.Options != nil {
@@ -32,6 +56,7 @@ func (ce *ClaudeExecutor) Execute(project *models.Project, cmd ExecuteCommand) (
 		options.FallbackModel = cmd.Options.FallbackModel
 		options.AddDirs = cmd.Options.AddDirs
 		options.StrictMCPConfig = cmd.Options.StrictMCPConfig
+		options.Interactive = cmd.Options.Interactive
 	}
 
 	// Call the main Execute method
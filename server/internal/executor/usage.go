@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"sync"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// usageMeter accumulates the usage an execution reports. A "result" carries
+// the usage of a whole turn, superseding the "message_delta" events of that
+// turn, which only count for turns that never finished.
+type usageMeter struct {
+	mu      sync.Mutex
+	settled models.Usage
+	pending models.Usage
+}
+
+// delta counts the usage of a streamed message
+func (m *usageMeter) delta(u models.Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending.Add(u)
+}
+
+// result counts the usage of a finished turn
+func (m *usageMeter) result(u models.Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settled.Add(u)
+	m.pending = models.Usage{}
+}
+
+// get returns the usage so far
+func (m *usageMeter) get() models.Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.settled
+	u.Add(m.pending)
+	return u
+}
+
+// parseUsage reads the token usage and cost of a "result" or
+// "message_delta" message
+func parseUsage(obj map[string]interface{}) models.Usage {
+	var u models.Usage
+	if fields, ok := obj["usage"].(map[string]interface{}); ok {
+		u.InputTokens = tokenCount(fields["input_tokens"])
+		u.OutputTokens = tokenCount(fields["output_tokens"])
+		u.CacheCreationInputTokens = tokenCount(fields["cache_creation_input_tokens"])
+		u.CacheReadInputTokens = tokenCount(fields["cache_read_input_tokens"])
+	}
+
+	// Older CLI versions report cost_usd instead of total_cost_usd
+	if cost, ok := obj["total_cost_usd"].(float64); ok {
+		u.CostUSD = cost
+	} else if cost, ok := obj["cost_usd"].(float64); ok {
+		u.CostUSD = cost
+	}
+	return u
+}
+
+// tokenCount converts a decoded JSON token count
+func tokenCount(v interface{}) int64 {
+	if n, ok := v.(float64); ok && n > 0 {
+		return int64(n)
+	}
+	return 0
+}
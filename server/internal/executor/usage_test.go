@@ -0,0 +1,92 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+func TestParseUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  map[string]interface{}
+		want models.Usage
+	}{
+		{
+			name: "result",
+			obj: map[string]interface{}{
+				"type":           "result",
+				"total_cost_usd": 0.25,
+				"usage": map[string]interface{}{
+					"input_tokens":                float64(100),
+					"output_tokens":               float64(50),
+					"cache_creation_input_tokens": float64(10),
+					"cache_read_input_tokens":     float64(2000),
+				},
+			},
+			want: models.Usage{InputTokens: 100, OutputTokens: 50, CacheCreationInputTokens: 10, CacheReadInputTokens: 2000, CostUSD: 0.25},
+		},
+		{
+			name: "legacy cost",
+			obj:  map[string]interface{}{"type": "result", "cost_usd": 0.5},
+			want: models.Usage{CostUSD: 0.5},
+		},
+		{
+			name: "message delta",
+			obj: map[string]interface{}{
+				"type":  "message_delta",
+				"usage": map[string]interface{}{"output_tokens": float64(7)},
+			},
+			want: models.Usage{OutputTokens: 7},
+		},
+		{
+			name: "malformed",
+			obj: map[string]interface{}{
+				"type":  "result",
+				"usage": map[string]interface{}{"input_tokens": "many", "output_tokens": float64(-3)},
+			},
+			want: models.Usage{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseUsage(tt.obj); got != tt.want {
+				t.Errorf("parseUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutionUsage(t *testing.T) {
+	// The first turn finishes, superseding its deltas; the second is cut
+	// short so only its delta counts
+	script := `
+echo '{"type":"message_delta","usage":{"output_tokens":40}}'
+echo '{"type":"result","total_cost_usd":0.1,"usage":{"input_tokens":100,"output_tokens":40}}'
+echo '{"type":"message_delta","usage":{"output_tokens":15}}'
+exit 1
+`
+	mockPath := createMockClaude(t, script)
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	executor, err := NewClaudeExecutor(Config{ClaudePath: mockPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "usage-project", Path: t.TempDir()}
+
+	result, err := executor.ExecuteWithCallback(project, ExecuteOptions{Prompt: "hello"}, nil)
+	if err == nil {
+		t.Fatal("expected the execution to fail")
+	}
+
+	want := models.Usage{InputTokens: 100, OutputTokens: 55, CostUSD: 0.1}
+	if result == nil || result.Usage != want {
+		t.Errorf("expected usage %+v, got %+v", want, result)
+	}
+}
@@ -0,0 +1,168 @@
+package executor
+
+import (
+	"sync"
+	"time"
+)
+
+// Stall describes an execution whose process stopped writing output
+type Stall struct {
+	ProjectID  string
+	WorktreeID string
+	// Since is when the process last wrote to stdout
+	Since time.Time
+	// KillAt is when the process is killed unless it writes again
+	KillAt time.Time
+}
+
+// watchdog notices a process that wrote nothing to stdout for a timeout.
+// It reports the stall, then kills the process if it stays silent for the
+// grace period. The watch is paused while the process is not expected to
+// write, such as an interactive execution waiting for input.
+type watchdog struct {
+	mu      sync.Mutex
+	clock   Clock
+	timeout time.Duration
+	grace   time.Duration
+	onStall func(since, killAt time.Time)
+	onKill  func()
+
+	// stopTimer stops the running timer; nil while none runs
+	stopTimer func() bool
+	// generation tells the current timer from stopped ones that already
+	// fired
+	generation int
+	last       time.Time
+	paused     bool
+	held       bool
+	stopped    bool
+	fired      bool
+}
+
+// newWatchdog starts watching a process that just started; a timeout of
+// zero watches nothing. A nil clock is the system clock.
+func newWatchdog(clock Clock, timeout, grace time.Duration, onStall func(since, killAt time.Time), onKill func()) *watchdog {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	w := &watchdog{
+		clock:   clock,
+		timeout: timeout,
+		grace:   grace,
+		onStall: onStall,
+		onKill:  onKill,
+		last:    clock.Now(),
+	}
+	w.mu.Lock()
+	w.arm(w.timeout, w.stall)
+	w.mu.Unlock()
+	return w
+}
+
+// arm replaces the timer. Callers hold w.mu.
+func (w *watchdog) arm(d time.Duration, fn func(generation int)) {
+	if w.stopTimer != nil {
+		w.stopTimer()
+		w.stopTimer = nil
+	}
+	w.generation++
+	if w.timeout <= 0 || w.paused || w.held || w.stopped {
+		return
+	}
+	generation := w.generation
+	w.stopTimer = w.clock.AfterFunc(d, func() { fn(generation) })
+}
+
+// output records that the process wrote to stdout
+func (w *watchdog) output() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.last = w.clock.Now()
+	w.arm(w.timeout, w.stall)
+}
+
+// pause stops the watch until resume
+func (w *watchdog) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+	w.arm(0, nil)
+}
+
+// resume restarts the watch, counting silence from now
+func (w *watchdog) resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = false
+	w.last = w.clock.Now()
+	w.arm(w.timeout, w.stall)
+}
+
+// hold stops the watch while the process is paused. Unlike pause, it is
+// independent of whether the process waits for input.
+func (w *watchdog) hold() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held = true
+	w.arm(0, nil)
+}
+
+// release restarts the watch of a resumed process, counting silence from
+// now
+func (w *watchdog) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held = false
+	w.last = w.clock.Now()
+	w.arm(w.timeout, w.stall)
+}
+
+// stop ends the watch once the process exited
+func (w *watchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	w.arm(0, nil)
+}
+
+// killed reports whether the watchdog killed the process
+func (w *watchdog) killed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fired
+}
+
+// silence returns how long the process had been silent when it was killed
+func (w *watchdog) silence() time.Duration {
+	return w.timeout + w.grace
+}
+
+// stall reports the stall and starts the grace period
+func (w *watchdog) stall(generation int) {
+	w.mu.Lock()
+	if generation != w.generation {
+		w.mu.Unlock()
+		return
+	}
+	since, killAt := w.last, w.clock.Now().Add(w.grace)
+	w.arm(w.grace, w.kill)
+	w.mu.Unlock()
+
+	if w.onStall != nil {
+		w.onStall(since, killAt)
+	}
+}
+
+// kill stops the process that stayed silent through the grace period
+func (w *watchdog) kill(generation int) {
+	w.mu.Lock()
+	if generation != w.generation {
+		w.mu.Unlock()
+		return
+	}
+	w.fired = true
+	w.stopped = true
+	w.mu.Unlock()
+
+	w.onKill()
+}
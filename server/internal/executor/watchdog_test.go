@@ -0,0 +1,132 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/test/mocks"
+)
+
+func TestWatchdog(t *testing.T) {
+	var stalls, kills atomic.Int32
+	clock := mocks.NewClock(time.Now())
+	w := newWatchdog(clock, 50*time.Millisecond, 30*time.Millisecond,
+		func(since, killAt time.Time) { stalls.Add(1) },
+		func() { kills.Add(1) })
+	defer w.stop()
+
+	// Output during the grace period cancels the kill
+	clock.Advance(50 * time.Millisecond)
+	if stalls.Load() != 1 {
+		t.Fatalf("expected a stall, got %d", stalls.Load())
+	}
+	w.output()
+	clock.Advance(40 * time.Millisecond)
+	if stalls.Load() != 1 || kills.Load() != 0 {
+		t.Fatalf("expected output to cancel the kill, got %d stalls, %d kills", stalls.Load(), kills.Load())
+	}
+
+	// A paused watch reports nothing
+	w.pause()
+	clock.Advance(time.Second)
+	if stalls.Load() != 1 || kills.Load() != 0 {
+		t.Fatalf("expected no stall while paused, got %d stalls, %d kills", stalls.Load(), kills.Load())
+	}
+
+	// Silence through the grace period kills
+	w.resume()
+	clock.Advance(49 * time.Millisecond)
+	if stalls.Load() != 1 {
+		t.Fatal("expected silence to count from the resume")
+	}
+	clock.Advance(time.Millisecond)
+	clock.Advance(30 * time.Millisecond)
+	if stalls.Load() != 2 || kills.Load() != 1 || !w.killed() {
+		t.Errorf("expected a kill, got %d stalls, %d kills", stalls.Load(), kills.Load())
+	}
+}
+
+func TestWatchdogHold(t *testing.T) {
+	var stalls, kills atomic.Int32
+	clock := mocks.NewClock(time.Now())
+	w := newWatchdog(clock, 50*time.Millisecond, 100*time.Millisecond,
+		func(since, killAt time.Time) { stalls.Add(1) },
+		func() { kills.Add(1) })
+	defer w.stop()
+
+	// A held process is not killed during the grace period
+	clock.Advance(50 * time.Millisecond)
+	w.hold()
+	clock.Advance(time.Second)
+	if stalls.Load() != 1 || kills.Load() != 0 {
+		t.Fatalf("expected no kill while held, got %d stalls, %d kills", stalls.Load(), kills.Load())
+	}
+
+	// Resuming input while held keeps the watch stopped
+	w.pause()
+	w.resume()
+	clock.Advance(time.Second)
+	if stalls.Load() != 1 {
+		t.Fatalf("expected no stall while held, got %d", stalls.Load())
+	}
+
+	// Releasing counts silence from then
+	w.release()
+	clock.Advance(49 * time.Millisecond)
+	if stalls.Load() != 1 {
+		t.Fatal("expected silence to count from the release")
+	}
+	clock.Advance(time.Millisecond)
+	if stalls.Load() != 2 {
+		t.Fatalf("expected a stall after the release, got %d", stalls.Load())
+	}
+	w.stop()
+	clock.Advance(time.Second)
+	if kills.Load() != 0 || w.killed() {
+		t.Error("expected a stopped watch not to kill")
+	}
+}
+
+func TestExecutionStallKillsProcess(t *testing.T) {
+	mockPath := createMockClaude(t, "cat > /dev/null\necho '{\"type\":\"system\",\"session_id\":\"s1\"}'\nsleep 30\n")
+	defer os.RemoveAll(filepath.Dir(mockPath))
+
+	executor, err := NewClaudeExecutor(Config{
+		ClaudePath:     mockPath,
+		DefaultTimeout: time.Minute,
+		StallTimeout:   100 * time.Millisecond,
+		StallGrace:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.logger = logger.New("error")
+	project := &models.Project{ID: "stalled-project", Path: t.TempDir()}
+
+	var stalled atomic.Pointer[Stall]
+	options := ExecuteOptions{
+		Prompt:  "hello",
+		OnStall: func(s Stall) { stalled.Store(&s) },
+	}
+	var received atomic.Int32
+	started := time.Now()
+	_, err = executor.ExecuteWithCallback(project, options, func(models.ClaudeMessage) { received.Add(1) })
+	if !errors.IsCode(err, errors.CodeExecutionStalled) {
+		t.Fatalf("expected a stalled execution, got %v", err)
+	}
+	if time.Since(started) > 10*time.Second {
+		t.Errorf("stalled process ran for %s", time.Since(started))
+	}
+	if s := stalled.Load(); s == nil || s.ProjectID != project.ID || !s.KillAt.After(s.Since) {
+		t.Errorf("expected subscribers to be warned, got %+v", s)
+	}
+	if received.Load() != 1 {
+		t.Errorf("expected the message before the stall, got %d messages", received.Load())
+	}
+}
@@ -0,0 +1,350 @@
+// Package history keeps a record of every execution of a project: what was
+// run, by whom, how it ended and which part of the message log it wrote.
+// Each record is a JSON file in the project's data directory, written when
+// the run starts and updated when it ends. The patch of the changes a run
+// made is kept in a file next to its record.
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+	"github.com/google/uuid"
+)
+
+// DirName is the directory of execution records in a project's data directory
+const DirName = "executions"
+
+// StderrTailSize is the number of trailing stderr bytes kept in a record
+const StderrTailSize = 4096
+
+// Status is the outcome of an execution
+type Status string
+
+const (
+	// StatusRunning is an execution that has not ended yet
+	StatusRunning Status = "running"
+	// StatusCompleted is an execution that exited successfully
+	StatusCompleted Status = "completed"
+	// StatusFailed is an execution that exited with an error
+	StatusFailed Status = "failed"
+	// StatusKilled is an execution stopped with agent_kill
+	StatusKilled Status = "killed"
+	// StatusTimedOut is an execution that exceeded its timeout
+	StatusTimedOut Status = "timed_out"
+	// StatusInterrupted is an execution the server stopped tracking, e.g.
+	// because it restarted while the execution ran
+	StatusInterrupted Status = "interrupted"
+)
+
+// Record describes one execution of a project
+type Record struct {
+	ID        string                `json:"id"`
+	ProjectID string                `json:"project_id"`
+	Status    Status                `json:"status"`
+	Prompt    string                `json:"prompt"`
+	Options   *models.ClaudeOptions `json:"options,omitempty"`
+	StartedBy string                `json:"started_by,omitempty"`
+	QueueID   string                `json:"queue_id,omitempty"`
+	// Worktree is the git worktree the execution ran in, if any
+	Worktree  *models.Worktree `json:"worktree,omitempty"`
+	StartedAt time.Time        `json:"started_at"`
+	EndedAt   *time.Time       `json:"ended_at,omitempty"`
+	ExitCode  *int             `json:"exit_code,omitempty"`
+	// StderrTail holds the last StderrTailSize bytes of stderr
+	StderrTail string              `json:"stderr_tail,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	SessionID  string              `json:"session_id,omitempty"`
+	Messages   models.MessageRange `json:"messages"`
+	Usage      models.Usage        `json:"usage"`
+	// Changes is what the execution changed in its working directory, if
+	// they were captured
+	Changes *models.WorkspaceChanges `json:"changes,omitempty"`
+}
+
+// Outcome is how an execution ended
+type Outcome struct {
+	Status    Status
+	ExitCode  *int
+	Stderr    string
+	Error     string
+	SessionID string
+	Messages  models.MessageRange
+	Usage     models.Usage
+	Changes   *models.WorkspaceChanges
+	Patch     string
+}
+
+// Store persists the execution records of all projects
+type Store struct {
+	dataDir    string
+	maxRecords int
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewStore creates a store under dataDir keeping at most maxRecords records
+// per project; 0 keeps all
+func NewStore(dataDir string, maxRecords int) *Store {
+	return &Store{
+		dataDir:    dataDir,
+		maxRecords: maxRecords,
+		now:        time.Now,
+	}
+}
+
+// Start records a new running execution. ID, Status and StartedAt are set
+// by the store.
+func (s *Store) Start(rec Record) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec.ID = uuid.New().String()
+	rec.Status = StatusRunning
+	rec.StartedAt = s.now().UTC()
+
+	if err := s.save(rec); err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+// Finish records how an execution ended and prunes old records
+func (s *Store) Finish(projectID, id string, outcome Outcome) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.load(projectID, id)
+	if err != nil {
+		return Record{}, err
+	}
+
+	ended := s.now().UTC()
+	rec.Status = outcome.Status
+	rec.EndedAt = &ended
+	rec.ExitCode = outcome.ExitCode
+	rec.StderrTail = tail(outcome.Stderr, StderrTailSize)
+	rec.Error = outcome.Error
+	rec.Messages = outcome.Messages
+	rec.Usage = outcome.Usage
+	rec.Changes = outcome.Changes
+	if outcome.SessionID != "" {
+		rec.SessionID = outcome.SessionID
+	}
+
+	if outcome.Patch != "" {
+		if err := storage.WriteFileAtomic(s.patchPath(projectID, id), []byte(outcome.Patch), 0o600); err != nil {
+			return Record{}, errors.NewFileOperationError("write execution patch", err)
+		}
+	}
+	if err := s.save(rec); err != nil {
+		return Record{}, err
+	}
+	s.prune(projectID)
+	return rec, nil
+}
+
+// Get returns a record of a project
+func (s *Store) Get(projectID, id string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(projectID, id)
+}
+
+// Patch returns the patch of the changes an execution made; it is empty
+// when it changed nothing or its changes were not captured
+func (s *Store) Patch(projectID, id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.load(projectID, id); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(s.patchPath(projectID, id))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.NewFileOperationError("read execution patch", err)
+	}
+	return string(data), nil
+}
+
+// List returns a project's records newest first. Only records started
+// before before are returned unless it is zero; limit <= 0 returns all.
+func (s *Store) List(projectID string, before time.Time, limit int) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.all(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Record, 0, len(records))
+	for _, rec := range records {
+		if !before.IsZero() && !rec.StartedAt.Before(before) {
+			continue
+		}
+		out = append(out, rec)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Interrupt marks the project's running records as interrupted. It is
+// called on startup, when no execution of a previous server run is tracked.
+func (s *Store) Interrupt(projectID string) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.all(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var interrupted []Record
+	for _, rec := range records {
+		if rec.Status != StatusRunning {
+			continue
+		}
+		ended := s.now().UTC()
+		rec.Status = StatusInterrupted
+		rec.EndedAt = &ended
+		if err := s.save(rec); err != nil {
+			return interrupted, err
+		}
+		interrupted = append(interrupted, rec)
+	}
+	return interrupted, nil
+}
+
+// dir returns the record directory of a project
+func (s *Store) dir(projectID string) string {
+	return filepath.Join(s.dataDir, storage.ProjectsDirName, projectID, DirName)
+}
+
+// patchPath returns the path of an execution's patch
+func (s *Store) patchPath(projectID, id string) string {
+	return filepath.Join(s.dir(projectID), id+".patch")
+}
+
+// load reads one record. Callers hold s.mu.
+func (s *Store) load(projectID, id string) (Record, error) {
+	// IDs are UUIDs; anything else could escape the directory
+	if _, err := uuid.Parse(id); err != nil {
+		return Record{}, notFound(projectID, id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir(projectID), id+".json"))
+	if os.IsNotExist(err) {
+		return Record{}, notFound(projectID, id)
+	}
+	if err != nil {
+		return Record{}, errors.NewFileOperationError("read execution record", err)
+	}
+
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Record{}, errors.NewJSONParsingError(err)
+	}
+	return rec, nil
+}
+
+// all reads a project's records, newest first. Unreadable records are
+// skipped. Callers hold s.mu.
+func (s *Store) all(projectID string) ([]Record, error) {
+	entries, err := os.ReadDir(s.dir(projectID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.NewFileOperationError("list execution records", err)
+	}
+
+	records := make([]Record, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		rec, err := s.load(projectID, id)
+		if err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+	return records, nil
+}
+
+// save writes a record. Callers hold s.mu.
+func (s *Store) save(rec Record) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+
+	dir := s.dir(rec.ProjectID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.NewFileOperationError("create execution record directory", err)
+	}
+	if err := storage.WriteFileAtomic(filepath.Join(dir, rec.ID+".json"), data, 0o600); err != nil {
+		return errors.NewFileOperationError("write execution record", err)
+	}
+	return nil
+}
+
+// prune removes the oldest finished records beyond maxRecords. Callers
+// hold s.mu.
+func (s *Store) prune(projectID string) {
+	if s.maxRecords <= 0 {
+		return
+	}
+
+	records, err := s.all(projectID)
+	if err != nil || len(records) <= s.maxRecords {
+		return
+	}
+	for _, rec := range records[s.maxRecords:] {
+		if rec.Status == StatusRunning {
+			continue
+		}
+		os.Remove(filepath.Join(s.dir(projectID), rec.ID+".json"))
+		os.Remove(s.patchPath(projectID, rec.ID))
+	}
+}
+
+// tail returns the last n bytes of s, starting at a rune boundary
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	start := len(s) - n
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
+
+// notFound reports an unknown execution
+func notFound(projectID, id string) *errors.AppError {
+	return errors.New(errors.CodeExecutionNotFound, "execution not found").
+		WithDetail("project_id", projectID).
+		WithDetail("execution_id", id)
+}
@@ -0,0 +1,198 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStore returns a store whose clock advances a second per call
+func newTestStore(t *testing.T, maxRecords int) *Store {
+	s := NewStore(t.TempDir(), maxRecords)
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	return s
+}
+
+func startAll(t *testing.T, s *Store, projectID string, prompts ...string) []Record {
+	records := make([]Record, 0, len(prompts))
+	for _, prompt := range prompts {
+		rec, err := s.Start(Record{ProjectID: projectID, Prompt: prompt, StartedBy: "device:phone"})
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestStore_StartAndFinish(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	rec, err := s.Start(Record{
+		ProjectID: "p1",
+		Prompt:    "hello",
+		Options:   &models.ClaudeOptions{Model: "sonnet"},
+		StartedBy: "device:phone",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, rec.ID)
+	assert.Equal(t, StatusRunning, rec.Status)
+	assert.False(t, rec.StartedAt.IsZero())
+
+	exitCode := 1
+	messages := models.MessageRange{From: rec.StartedAt, To: rec.StartedAt.Add(time.Second), Count: 3}
+	finished, err := s.Finish("p1", rec.ID, Outcome{
+		Status:    StatusFailed,
+		ExitCode:  &exitCode,
+		Stderr:    "boom",
+		Error:     "exit status 1",
+		SessionID: "session-1",
+		Messages:  messages,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, finished.Status)
+	require.NotNil(t, finished.EndedAt)
+
+	// The record is read back from disk
+	got, err := NewStore(s.dataDir, 0).Get("p1", rec.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got.Prompt)
+	assert.Equal(t, "sonnet", got.Options.Model)
+	assert.Equal(t, 1, *got.ExitCode)
+	assert.Equal(t, "boom", got.StderrTail)
+	assert.Equal(t, "session-1", got.SessionID)
+	assert.Equal(t, 3, got.Messages.Count)
+
+	// Records belong to their project
+	_, err = s.Get("p2", rec.ID)
+	assert.True(t, errors.IsCode(err, errors.CodeExecutionNotFound))
+
+	_, err = s.Get("p1", "../../etc/passwd")
+	assert.True(t, errors.IsCode(err, errors.CodeExecutionNotFound))
+}
+
+func TestStore_StderrTail(t *testing.T) {
+	s := newTestStore(t, 0)
+	rec := startAll(t, s, "p1", "hello")[0]
+
+	stderr := strings.Repeat("a", StderrTailSize) + "end"
+	finished, err := s.Finish("p1", rec.ID, Outcome{Status: StatusFailed, Stderr: stderr})
+	require.NoError(t, err)
+	assert.Len(t, finished.StderrTail, StderrTailSize)
+	assert.True(t, strings.HasSuffix(finished.StderrTail, "end"))
+}
+
+func TestStore_List(t *testing.T) {
+	s := newTestStore(t, 0)
+	records := startAll(t, s, "p1", "one", "two", "three")
+
+	listed, err := s.List("p1", time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, listed, 3)
+	assert.Equal(t, "three", listed[0].Prompt)
+	assert.Equal(t, "one", listed[2].Prompt)
+
+	// Pages continue before the last record
+	page, err := s.List("p1", time.Time{}, 2)
+	require.NoError(t, err)
+	assert.Len(t, page, 2)
+
+	page, err = s.List("p1", page[1].StartedAt, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, records[0].ID, page[0].ID)
+
+	empty, err := s.List("p2", time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
+func TestStore_Prune(t *testing.T) {
+	s := newTestStore(t, 2)
+	records := startAll(t, s, "p1", "one", "two", "three")
+
+	// Running records are kept until they finish
+	for _, rec := range records[1:] {
+		_, err := s.Finish("p1", rec.ID, Outcome{Status: StatusCompleted})
+		require.NoError(t, err)
+	}
+	listed, err := s.List("p1", time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+
+	_, err = s.Finish("p1", records[0].ID, Outcome{Status: StatusCompleted})
+	require.NoError(t, err)
+
+	listed, err = s.List("p1", time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, []string{"three", "two"}, []string{listed[0].Prompt, listed[1].Prompt})
+
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, "projects", "p1", DirName))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestStore_Patch(t *testing.T) {
+	s := newTestStore(t, 1)
+	first := startAll(t, s, "p1", "one")[0]
+
+	changes := &models.WorkspaceChanges{
+		Files:     []models.FileChange{{Path: "main.go", Status: models.FileModified, Additions: 1}},
+		Additions: 1,
+	}
+	patch := "diff --git a/main.go b/main.go\n"
+	rec, err := s.Finish("p1", first.ID, Outcome{Status: StatusCompleted, Changes: changes, Patch: patch})
+	require.NoError(t, err)
+	assert.Equal(t, changes, rec.Changes)
+
+	got, err := s.Patch("p1", first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, patch, got)
+	_, err = s.Patch("p1", "no-such-id")
+	assert.True(t, errors.IsCode(err, errors.CodeExecutionNotFound))
+
+	// Runs without captured changes have no patch
+	second := startAll(t, s, "p1", "two")[0]
+	got, err = s.Patch("p1", second.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// The patch goes with its record
+	_, err = s.Finish("p1", second.ID, Outcome{Status: StatusCompleted})
+	require.NoError(t, err)
+	_, err = s.Patch("p1", first.ID)
+	assert.True(t, errors.IsCode(err, errors.CodeExecutionNotFound))
+	assert.NoFileExists(t, filepath.Join(s.dataDir, "projects", "p1", DirName, first.ID+".patch"))
+}
+
+func TestStore_Interrupt(t *testing.T) {
+	s := newTestStore(t, 0)
+	records := startAll(t, s, "p1", "one", "two")
+
+	_, err := s.Finish("p1", records[0].ID, Outcome{Status: StatusCompleted})
+	require.NoError(t, err)
+
+	interrupted, err := s.Interrupt("p1")
+	require.NoError(t, err)
+	require.Len(t, interrupted, 1)
+	assert.Equal(t, records[1].ID, interrupted[0].ID)
+
+	got, err := s.Get("p1", records[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusInterrupted, got.Status)
+	assert.NotNil(t, got.EndedAt)
+
+	got, err = s.Get("p1", records[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCompleted, got.Status)
+}
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
 )
 
 // Collector collects and aggregates metrics
@@ -28,6 +30,9 @@ type Collector struct {
 	goroutineCount int32
 	cpuPercent     float64
 
+	// Token usage and cost of finished executions
+	usage models.Usage
+
 	mu sync.RWMutex
 }
 
@@ -112,6 +117,13 @@ func (c *Collector) RecordExecutionDuration(duration time.Duration) {
 	c.executionDurations.Record(duration)
 }
 
+// RecordUsage adds the usage of a finished execution
+func (c *Collector) RecordUsage(usage models.Usage) {
+	c.mu.Lock()
+	c.usage.Add(usage)
+	c.mu.Unlock()
+}
+
 // UpdateResourceMetrics updates resource usage metrics
 func (c *Collector) UpdateResourceMetrics(memoryMB uint64, goroutines int, cpuPercent float64) {
 	atomic.StoreUint64(&c.memoryUsage, memoryMB)
@@ -126,6 +138,7 @@ func (c *Collector) UpdateResourceMetrics(memoryMB uint64, goroutines int, cpuPe
 func (c *Collector) GetSnapshot() Snapshot {
 	c.mu.RLock()
 	cpu := c.cpuPercent
+	usage := c.usage
 	c.mu.RUnlock()
 
 	return Snapshot{
@@ -149,6 +162,7 @@ func (c *Collector) GetSnapshot() Snapshot {
 			ExecutionDurations: c.executionDurations.GetPercentiles(),
 			MessageThroughput:  c.messageThroughput.GetRate(),
 		},
+		Usage: usage,
 	}
 }
 
@@ -242,6 +256,7 @@ type Snapshot struct {
 	Gauges      GaugeSnapshot
 	Resources   ResourceSnapshot
 	Performance PerformanceSnapshot
+	Usage       models.Usage
 	Timestamp   time.Time
 }
 
@@ -0,0 +1,89 @@
+package models
+
+import "fmt"
+
+// Role is the level of access an identity has to a project
+type Role string
+
+const (
+	// RoleObserver can join a project and read its messages
+	RoleObserver Role = "observer"
+	// RoleExecutor can additionally run and stop executions
+	RoleExecutor Role = "executor"
+	// RoleOwner can additionally delete the project and manage its ACL
+	RoleOwner Role = "owner"
+)
+
+// roleRank orders roles from least to most privileged
+var roleRank = map[Role]int{
+	RoleObserver: 1,
+	RoleExecutor: 2,
+	RoleOwner:    3,
+}
+
+// Valid reports whether the role is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// Includes reports whether the role grants at least the required role
+func (r Role) Includes(required Role) bool {
+	return roleRank[r] >= roleRank[required] && r.Valid()
+}
+
+// ParseRole validates a role name
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if !role.Valid() {
+		return "", fmt.Errorf("invalid role %q (must be owner, executor or observer)", s)
+	}
+	return role, nil
+}
+
+// RoleOf returns the role an identity holds on the project, or "" if it has
+// no access. A nil identity (authentication disabled) and admin identities
+// are treated as owners.
+func (p *Project) RoleOf(identity *Identity) Role {
+	if identity == nil || identity.Admin {
+		return RoleOwner
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ACL[identity.Principal()]
+}
+
+// GetACL returns a copy of the project's access control list
+func (p *Project) GetACL() map[string]Role {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return copyACL(p.ACL)
+}
+
+// SetRole grants a role to a principal, or removes its access if role is empty
+func (p *Project) SetRole(principal string, role Role) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if role == "" {
+		delete(p.ACL, principal)
+		return
+	}
+	if p.ACL == nil {
+		p.ACL = make(map[string]Role)
+	}
+	p.ACL[principal] = role
+}
+
+// copyACL returns a copy of an ACL map, or nil for an empty ACL
+func copyACL(acl map[string]Role) map[string]Role {
+	if len(acl) == 0 {
+		return nil
+	}
+	copied := make(map[string]Role, len(acl))
+	for principal, role := range acl {
+		copied[principal] = role
+	}
+	return copied
+}
@@ -0,0 +1,107 @@
+package models
+
+import "testing"
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		{RoleOwner, RoleOwner, true},
+		{RoleOwner, RoleObserver, true},
+		{RoleExecutor, RoleExecutor, true},
+		{RoleExecutor, RoleOwner, false},
+		{RoleObserver, RoleObserver, true},
+		{RoleObserver, RoleExecutor, false},
+		{Role(""), RoleObserver, false},
+		{Role("superuser"), RoleObserver, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.required); got != tt.want {
+			t.Errorf("%q.Includes(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if role, err := ParseRole("executor"); err != nil || role != RoleExecutor {
+		t.Errorf("expected executor, got %q (err: %v)", role, err)
+	}
+
+	if _, err := ParseRole("admin"); err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
+
+func TestProjectRoleOf(t *testing.T) {
+	project := NewProject("test-id", "/test/path")
+	device := &Identity{ID: "dev-1", Method: AuthMethodDevice}
+	token := &Identity{ID: "tok-1", Method: AuthMethodToken}
+
+	// Authentication disabled and admins have full access
+	if role := project.RoleOf(nil); role != RoleOwner {
+		t.Errorf("expected owner for nil identity, got %q", role)
+	}
+	if role := project.RoleOf(&Identity{ID: "tok-2", Method: AuthMethodToken, Admin: true}); role != RoleOwner {
+		t.Errorf("expected owner for admin identity, got %q", role)
+	}
+
+	// Other identities need an ACL entry
+	if role := project.RoleOf(device); role != "" {
+		t.Errorf("expected no access, got %q", role)
+	}
+
+	project.SetRole(device.Principal(), RoleObserver)
+	if role := project.RoleOf(device); role != RoleObserver {
+		t.Errorf("expected observer, got %q", role)
+	}
+
+	// Roles are keyed by method, so a token with the same ID is unaffected
+	if role := project.RoleOf(&Identity{ID: "dev-1", Method: AuthMethodToken}); role != "" {
+		t.Errorf("expected no access for token with device ID, got %q", role)
+	}
+	if role := project.RoleOf(token); role != "" {
+		t.Errorf("expected no access, got %q", role)
+	}
+
+	project.SetRole(device.Principal(), "")
+	if role := project.RoleOf(device); role != "" {
+		t.Errorf("expected access to be removed, got %q", role)
+	}
+}
+
+func TestProjectACLPersistence(t *testing.T) {
+	project := NewProject("test-id", "/test/path")
+	project.SetRole("device:dev-1", RoleOwner)
+
+	metadata := project.ToMetadata()
+	restored := FromMetadata(metadata)
+	if restored.ACL["device:dev-1"] != RoleOwner {
+		t.Errorf("expected ACL to round-trip, got %v", restored.ACL)
+	}
+
+	// GetACL returns a copy
+	acl := project.GetACL()
+	acl["device:dev-2"] = RoleOwner
+	if _, ok := project.GetACL()["device:dev-2"]; ok {
+		t.Error("expected GetACL to return a copy")
+	}
+}
+
+func TestValidatePrincipal(t *testing.T) {
+	valid := []string{"token:abc", "device:abc"}
+	for _, p := range valid {
+		if err := ValidatePrincipal(p); err != nil {
+			t.Errorf("expected %q to be valid: %v", p, err)
+		}
+	}
+
+	invalid := []string{"", "abc", "pairing:abc", "device:", "mtls:abc"}
+	for _, p := range invalid {
+		if err := ValidatePrincipal(p); err == nil {
+			t.Errorf("expected %q to be invalid", p)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthMethod identifies how a session was authenticated
+type AuthMethod string
+
+const (
+	// AuthMethodToken indicates authentication with a server-issued bearer token
+	AuthMethodToken AuthMethod = "token"
+	// AuthMethodDevice indicates authentication with a paired device credential
+	AuthMethodDevice AuthMethod = "device"
+	// AuthMethodPairing indicates a connection presenting a one-time pairing
+	// code; it may only be used to complete pairing
+	AuthMethodPairing AuthMethod = "pairing"
+	// AuthMethodCertificate indicates authentication with a verified TLS
+	// client certificate
+	AuthMethodCertificate AuthMethod = "certificate"
+)
+
+// Identity represents the authenticated principal behind a session
+type Identity struct {
+	// ID is the stable identifier of the principal (e.g. the token ID)
+	ID string `json:"id"`
+	// Name is a human readable label for the principal
+	Name string `json:"name"`
+	// Method is the mechanism used to authenticate the principal
+	Method AuthMethod `json:"method"`
+	// Admin identities bypass per-project access control
+	Admin bool `json:"admin,omitempty"`
+	// Serial is the client certificate serial for certificate identities
+	Serial string `json:"serial,omitempty"`
+}
+
+// IsPairing reports whether the identity is a pairing-only identity
+func (i *Identity) IsPairing() bool {
+	return i != nil && i.Method == AuthMethodPairing
+}
+
+// Principal returns the key identifying the principal in access control lists
+func (i *Identity) Principal() string {
+	return string(i.Method) + ":" + i.ID
+}
+
+// String returns a compact representation suitable for logging
+func (i *Identity) String() string {
+	if i == nil {
+		return "anonymous"
+	}
+	return i.Principal()
+}
+
+// ValidatePrincipal checks that a principal key names a token, device or
+// client certificate
+func ValidatePrincipal(principal string) error {
+	method, id, ok := strings.Cut(principal, ":")
+	if !ok || id == "" {
+		return fmt.Errorf("invalid principal %q, expected <method>:<id>", principal)
+	}
+	switch AuthMethod(method) {
+	case AuthMethodToken, AuthMethodDevice, AuthMethodCertificate:
+		return nil
+	default:
+		return fmt.Errorf("invalid principal method %q, expected token, device or certificate", method)
+	}
+}
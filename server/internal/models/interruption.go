@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Interruption is a run a server crash or restart cut short, found when
+// the server started again
+type Interruption struct {
+	// ExecutionID identifies the run's execution record, if one was kept
+	ExecutionID string `json:"execution_id,omitempty"`
+	// WorktreeID is set for runs in a worktree of the project
+	WorktreeID string `json:"worktree_id,omitempty"`
+	// SessionID is the Claude session the run resumed, if any
+	SessionID string    `json:"session_id,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	// InterruptedAt is when the server found the run interrupted
+	InterruptedAt time.Time `json:"interrupted_at"`
+	// ProcessKilled reports that the run's process, or processes it
+	// started, survived the server and were stopped
+	ProcessKilled bool `json:"process_killed"`
+}
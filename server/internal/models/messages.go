@@ -12,29 +12,70 @@ type MessageType string
 
 const (
 	// Client to Server message types
-	MessageTypeExecute         MessageType = "execute"
-	MessageTypeProjectCreate   MessageType = "project_create"
-	MessageTypeProjectDelete   MessageType = "project_delete"
-	MessageTypeProjectList     MessageType = "project_list"
-	MessageTypeProjectJoin     MessageType = "project_join"
-	MessageTypeProjectLeave    MessageType = "project_leave"
-	MessageTypeAgentNewSession MessageType = "agent_new_session"
-	MessageTypeAgentKill       MessageType = "agent_kill"
-	MessageTypeGetMessages     MessageType = "get_messages"
+	MessageTypeExecute            MessageType = "execute"
+	MessageTypeProjectCreate      MessageType = "project_create"
+	MessageTypeProjectDelete      MessageType = "project_delete"
+	MessageTypeProjectList        MessageType = "project_list"
+	MessageTypeProjectJoin        MessageType = "project_join"
+	MessageTypeProjectLeave       MessageType = "project_leave"
+	MessageTypeAgentNewSession    MessageType = "agent_new_session"
+	MessageTypeAgentKill          MessageType = "agent_kill"
+	MessageTypeAgentPause         MessageType = "agent_pause"
+	MessageTypeAgentResume        MessageType = "agent_resume"
+	MessageTypeGetMessages        MessageType = "get_messages"
+	MessageTypePair               MessageType = "pair"
+	MessageTypeDeviceList         MessageType = "device_list"
+	MessageTypeDeviceRename       MessageType = "device_rename"
+	MessageTypeDeviceRevoke       MessageType = "device_revoke"
+	MessageTypeProjectACLGet      MessageType = "project_acl_get"
+	MessageTypeProjectACLUpdate   MessageType = "project_acl_update"
+	MessageTypePolicyList         MessageType = "policy_list"
+	MessageTypeProjectSetPolicy   MessageType = "project_set_policy"
+	MessageTypeAuditQuery         MessageType = "audit_query"
+	MessageTypeQueueList          MessageType = "queue_list"
+	MessageTypeQueueMove          MessageType = "queue_move"
+	MessageTypeQueueCancel        MessageType = "queue_cancel"
+	MessageTypePermissionResponse MessageType = "permission_response"
+	MessageTypeSendInput          MessageType = "send_input"
+	MessageTypeExecutionList      MessageType = "execution_list"
+	MessageTypeExecutionGet       MessageType = "execution_get"
+	MessageTypeExecutionDiff      MessageType = "execution_diff"
+	MessageTypeUsageReport        MessageType = "usage_report"
+	MessageTypeScheduleCreate     MessageType = "schedule_create"
+	MessageTypeScheduleList       MessageType = "schedule_list"
+	MessageTypeScheduleDelete     MessageType = "schedule_delete"
+	MessageTypeProjectEnvGet      MessageType = "project_env_get"
+	MessageTypeProjectEnvSet      MessageType = "project_env_set"
+	MessageTypeProjectEnvUnset    MessageType = "project_env_unset"
+	MessageTypeProjectEnvSetMode  MessageType = "project_env_set_mode"
+	MessageTypeWorktreeList       MessageType = "worktree_list"
+	MessageTypeWorktreeMerge      MessageType = "worktree_merge"
+	MessageTypeWorktreeDiscard    MessageType = "worktree_discard"
+	MessageTypeSessionList        MessageType = "session_list"
+	MessageTypeSessionSwitch      MessageType = "session_switch"
+	MessageTypeSessionFork        MessageType = "session_fork"
+	MessageTypeBackendList        MessageType = "backend_list"
+	MessageTypeProjectSetBackend  MessageType = "project_set_backend"
 
 	// Server to Client message types
-	MessageTypeError            MessageType = "error"
-	MessageTypeProjectState     MessageType = "project_state"
-	MessageTypeProjectJoined    MessageType = "project_joined"
-	MessageTypeProjectLeft      MessageType = "project_left"
-	MessageTypeAgentMessage     MessageType = "agent_message"
-	MessageTypeServerStats      MessageType = "server_stats"
-	MessageTypeHealthCheck      MessageType = "health_check"
-	MessageTypeProjectUpdate    MessageType = "project_update"
-	MessageTypeProjectDeleted   MessageType = "project_deleted"
-	MessageTypeSessionReset     MessageType = "session_reset"
-	MessageTypeProcessKilled    MessageType = "process_killed"
-	MessageTypeConnectionHealth MessageType = "connection_health"
+	MessageTypeError                MessageType = "error"
+	MessageTypeProjectState         MessageType = "project_state"
+	MessageTypeProjectJoined        MessageType = "project_joined"
+	MessageTypeProjectLeft          MessageType = "project_left"
+	MessageTypeAgentMessage         MessageType = "agent_message"
+	MessageTypeServerStats          MessageType = "server_stats"
+	MessageTypeHealthCheck          MessageType = "health_check"
+	MessageTypeProjectUpdate        MessageType = "project_update"
+	MessageTypeProjectDeleted       MessageType = "project_deleted"
+	MessageTypeSessionReset         MessageType = "session_reset"
+	MessageTypeProcessKilled        MessageType = "process_killed"
+	MessageTypeConnectionHealth     MessageType = "connection_health"
+	MessageTypeQueueUpdated         MessageType = "queue_updated"
+	MessageTypePermissionRequest    MessageType = "permission_request"
+	MessageTypePermissionResolved   MessageType = "permission_resolved"
+	MessageTypeWorkspaceChanges     MessageType = "workspace_changes"
+	MessageTypeExecutionInterrupted MessageType = "execution_interrupted"
+	MessageTypeExecutionStalled     MessageType = "execution_stalled"
 )
 
 // ClientMessage represents a message from client to server
@@ -55,6 +96,9 @@ type ServerMessage struct {
 type ExecuteCommand struct {
 	Prompt  string         `json:"prompt"`
 	Options *ClaudeOptions `json:"options,omitempty"`
+	// TimeoutSeconds overrides the server's execution timeout, up to the
+	// maximum of the project's policy or else the server's
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
 }
 
 // ClaudeOptions contains optional parameters for Claude execution
@@ -69,6 +113,10 @@ type ClaudeOptions struct {
 	FallbackModel              string   `json:"fallback_model,omitempty"`
 	AddDirs                    []string `json:"add_dirs,omitempty"`
 	StrictMCPConfig            bool     `json:"strict_mcp_config,omitempty"`
+	// Interactive keeps the execution running for follow-up send_input turns
+	Interactive bool `json:"interactive,omitempty"`
+	// Worktree runs the execution in a fresh git worktree on a new branch
+	Worktree *WorktreeOptions `json:"worktree,omitempty"`
 }
 
 // ProjectCreateData contains data for creating a project
@@ -124,7 +172,39 @@ type HealthCheckData struct {
 type TimestampedMessage struct {
 	Timestamp time.Time     `json:"timestamp"`
 	Message   ClaudeMessage `json:"message"`
-	Direction string        `json:"direction"` // "client" or "claude"
+	Direction string        `json:"direction"` // "client", "claude" or "server"
+}
+
+// MessageRange is the part of a project's message log written by one
+// execution. From can be passed as "since" to get_messages.
+type MessageRange struct {
+	From  time.Time `json:"from,omitempty"`
+	To    time.Time `json:"to,omitempty"`
+	Count int       `json:"count"`
+}
+
+// Usage is the tokens Claude consumed and what they cost, as reported by
+// the CLI
+type Usage struct {
+	InputTokens              int64   `json:"input_tokens"`
+	OutputTokens             int64   `json:"output_tokens"`
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens"`
+	CostUSD                  float64 `json:"cost_usd"`
+}
+
+// Add adds other to u
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+	u.CostUSD += other.CostUSD
+}
+
+// IsZero reports whether no usage was recorded
+func (u Usage) IsZero() bool {
+	return u == Usage{}
 }
 
 // ClaudeMessage represents a message from Claude CLI
@@ -132,6 +212,11 @@ type ClaudeMessage struct {
 	Type    string          `json:"type"`
 	Content json.RawMessage `json:"content,omitempty"`
 	Error   string          `json:"error,omitempty"`
+	// Redactions names the redaction rules that removed secrets from Content
+	Redactions []string `json:"redactions,omitempty"`
+	// WorktreeID identifies the worktree of the execution that produced the
+	// message, if it ran in one
+	WorktreeID string `json:"worktree_id,omitempty"`
 }
 
 // MessageLog is now implemented in the storage package
@@ -175,7 +260,8 @@ func (m *ClientMessage) Validate() error {
 			return fmt.Errorf("path cannot be empty")
 		}
 
-	case MessageTypeProjectDelete, MessageTypeAgentNewSession, MessageTypeAgentKill:
+	case MessageTypeProjectDelete, MessageTypeAgentNewSession, MessageTypeAgentKill,
+		MessageTypeAgentPause, MessageTypeAgentResume:
 		if m.ProjectID == "" {
 			return fmt.Errorf("project_id required for %s", m.Type)
 		}
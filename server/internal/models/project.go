@@ -23,8 +23,17 @@ const (
 	StateExecuting State = "EXECUTING"
 	// StateError indicates the project encountered an error
 	StateError State = "ERROR"
+	// StatePaused indicates the project's execution is stopped until it is
+	// resumed
+	StatePaused State = "PAUSED"
 )
 
+// Active reports whether a project in the state has a running execution,
+// paused or not
+func (s State) Active() bool {
+	return s == StateExecuting || s == StatePaused
+}
+
 // Project represents a Claude project with its associated state and metadata
 type Project struct {
 	// ID is the unique identifier for the project (UUID)
@@ -47,16 +56,29 @@ type Project struct {
 	mu sync.RWMutex `json:"-"`
 	// ErrorDetails contains information about the last error if State is ERROR
 	ErrorDetails string `json:"error_details,omitempty"`
+	// ACL maps identity principals (e.g. "device:<id>") to their role on the project
+	ACL map[string]Role `json:"acl,omitempty"`
+	// Policy names the execution policy profile; empty uses the server default
+	Policy string `json:"policy,omitempty"`
+	// Backend names the agent backend that runs executions; empty runs the
+	// Claude CLI
+	Backend string `json:"backend,omitempty"`
+	// Schedules are the prompts run against the project on a schedule
+	Schedules []Schedule `json:"schedules,omitempty"`
 }
 
 // ProjectMetadata contains the persistent data for a project
 type ProjectMetadata struct {
-	ID           string    `json:"id"`
-	Path         string    `json:"path"`
-	SessionID    string    `json:"session_id,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	LastActive   time.Time `json:"last_active"`
-	ErrorDetails string    `json:"error_details,omitempty"`
+	ID           string          `json:"id"`
+	Path         string          `json:"path"`
+	SessionID    string          `json:"session_id,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	LastActive   time.Time       `json:"last_active"`
+	ErrorDetails string          `json:"error_details,omitempty"`
+	ACL          map[string]Role `json:"acl,omitempty"`
+	Policy       string          `json:"policy,omitempty"`
+	Backend      string          `json:"backend,omitempty"`
+	Schedules    []Schedule      `json:"schedules,omitempty"`
 }
 
 // NewProject creates a new project instance
@@ -154,6 +176,34 @@ func (p *Project) SubscriberCount() int {
 	return len(p.Subscribers)
 }
 
+// GetPolicy returns the project's execution policy profile name
+func (p *Project) GetPolicy() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Policy
+}
+
+// SetPolicy sets the project's execution policy profile name
+func (p *Project) SetPolicy(policy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Policy = policy
+}
+
+// GetBackend returns the name of the project's agent backend
+func (p *Project) GetBackend() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Backend
+}
+
+// SetBackend sets the name of the project's agent backend
+func (p *Project) SetBackend(backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Backend = backend
+}
+
 // ToMetadata converts a Project to ProjectMetadata for persistence
 func (p *Project) ToMetadata() ProjectMetadata {
 	p.mu.Lock()
@@ -166,6 +216,10 @@ func (p *Project) ToMetadata() ProjectMetadata {
 		CreatedAt:    p.CreatedAt,
 		LastActive:   p.LastActive,
 		ErrorDetails: p.ErrorDetails,
+		ACL:          copyACL(p.ACL),
+		Policy:       p.Policy,
+		Backend:      p.Backend,
+		Schedules:    copySchedules(p.Schedules),
 	}
 }
 
@@ -179,6 +233,10 @@ func FromMetadata(meta ProjectMetadata) *Project {
 		CreatedAt:    meta.CreatedAt,
 		LastActive:   meta.LastActive,
 		ErrorDetails: meta.ErrorDetails,
+		ACL:          copyACL(meta.ACL),
+		Policy:       meta.Policy,
+		Backend:      meta.Backend,
+		Schedules:    copySchedules(meta.Schedules),
 		Subscribers:  make(map[string]*Session),
 	}
 }
@@ -197,6 +255,10 @@ func (p *Project) Copy() *Project {
 		CreatedAt:    p.CreatedAt,
 		LastActive:   p.LastActive,
 		ErrorDetails: p.ErrorDetails,
+		ACL:          copyACL(p.ACL),
+		Policy:       p.Policy,
+		Backend:      p.Backend,
+		Schedules:    copySchedules(p.Schedules),
 		// MessageLog is not copied - it's a reference to the storage layer
 		// Subscribers are not copied - they belong to the original project
 		Subscribers: make(map[string]*Session), // Empty map for the copy
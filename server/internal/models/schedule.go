@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+// Schedule is a prompt run against a project whenever its cron expression
+// matches
+type Schedule struct {
+	// ID is the unique identifier for the schedule (UUID)
+	ID string `json:"id"`
+	// Cron is a five-field cron expression or descriptor such as "@daily"
+	Cron string `json:"cron"`
+	// Timezone is the IANA time zone the expression is evaluated in; empty
+	// uses the server's local time
+	Timezone string `json:"timezone,omitempty"`
+	// Command is the prompt and options each run executes
+	Command ExecuteCommand `json:"command"`
+	// CreatedBy is the identity the runs execute as; nil when
+	// authentication is disabled
+	CreatedBy *Identity `json:"created_by,omitempty"`
+	// CreatedAt is when the schedule was created
+	CreatedAt time.Time `json:"created_at"`
+	// DisabledReason is set once the schedule may no longer run, e.g. after
+	// its creator's credentials were revoked; disabled schedules never run
+	DisabledReason string `json:"disabled_reason,omitempty"`
+}
+
+// Disabled reports whether the schedule may no longer run
+func (s Schedule) Disabled() bool {
+	return s.DisabledReason != ""
+}
+
+// GetSchedules returns a copy of the project's schedules
+func (p *Project) GetSchedules() []Schedule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return copySchedules(p.Schedules)
+}
+
+// AddSchedule adds a schedule unless the project already has limit
+// schedules, and reports whether it was added
+func (p *Project) AddSchedule(schedule Schedule, limit int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.Schedules) >= limit {
+		return false
+	}
+	p.Schedules = append(p.Schedules, schedule)
+	return true
+}
+
+// RemoveSchedule removes a schedule by ID and returns it
+func (p *Project) RemoveSchedule(id string) (Schedule, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, schedule := range p.Schedules {
+		if schedule.ID == id {
+			p.Schedules = append(p.Schedules[:i:i], p.Schedules[i+1:]...)
+			return schedule, true
+		}
+	}
+	return Schedule{}, false
+}
+
+// DisableSchedule stops a schedule from running, keeping it for owners to
+// inspect and delete, and reports whether it was found
+func (p *Project) DisableSchedule(id, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range p.Schedules {
+		if p.Schedules[i].ID == id {
+			p.Schedules[i].DisabledReason = reason
+			return true
+		}
+	}
+	return false
+}
+
+// SetSchedules replaces the project's schedules
+func (p *Project) SetSchedules(schedules []Schedule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Schedules = copySchedules(schedules)
+}
+
+// copySchedules returns a copy of a schedule list, or nil if it is empty
+func copySchedules(schedules []Schedule) []Schedule {
+	if len(schedules) == 0 {
+		return nil
+	}
+	out := make([]Schedule, len(schedules))
+	copy(out, schedules)
+	return out
+}
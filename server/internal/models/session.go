@@ -14,12 +14,16 @@ type Session struct {
 	ID string `json:"id"`
 	// Conn is the underlying WebSocket connection
 	Conn *websocket.Conn `json:"-"`
+	// RemoteIP is the client address, resolved through trusted proxies
+	RemoteIP string `json:"remote_ip,omitempty"`
 	// CreatedAt is when the session was established
 	CreatedAt time.Time `json:"created_at"`
 	// LastPing is when the last ping was sent/received
 	LastPing time.Time `json:"last_ping"`
 	// ProjectID is the currently joined project, if any
 	ProjectID string `json:"project_id,omitempty"`
+	// Identity is the authenticated principal, nil when authentication is disabled
+	Identity *Identity `json:"identity,omitempty"`
 	// mu provides thread-safe access to the session
 	mu sync.Mutex `json:"-"`
 	// writeMu ensures only one goroutine writes at a time
@@ -58,6 +62,20 @@ func (s *Session) GetProject() string {
 	return s.ProjectID
 }
 
+// SetIdentity sets the authenticated identity for this session
+func (s *Session) SetIdentity(identity *Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Identity = identity
+}
+
+// GetIdentity returns the authenticated identity, or nil if none
+func (s *Session) GetIdentity() *Identity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Identity
+}
+
 // IsExpired checks if the session has been idle too long
 func (s *Session) IsExpired(timeout time.Duration) bool {
 	s.mu.Lock()
@@ -0,0 +1,33 @@
+package models
+
+// FileChangeStatus is how an execution changed a file
+type FileChangeStatus string
+
+const (
+	// FileAdded is a file the execution created
+	FileAdded FileChangeStatus = "added"
+	// FileModified is a file whose content or mode the execution changed
+	FileModified FileChangeStatus = "modified"
+	// FileDeleted is a file the execution removed
+	FileDeleted FileChangeStatus = "deleted"
+)
+
+// FileChange is a file an execution changed, relative to its working
+// directory
+type FileChange struct {
+	Path      string           `json:"path"`
+	Status    FileChangeStatus `json:"status"`
+	Additions int              `json:"additions"`
+	Deletions int              `json:"deletions"`
+	// Binary is set for files whose lines cannot be counted
+	Binary bool `json:"binary,omitempty"`
+}
+
+// WorkspaceChanges is what an execution changed in its working directory
+type WorkspaceChanges struct {
+	Files     []FileChange `json:"files"`
+	Additions int          `json:"additions"`
+	Deletions int          `json:"deletions"`
+	// Truncated reports that the patch exceeded the size limit and was cut
+	Truncated bool `json:"truncated,omitempty"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// WorktreeStatus is the state of an execution's worktree
+type WorktreeStatus string
+
+const (
+	// WorktreeRunning is a worktree whose execution is running
+	WorktreeRunning WorktreeStatus = "running"
+	// WorktreeReady is a worktree whose execution ended; it waits to be
+	// merged or discarded
+	WorktreeReady WorktreeStatus = "ready"
+)
+
+// WorktreeOptions asks for an execution to run in a fresh git worktree of
+// the project
+type WorktreeOptions struct {
+	// Base is the commit, branch or tag the worktree's branch starts from;
+	// empty uses the project's HEAD
+	Base string `json:"base,omitempty"`
+	// Branch names the new branch; empty generates one
+	Branch string `json:"branch,omitempty"`
+}
+
+// Worktree is a git worktree an execution runs in, on a branch of its own
+type Worktree struct {
+	// ID is the unique identifier for the worktree (UUID)
+	ID string `json:"id"`
+	// Branch is the branch checked out in the worktree
+	Branch string `json:"branch"`
+	// Base is the base as requested and BaseCommit the commit it resolved to
+	Base       string `json:"base"`
+	BaseCommit string `json:"base_commit"`
+	// Path is the root of the worktree and Dir the directory in it that
+	// corresponds to the project's path
+	Path string `json:"path"`
+	Dir  string `json:"dir"`
+	// ExecutionID identifies the execution that runs in the worktree
+	ExecutionID string         `json:"execution_id,omitempty"`
+	Status      WorktreeStatus `json:"status"`
+	CreatedBy   string         `json:"created_by,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
@@ -0,0 +1,134 @@
+// Package netutil matches request origins and resolves client addresses
+// behind trusted reverse proxies.
+package netutil
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// OriginPattern is an allowed browser origin. It is "*" (any origin),
+// scheme://host[:port], or scheme://*.domain[:port] for any subdomain of
+// domain. A pattern without a port matches every port.
+type OriginPattern struct {
+	any      bool
+	scheme   string
+	host     string
+	port     string
+	wildcard bool
+}
+
+// ParseOriginPattern parses an allowed origin
+func ParseOriginPattern(pattern string) (OriginPattern, error) {
+	if pattern == "*" {
+		return OriginPattern{any: true}, nil
+	}
+
+	u, err := url.Parse(pattern)
+	if err != nil {
+		return OriginPattern{}, fmt.Errorf("invalid origin %q: %w", pattern, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return OriginPattern{}, fmt.Errorf("invalid origin %q: must be scheme://host[:port]", pattern)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return OriginPattern{}, fmt.Errorf("invalid origin %q: must not contain a path, query or credentials", pattern)
+	}
+
+	p := OriginPattern{
+		scheme: strings.ToLower(u.Scheme),
+		host:   strings.ToLower(u.Hostname()),
+		port:   u.Port(),
+	}
+
+	if strings.HasPrefix(p.host, "*.") {
+		p.wildcard = true
+		p.host = strings.TrimPrefix(p.host, "*")
+	}
+	if strings.Contains(p.host, "*") || p.host == "." {
+		return OriginPattern{}, fmt.Errorf("invalid origin %q: wildcards are only allowed as the leftmost label", pattern)
+	}
+
+	return p, nil
+}
+
+// ParseOriginPatterns parses a list of allowed origins
+func ParseOriginPatterns(patterns []string) ([]OriginPattern, error) {
+	parsed := make([]OriginPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := ParseOriginPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// Matches reports whether an Origin header value is allowed by the pattern.
+// Scheme and host are compared exactly; a wildcard pattern matches hosts
+// ending in ".domain" but not domain itself.
+func (p OriginPattern) Matches(origin string) bool {
+	if p.any {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.ToLower(u.Scheme) != p.scheme {
+		return false
+	}
+	if p.port != "" && u.Port() != p.port {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if p.wildcard {
+		return strings.HasSuffix(host, p.host) && len(host) > len(p.host)
+	}
+	return host == p.host
+}
+
+// MatchOrigin reports whether any pattern allows the origin
+func MatchOrigin(patterns []OriginPattern, origin string) bool {
+	for _, p := range patterns {
+		if p.Matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses networks in CIDR notation; bare addresses are treated as
+// single-host networks
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
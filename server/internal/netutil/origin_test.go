@@ -0,0 +1,67 @@
+package netutil
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOriginPattern(t *testing.T) {
+	valid := []string{"*", "https://good.com", "http://localhost:3000", "https://*.good.com", "https://good.com/"}
+	for _, pattern := range valid {
+		_, err := ParseOriginPattern(pattern)
+		assert.NoError(t, err, pattern)
+	}
+
+	invalid := []string{"good.com", "https://", "https://good.com/app", "https://user@good.com", "https://a.*.good.com", "https://*"}
+	for _, pattern := range invalid {
+		_, err := ParseOriginPattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestOriginPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		match   bool
+	}{
+		{"*", "https://anything.example", true},
+		{"https://good.com", "https://good.com", true},
+		{"https://good.com", "https://GOOD.com", true},
+		{"https://good.com", "https://good.com:8443", true},
+		{"https://good.com", "https://good.com.evil.io", false},
+		{"https://good.com", "https://evil.io/https://good.com", false},
+		{"https://good.com", "http://good.com", false},
+		{"https://good.com:8443", "https://good.com", false},
+		{"https://*.good.com", "https://app.good.com", true},
+		{"https://*.good.com", "https://a.b.good.com", true},
+		{"https://*.good.com", "https://good.com", false},
+		{"https://*.good.com", "https://evilgood.com", false},
+		{"https://good.com", "null", false},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseOriginPattern(tt.pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tt.match, p.Matches(tt.origin), "%s vs %s", tt.pattern, tt.origin)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "::1"})
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+
+	assert.True(t, networks[0].Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, networks[1].Contains(net.ParseIP("192.0.2.1")))
+	assert.False(t, networks[1].Contains(net.ParseIP("192.0.2.2")))
+	assert.True(t, networks[2].Contains(net.ParseIP("::1")))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseCIDRs([]string{"proxy.local"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package permission
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// protocolVersion is the MCP revision the broker implements
+const protocolVersion = "2025-03-26"
+
+// maxRequestSize bounds MCP request bodies
+const maxRequestSize = 1 << 20
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// rpcRequest is a JSON-RPC 2.0 request or notification
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC 2.0 error
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// promptInput is what the CLI passes to the permission prompt tool
+type promptInput struct {
+	ToolName  string                 `json:"tool_name"`
+	Input     map[string]interface{} `json:"input"`
+	ToolUseID string                 `json:"tool_use_id"`
+}
+
+// toolDefinition describes the permission tool in tools/list
+var toolDefinition = map[string]interface{}{
+	"name":        ToolName,
+	"description": "Asks the user of Pocket Agent whether a tool may run",
+	"inputSchema": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"tool_name":   map[string]string{"type": "string"},
+			"input":       map[string]string{"type": "object"},
+			"tool_use_id": map[string]string{"type": "string"},
+		},
+		"required": []string{"tool_name", "input"},
+	},
+}
+
+// handleMCP serves the broker's MCP endpoint. Only POSTed JSON-RPC messages
+// with JSON responses are supported, which is all the CLI needs for a tool.
+func (b *Broker) handleMCP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, ok := b.authorize(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeRPC(w, rpcResponse{ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: "parse error"}})
+		return
+	}
+
+	// Notifications and responses get no reply
+	if len(req.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	resp := rpcResponse{ID: req.ID}
+	switch req.Method {
+	case "initialize":
+		resp.Result = map[string]interface{}{
+			"protocolVersion": negotiateVersion(req.Params),
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": MCPServerName, "version": "1.0.0"},
+		}
+
+	case "ping":
+		resp.Result = map[string]interface{}{}
+
+	case "tools/list":
+		resp.Result = map[string]interface{}{"tools": []interface{}{toolDefinition}}
+
+	case "tools/call":
+		var params struct {
+			Name      string      `json:"name"`
+			Arguments promptInput `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Name != ToolName || params.Arguments.ToolName == "" {
+			resp.Error = &rpcError{Code: rpcInvalidParams, Message: "invalid tool call"}
+			break
+		}
+
+		args := params.Arguments
+		res := b.ask(r.Context(), projectID, args.ToolName, args.Input, args.ToolUseID)
+		resp.Result = toolResult(args, res)
+
+	case "":
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "method is required"}
+
+	default:
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+
+	writeRPC(w, resp)
+}
+
+// authorize maps the request's bearer token to the project of its grant
+func (b *Broker) authorize(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	projectID, ok := b.grants[token]
+	return projectID, ok
+}
+
+// negotiateVersion accepts the client's protocol version, which is
+// compatible for the single tool the broker offers
+func negotiateVersion(params json.RawMessage) string {
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if json.Unmarshal(params, &init) == nil && init.ProtocolVersion != "" {
+		return init.ProtocolVersion
+	}
+	return protocolVersion
+}
+
+// toolResult encodes a resolution in the format the CLI expects from a
+// permission prompt tool
+func toolResult(args promptInput, res Resolution) map[string]interface{} {
+	var decision map[string]interface{}
+	if res.Decision.allows() {
+		input := args.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		decision = map[string]interface{}{"behavior": "allow", "updatedInput": input}
+	} else {
+		message := "Permission denied by " + res.By
+		if res.By == "timeout" {
+			message = "Permission request timed out"
+		}
+		decision = map[string]interface{}{"behavior": "deny", "message": message}
+	}
+
+	text, _ := json.Marshal(decision)
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": string(text)}},
+	}
+}
+
+// writeRPC writes a JSON-RPC response
+func writeRPC(w http.ResponseWriter, resp rpcResponse) {
+	resp.JSONRPC = "2.0"
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
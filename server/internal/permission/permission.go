@@ -0,0 +1,363 @@
+// Package permission relays the Claude CLI's tool permission prompts to the
+// clients of a project. The broker serves a small MCP server on the loopback
+// interface; each execution gets a grant whose MCP config registers the
+// broker's tool as the CLI's --permission-prompt-tool. A prompt is broadcast
+// to the project's subscribers and answered by the first response, or by the
+// configured default when none arrives in time.
+package permission
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	// MCPServerName is the name of the broker in generated MCP configs
+	MCPServerName = "pocket_agent"
+	// ToolName is the name of the broker's permission tool
+	ToolName = "approve"
+	// PromptTool is the value passed to --permission-prompt-tool
+	PromptTool = "mcp__" + MCPServerName + "__" + ToolName
+
+	// mcpPath is the URL path of the MCP endpoint
+	mcpPath = "/mcp"
+)
+
+// Decision answers a permission request
+type Decision string
+
+const (
+	// DecisionApprove allows this tool use
+	DecisionApprove Decision = "approve"
+	// DecisionDeny rejects this tool use
+	DecisionDeny Decision = "deny"
+	// DecisionAlwaysAllow allows this and later uses of the tool in the project
+	DecisionAlwaysAllow Decision = "always_allow"
+)
+
+// ParseDecision validates a decision name
+func ParseDecision(s string) (Decision, error) {
+	switch d := Decision(s); d {
+	case DecisionApprove, DecisionDeny, DecisionAlwaysAllow:
+		return d, nil
+	default:
+		return "", fmt.Errorf("invalid decision %q (must be approve, deny or always_allow)", s)
+	}
+}
+
+// allows reports whether the decision lets the tool run
+func (d Decision) allows() bool {
+	return d == DecisionApprove || d == DecisionAlwaysAllow
+}
+
+// Request is a pending permission prompt
+type Request struct {
+	ID        string                 `json:"request_id"`
+	ProjectID string                 `json:"project_id"`
+	ToolName  string                 `json:"tool_name"`
+	Input     map[string]interface{} `json:"input,omitempty"`
+	ToolUseID string                 `json:"tool_use_id,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	ExpiresAt time.Time              `json:"expires_at"`
+}
+
+// Resolution records how a request was answered
+type Resolution struct {
+	Decision Decision `json:"decision"`
+	// By is the identity that answered, or "timeout" / "cancelled"
+	By string `json:"by"`
+}
+
+// Notifier tells a project's clients about permission prompts
+type Notifier interface {
+	PermissionRequested(req Request)
+	PermissionResolved(req Request, res Resolution)
+}
+
+// Config configures a Broker
+type Config struct {
+	// Timeout is how long a prompt waits for a response
+	Timeout time.Duration
+	// Default answers prompts nobody responded to; approve or deny
+	Default Decision
+}
+
+// pending is a request waiting for a response
+type pending struct {
+	req      Request
+	response chan Resolution
+}
+
+// Broker serves the permission prompt tool and tracks pending requests
+type Broker struct {
+	config Config
+	log    *logger.Logger
+
+	listener net.Listener
+	server   *http.Server
+
+	mu       sync.Mutex
+	notifier Notifier
+	grants   map[string]string              // token -> project ID
+	pending  map[string]*pending            // request ID -> request
+	allowed  map[string]map[string]struct{} // project ID -> always allowed tools
+}
+
+// NewBroker starts a broker listening on a random loopback port
+func NewBroker(config Config, log *logger.Logger) (*Broker, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * time.Minute
+	}
+	if config.Default == "" {
+		config.Default = DecisionDeny
+	}
+	if config.Default != DecisionApprove && config.Default != DecisionDeny {
+		return nil, errors.New(errors.CodeValidationFailed,
+			"default permission decision must be approve or deny: %s", config.Default)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CodeInternalError, "failed to start permission broker")
+	}
+
+	b := &Broker{
+		config:   config,
+		log:      log,
+		listener: listener,
+		grants:   make(map[string]string),
+		pending:  make(map[string]*pending),
+		allowed:  make(map[string]map[string]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(mcpPath, b.handleMCP)
+	b.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := b.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Permission broker stopped", "error", err)
+		}
+	}()
+
+	return b, nil
+}
+
+// SetNotifier sets the receiver of prompts
+func (b *Broker) SetNotifier(n Notifier) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.notifier = n
+}
+
+// URL returns the broker's MCP endpoint
+func (b *Broker) URL() string {
+	return "http://" + b.listener.Addr().String() + mcpPath
+}
+
+// Close stops the broker and denies all pending requests
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	for id, p := range b.pending {
+		p.response <- Resolution{Decision: DecisionDeny, By: "cancelled"}
+		delete(b.pending, id)
+	}
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return b.server.Shutdown(ctx)
+}
+
+// Grant lets one execution of a project use the broker
+type Grant struct {
+	broker     *Broker
+	token      string
+	configPath string
+	closeOnce  sync.Once
+}
+
+// Grant issues a grant for an execution of projectID. The caller passes
+// Args to the CLI and closes the grant when the execution ends.
+func (b *Broker) Grant(projectID string) (*Grant, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+	token := hex.EncodeToString(secret)
+
+	// The token goes in a private file rather than the command line, where
+	// other local users could read it
+	config := map[string]interface{}{
+		"mcpServers": map[string]interface{}{
+			MCPServerName: map[string]interface{}{
+				"type":    "http",
+				"url":     b.URL(),
+				"headers": map[string]string{"Authorization": "Bearer " + token},
+			},
+		},
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.NewJSONParsingError(err)
+	}
+
+	f, err := os.CreateTemp("", "pocket-agent-mcp-*.json")
+	if err != nil {
+		return nil, errors.NewFileOperationError("create MCP config", err)
+	}
+	_, writeErr := f.Write(data)
+	closeErr := f.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(f.Name())
+		if writeErr == nil {
+			writeErr = closeErr
+		}
+		return nil, errors.NewFileOperationError("write MCP config", writeErr)
+	}
+
+	b.mu.Lock()
+	b.grants[token] = projectID
+	b.mu.Unlock()
+
+	return &Grant{broker: b, token: token, configPath: f.Name()}, nil
+}
+
+// Args returns the CLI arguments that register the permission prompt tool
+func (g *Grant) Args() []string {
+	return []string{"--permission-prompt-tool", PromptTool, "--mcp-config", g.configPath}
+}
+
+// Close revokes the grant and removes its MCP config
+func (g *Grant) Close() {
+	g.closeOnce.Do(func() {
+		g.broker.mu.Lock()
+		delete(g.broker.grants, g.token)
+		g.broker.mu.Unlock()
+		os.Remove(g.configPath)
+	})
+}
+
+// Respond answers a pending request of a project
+func (b *Broker) Respond(projectID, requestID string, decision Decision, by string) (Request, error) {
+	b.mu.Lock()
+	p, ok := b.pending[requestID]
+	if !ok || p.req.ProjectID != projectID {
+		b.mu.Unlock()
+		return Request{}, errors.New(errors.CodePermissionRequestNotFound, "permission request not found").
+			WithDetail("project_id", projectID).
+			WithDetail("request_id", requestID)
+	}
+	delete(b.pending, requestID)
+	if decision == DecisionAlwaysAllow {
+		b.allow(projectID, p.req.ToolName)
+	}
+	b.mu.Unlock()
+
+	p.response <- Resolution{Decision: decision, By: by}
+	return p.req, nil
+}
+
+// Reset forgets the tools always allowed in a project
+func (b *Broker) Reset(projectID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.allowed, projectID)
+}
+
+// allow always allows a tool in a project. Callers hold b.mu.
+func (b *Broker) allow(projectID, tool string) {
+	tools := b.allowed[projectID]
+	if tools == nil {
+		tools = make(map[string]struct{})
+		b.allowed[projectID] = tools
+	}
+	tools[tool] = struct{}{}
+}
+
+// ask relays a prompt to the project's clients and waits for the answer
+func (b *Broker) ask(ctx context.Context, projectID, tool string, input map[string]interface{}, toolUseID string) Resolution {
+	now := time.Now()
+	req := Request{
+		ID:        uuid.New().String(),
+		ProjectID: projectID,
+		ToolName:  tool,
+		Input:     input,
+		ToolUseID: toolUseID,
+		CreatedAt: now.UTC(),
+		ExpiresAt: now.Add(b.config.Timeout).UTC(),
+	}
+
+	b.mu.Lock()
+	if _, ok := b.allowed[projectID][tool]; ok {
+		b.mu.Unlock()
+		return Resolution{Decision: DecisionAlwaysAllow, By: "always_allow"}
+	}
+	p := &pending{req: req, response: make(chan Resolution, 1)}
+	b.pending[req.ID] = p
+	notifier := b.notifier
+	b.mu.Unlock()
+
+	b.log.Info("Permission requested",
+		"project_id", projectID,
+		"request_id", req.ID,
+		"tool", tool,
+	)
+	if notifier != nil {
+		notifier.PermissionRequested(req)
+	}
+
+	timer := time.NewTimer(b.config.Timeout)
+	defer timer.Stop()
+
+	var res Resolution
+	select {
+	case res = <-p.response:
+	case <-timer.C:
+		res = b.expire(p, Resolution{Decision: b.config.Default, By: "timeout"})
+	case <-ctx.Done():
+		res = b.expire(p, Resolution{Decision: DecisionDeny, By: "cancelled"})
+	}
+
+	b.log.Info("Permission resolved",
+		"project_id", projectID,
+		"request_id", req.ID,
+		"tool", tool,
+		"decision", res.Decision,
+		"by", res.By,
+	)
+	if notifier != nil {
+		notifier.PermissionResolved(req, res)
+	}
+
+	return res
+}
+
+// expire resolves a request nobody answered with fallback. A response that
+// raced the expiry wins, since its sender was told it succeeded.
+func (b *Broker) expire(p *pending, fallback Resolution) Resolution {
+	b.mu.Lock()
+	_, waiting := b.pending[p.req.ID]
+	delete(b.pending, p.req.ID)
+	b.mu.Unlock()
+
+	if waiting {
+		return fallback
+	}
+	return <-p.response
+}
@@ -0,0 +1,278 @@
+package permission
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a Notifier that keeps what it was told
+type recorder struct {
+	mu       sync.Mutex
+	requests chan Request
+	resolved []Resolution
+}
+
+func newRecorder() *recorder {
+	return &recorder{requests: make(chan Request, 10)}
+}
+
+func (r *recorder) PermissionRequested(req Request) {
+	r.requests <- req
+}
+
+func (r *recorder) PermissionResolved(req Request, res Resolution) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolved = append(r.resolved, res)
+}
+
+func (r *recorder) resolutions() []Resolution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Resolution(nil), r.resolved...)
+}
+
+func newTestBroker(t *testing.T, config Config) (*Broker, *recorder) {
+	b, err := NewBroker(config, logger.New("error"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	rec := newRecorder()
+	b.SetNotifier(rec)
+	return b, rec
+}
+
+// call posts a JSON-RPC request to the broker and decodes the response
+func call(t *testing.T, b *Broker, token, method string, params interface{}) (int, map[string]interface{}) {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, b.URL(), bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var out map[string]interface{}
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	}
+	return resp.StatusCode, out
+}
+
+// callTool asks the broker's tool and returns the decoded decision
+func callTool(t *testing.T, b *Broker, token, tool string) map[string]interface{} {
+	status, out := call(t, b, token, "tools/call", map[string]interface{}{
+		"name": ToolName,
+		"arguments": map[string]interface{}{
+			"tool_name":   tool,
+			"input":       map[string]interface{}{"command": "ls"},
+			"tool_use_id": "toolu_01",
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.Nil(t, out["error"])
+
+	content := out["result"].(map[string]interface{})["content"].([]interface{})
+	require.Len(t, content, 1)
+
+	var decision map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(content[0].(map[string]interface{})["text"].(string)), &decision))
+	return decision
+}
+
+// respondWhenAsked answers the next prompt the recorder receives
+func respondWhenAsked(t *testing.T, b *Broker, rec *recorder, decision Decision) {
+	go func() {
+		select {
+		case req := <-rec.requests:
+			_, err := b.Respond(req.ProjectID, req.ID, decision, "device:phone")
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Error("no permission request received")
+		}
+	}()
+}
+
+func grantToken(t *testing.T, g *Grant) string {
+	data, err := os.ReadFile(g.configPath)
+	require.NoError(t, err)
+
+	var config struct {
+		MCPServers map[string]struct {
+			Type    string            `json:"type"`
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+		} `json:"mcpServers"`
+	}
+	require.NoError(t, json.Unmarshal(data, &config))
+
+	server, ok := config.MCPServers[MCPServerName]
+	require.True(t, ok)
+	assert.Equal(t, "http", server.Type)
+	return server.Headers["Authorization"][len("Bearer "):]
+}
+
+func TestBroker_Grant(t *testing.T) {
+	b, _ := newTestBroker(t, Config{})
+
+	g, err := b.Grant("p1")
+	require.NoError(t, err)
+
+	args := g.Args()
+	assert.Equal(t, []string{"--permission-prompt-tool", PromptTool, "--mcp-config", g.configPath}, args)
+
+	info, err := os.Stat(g.configPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	token := grantToken(t, g)
+	status, out := call(t, b, token, "initialize", map[string]interface{}{"protocolVersion": "2025-06-18"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "2025-06-18", out["result"].(map[string]interface{})["protocolVersion"])
+
+	status, out = call(t, b, token, "tools/list", nil)
+	require.Equal(t, http.StatusOK, status)
+	tools := out["result"].(map[string]interface{})["tools"].([]interface{})
+	require.Len(t, tools, 1)
+	assert.Equal(t, ToolName, tools[0].(map[string]interface{})["name"])
+
+	// Closing the grant revokes its token and removes the config
+	g.Close()
+	_, err = os.Stat(g.configPath)
+	assert.True(t, os.IsNotExist(err))
+
+	status, _ = call(t, b, token, "tools/list", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestBroker_Unauthorized(t *testing.T) {
+	b, _ := newTestBroker(t, Config{})
+
+	status, _ := call(t, b, "", "tools/list", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = call(t, b, "not-a-token", "tools/list", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestBroker_ApproveAndDeny(t *testing.T) {
+	b, rec := newTestBroker(t, Config{})
+	g, err := b.Grant("p1")
+	require.NoError(t, err)
+	defer g.Close()
+	token := grantToken(t, g)
+
+	respondWhenAsked(t, b, rec, DecisionApprove)
+	decision := callTool(t, b, token, "Bash")
+	assert.Equal(t, "allow", decision["behavior"])
+	assert.Equal(t, map[string]interface{}{"command": "ls"}, decision["updatedInput"])
+
+	respondWhenAsked(t, b, rec, DecisionDeny)
+	decision = callTool(t, b, token, "Bash")
+	assert.Equal(t, "deny", decision["behavior"])
+	assert.Contains(t, decision["message"], "device:phone")
+
+	assert.Equal(t, []Resolution{
+		{Decision: DecisionApprove, By: "device:phone"},
+		{Decision: DecisionDeny, By: "device:phone"},
+	}, rec.resolutions())
+}
+
+func TestBroker_AlwaysAllow(t *testing.T) {
+	b, rec := newTestBroker(t, Config{})
+	g, err := b.Grant("p1")
+	require.NoError(t, err)
+	defer g.Close()
+	token := grantToken(t, g)
+
+	respondWhenAsked(t, b, rec, DecisionAlwaysAllow)
+	assert.Equal(t, "allow", callTool(t, b, token, "Write")["behavior"])
+
+	// Later uses of the tool are allowed without asking
+	assert.Equal(t, "allow", callTool(t, b, token, "Write")["behavior"])
+	assert.Len(t, rec.requests, 0)
+
+	// Other projects and a reset ask again
+	other, err := b.Grant("p2")
+	require.NoError(t, err)
+	defer other.Close()
+	respondWhenAsked(t, b, rec, DecisionDeny)
+	assert.Equal(t, "deny", callTool(t, b, grantToken(t, other), "Write")["behavior"])
+
+	b.Reset("p1")
+	respondWhenAsked(t, b, rec, DecisionDeny)
+	assert.Equal(t, "deny", callTool(t, b, token, "Write")["behavior"])
+}
+
+func TestBroker_Timeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback Decision
+		want     string
+	}{
+		{"default deny", DecisionDeny, "deny"},
+		{"default approve", DecisionApprove, "allow"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, rec := newTestBroker(t, Config{Timeout: 50 * time.Millisecond, Default: tt.fallback})
+			g, err := b.Grant("p1")
+			require.NoError(t, err)
+			defer g.Close()
+
+			assert.Equal(t, tt.want, callTool(t, b, grantToken(t, g), "Bash")["behavior"])
+			assert.Equal(t, []Resolution{{Decision: tt.fallback, By: "timeout"}}, rec.resolutions())
+
+			// The expired request can no longer be answered
+			req := <-rec.requests
+			_, err = b.Respond("p1", req.ID, DecisionApprove, "device:phone")
+			assert.True(t, errors.IsCode(err, errors.CodePermissionRequestNotFound))
+		})
+	}
+}
+
+func TestBroker_RespondWrongProject(t *testing.T) {
+	b, rec := newTestBroker(t, Config{})
+	g, err := b.Grant("p1")
+	require.NoError(t, err)
+	defer g.Close()
+	token := grantToken(t, g)
+
+	done := make(chan map[string]interface{})
+	go func() { done <- callTool(t, b, token, "Bash") }()
+
+	req := <-rec.requests
+	_, err = b.Respond("p2", req.ID, DecisionApprove, "device:phone")
+	assert.True(t, errors.IsCode(err, errors.CodePermissionRequestNotFound))
+
+	_, err = b.Respond("p1", req.ID, DecisionApprove, "device:phone")
+	require.NoError(t, err)
+	assert.Equal(t, "allow", (<-done)["behavior"])
+}
+
+func TestNewBroker_InvalidDefault(t *testing.T) {
+	_, err := NewBroker(Config{Default: DecisionAlwaysAllow}, logger.New("error"))
+	assert.Error(t, err)
+}
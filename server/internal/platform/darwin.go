@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // CheckMacOSPermissions checks for macOS-specific permissions
@@ -171,3 +173,34 @@ func CheckPlatformSpecificPermissions() []string {
 	// On Darwin, platform-specific checks are handled by CheckMacOSPermissions
 	return nil
 }
+
+// SetupSandbox fails on Darwin, which has no sandbox support
+func SetupSandbox(cmd *exec.Cmd, sandbox Sandbox) (*SandboxCgroup, error) {
+	return nil, fmt.Errorf("sandboxed execution is only supported on Linux")
+}
+
+// RunSandboxInit fails on Darwin, which has no sandbox support
+func RunSandboxInit(args []string) int {
+	fmt.Fprintln(os.Stderr, "sandbox: only supported on Linux")
+	return 125
+}
+
+// LimitExceeded returns "" on Darwin, which has no sandbox support
+func (c *SandboxCgroup) LimitExceeded() string {
+	return ""
+}
+
+// Close is a no-op on Darwin, which has no sandbox support
+func (c *SandboxCgroup) Close() error {
+	return nil
+}
+
+// ProcessStartTime returns when a process started. Together with its PID it
+// tells a process apart from a later one that reused the PID.
+func ProcessStartTime(pid int) (time.Time, error) {
+	out, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("no process %d: %w", pid, err)
+	}
+	return time.ParseInLocation("Mon Jan _2 15:04:05 2006", strings.TrimSpace(string(out)), time.Local)
+}
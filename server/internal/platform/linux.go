@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // CheckPlatformSpecificPermissions checks platform-specific permissions
@@ -223,3 +225,68 @@ func GetProcessNamespaces(pid int) (map[string]string, error) {
 
 	return namespaces, nil
 }
+
+// clockTicks is the kernel's USER_HZ, in which /proc reports times. It is
+// 100 on all architectures Go supports.
+const clockTicks = 100
+
+// ProcessStartTime returns when a process started. Together with its PID it
+// tells a process apart from a later one that reused the PID.
+func ProcessStartTime(pid int) (time.Time, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// The command name may contain spaces and parentheses; the fields
+	// after it start with the state, so starttime is the 20th
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time in /proc/%d/stat: %w", pid, err)
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks), nil
+}
+
+// bootTime returns when the system booted
+func bootTime() (time.Time, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime in /proc/stat: %w", err)
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// SetupMacOSProcess is a no-op on Linux
+func SetupMacOSProcess(cmd *exec.Cmd) {
+	// macOS-specific process setup not needed on Linux
+}
+
+// CheckMacOSPermissions is a no-op on Linux
+func CheckMacOSPermissions() []string {
+	return nil
+}
@@ -0,0 +1,46 @@
+package platform
+
+import "os"
+
+// SandboxInitCommand is the first argument of the re-executed server binary
+// that sets up a sandbox and then runs the sandboxed command. main must hand
+// such invocations to RunSandboxInit before doing anything else.
+const SandboxInitCommand = "__sandbox_init"
+
+// Limits that terminate a sandboxed process when exceeded
+const (
+	SandboxLimitMemory = "memory"
+	SandboxLimitPids   = "pids"
+)
+
+// Sandbox describes how a sandboxed process is isolated
+type Sandbox struct {
+	// WritablePaths stay writable; every other mount is read-only except
+	// /proc, /sys and /dev
+	WritablePaths []string `json:"writable_paths"`
+	// IsolateNetwork gives the process a network namespace of its own with
+	// only a loopback interface
+	IsolateNetwork bool `json:"isolate_network"`
+
+	// CgroupRoot is the cgroup v2 directory the process's cgroup is created
+	// in. It must be writable with the memory, cpu and pids controllers
+	// enabled for its children.
+	CgroupRoot string `json:"-"`
+	// MemoryBytes, CPUs and MaxPids limit the process and its children;
+	// zero leaves the resource unlimited
+	MemoryBytes int64   `json:"-"`
+	CPUs        float64 `json:"-"`
+	MaxPids     int64   `json:"-"`
+}
+
+// limited reports whether the sandbox needs a cgroup
+func (s Sandbox) limited() bool {
+	return s.MemoryBytes > 0 || s.CPUs > 0 || s.MaxPids > 0
+}
+
+// SandboxCgroup is the cgroup holding a sandboxed process. A nil
+// SandboxCgroup stands for a sandbox without resource limits.
+type SandboxCgroup struct {
+	dir string
+	fd  *os.File
+}
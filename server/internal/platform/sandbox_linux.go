@@ -0,0 +1,390 @@
+//go:build linux
+// +build linux
+
+package platform
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// sandboxInitFailed is the exit code of a sandbox that could not be set up
+const sandboxInitFailed = 125
+
+// Capabilities the sandbox needs while it is set up
+const (
+	capNetAdmin = 12
+	capSysAdmin = 21
+)
+
+// cgroupCPUPeriod is the cpu.max period, in microseconds, CPU limits use
+const cgroupCPUPeriod = 100000
+
+// sharedMounts keep their own mounts inside a sandbox: the kernel
+// interfaces and devices processes need to run at all
+var sharedMounts = []string{"/proc", "/sys", "/dev"}
+
+// SetupSandbox makes cmd run in a sandbox. The server binary is executed in
+// place of the command, in new mount and optionally network namespaces, and
+// runs the command once it has set them up. Processes of an unprivileged
+// server also get a user namespace that maps only the server's user; it
+// keeps the capabilities to mount until the command is executed.
+//
+// When the sandbox limits resources, cmd is started in a new cgroup that the
+// caller must close once the process has exited.
+func SetupSandbox(cmd *exec.Cmd, sandbox Sandbox) (*SandboxCgroup, error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+
+	spec, err := json.Marshal(sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sandbox: %w", err)
+	}
+	args := []string{"/proc/self/exe", SandboxInitCommand, string(spec), cmd.Path}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWNS
+	if sandbox.IsolateNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid != 0 {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+		attr.AmbientCaps = []uintptr{capSysAdmin}
+		if sandbox.IsolateNetwork {
+			attr.AmbientCaps = append(attr.AmbientCaps, capNetAdmin)
+		}
+		if attr.Credential != nil {
+			attr.Credential.NoSetGroups = true
+		}
+	}
+
+	if !sandbox.limited() {
+		return nil, nil
+	}
+
+	cgroup, err := createSandboxCgroup(sandbox)
+	if err != nil {
+		return nil, err
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(cgroup.fd.Fd())
+
+	return cgroup, nil
+}
+
+// createSandboxCgroup creates a cgroup with the sandbox's limits
+func createSandboxCgroup(sandbox Sandbox) (*SandboxCgroup, error) {
+	if sandbox.CgroupRoot == "" {
+		return nil, fmt.Errorf("sandbox resource limits need a cgroup root")
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(sandbox.CgroupRoot, "pocket-agent-"+hex.EncodeToString(suffix))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox cgroup: %w", err)
+	}
+
+	limits := map[string]string{}
+	if sandbox.MemoryBytes > 0 {
+		limits["memory.max"] = strconv.FormatInt(sandbox.MemoryBytes, 10)
+	}
+	if sandbox.CPUs > 0 {
+		quota := int64(sandbox.CPUs * cgroupCPUPeriod)
+		limits["cpu.max"] = fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)
+	}
+	if sandbox.MaxPids > 0 {
+		limits["pids.max"] = strconv.FormatInt(sandbox.MaxPids, 10)
+	}
+	for file, value := range limits {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644); err != nil {
+			os.Remove(dir)
+			return nil, fmt.Errorf("failed to set sandbox %s: %w", file, err)
+		}
+	}
+
+	// Swap would let the process outgrow its memory limit unnoticed. Not
+	// every kernel has swap accounting, so failing to disable it is fine.
+	if sandbox.MemoryBytes > 0 {
+		_ = os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0o644)
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("failed to open sandbox cgroup: %w", err)
+	}
+
+	return &SandboxCgroup{dir: dir, fd: fd}, nil
+}
+
+// LimitExceeded returns the limit, SandboxLimitMemory or SandboxLimitPids,
+// that the cgroup's processes ran into, or "" if there is none. Running out
+// of memory kills a process; running out of pids fails its forks.
+func (c *SandboxCgroup) LimitExceeded() string {
+	if c == nil {
+		return ""
+	}
+	if readCgroupEvents(c.dir, "memory.events")["oom_kill"] > 0 {
+		return SandboxLimitMemory
+	}
+	if readCgroupEvents(c.dir, "pids.events")["max"] > 0 {
+		return SandboxLimitPids
+	}
+	return ""
+}
+
+// Close kills any processes left in the cgroup and removes it
+func (c *SandboxCgroup) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.fd.Close()
+
+	// Children of the sandboxed process may outlive it
+	_ = os.WriteFile(filepath.Join(c.dir, "cgroup.kill"), []byte("1"), 0o644)
+
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = os.Remove(c.dir); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove sandbox cgroup: %w", err)
+}
+
+// readCgroupEvents reads a cgroup events file of "name count" lines
+func readCgroupEvents(dir, file string) map[string]int64 {
+	events := make(map[string]int64)
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return events
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var name string
+		var count int64
+		if _, err := fmt.Sscanf(line, "%s %d", &name, &count); err == nil {
+			events[name] = count
+		}
+	}
+	return events
+}
+
+// RunSandboxInit sets up the sandbox described by args and executes the
+// sandboxed command in it. It runs in the server binary re-executed by
+// SetupSandbox and only returns, with the exit code to use, on failure.
+func RunSandboxInit(args []string) int {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "sandbox: missing command")
+		return sandboxInitFailed
+	}
+
+	var sandbox Sandbox
+	if err := json.Unmarshal([]byte(args[0]), &sandbox); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: invalid specification: %v\n", err)
+		return sandboxInitFailed
+	}
+	if err := enterSandbox(sandbox); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return sandboxInitFailed
+	}
+
+	err := syscall.Exec(args[1], args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: failed to execute %s: %v\n", args[1], err)
+	return sandboxInitFailed
+}
+
+// enterSandbox sets up the mounts and network of the current process, which
+// already runs in its own namespaces
+func enterSandbox(sandbox Sandbox) error {
+	// Keep every change out of the server's mount namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	// Writable paths become mounts of their own, so they stay writable when
+	// the mounts they are on are made read-only
+	var writable []string
+	for _, path := range sandbox.WritablePaths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind %s: %w", path, err)
+		}
+		writable = append(writable, path)
+	}
+
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if withinAny(sharedMounts, mount) || withinAny(writable, mount) {
+			continue
+		}
+		if err := remountReadOnly(mount); err != nil {
+			return err
+		}
+	}
+
+	if sandbox.IsolateNetwork {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("failed to bring up loopback: %w", err)
+		}
+	}
+
+	// The command must not keep the capabilities to undo the sandbox, and
+	// setuid programs must not regain them
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to clear ambient capabilities: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+
+	return nil
+}
+
+// prctl options syscall does not define
+const (
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+)
+
+// mountPoints lists the mount points of the current mount namespace
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, unescapeMountPath(fields[4]))
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes of mountinfo paths
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// remountReadOnly makes a mount read-only, keeping the flags a user
+// namespace may not clear
+func remountReadOnly(mount string) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mount, &stat); err != nil {
+		// Mount points hidden by later mounts cannot be reached
+		if os.IsNotExist(err) || err == syscall.EACCES {
+			return nil
+		}
+		return fmt.Errorf("failed to inspect mount %s: %w", mount, err)
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	kept := map[int64]uintptr{
+		stRdonly:     0,
+		stNosuid:     syscall.MS_NOSUID,
+		stNodev:      syscall.MS_NODEV,
+		stNoexec:     syscall.MS_NOEXEC,
+		stNoatime:    syscall.MS_NOATIME,
+		stNodiratime: syscall.MS_NODIRATIME,
+		stRelatime:   syscall.MS_RELATIME,
+	}
+	for st, ms := range kept {
+		if int64(stat.Flags)&st != 0 {
+			flags |= ms
+		}
+	}
+
+	if err := syscall.Mount("", mount, "", flags, ""); err != nil {
+		return fmt.Errorf("failed to make %s read-only: %w", mount, err)
+	}
+	return nil
+}
+
+// Mount flags reported by statfs
+const (
+	stRdonly     = 0x1
+	stNosuid     = 0x2
+	stNodev      = 0x4
+	stNoexec     = 0x8
+	stNoatime    = 0x400
+	stNodiratime = 0x800
+	stRelatime   = 0x1000
+)
+
+// loopbackUp brings up the loopback interface of a new network namespace
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var req struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(req.name[:], "lo")
+	req.flags = syscall.IFF_UP | syscall.IFF_RUNNING
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// withinAny reports whether path is one of roots or inside one of them
+func withinAny(roots []string, path string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@
 package platform
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -49,6 +50,21 @@ func KillProcessGroup(cmd *exec.Cmd) error {
 	return cmd.Process.Kill()
 }
 
+// SignalProcessGroup sends a signal to every process of a process group.
+// Signal 0 only checks whether the group still has processes.
+func SignalProcessGroup(pgid int, sig syscall.Signal) error {
+	if pgid <= 0 {
+		return fmt.Errorf("invalid process group %d", pgid)
+	}
+	return syscall.Kill(-pgid, sig)
+}
+
+// ProcessGroupAlive reports whether a process group still has processes
+func ProcessGroupAlive(pgid int) bool {
+	err := SignalProcessGroup(pgid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
 // SetupSignalHandling sets up Unix-specific signal handling
 func SetupSignalHandling() chan os.Signal {
 	sigChan := make(chan os.Signal, 1)
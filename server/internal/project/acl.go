@@ -0,0 +1,58 @@
+package project
+
+import (
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// SetProjectRole grants a role on a project to a principal, or revokes its
+// access when role is empty. The last owner of a project cannot be removed.
+func (m *Manager) SetProjectRole(projectID, principal string, role models.Role) (*models.Project, error) {
+	if err := models.ValidatePrincipal(principal); err != nil {
+		return nil, errors.NewValidationError(err.Error())
+	}
+	if role != "" && !role.Valid() {
+		return nil, errors.NewValidationError("invalid role %q (must be owner, executor or observer)", role)
+	}
+
+	project, err := m.GetProjectByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := project.GetACL()
+	previous := acl[principal]
+
+	if previous == models.RoleOwner && role != models.RoleOwner && countOwners(acl) == 1 {
+		return nil, errors.New(errors.CodeValidationFailed, "cannot remove the last owner of a project").
+			WithDetail("project_id", projectID)
+	}
+
+	project.SetRole(principal, role)
+
+	// Persist the change
+	if err := m.UpdateProject(project); err != nil {
+		// Rollback on failure
+		project.SetRole(principal, previous)
+		return nil, err
+	}
+
+	m.logger.Info("Project ACL updated",
+		"project_id", projectID,
+		"principal", principal,
+		"old_role", previous,
+		"new_role", role)
+
+	return project, nil
+}
+
+// countOwners returns the number of principals holding the owner role
+func countOwners(acl map[string]models.Role) int {
+	count := 0
+	for _, role := range acl {
+		if role == models.RoleOwner {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,113 @@
+package project
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+func TestCreateProjectWithOwner(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer os.RemoveAll(tempDir)
+
+	owner := &models.Identity{ID: "dev-1", Method: models.AuthMethodDevice}
+	project, err := manager.CreateProjectWithOwner(tempDir+"/testproject", owner)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if role := project.RoleOf(owner); role != models.RoleOwner {
+		t.Errorf("expected creator to be owner, got %q", role)
+	}
+
+	// Admin creators are not added to the ACL
+	admin := &models.Identity{ID: "tok-1", Method: models.AuthMethodToken, Admin: true}
+	if err := os.MkdirAll(tempDir+"/adminproject", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	project, err = manager.CreateProjectWithOwner(tempDir+"/adminproject", admin)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(project.GetACL()) != 0 {
+		t.Errorf("expected empty ACL for admin-created project, got %v", project.GetACL())
+	}
+}
+
+func TestSetProjectRole(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer os.RemoveAll(tempDir)
+
+	owner := &models.Identity{ID: "dev-1", Method: models.AuthMethodDevice}
+	project, err := manager.CreateProjectWithOwner(tempDir+"/testproject", owner)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("grant role", func(t *testing.T) {
+		if _, err := manager.SetProjectRole(project.ID, "device:dev-2", models.RoleObserver); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if role := project.GetACL()["device:dev-2"]; role != models.RoleObserver {
+			t.Errorf("expected observer, got %q", role)
+		}
+	})
+
+	t.Run("invalid role", func(t *testing.T) {
+		_, err := manager.SetProjectRole(project.ID, "device:dev-2", models.Role("admin"))
+		if !errors.IsCode(err, errors.CodeValidationFailed) {
+			t.Errorf("expected validation error, got %v", err)
+		}
+	})
+
+	t.Run("invalid principal", func(t *testing.T) {
+		_, err := manager.SetProjectRole(project.ID, "dev-2", models.RoleObserver)
+		if !errors.IsCode(err, errors.CodeValidationFailed) {
+			t.Errorf("expected validation error, got %v", err)
+		}
+	})
+
+	t.Run("unknown project", func(t *testing.T) {
+		_, err := manager.SetProjectRole("00000000-0000-0000-0000-000000000000", "device:dev-2", models.RoleObserver)
+		if !errors.IsCode(err, errors.CodeProjectNotFound) {
+			t.Errorf("expected project not found, got %v", err)
+		}
+	})
+
+	t.Run("cannot remove last owner", func(t *testing.T) {
+		_, err := manager.SetProjectRole(project.ID, owner.Principal(), models.RoleExecutor)
+		if !errors.IsCode(err, errors.CodeValidationFailed) {
+			t.Errorf("expected validation error, got %v", err)
+		}
+		if role := project.RoleOf(owner); role != models.RoleOwner {
+			t.Errorf("expected owner to be kept, got %q", role)
+		}
+
+		// Allowed once another owner exists
+		if _, err := manager.SetProjectRole(project.ID, "device:dev-2", models.RoleOwner); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := manager.SetProjectRole(project.ID, owner.Principal(), ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if role := project.RoleOf(owner); role != "" {
+			t.Errorf("expected access to be removed, got %q", role)
+		}
+	})
+
+	t.Run("persisted", func(t *testing.T) {
+		reloaded, err := NewManager(Config{DataDir: tempDir, MaxProjects: 10})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		p, err := reloaded.GetProjectByID(project.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if role := p.GetACL()["device:dev-2"]; role != models.RoleOwner {
+			t.Errorf("expected persisted owner, got %q", role)
+		}
+	})
+}
@@ -9,6 +9,13 @@ import (
 
 // CreateProject creates a new project with validation
 func (m *Manager) CreateProject(path string) (*models.Project, error) {
+	return m.CreateProjectWithOwner(path, nil)
+}
+
+// CreateProjectWithOwner creates a new project and grants the owner role to
+// the given identity. A nil or admin owner leaves the ACL empty, so only
+// admins can access the project until roles are granted.
+func (m *Manager) CreateProjectWithOwner(path string, owner *models.Identity) (*models.Project, error) {
 	// Validate path using Phase 2.5 validators
 	if err := m.validator.ValidatePath(path); err != nil {
 		return nil, err
@@ -38,6 +45,9 @@ func (m *Manager) CreateProject(path string) (*models.Project, error) {
 
 	// Create new project instance
 	project := models.NewProject(projectID, path)
+	if owner != nil && !owner.Admin {
+		project.SetRole(owner.Principal(), models.RoleOwner)
+	}
 
 	// Create message log for the project
 	messageLog, err := m.storageFactory.CreateMessageLog(projectID)
@@ -62,7 +72,8 @@ func (m *Manager) CreateProject(path string) (*models.Project, error) {
 
 	m.logger.Info("Project created successfully",
 		"project_id", projectID,
-		"path", path)
+		"path", path,
+		"owner", owner.String())
 
 	return project, nil
 }
@@ -84,7 +95,7 @@ func (m *Manager) DeleteProject(projectID string) error {
 
 	// Check if project is currently executing (Requirement 2.6)
 	project.Lock()
-	if project.State == models.StateExecuting {
+	if project.State.Active() {
 		project.Unlock()
 		return errors.New(errors.CodeProcessActive,
 			"cannot delete project while execution is active")
@@ -183,3 +194,63 @@ func (m *Manager) UpdateProject(project *models.Project) error {
 
 	return nil
 }
+
+// SetProjectPolicy assigns an execution policy profile to a project. An empty
+// policy makes the project use the server default.
+func (m *Manager) SetProjectPolicy(projectID, policy string) (*models.Project, error) {
+	project, err := m.GetProjectByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := project.GetPolicy()
+	project.SetPolicy(policy)
+
+	// Persist the change
+	if err := m.UpdateProject(project); err != nil {
+		// Rollback on failure
+		project.SetPolicy(previous)
+		return nil, err
+	}
+
+	m.logger.Info("Project policy updated",
+		"project_id", projectID,
+		"old_policy", previous,
+		"new_policy", policy)
+
+	return project, nil
+}
+
+// SetProjectBackend assigns an agent backend to a project and clears its
+// session, which the new backend could not resume. An empty backend makes
+// the project use the Claude CLI.
+func (m *Manager) SetProjectBackend(projectID, backend string) (*models.Project, error) {
+	project, err := m.GetProjectByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	project.Lock()
+	previous, previousSession := project.Backend, project.SessionID
+	project.Backend = backend
+	project.SessionID = ""
+	project.Unlock()
+
+	// Persist the change
+	if err := m.UpdateProject(project); err != nil {
+		// Rollback on failure
+		project.Lock()
+		project.Backend = previous
+		project.SessionID = previousSession
+		project.Unlock()
+		return nil, err
+	}
+
+	m.logger.Info("Project backend updated",
+		"project_id", projectID,
+		"old_backend", previous,
+		"new_backend", backend,
+		"old_session_id", previousSession)
+
+	return project, nil
+}
@@ -0,0 +1,92 @@
+package project
+
+import (
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// AddProjectSchedule adds a schedule to a project. A project holds at most
+// limit schedules.
+func (m *Manager) AddProjectSchedule(projectID string, schedule models.Schedule, limit int) (*models.Project, error) {
+	project, err := m.GetProjectByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !project.AddSchedule(schedule, limit) {
+		return nil, errors.NewResourceLimitError("schedules", limit, len(project.GetSchedules())).
+			WithDetail("project_id", projectID)
+	}
+
+	// Persist the change
+	if err := m.UpdateProject(project); err != nil {
+		// Rollback on failure
+		project.RemoveSchedule(schedule.ID)
+		return nil, err
+	}
+
+	m.logger.Info("Project schedule added",
+		"project_id", projectID,
+		"schedule_id", schedule.ID,
+		"cron", schedule.Cron)
+
+	return project, nil
+}
+
+// RemoveProjectSchedule removes a schedule from a project and returns it
+func (m *Manager) RemoveProjectSchedule(projectID, scheduleID string) (models.Schedule, error) {
+	project, err := m.GetProjectByID(projectID)
+	if err != nil {
+		return models.Schedule{}, err
+	}
+
+	previous := project.GetSchedules()
+	removed, ok := project.RemoveSchedule(scheduleID)
+	if !ok {
+		return models.Schedule{}, errors.New(errors.CodeScheduleNotFound, "schedule not found").
+			WithDetail("project_id", projectID).
+			WithDetail("schedule_id", scheduleID)
+	}
+
+	// Persist the change
+	if err := m.UpdateProject(project); err != nil {
+		// Rollback on failure
+		project.SetSchedules(previous)
+		return models.Schedule{}, err
+	}
+
+	m.logger.Info("Project schedule removed",
+		"project_id", projectID,
+		"schedule_id", scheduleID)
+
+	return removed, nil
+}
+
+// DisableProjectSchedule stops a project's schedule from running
+func (m *Manager) DisableProjectSchedule(projectID, scheduleID, reason string) error {
+	project, err := m.GetProjectByID(projectID)
+	if err != nil {
+		return err
+	}
+
+	previous := project.GetSchedules()
+	if !project.DisableSchedule(scheduleID, reason) {
+		return errors.New(errors.CodeScheduleNotFound, "schedule not found").
+			WithDetail("project_id", projectID).
+			WithDetail("schedule_id", scheduleID)
+	}
+
+	// Persist the change
+	if err := m.UpdateProject(project); err != nil {
+		// Rollback on failure
+		project.SetSchedules(previous)
+		return err
+	}
+
+	m.logger.Warn("Project schedule disabled",
+		"project_id", projectID,
+		"schedule_id", scheduleID,
+		"reason", reason)
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package project
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+func TestProjectSchedules(t *testing.T) {
+	manager, tempDir := setupTestManager(t)
+	defer os.RemoveAll(tempDir)
+
+	project, err := manager.CreateProject(tempDir + "/testproject")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	schedule := models.Schedule{
+		ID:      "schedule-1",
+		Cron:    "0 9 * * 1-5",
+		Command: models.ExecuteCommand{Prompt: "run the tests"},
+	}
+	if _, err := manager.AddProjectSchedule(project.ID, schedule, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The limit applies per project
+	_, err = manager.AddProjectSchedule(project.ID, models.Schedule{ID: "schedule-2", Cron: "@daily"}, 1)
+	if !errors.IsCode(err, errors.CodeResourceLimit) {
+		t.Errorf("expected RESOURCE_LIMIT, got %v", err)
+	}
+
+	// Schedules survive a restart
+	reloaded, err := NewManager(Config{DataDir: tempDir, MaxProjects: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := reloaded.GetProjectByID(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules := restored.GetSchedules()
+	if len(schedules) != 1 || schedules[0].Command.Prompt != "run the tests" {
+		t.Fatalf("expected the schedule to be persisted, got %+v", schedules)
+	}
+
+	// Disabled schedules are kept, and stay disabled after a restart
+	if err := manager.DisableProjectSchedule(project.ID, "schedule-1", "creator revoked"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloaded, err = NewManager(Config{DataDir: tempDir, MaxProjects: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = reloaded.GetProjectByID(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedules := restored.GetSchedules(); len(schedules) != 1 || !schedules[0].Disabled() {
+		t.Fatalf("expected the schedule to stay disabled, got %+v", schedules)
+	}
+	err = manager.DisableProjectSchedule(project.ID, "missing", "creator revoked")
+	if !errors.IsCode(err, errors.CodeScheduleNotFound) {
+		t.Errorf("expected SCHEDULE_NOT_FOUND, got %v", err)
+	}
+
+	if _, err := manager.RemoveProjectSchedule(project.ID, "schedule-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(project.GetSchedules()) != 0 {
+		t.Errorf("expected no schedules, got %+v", project.GetSchedules())
+	}
+
+	_, err = manager.RemoveProjectSchedule(project.ID, "schedule-1")
+	if !errors.IsCode(err, errors.CodeScheduleNotFound) {
+		t.Errorf("expected SCHEDULE_NOT_FOUND, got %v", err)
+	}
+}
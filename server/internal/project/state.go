@@ -150,7 +150,7 @@ func (m *Manager) IsProjectExecuting(projectID string) (bool, error) {
 	project.Lock()
 	defer project.Unlock()
 
-	return project.State == models.StateExecuting, nil
+	return project.State.Active(), nil
 }
 
 // CanProjectExecute checks if a project can start execution
@@ -163,7 +163,7 @@ func (m *Manager) CanProjectExecute(projectID string) error {
 	project.Lock()
 	defer project.Unlock()
 
-	if project.State == models.StateExecuting {
+	if project.State.Active() {
 		return errors.New(errors.CodeProcessActive,
 			"project is already executing")
 	}
@@ -204,6 +204,7 @@ func (m *Manager) GetProjectStats() map[string]interface{} {
 			string(models.StateIdle):      0,
 			string(models.StateExecuting): 0,
 			string(models.StateError):     0,
+			string(models.StatePaused):    0,
 		},
 		"total_subscribers": 0,
 	}
@@ -0,0 +1,266 @@
+// Package queue keeps a durable FIFO queue of execute commands per project.
+// Prompts sent while a project is executing wait here until the current run
+// finishes. Each queue is persisted in the project's data directory so queued
+// prompts survive a restart.
+package queue
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+	"github.com/google/uuid"
+)
+
+// FileName is the name of the queue file in a project's data directory
+const FileName = "queue.json"
+
+// Item is a queued execute command
+type Item struct {
+	ID       string                `json:"id"`
+	Command  models.ExecuteCommand `json:"command"`
+	QueuedBy string                `json:"queued_by"`
+	QueuedAt time.Time             `json:"queued_at"`
+}
+
+// Manager owns the queues of all projects
+type Manager struct {
+	dataDir   string
+	maxLength int
+
+	mu     sync.Mutex
+	queues map[string][]Item
+	now    func() time.Time
+}
+
+// NewManager creates a manager persisting queues under dataDir. maxLength
+// caps the number of queued commands per project; 0 is unlimited.
+func NewManager(dataDir string, maxLength int) *Manager {
+	return &Manager{
+		dataDir:   dataDir,
+		maxLength: maxLength,
+		queues:    make(map[string][]Item),
+		now:       time.Now,
+	}
+}
+
+// Push appends a command to the project's queue and returns the new item and
+// its 1-based position
+func (m *Manager) Push(projectID string, cmd models.ExecuteCommand, queuedBy string) (Item, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	if err != nil {
+		return Item{}, 0, err
+	}
+
+	if m.maxLength > 0 && len(items) >= m.maxLength {
+		return Item{}, 0, errors.NewResourceLimitError("queued prompts", m.maxLength, len(items)).
+			WithDetail("project_id", projectID)
+	}
+
+	item := Item{
+		ID:       uuid.New().String(),
+		Command:  cmd,
+		QueuedBy: queuedBy,
+		QueuedAt: m.now().UTC(),
+	}
+
+	if err := m.save(projectID, append(items, item)); err != nil {
+		return Item{}, 0, err
+	}
+	return item, len(items) + 1, nil
+}
+
+// Pop removes and returns the head of the project's queue
+func (m *Manager) Pop(projectID string) (Item, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	if err != nil || len(items) == 0 {
+		return Item{}, false, err
+	}
+
+	if err := m.save(projectID, items[1:]); err != nil {
+		return Item{}, false, err
+	}
+	return items[0], true, nil
+}
+
+// List returns the project's queued commands in order
+func (m *Manager) List(projectID string) ([]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Item{}, items...), nil
+}
+
+// Len returns the number of queued commands
+func (m *Manager) Len(projectID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	return len(items), err
+}
+
+// Move places an item at a 1-based position, clamped to the queue bounds,
+// and returns the reordered queue
+func (m *Manager) Move(projectID, itemID string, position int) ([]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := indexOf(items, itemID)
+	if index < 0 {
+		return nil, notFound(projectID, itemID)
+	}
+
+	if position < 1 {
+		position = 1
+	}
+	if position > len(items) {
+		position = len(items)
+	}
+
+	item := items[index]
+	reordered := make([]Item, 0, len(items))
+	reordered = append(reordered, items[:index]...)
+	reordered = append(reordered, items[index+1:]...)
+	reordered = append(reordered[:position-1], append([]Item{item}, reordered[position-1:]...)...)
+
+	if err := m.save(projectID, reordered); err != nil {
+		return nil, err
+	}
+	return append([]Item{}, reordered...), nil
+}
+
+// Remove deletes an item from the project's queue and returns it
+func (m *Manager) Remove(projectID, itemID string) (Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	if err != nil {
+		return Item{}, err
+	}
+
+	index := indexOf(items, itemID)
+	if index < 0 {
+		return Item{}, notFound(projectID, itemID)
+	}
+
+	item := items[index]
+	remaining := append(append([]Item{}, items[:index]...), items[index+1:]...)
+	if err := m.save(projectID, remaining); err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// Clear empties the project's queue and returns the removed items
+func (m *Manager) Clear(projectID string) ([]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items, err := m.load(projectID)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	if err := m.save(projectID, nil); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Forget drops the cached queue of a deleted project. The queue file is
+// removed with the project's data directory.
+func (m *Manager) Forget(projectID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.queues, projectID)
+}
+
+// path returns the queue file of a project
+func (m *Manager) path(projectID string) string {
+	return filepath.Join(m.dataDir, storage.ProjectsDirName, projectID, FileName)
+}
+
+// load returns the project's queue, reading it from disk on first use.
+// Callers hold m.mu.
+func (m *Manager) load(projectID string) ([]Item, error) {
+	if items, ok := m.queues[projectID]; ok {
+		return items, nil
+	}
+
+	var items []Item
+	data, err := os.ReadFile(m.path(projectID))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.NewFileOperationError("read queue", err)
+	default:
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, errors.NewJSONParsingError(err)
+		}
+	}
+
+	m.queues[projectID] = items
+	return items, nil
+}
+
+// save persists the project's queue. Callers hold m.mu.
+func (m *Manager) save(projectID string, items []Item) error {
+	if items == nil {
+		items = []Item{}
+	}
+
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+
+	path := m.path(projectID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.NewFileOperationError("create queue directory", err)
+	}
+	if err := storage.WriteFileAtomic(path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write queue", err)
+	}
+
+	m.queues[projectID] = items
+	return nil
+}
+
+// indexOf returns the index of the item with id, or -1
+func indexOf(items []Item, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// notFound reports an unknown queue item
+func notFound(projectID, itemID string) *errors.AppError {
+	return errors.New(errors.CodeQueueItemNotFound, "queued prompt not found").
+		WithDetail("project_id", projectID).
+		WithDetail("queue_id", itemID)
+}
@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func prompts(items []Item) []string {
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, item.Command.Prompt)
+	}
+	return out
+}
+
+func pushAll(t *testing.T, m *Manager, projectID string, texts ...string) []Item {
+	items := make([]Item, 0, len(texts))
+	for i, text := range texts {
+		item, position, err := m.Push(projectID, models.ExecuteCommand{Prompt: text}, "device:phone")
+		require.NoError(t, err)
+		assert.Equal(t, i+1, position)
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestManager_FIFO(t *testing.T) {
+	m := NewManager(t.TempDir(), 0)
+	pushAll(t, m, "p1", "one", "two", "three")
+
+	item, ok, err := m.Pop("p1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "one", item.Command.Prompt)
+	assert.Equal(t, "device:phone", item.QueuedBy)
+	assert.False(t, item.QueuedAt.IsZero())
+
+	items, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two", "three"}, prompts(items))
+
+	// Queues are per project
+	n, err := m.Len("p2")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	_, ok, err = m.Pop("p2")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestManager_Persistence(t *testing.T) {
+	dataDir := t.TempDir()
+	m := NewManager(dataDir, 0)
+	pushAll(t, m, "p1", "one", "two")
+
+	_, err := os.Stat(filepath.Join(dataDir, "projects", "p1", FileName))
+	require.NoError(t, err)
+
+	// A new manager reads the queue back
+	reopened := NewManager(dataDir, 0)
+	items, err := reopened.List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "two"}, prompts(items))
+
+	_, _, err = reopened.Pop("p1")
+	require.NoError(t, err)
+
+	items, err = NewManager(dataDir, 0).List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two"}, prompts(items))
+}
+
+func TestManager_MaxLength(t *testing.T) {
+	m := NewManager(t.TempDir(), 2)
+	pushAll(t, m, "p1", "one", "two")
+
+	_, _, err := m.Push("p1", models.ExecuteCommand{Prompt: "three"}, "device:phone")
+	require.Error(t, err)
+	assert.True(t, errors.IsCode(err, errors.CodeResourceLimit))
+
+	// Other projects have their own limit
+	_, _, err = m.Push("p2", models.ExecuteCommand{Prompt: "one"}, "device:phone")
+	assert.NoError(t, err)
+}
+
+func TestManager_Move(t *testing.T) {
+	tests := []struct {
+		name     string
+		index    int
+		position int
+		want     []string
+	}{
+		{"to front", 2, 1, []string{"c", "a", "b", "d"}},
+		{"to back", 0, 4, []string{"b", "c", "d", "a"}},
+		{"forward", 3, 2, []string{"a", "d", "b", "c"}},
+		{"backward", 0, 3, []string{"b", "c", "a", "d"}},
+		{"same place", 1, 2, []string{"a", "b", "c", "d"}},
+		{"clamped", 0, 99, []string{"b", "c", "d", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(t.TempDir(), 0)
+			items := pushAll(t, m, "p1", "a", "b", "c", "d")
+
+			moved, err := m.Move("p1", items[tt.index].ID, tt.position)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, prompts(moved))
+
+			listed, err := m.List("p1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, prompts(listed))
+		})
+	}
+
+	t.Run("unknown item", func(t *testing.T) {
+		m := NewManager(t.TempDir(), 0)
+		pushAll(t, m, "p1", "a")
+
+		_, err := m.Move("p1", "missing", 1)
+		assert.True(t, errors.IsCode(err, errors.CodeQueueItemNotFound))
+	})
+}
+
+func TestManager_RemoveAndClear(t *testing.T) {
+	m := NewManager(t.TempDir(), 0)
+	items := pushAll(t, m, "p1", "a", "b", "c")
+
+	removed, err := m.Remove("p1", items[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "b", removed.Command.Prompt)
+
+	listed, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, prompts(listed))
+
+	_, err = m.Remove("p1", items[1].ID)
+	assert.True(t, errors.IsCode(err, errors.CodeQueueItemNotFound))
+
+	cleared, err := m.Clear("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, prompts(cleared))
+
+	n, err := m.Len("p1")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestManager_ListReturnsCopy(t *testing.T) {
+	m := NewManager(t.TempDir(), 0)
+	pushAll(t, m, "p1", "a", "b")
+
+	listed, err := m.List("p1")
+	require.NoError(t, err)
+	listed[0].Command.Prompt = "changed"
+
+	again, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, prompts(again))
+}
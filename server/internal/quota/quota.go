@@ -0,0 +1,159 @@
+// Package quota enforces daily quotas per identity. Usage is persisted under
+// the data directory so restarting the server does not reset it.
+package quota
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+const (
+	// DirName is the name of the quota directory under the data directory
+	DirName = "quota"
+
+	// dayFormat identifies a UTC day
+	dayFormat = "2006-01-02"
+)
+
+// Config configures a Tracker
+type Config struct {
+	// Name identifies the quota in errors and its usage file
+	Name string
+	// Daily is the number of uses per identity per UTC day; 0 is unlimited
+	Daily int
+	// Overrides sets the daily limit of specific principals; 0 is unlimited
+	Overrides map[string]int
+}
+
+// usage is the persisted state of a Tracker
+type usage struct {
+	Day    string         `json:"day"`
+	Counts map[string]int `json:"counts"`
+}
+
+// Tracker counts uses per principal and UTC day
+type Tracker struct {
+	config Config
+	path   string
+
+	mu    sync.Mutex
+	usage usage
+	now   func() time.Time
+}
+
+// NewTracker opens the usage of the named quota under dataDir
+func NewTracker(dataDir string, config Config) (*Tracker, error) {
+	dir := filepath.Join(dataDir, DirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.NewFileOperationError("create quota directory", err)
+	}
+
+	t := &Tracker{
+		config: config,
+		path:   filepath.Join(dir, config.Name+".json"),
+		now:    time.Now,
+	}
+
+	data, err := os.ReadFile(t.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.NewFileOperationError("read quota usage", err)
+	default:
+		if err := json.Unmarshal(data, &t.usage); err != nil {
+			return nil, errors.NewJSONParsingError(err)
+		}
+	}
+
+	return t, nil
+}
+
+// Limit returns the daily limit of principal; 0 is unlimited
+func (t *Tracker) Limit(principal string) int {
+	if limit, ok := t.config.Overrides[principal]; ok {
+		return limit
+	}
+	return t.config.Daily
+}
+
+// Used returns how often principal used the quota today
+func (t *Tracker) Used(principal string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover()
+	return t.usage.Counts[principal]
+}
+
+// Reserve counts one use by principal, failing with QUOTA_EXCEEDED when the
+// daily limit is reached. The returned release gives the use back, for
+// requests that fail after reserving.
+func (t *Tracker) Reserve(principal string) (release func(), err error) {
+	limit := t.Limit(principal)
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	day := t.rollover()
+	if t.usage.Counts[principal] >= limit {
+		midnight := day.AddDate(0, 0, 1)
+		return nil, errors.NewQuotaExceededError(t.config.Name, limit, midnight.Sub(t.now()))
+	}
+
+	t.usage.Counts[principal]++
+	if err := t.save(); err != nil {
+		t.usage.Counts[principal]--
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { t.release(principal, day) }) }, nil
+}
+
+// release gives back a use reserved on day
+func (t *Tracker) release(principal string, day time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.rollover().Equal(day) || t.usage.Counts[principal] == 0 {
+		return
+	}
+	t.usage.Counts[principal]--
+	// Best effort; an unsaved release only errs in the user's disfavor
+	_ = t.save()
+}
+
+// rollover resets the counts when the UTC day changed and returns the start
+// of the current day. Callers hold t.mu.
+func (t *Tracker) rollover() time.Time {
+	now := t.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if key := day.Format(dayFormat); t.usage.Day != key {
+		t.usage = usage{Day: key, Counts: make(map[string]int)}
+	} else if t.usage.Counts == nil {
+		t.usage.Counts = make(map[string]int)
+	}
+	return day
+}
+
+// save persists the usage. Callers hold t.mu.
+func (t *Tracker) save() error {
+	data, err := json.MarshalIndent(t.usage, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+	if err := storage.WriteFileAtomic(t.path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write quota usage", err)
+	}
+	return nil
+}
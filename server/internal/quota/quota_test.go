@@ -0,0 +1,76 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserve(t *testing.T) {
+	tracker, err := NewTracker(t.TempDir(), Config{Name: "daily_executions", Daily: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := tracker.Reserve("token:tok-1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, tracker.Used("token:tok-1"))
+
+	_, err = tracker.Reserve("token:tok-1")
+	require.True(t, errors.IsCode(err, errors.CodeQuotaExceeded))
+	details := err.(*errors.AppError).Details
+	assert.Equal(t, "daily_executions", details["quota"])
+	assert.Equal(t, 2, details["limit"])
+	assert.Greater(t, details["retry_after_ms"], int64(0))
+	assert.LessOrEqual(t, details["retry_after_ms"], int64(24*time.Hour/time.Millisecond))
+
+	// Other principals have their own count
+	_, err = tracker.Reserve("token:tok-2")
+	assert.NoError(t, err)
+}
+
+func TestReleaseAndOverrides(t *testing.T) {
+	tracker, err := NewTracker(t.TempDir(), Config{
+		Name:      "daily_executions",
+		Daily:     1,
+		Overrides: map[string]int{"device:dev-1": 0},
+	})
+	require.NoError(t, err)
+
+	release, err := tracker.Reserve("token:tok-1")
+	require.NoError(t, err)
+	release()
+	release() // releasing twice gives back one use only
+	assert.Equal(t, 0, tracker.Used("token:tok-1"))
+
+	// An override of 0 is unlimited
+	for i := 0; i < 5; i++ {
+		_, err := tracker.Reserve("device:dev-1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, tracker.Limit("device:dev-1"))
+}
+
+func TestUsagePersistsAndResetsDaily(t *testing.T) {
+	dataDir := t.TempDir()
+	tracker, err := NewTracker(dataDir, Config{Name: "daily_executions", Daily: 1})
+	require.NoError(t, err)
+	_, err = tracker.Reserve("token:tok-1")
+	require.NoError(t, err)
+
+	// Usage survives a restart
+	tracker, err = NewTracker(dataDir, Config{Name: "daily_executions", Daily: 1})
+	require.NoError(t, err)
+	_, err = tracker.Reserve("token:tok-1")
+	assert.True(t, errors.IsCode(err, errors.CodeQuotaExceeded))
+
+	// and resets on the next UTC day
+	tomorrow := time.Now().Add(24 * time.Hour)
+	tracker.now = func() time.Time { return tomorrow }
+	assert.Equal(t, 0, tracker.Used("token:tok-1"))
+	_, err = tracker.Reserve("token:tok-1")
+	assert.NoError(t, err)
+}
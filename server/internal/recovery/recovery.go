@@ -0,0 +1,206 @@
+// Package recovery keeps track of the Claude processes the server runs, so
+// that a server that crashed mid-run can clean up after itself. A marker
+// with the PID and start time of each running process is persisted in its
+// project's data directory while the process runs. On startup, markers
+// left behind identify processes that may have survived the crash.
+package recovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/platform"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+const (
+	// FileName is the name of the marker file in a project's data directory
+	FileName = "running.json"
+	// StartTimeTolerance absorbs the rounding of process start times, which
+	// some platforms report to the second
+	StartTimeTolerance = time.Second
+)
+
+// Marker records a running Claude process
+type Marker struct {
+	// ExecutionID identifies the run's execution record, if one is kept
+	ExecutionID string `json:"execution_id,omitempty"`
+	// WorktreeID is set for runs in a worktree of the project
+	WorktreeID string `json:"worktree_id,omitempty"`
+	// SessionID is the Claude session the run resumed, if any
+	SessionID string `json:"session_id,omitempty"`
+	// PID is the process ID, which is also its process group ID
+	PID int `json:"pid"`
+	// ProcessStart is when the process started according to the system;
+	// zero when it could not be read
+	ProcessStart time.Time `json:"process_start"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// Store persists the markers of all projects
+type Store struct {
+	dataDir string
+	mu      sync.Mutex
+}
+
+// NewStore creates a store persisting markers under dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{dataDir: dataDir}
+}
+
+// Mark records a running process of a project, replacing the marker of an
+// earlier process of the same run slot, i.e. the project's directory or
+// the same worktree
+func (s *Store) Mark(projectID string, marker Marker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	markers, err := s.load(projectID)
+	if err != nil {
+		return err
+	}
+	if i := indexOf(markers, marker.WorktreeID); i >= 0 {
+		markers[i] = marker
+	} else {
+		markers = append(markers, marker)
+	}
+	return s.save(projectID, markers)
+}
+
+// Clear removes the marker of a project's process that ended
+func (s *Store) Clear(projectID, worktreeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	markers, err := s.load(projectID)
+	if err != nil {
+		return err
+	}
+	i := indexOf(markers, worktreeID)
+	if i < 0 {
+		return nil
+	}
+	return s.save(projectID, append(markers[:i], markers[i+1:]...))
+}
+
+// Take returns the markers of a project and removes them. It is called on
+// startup, when no process of a previous server run is tracked.
+func (s *Store) Take(projectID string) ([]Marker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	markers, err := s.load(projectID)
+	if err != nil || len(markers) == 0 {
+		return nil, err
+	}
+	if err := s.save(projectID, nil); err != nil {
+		return nil, err
+	}
+	return markers, nil
+}
+
+// path returns the marker file of a project
+func (s *Store) path(projectID string) string {
+	return filepath.Join(s.dataDir, storage.ProjectsDirName, projectID, FileName)
+}
+
+// load reads a project's markers. Callers hold s.mu.
+func (s *Store) load(projectID string) ([]Marker, error) {
+	data, err := os.ReadFile(s.path(projectID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.NewFileOperationError("read running markers", err)
+	}
+
+	var markers []Marker
+	if err := json.Unmarshal(data, &markers); err != nil {
+		return nil, errors.NewJSONParsingError(err)
+	}
+	return markers, nil
+}
+
+// save persists a project's markers, removing the file once none are left.
+// Callers hold s.mu.
+func (s *Store) save(projectID string, markers []Marker) error {
+	path := s.path(projectID)
+	if len(markers) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.NewFileOperationError("remove running markers", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(markers, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.NewFileOperationError("create running marker directory", err)
+	}
+	if err := storage.WriteFileAtomic(path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write running markers", err)
+	}
+	return nil
+}
+
+// indexOf returns the index of the marker of a run slot, or -1
+func indexOf(markers []Marker, worktreeID string) int {
+	for i, marker := range markers {
+		if marker.WorktreeID == worktreeID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Survives reports whether anything of a marker's process is still running:
+// the process itself, if it is the one the marker recorded rather than a
+// later one that reused its PID, or other processes of its group, such as
+// the tools Claude ran
+func Survives(marker Marker) bool {
+	if marker.PID <= 0 {
+		return false
+	}
+	if start, err := platform.ProcessStartTime(marker.PID); err == nil {
+		diff := start.Sub(marker.ProcessStart)
+		if !marker.ProcessStart.IsZero() && diff <= StartTimeTolerance && diff >= -StartTimeTolerance {
+			return true
+		}
+		// Another process has the PID now. Unless it leads a group of its
+		// own, which would have the same ID, the group is still the run's.
+		if pgid, err := syscall.Getpgid(marker.PID); err != nil || pgid == marker.PID {
+			return false
+		}
+	}
+	return platform.ProcessGroupAlive(marker.PID)
+}
+
+// Reap stops what survives of a marker's process and its group. It asks the
+// group to terminate and kills it after grace. It reports whether anything
+// survived.
+func Reap(marker Marker, grace time.Duration) (bool, error) {
+	if !Survives(marker) {
+		return false, nil
+	}
+
+	if err := platform.SignalProcessGroup(marker.PID, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return true, errors.Wrap(err, errors.CodeExecutionFailed, "failed to terminate process group %d", marker.PID)
+	}
+	for deadline := time.Now().Add(grace); time.Now().Before(deadline); {
+		if !platform.ProcessGroupAlive(marker.PID) {
+			return true, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := platform.SignalProcessGroup(marker.PID, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return true, errors.Wrap(err, errors.CodeExecutionFailed, "failed to kill process group %d", marker.PID)
+	}
+	return true, nil
+}
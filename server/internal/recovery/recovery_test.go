@@ -0,0 +1,87 @@
+package recovery
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/platform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	markers, err := s.Take("p1")
+	require.NoError(t, err)
+	assert.Empty(t, markers)
+
+	require.NoError(t, s.Mark("p1", Marker{ExecutionID: "e1", PID: 10}))
+	require.NoError(t, s.Mark("p1", Marker{ExecutionID: "e2", WorktreeID: "w1", PID: 11}))
+	// A later process of the same slot replaces the marker
+	require.NoError(t, s.Mark("p1", Marker{ExecutionID: "e3", PID: 12}))
+	require.NoError(t, s.Mark("p2", Marker{ExecutionID: "e4", PID: 13}))
+	require.NoError(t, s.Clear("p2", ""))
+	require.NoError(t, s.Clear("p2", "unknown"))
+
+	markers, err = s.Take("p1")
+	require.NoError(t, err)
+	require.Len(t, markers, 2)
+	assert.Equal(t, "e3", markers[0].ExecutionID)
+	assert.Equal(t, "w1", markers[1].WorktreeID)
+
+	// Taking removes the markers
+	markers, err = s.Take("p1")
+	require.NoError(t, err)
+	assert.Empty(t, markers)
+	markers, err = s.Take("p2")
+	require.NoError(t, err)
+	assert.Empty(t, markers)
+}
+
+// startGroup starts a shell leading a process group of its own, with a
+// child of its own in the group
+func startGroup(t *testing.T) (*exec.Cmd, Marker) {
+	cmd := exec.Command("sh", "-c", "sleep 30 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = platform.SignalProcessGroup(cmd.Process.Pid, syscall.SIGKILL)
+	})
+
+	start, err := platform.ProcessStartTime(cmd.Process.Pid)
+	require.NoError(t, err)
+	return cmd, Marker{PID: cmd.Process.Pid, ProcessStart: start}
+}
+
+func TestReap(t *testing.T) {
+	cmd, marker := startGroup(t)
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	// A marker whose start time does not match is a different process
+	other := marker
+	other.ProcessStart = marker.ProcessStart.Add(-time.Hour)
+	assert.False(t, Survives(other))
+	assert.True(t, Survives(marker))
+
+	survived, err := Reap(marker, time.Second)
+	require.NoError(t, err)
+	assert.True(t, survived)
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process survived reaping")
+	}
+	require.Eventually(t, func() bool { return !platform.ProcessGroupAlive(marker.PID) }, 5*time.Second, 20*time.Millisecond)
+
+	survived, err = Reap(marker, time.Second)
+	require.NoError(t, err)
+	assert.False(t, survived)
+}
@@ -0,0 +1,64 @@
+package redact
+
+import "fmt"
+
+// builtinPatterns are the detectors for common credential formats, in the
+// order they run. Specific formats come before the generic assignment rule
+// so their placeholders name the credential type.
+var builtinPatterns = []struct {
+	name    string
+	pattern string
+}{
+	{"private_key", `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`},
+	{"anthropic_api_key", `\bsk-ant-[A-Za-z0-9_-]{20,}`},
+	{"openai_api_key", `\bsk-(?:proj-)?[A-Za-z0-9_-]{20,}`},
+	{"aws_access_key_id", `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{"github_token", `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`},
+	{"slack_token", `\bxox[abposr]-[A-Za-z0-9-]{10,}`},
+	{"stripe_key", `\b[rs]k_(?:live|test)_[0-9A-Za-z]{16,}`},
+	{"google_api_key", `\bAIza[0-9A-Za-z_-]{35}`},
+	{"pocket_agent_credential", `\b(?:pat|dev)_[A-Za-z0-9_-]{40,}`},
+	{"jwt", `\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`},
+	{"bearer_token", `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{16,}=*)`},
+	{"url_credentials", `[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`},
+	{"secret_assignment", `(?i)\b[A-Z0-9_]*(?:api[_-]?key|secret|password|passwd|token|access[_-]?key|private[_-]?key)[A-Z0-9_]*\b["']?\s*[:=]\s*["']?(?P<secret>[^\s"',;\[\]]{8,})`},
+}
+
+// builtins are the compiled built-in detectors
+var builtins = func() []Detector {
+	detectors := make([]Detector, 0, len(builtinPatterns))
+	for _, p := range builtinPatterns {
+		d, err := NewRegexDetector(p.name, p.pattern)
+		if err != nil {
+			panic(fmt.Sprintf("redact: %v", err))
+		}
+		detectors = append(detectors, d)
+	}
+	return detectors
+}()
+
+// Builtin returns the built-in detectors, except those named in disabled
+func Builtin(disabled ...string) []Detector {
+	skip := make(map[string]bool, len(disabled))
+	for _, name := range disabled {
+		skip[name] = true
+	}
+
+	detectors := make([]Detector, 0, len(builtins))
+	for _, d := range builtins {
+		if !skip[d.Name()] {
+			detectors = append(detectors, d)
+		}
+	}
+	return detectors
+}
+
+// IsBuiltin reports whether name is a built-in detector
+func IsBuiltin(name string) bool {
+	for _, p := range builtinPatterns {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+// Package redact removes credentials from Claude output before it is
+// persisted or broadcast. A Pipeline runs a list of detectors over every
+// string and replaces what they find with a placeholder naming the rule.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SecretGroup is the name of the capture group a pattern may use to limit the
+// replacement to part of its match, e.g. the value of a KEY=value assignment
+const SecretGroup = "secret"
+
+// Detector finds and replaces secrets in text
+type Detector interface {
+	// Name identifies the rule in placeholders and findings
+	Name() string
+	// Redact returns text with secrets replaced and the number replaced
+	Redact(text string) (string, int)
+}
+
+// Findings counts the replacements made per rule
+type Findings map[string]int
+
+// Rules returns the names of the rules that fired, sorted
+func (f Findings) Rules() []string {
+	if len(f) == 0 {
+		return nil
+	}
+	rules := make([]string, 0, len(f))
+	for rule := range f {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// add merges other into f
+func (f Findings) add(other Findings) {
+	for rule, n := range other {
+		f[rule] += n
+	}
+}
+
+// Placeholder returns the text that replaces a secret found by rule
+func Placeholder(rule string) string {
+	return "[REDACTED:" + rule + "]"
+}
+
+// Pipeline applies detectors in order. A nil pipeline redacts nothing.
+type Pipeline struct {
+	detectors []Detector
+}
+
+// NewPipeline creates a pipeline of detectors
+func NewPipeline(detectors ...Detector) *Pipeline {
+	return &Pipeline{detectors: detectors}
+}
+
+// With returns a pipeline that runs detectors after those of p. p is not
+// changed and may be nil.
+func (p *Pipeline) With(detectors ...Detector) *Pipeline {
+	if len(detectors) == 0 {
+		return p
+	}
+	var base []Detector
+	if p != nil {
+		base = p.detectors
+	}
+	return &Pipeline{detectors: append(append([]Detector{}, base...), detectors...)}
+}
+
+// Redact replaces secrets in text
+func (p *Pipeline) Redact(text string) (string, Findings) {
+	findings := make(Findings)
+	if p == nil {
+		return text, findings
+	}
+
+	for _, d := range p.detectors {
+		var n int
+		text, n = d.Redact(text)
+		if n > 0 {
+			findings[d.Name()] += n
+		}
+	}
+	return text, findings
+}
+
+// RedactValue replaces secrets in every string of a decoded JSON value,
+// including object keys, and returns the redacted copy
+func (p *Pipeline) RedactValue(value interface{}) (interface{}, Findings) {
+	findings := make(Findings)
+	if p == nil {
+		return value, findings
+	}
+	return p.redactValue(value, findings), findings
+}
+
+// redactValue walks value, accumulating into findings
+func (p *Pipeline) redactValue(value interface{}, findings Findings) interface{} {
+	switch v := value.(type) {
+	case string:
+		redacted, f := p.Redact(v)
+		findings.add(f)
+		return redacted
+
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redactedKey, f := p.Redact(key)
+			findings.add(f)
+			out[redactedKey] = p.redactValue(item, findings)
+		}
+		return out
+
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = p.redactValue(item, findings)
+		}
+		return out
+
+	default:
+		return value
+	}
+}
+
+// RegexDetector replaces matches of a regular expression. If the expression
+// has a capture group named SecretGroup only that group is replaced.
+type RegexDetector struct {
+	name  string
+	re    *regexp.Regexp
+	group int
+}
+
+// NewRegexDetector compiles a detector for pattern
+func NewRegexDetector(name, pattern string) (*RegexDetector, error) {
+	if name == "" {
+		return nil, fmt.Errorf("detector name cannot be empty")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern for %s: %w", name, err)
+	}
+
+	return &RegexDetector{
+		name:  name,
+		re:    re,
+		group: re.SubexpIndex(SecretGroup),
+	}, nil
+}
+
+// Name implements Detector
+func (d *RegexDetector) Name() string {
+	return d.name
+}
+
+// Redact implements Detector
+func (d *RegexDetector) Redact(text string) (string, int) {
+	matches := d.re.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, 0
+	}
+
+	placeholder := Placeholder(d.name)
+	var out []byte
+	last, count := 0, 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if d.group > 0 {
+			start, end = m[2*d.group], m[2*d.group+1]
+			if start < 0 {
+				continue
+			}
+		}
+		if start < last || start == end {
+			continue
+		}
+		out = append(out, text[last:start]...)
+		out = append(out, placeholder...)
+		last = end
+		count++
+	}
+	out = append(out, text[last:]...)
+
+	return string(out), count
+}
+
+// LiteralDetector replaces known secret values, such as those a project
+// passes to executions in its environment
+type LiteralDetector struct {
+	name        string
+	placeholder string
+	replacer    *strings.Replacer
+}
+
+// NewLiteralDetector creates a detector for values. Empty values are ignored.
+func NewLiteralDetector(name string, values ...string) *LiteralDetector {
+	placeholder := Placeholder(name)
+
+	// Longer values first, so a value containing another is replaced whole
+	sorted := append([]string{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var pairs []string
+	for _, v := range sorted {
+		if v != "" {
+			pairs = append(pairs, v, placeholder)
+		}
+	}
+
+	return &LiteralDetector{name: name, placeholder: placeholder, replacer: strings.NewReplacer(pairs...)}
+}
+
+// Name implements Detector
+func (d *LiteralDetector) Name() string {
+	return d.name
+}
+
+// Redact implements Detector
+func (d *LiteralDetector) Redact(text string) (string, int) {
+	redacted := d.replacer.Replace(text)
+	if redacted == text {
+		return text, 0
+	}
+	return redacted, strings.Count(redacted, d.placeholder) - strings.Count(text, d.placeholder)
+}
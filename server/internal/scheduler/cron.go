@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+)
+
+// searchYears bounds the search for the next match of an expression, so
+// expressions that never match (e.g. February 30th) end the search
+const searchYears = 5
+
+// field describes one of the five fields of a cron expression
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// descriptors are the shorthands accepted in place of five fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Expr is a parsed cron expression. Each field is a bit set of the values it
+// matches.
+type Expr struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record day fields starting with "*". As in cron,
+	// a day matches either restricted day field when both are restricted.
+	domStar, dowStar bool
+}
+
+// Parse parses a five-field cron expression (minute, hour, day of month,
+// month, day of week) or one of the descriptors @yearly, @annually,
+// @monthly, @weekly, @daily, @midnight and @hourly. Fields accept "*",
+// values, ranges ("1-5"), lists ("1,15"), steps ("*/15", "9-17/2") and,
+// for months and days of the week, three-letter names.
+func Parse(spec string) (*Expr, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.NewValidationError("invalid cron expression %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var expr Expr
+	var err error
+	parsers := []struct {
+		set   *uint64
+		field field
+	}{
+		{&expr.minute, minuteField},
+		{&expr.hour, hourField},
+		{&expr.dom, domField},
+		{&expr.month, monthField},
+		{&expr.dow, dowField},
+	}
+	for i, p := range parsers {
+		if *p.set, err = parseField(fields[i], p.field); err != nil {
+			return nil, errors.NewValidationError("invalid cron expression %q: %s", spec, err.Error())
+		}
+	}
+
+	// Fold Sunday as 7 onto 0
+	if expr.dow&(1<<7) != 0 {
+		expr.dow = expr.dow&^(1<<7) | 1
+	}
+	expr.domStar = strings.HasPrefix(fields[2], "*")
+	expr.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return &expr, nil
+}
+
+// parseField parses a comma separated list of ranges into a bit set
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		bits, err := parseRange(part, f)
+		if err != nil {
+			return 0, err
+		}
+		set |= bits
+	}
+	return set, nil
+}
+
+// parseRange parses "*", a value or a range, each with an optional step
+func parseRange(s string, f field) (uint64, error) {
+	rangePart, stepPart, hasStep := strings.Cut(s, "/")
+
+	var low, high int
+	switch {
+	case rangePart == "*":
+		low, high = f.min, f.max
+	case strings.Contains(rangePart, "-"):
+		lowPart, highPart, _ := strings.Cut(rangePart, "-")
+		var err error
+		if low, err = parseValue(lowPart, f); err != nil {
+			return 0, err
+		}
+		if high, err = parseValue(highPart, f); err != nil {
+			return 0, err
+		}
+		if low > high {
+			return 0, fmt.Errorf("%s range %s is backwards", f.name, rangePart)
+		}
+	default:
+		var err error
+		if low, err = parseValue(rangePart, f); err != nil {
+			return 0, err
+		}
+		// "5/15" runs from 5 to the end of the range
+		high = low
+		if hasStep {
+			high = f.max
+		}
+	}
+
+	step := 1
+	if hasStep {
+		var err error
+		step, err = strconv.Atoi(stepPart)
+		if err != nil || step < 1 {
+			return 0, fmt.Errorf("invalid %s step %q", f.name, stepPart)
+		}
+	}
+
+	var set uint64
+	for v := low; v <= high; v += step {
+		set |= 1 << uint(v)
+	}
+	return set, nil
+}
+
+// parseValue parses a number or name within the field's bounds
+func parseValue(s string, f field) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t the expression matches, in t's
+// location, or the zero time if it does not match within the next years.
+// Wall clock times skipped by a daylight saving change do not match.
+func (e *Expr) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(searchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !has(e.month, int(t.Month())) {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !e.matchesDay(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !has(e.hour, t.Hour()) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if !has(e.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// forward returns next, unless a daylight saving change maps it back to or
+// before t, in which case it moves on by an hour
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Hour)
+}
+
+// matchesDay reports whether the day fields match t's day
+func (e *Expr) matchesDay(t time.Time) bool {
+	dom := has(e.dom, t.Day())
+	dow := has(e.dow, int(t.Weekday()))
+
+	if e.domStar || e.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// has reports whether value is in the bit set
+func has(set uint64, value int) bool {
+	return set&(1<<uint(value)) != 0
+}
@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@often",
+	}
+
+	for _, spec := range tests {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := Parse(spec); !errors.IsCode(err, errors.CodeValidationFailed) {
+				t.Errorf("Parse(%q) error = %v, want VALIDATION_FAILED", spec, err)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Monday
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"every minute", "* * * * *", base, base.Add(time.Minute)},
+		{"seconds are dropped", "* * * * *", base.Add(30 * time.Second), base.Add(time.Minute)},
+		{"weekday mornings", "0 9 * * 1-5", base, time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{"weekday mornings by name", "0 9 * * MON-FRI", time.Date(2024, 1, 19, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 22, 9, 0, 0, 0, time.UTC)},
+		{"steps", "*/15 * * * *", base, time.Date(2024, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"range steps", "0 9-17/4 * * *", base, time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"lists", "0 0 1,15 * *", base, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", base, time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC)},
+		{"day of month or week", "0 0 20 * 3", base, time.Date(2024, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"month names", "0 0 1 mar *", base, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", base, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"descriptor", "@daily", base, time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", base, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.spec, err)
+			}
+			if got := expr.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	expr, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 9:00 in New York is 14:00 UTC in winter and 13:00 UTC in summer
+	winter := expr.Next(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC).In(loc))
+	if want := time.Date(2024, 1, 15, 14, 0, 0, 0, time.UTC); !winter.Equal(want) {
+		t.Errorf("expected %v, got %v", want, winter.UTC())
+	}
+	summer := expr.Next(time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC).In(loc))
+	if want := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC); !summer.Equal(want) {
+		t.Errorf("expected %v, got %v", want, summer.UTC())
+	}
+
+	// Times the spring change skips do not run that day
+	expr, err = Parse("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := expr.Next(time.Date(2024, 3, 10, 0, 0, 0, 0, loc))
+	if want := time.Date(2024, 3, 11, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,215 @@
+// Package scheduler runs the scheduled prompts stored in project metadata
+// when their cron expressions come due.
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// checkInterval is how often schedules are checked; runs start at most this
+// late
+const checkInterval = 15 * time.Second
+
+// Projects lists the projects whose schedules are run
+type Projects interface {
+	GetAllProjects() []*models.Project
+}
+
+// Runner starts the prompt of a due schedule
+type Runner interface {
+	RunSchedule(projectID string, schedule models.Schedule) error
+}
+
+// Scheduler checks the schedules of all projects periodically and starts
+// those that came due since the last check. Runs that cannot start because
+// the executor or the project is busy are retried on later checks until
+// they start; runs due while the server was down are not caught up.
+type Scheduler struct {
+	projects Projects
+	runner   Runner
+	log      *logger.Logger
+	now      func() time.Time
+
+	mu sync.Mutex
+	// last is when schedules were last checked
+	last time.Time
+	// pending holds the IDs of due schedules waiting to start
+	pending map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a scheduler
+func New(projects Projects, runner Runner, log *logger.Logger) *Scheduler {
+	return &Scheduler{
+		projects: projects,
+		runner:   runner,
+		log:      log,
+		now:      time.Now,
+		pending:  make(map[string]bool),
+	}
+}
+
+// Start begins checking schedules in the background
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	s.last = s.now()
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.loop(s.stop, s.done)
+	s.log.Info("Scheduler started", "interval", checkInterval)
+}
+
+// Stop stops checking schedules. Runs already started are not affected.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	s.log.Info("Scheduler stopped")
+}
+
+// loop checks schedules until stopped
+func (s *Scheduler) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+// check starts the schedules that came due since the last check, and those
+// still waiting from earlier checks
+func (s *Scheduler) check() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	seen := make(map[string]bool)
+
+	for _, project := range s.projects.GetAllProjects() {
+		for _, schedule := range project.GetSchedules() {
+			if schedule.Disabled() {
+				continue
+			}
+			seen[schedule.ID] = true
+
+			if !s.pending[schedule.ID] {
+				next, err := Next(schedule, s.last)
+				if err != nil {
+					s.log.Error("Invalid schedule",
+						"project_id", project.ID,
+						"schedule_id", schedule.ID,
+						"error", err,
+					)
+					continue
+				}
+				if next.IsZero() || next.After(now) {
+					continue
+				}
+			}
+
+			s.run(project.ID, schedule)
+		}
+	}
+
+	// Forget deleted schedules
+	for id := range s.pending {
+		if !seen[id] {
+			delete(s.pending, id)
+		}
+	}
+	s.last = now
+}
+
+// run starts a due schedule, keeping it pending while it has to wait.
+// Callers hold s.mu.
+func (s *Scheduler) run(projectID string, schedule models.Schedule) {
+	err := s.runner.RunSchedule(projectID, schedule)
+	if Retryable(err) {
+		if !s.pending[schedule.ID] {
+			s.log.Info("Scheduled run waiting",
+				"project_id", projectID,
+				"schedule_id", schedule.ID,
+				"reason", err,
+			)
+		}
+		s.pending[schedule.ID] = true
+		return
+	}
+	delete(s.pending, schedule.ID)
+
+	if err != nil {
+		s.log.Warn("Scheduled run failed to start",
+			"project_id", projectID,
+			"schedule_id", schedule.ID,
+			"error", err,
+		)
+		return
+	}
+
+	s.log.Info("Scheduled run started",
+		"project_id", projectID,
+		"schedule_id", schedule.ID,
+		"cron", schedule.Cron,
+	)
+}
+
+// Retryable reports whether a schedule failed to start only because the
+// executor is at its concurrency limit or the project is busy
+func Retryable(err error) bool {
+	return errors.IsCode(err, errors.CodeResourceLimit) || errors.IsCode(err, errors.CodeProcessActive)
+}
+
+// Next returns the first time after t a schedule comes due, evaluated in
+// the schedule's time zone. The zero time means it never comes due.
+func Next(schedule models.Schedule, t time.Time) (time.Time, error) {
+	expr, err := Parse(schedule.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := Location(schedule.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expr.Next(t.In(loc)), nil
+}
+
+// Location loads the time zone of a schedule; empty is the server's local
+// time
+func Location(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.NewValidationError("unknown time zone %q", name)
+	}
+	return loc, nil
+}
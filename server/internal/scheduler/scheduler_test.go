@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// fakeProjects serves a fixed list of projects
+type fakeProjects []*models.Project
+
+func (f fakeProjects) GetAllProjects() []*models.Project {
+	return f
+}
+
+// fakeRunner records runs and fails them with err
+type fakeRunner struct {
+	runs []string
+	err  error
+}
+
+func (f *fakeRunner) RunSchedule(projectID string, schedule models.Schedule) error {
+	f.runs = append(f.runs, schedule.ID)
+	return f.err
+}
+
+func newTestScheduler(project *models.Project, runner Runner, start time.Time) *Scheduler {
+	s := New(fakeProjects{project}, runner, logger.New("error"))
+	s.last = start
+	return s
+}
+
+func TestSchedulerCheck(t *testing.T) {
+	start := time.Date(2024, 1, 15, 8, 59, 50, 0, time.UTC)
+	project := models.NewProject("p1", "/tmp/p1")
+	project.AddSchedule(models.Schedule{ID: "morning", Cron: "0 9 * * *", Timezone: "UTC"}, 10)
+	project.AddSchedule(models.Schedule{ID: "evening", Cron: "0 18 * * *", Timezone: "UTC"}, 10)
+
+	runner := &fakeRunner{}
+	s := newTestScheduler(project, runner, start)
+
+	// Nothing is due before 9:00
+	s.now = func() time.Time { return start.Add(5 * time.Second) }
+	s.check()
+	if len(runner.runs) != 0 {
+		t.Fatalf("expected no runs, got %v", runner.runs)
+	}
+
+	// The check after 9:00 starts the morning schedule once
+	s.now = func() time.Time { return start.Add(20 * time.Second) }
+	s.check()
+	s.now = func() time.Time { return start.Add(35 * time.Second) }
+	s.check()
+	if len(runner.runs) != 1 || runner.runs[0] != "morning" {
+		t.Fatalf("expected one morning run, got %v", runner.runs)
+	}
+}
+
+func TestSchedulerSkipsDisabled(t *testing.T) {
+	start := time.Date(2024, 1, 15, 8, 59, 50, 0, time.UTC)
+	project := models.NewProject("p1", "/tmp/p1")
+	project.AddSchedule(models.Schedule{ID: "morning", Cron: "0 9 * * *", Timezone: "UTC"}, 10)
+	project.DisableSchedule("morning", "creator revoked")
+
+	runner := &fakeRunner{}
+	s := newTestScheduler(project, runner, start)
+
+	s.now = func() time.Time { return start.Add(20 * time.Second) }
+	s.check()
+	if len(runner.runs) != 0 {
+		t.Fatalf("expected no runs, got %v", runner.runs)
+	}
+}
+
+func TestSchedulerRetriesBusyRuns(t *testing.T) {
+	start := time.Date(2024, 1, 15, 8, 59, 50, 0, time.UTC)
+	project := models.NewProject("p1", "/tmp/p1")
+	project.AddSchedule(models.Schedule{ID: "morning", Cron: "0 9 * * *", Timezone: "UTC"}, 10)
+
+	runner := &fakeRunner{err: errors.NewResourceLimitError("concurrent executions", 1, 1)}
+	s := newTestScheduler(project, runner, start)
+
+	// A run that cannot start yet is retried on every check
+	s.now = func() time.Time { return start.Add(20 * time.Second) }
+	s.check()
+	s.now = func() time.Time { return start.Add(35 * time.Second) }
+	s.check()
+	if len(runner.runs) != 2 {
+		t.Fatalf("expected the run to be retried, got %v", runner.runs)
+	}
+
+	// Once it starts it is no longer pending
+	runner.err = nil
+	s.now = func() time.Time { return start.Add(50 * time.Second) }
+	s.check()
+	s.now = func() time.Time { return start.Add(65 * time.Second) }
+	s.check()
+	if len(runner.runs) != 3 {
+		t.Fatalf("expected the run to start once, got %v", runner.runs)
+	}
+
+	// Other failures are not retried
+	runner.err = errors.NewBudgetExceededError("project_daily", 1, 1, time.Hour)
+	s.last = start
+	s.now = func() time.Time { return start.Add(20 * time.Second) }
+	s.check()
+	s.now = func() time.Time { return start.Add(35 * time.Second) }
+	s.check()
+	if len(runner.runs) != 4 {
+		t.Fatalf("expected the failed run not to be retried, got %v", runner.runs)
+	}
+
+	// Deleted schedules stop waiting
+	runner.err = errors.New(errors.CodeProcessActive, "busy")
+	s.last = start
+	s.now = func() time.Time { return start.Add(20 * time.Second) }
+	s.check()
+	project.RemoveSchedule("morning")
+	s.now = func() time.Time { return start.Add(35 * time.Second) }
+	s.check()
+	if len(runner.runs) != 5 || len(s.pending) != 0 {
+		t.Fatalf("expected the deleted schedule to be forgotten, got runs %v pending %v", runner.runs, s.pending)
+	}
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	s := New(fakeProjects{}, &fakeRunner{}, logger.New("error"))
+	s.Start()
+	s.Start()
+	s.Stop()
+	s.Stop()
+}
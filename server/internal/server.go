@@ -8,16 +8,33 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/certs"
 	"github.com/boyd/pocket_agent/server/internal/config"
+	"github.com/boyd/pocket_agent/server/internal/env"
 	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/history"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/metrics"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/netutil"
+	"github.com/boyd/pocket_agent/server/internal/permission"
 	"github.com/boyd/pocket_agent/server/internal/platform"
 	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+	"github.com/boyd/pocket_agent/server/internal/redact"
+	"github.com/boyd/pocket_agent/server/internal/scheduler"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/boyd/pocket_agent/server/internal/usage"
 	"github.com/boyd/pocket_agent/server/internal/validation"
 	"github.com/boyd/pocket_agent/server/internal/websocket"
 	"github.com/boyd/pocket_agent/server/internal/websocket/handlers"
+	"github.com/boyd/pocket_agent/server/internal/workspace"
+	"github.com/boyd/pocket_agent/server/internal/worktree"
 )
 
 // Server represents the main application server that integrates all components
@@ -29,6 +46,13 @@ type Server struct {
 	projectManager *project.Manager
 	executor       *executor.ClaudeExecutor
 	validator      *validation.Validator
+	authService    *auth.Service
+	certAuth       *auth.CertificateAuthenticator // client certificates without authentication
+	auditLog       *audit.Log
+	certManager    *certs.Manager // nil when TLS is disabled
+	permissions    *permission.Broker
+	handlers       *handlers.Handlers
+	scheduler      *scheduler.Scheduler // nil when schedules are disabled
 
 	// Resource management
 	maxConnections int32
@@ -93,12 +117,64 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		return nil, fmt.Errorf("failed to create project manager: %w", err)
 	}
 
+	// Build the redaction pipeline for Claude output
+	redactor, err := redactionPipeline(cfg.Config.Redaction)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction config: %w", err)
+	}
+
+	// Relay tool permission prompts to clients
+	var permissions *permission.Broker
+	if cfg.Config.Permissions.Enabled {
+		permissions, err = permission.NewBroker(permission.Config{
+			Timeout: cfg.Config.Permissions.Timeout.Get(),
+			Default: permission.Decision(cfg.Config.Permissions.Default),
+		}, log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start permission broker: %w", err)
+		}
+	}
+
+	// Keep each project's environment variables and secrets
+	var projectEnv *env.Manager
+	if cfg.Config.Environment.Enabled {
+		projectEnv, err = env.NewManager(cfg.Config.DataDir, env.Config{
+			KeyFile:      cfg.Config.Environment.KeyFile,
+			Allowlist:    cfg.Config.Environment.Allowlist,
+			MaxVariables: cfg.Config.Environment.MaxVariables,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open project environments: %w", err)
+		}
+	}
+
+	// Record running processes to stop those a crash leaves behind
+	runningProcesses := recovery.NewStore(cfg.Config.DataDir)
+
 	// Create Claude executor
 	executorCfg := executor.Config{
 		ClaudePath:              cfg.Config.Execution.ClaudeBinaryPath,
 		DefaultTimeout:          cfg.Config.Execution.CommandTimeout.Get(),
+		MaxTimeout:              cfg.Config.Execution.MaxTimeout.Get(),
+		IdleTimeout:             cfg.Config.Execution.IdleTimeout.Get(),
+		StallTimeout:            cfg.Config.Execution.StallTimeout.Get(),
+		StallGrace:              cfg.Config.Execution.StallGrace.Get(),
 		MaxConcurrentExecutions: 10,
 		StorageFactory:          projectManager.GetStorageFactory(),
+		Policies:                policyProfiles(cfg.Config.Execution.Policies),
+		DefaultPolicy:           cfg.Config.Execution.DefaultPolicy,
+		Redactor:                redactor,
+		Permissions:             permissions,
+		Environment:             projectEnv,
+		SandboxCgroup:           cfg.Config.Execution.SandboxCgroup,
+		Recovery:                runningProcesses,
+	}
+	executorCfg.Backends, err = agentBackends(cfg.Config.Execution.Backends)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent backends: %w", err)
+	}
+	if cfg.Config.Execution.MaxPatchSize > 0 {
+		executorCfg.Workspace = workspace.NewCapturer(cfg.Config.Execution.MaxPatchSize)
 	}
 	claudeExecutor, err := executor.NewClaudeExecutor(executorCfg)
 	if err != nil {
@@ -113,6 +189,7 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		config:           cfg.Config,
 		logger:           log,
 		projectManager:   projectManager,
+		permissions:      permissions,
 		executor:         claudeExecutor,
 		validator:        validator,
 		maxConnections:   int32(cfg.MaxConnections),
@@ -126,6 +203,12 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		metricsCollector: metrics.NewCollector(),
 	}
 
+	// Origins were validated with the config; proxies are parsed here
+	trustedProxies, err := netutil.ParseCIDRs(cfg.Config.WebSocket.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
 	// Create WebSocket configuration
 	wsConfig := websocket.Config{
 		Port:                cfg.Config.Port,
@@ -136,28 +219,178 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		ConnectionTimeout:   5 * time.Minute,
 		PingInterval:        cfg.Config.WebSocket.PingInterval.Get(),
 		PongTimeout:         cfg.Config.WebSocket.PongTimeout.Get(),
-		AllowedOrigins:      []string{"*"}, // TODO: Configure from config
+		AllowedOrigins:      cfg.Config.WebSocket.AllowedOrigins,
+		TrustedProxies:      trustedProxies,
+		Subprotocols:        []string{auth.Subprotocol},
 		RateLimitPerIP:      60,
 		MaxMessageSize:      cfg.Config.WebSocket.MaxMessageSize,
 		BufferSize:          cfg.Config.WebSocket.WriteBufferSize,
 	}
 
 	// Only set TLS certificate paths if TLS is enabled
+	var revocations *auth.RevocationList
 	if cfg.Config.TLSEnabled {
 		wsConfig.TLSCert = cfg.Config.TLSCertFile
 		wsConfig.TLSKey = cfg.Config.TLSKeyFile
+
+		// Generate a self-signed certificate on first start
+		generated, err := certs.Ensure(cfg.Config.TLSCertFile, cfg.Config.TLSKeyFile, certs.DefaultHosts(cfg.Config.Host))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate TLS certificate: %w", err)
+		}
+		certManager, err := certs.NewManager(cfg.Config.TLSCertFile, cfg.Config.TLSKeyFile, log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		wsConfig.Certificates = certManager
+		s.certManager = certManager
+
+		status := certManager.Status()
+		if generated {
+			log.Info("Generated self-signed TLS certificate",
+				"cert", cfg.Config.TLSCertFile,
+				"key", cfg.Config.TLSKeyFile,
+			)
+		}
+		log.Info("TLS certificate fingerprint (SHA-256); clients should pin it",
+			"fingerprint", status.Fingerprint,
+			"not_after", status.NotAfter.Format(time.RFC3339),
+		)
+		if status.NextFingerprint != "" {
+			log.Info("TLS certificate rotation pending",
+				"next_fingerprint", status.NextFingerprint,
+				"rotates_at", status.RotatesAt.Format(time.RFC3339),
+			)
+		}
+
+		// Verify client certificates (mTLS) when a client CA is configured
+		wsConfig.ClientCAFile = cfg.Config.TLSClientCAFile
+		wsConfig.RequireClientCert = cfg.Config.TLSClientCertRequired
+		if cfg.Config.TLSClientRevocationFile != "" {
+			revocations, err = auth.NewRevocationList(cfg.Config.TLSClientRevocationFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate revocation list: %w", err)
+			}
+			wsConfig.VerifyClientCert = revocations.Check
+		}
+	}
+
+	// Open credential stores when authentication is enabled
+	if cfg.Config.Auth.Enabled {
+		authService, err := auth.NewService(cfg.Config.DataDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open credential stores: %w", err)
+		}
+		if revocations != nil {
+			authService.SetRevocationList(revocations)
+		}
+		s.authService = authService
+	}
+
+	// Without authentication, verified client certificates still identify
+	// their clients, so project roles and revocations apply to them
+	if !cfg.Config.Auth.Enabled && cfg.Config.TLSEnabled && cfg.Config.TLSClientCAFile != "" {
+		s.certAuth = auth.NewCertificateAuthenticator(revocations)
+	}
+
+	// Open the audit log of security-relevant actions
+	auditLog, err := audit.NewLog(cfg.Config.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	s.auditLog = auditLog
+
+	// Limit inbound messages and daily executions
+	var rateLimiter *websocket.MessageLimiter
+	var executionQuota *quota.Tracker
+	if limits := cfg.Config.RateLimits; limits.Enabled {
+		rateLimiter = websocket.NewMessageLimiter(messageLimits(limits))
+		if limits.DailyExecutions > 0 || len(limits.DailyExecutionOverrides) > 0 {
+			executionQuota, err = quota.NewTracker(cfg.Config.DataDir, quota.Config{
+				Name:      "daily_executions",
+				Daily:     limits.DailyExecutions,
+				Overrides: limits.DailyExecutionOverrides,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to open execution quotas: %w", err)
+			}
+		}
+	}
+
+	// Queue prompts sent while a project is executing
+	var executionQueue *queue.Manager
+	if cfg.Config.Execution.MaxQueueLength > 0 {
+		executionQueue = queue.NewManager(cfg.Config.DataDir, cfg.Config.Execution.MaxQueueLength)
+	}
+
+	// Record every execution
+	var executionHistory *history.Store
+	if cfg.Config.Execution.MaxRecords > 0 {
+		executionHistory = history.NewStore(cfg.Config.DataDir, cfg.Config.Execution.MaxRecords)
+	}
+
+	// Run executions in git worktrees on request
+	var worktrees *worktree.Manager
+	if cfg.Config.Execution.MaxWorktrees > 0 {
+		worktrees = worktree.NewManager(cfg.Config.DataDir, cfg.Config.Execution.MaxWorktrees)
+	}
+
+	// Catalogue the Claude sessions of each project
+	var sessionCatalogue *sessions.Manager
+	if cfg.Config.Execution.MaxSessions > 0 {
+		sessionCatalogue = sessions.NewManager(cfg.Config.DataDir, cfg.Config.Execution.MaxSessions)
+	}
+
+	// Account tokens and cost per project and identity
+	var usageLedger *usage.Ledger
+	if cfg.Config.Usage.Enabled {
+		budgets := cfg.Config.Usage.Budgets
+		usageLedger, err = usage.NewLedger(cfg.Config.DataDir, usage.Config{
+			RetentionDays: cfg.Config.Usage.RetentionDays,
+			Budgets: usage.Budgets{
+				ProjectDaily:    budgets.ProjectDailyUSD,
+				ProjectMonthly:  budgets.ProjectMonthlyUSD,
+				IdentityDaily:   budgets.IdentityDailyUSD,
+				IdentityMonthly: budgets.IdentityMonthlyUSD,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open usage ledger: %w", err)
+		}
 	}
 
 	// Create handlers with all dependencies
 	handlerCfg := handlers.Config{
-		ProjectManager:  projectManager,
-		Executor:        claudeExecutor,
-		Logger:          log,
-		BroadcastConfig: handlers.DefaultBroadcasterConfig(),
-		ClaudePath:      cfg.Config.Execution.ClaudeBinaryPath,
-		DataDir:         cfg.Config.DataDir,
+		ProjectManager:   projectManager,
+		Executor:         claudeExecutor,
+		Logger:           log,
+		BroadcastConfig:  handlers.DefaultBroadcasterConfig(),
+		ClaudePath:       cfg.Config.Execution.ClaudeBinaryPath,
+		DataDir:          cfg.Config.DataDir,
+		Auth:             s.authService,
+		Certificates:     s.certAuth,
+		Audit:            s.auditLog,
+		RateLimiter:      rateLimiter,
+		ExecutionQuota:   executionQuota,
+		ExecutionQueue:   executionQueue,
+		ExecutionHistory: executionHistory,
+		Permissions:      permissions,
+		Usage:            usageLedger,
+		UsageMetrics:     s,
+		MaxSchedules:     cfg.Config.Execution.MaxSchedules,
+		Environment:      projectEnv,
+		Worktrees:        worktrees,
+		Sessions:         sessionCatalogue,
+		Recovery:         runningProcesses,
 	}
 	handler := handlers.NewHandlers(handlerCfg, s)
+	s.handlers = handler
+
+	// Run scheduled prompts through the execution handlers so they queue,
+	// record and broadcast like any other run
+	if cfg.Config.Execution.MaxSchedules > 0 {
+		s.scheduler = scheduler.New(projectManager, handler.Schedule, log)
+	}
 
 	// Create WebSocket server
 	s.wsServer = websocket.NewServer(wsConfig, handler, log)
@@ -165,6 +398,13 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 	// Set server as metrics provider for WebSocket server
 	s.wsServer.SetMetricsProvider(s)
 
+	// Require credentials on the WebSocket upgrade
+	if s.authService != nil {
+		s.wsServer.SetAuthenticator(s.authService)
+	} else if s.certAuth != nil {
+		s.wsServer.SetAuthenticator(s.certAuth)
+	}
+
 	// Wire up metrics collection from WebSocket server
 	go s.collectWebSocketMetrics()
 
@@ -196,6 +436,8 @@ func (s *Server) Start() error {
 		"tls_enabled", s.config.TLSEnabled,
 		"tls_cert", s.config.TLSCertFile,
 		"tls_key", s.config.TLSKeyFile,
+		"tls_client_ca", s.config.TLSClientCAFile,
+		"tls_client_cert_required", s.config.TLSClientCertRequired,
 	)
 
 	// Log WebSocket configuration
@@ -207,11 +449,18 @@ func (s *Server) Start() error {
 		"max_message_size", s.config.WebSocket.MaxMessageSize,
 		"buffer_size", s.config.WebSocket.WriteBufferSize,
 	)
+	for _, origin := range s.config.WebSocket.AllowedOrigins {
+		if origin == "*" {
+			s.logger.Warn("allowed_origins contains \"*\"; web pages on any site can connect to the server from a user's browser")
+			break
+		}
+	}
 
 	// Log execution configuration
 	s.logger.Info("Execution configuration",
 		"claude_binary", s.config.Execution.ClaudeBinaryPath,
 		"command_timeout", s.config.Execution.CommandTimeout.Get().String(),
+		"max_timeout", s.config.Execution.MaxTimeout.Get().String(),
 		"max_projects", s.config.Execution.MaxProjects,
 		"max_log_size", s.config.Execution.MaxLogSize,
 		"max_messages_per_log", s.config.Execution.MaxMessagesPerLog,
@@ -232,6 +481,24 @@ func (s *Server) Start() error {
 		"log_level", s.config.LogLevel,
 	)
 
+	// Log authentication configuration
+	if s.authService != nil {
+		activeTokens := s.authService.Tokens().ActiveCount()
+		activeDevices := s.authService.Devices().ActiveCount()
+		s.logger.Info("Authentication configuration",
+			"enabled", true,
+			"active_tokens", activeTokens,
+			"active_devices", activeDevices,
+		)
+		if activeTokens == 0 && activeDevices == 0 && s.config.TLSClientCAFile == "" {
+			s.logger.Warn("No access tokens or paired devices; all clients will be rejected. Run 'pocket-agent-server pair' or 'pocket-agent-server token issue -name <name>'")
+		}
+	} else if s.certAuth != nil && s.config.TLSClientCertRequired {
+		s.logger.Info("Authentication is disabled; clients are identified by their client certificates")
+	} else {
+		s.logger.Warn("Authentication is disabled; any client that can reach the server can execute commands")
+	}
+
 	// Start resource monitoring
 	s.wg.Add(1)
 	go s.monitorResources()
@@ -240,6 +507,23 @@ func (s *Server) Start() error {
 	s.wg.Add(1)
 	go s.collectMetrics()
 
+	// Stop the processes and close the records of executions the last run
+	// left behind and remove its stale worktrees, then resume prompts
+	// queued before the last shutdown
+	s.handlers.Execution.InterruptExecutions()
+	s.handlers.Worktree.CleanupWorktrees()
+	s.handlers.Execution.DrainQueues()
+
+	// Activate staged certificate rotations and reload changed certificates
+	if s.certManager != nil {
+		s.certManager.Start()
+	}
+
+	// Start running scheduled prompts
+	if s.scheduler != nil {
+		s.scheduler.Start()
+	}
+
 	// Start WebSocket server
 	errChan := make(chan error, 1)
 	go func() {
@@ -285,6 +569,16 @@ func (s *Server) Shutdown() error {
 		// Stop resource ticker
 		s.resourceTicker.Stop()
 
+		// Start no more scheduled runs
+		if s.scheduler != nil {
+			s.scheduler.Stop()
+		}
+
+		// Stop checking the certificate files
+		if s.certManager != nil {
+			s.certManager.Stop()
+		}
+
 		// Create shutdown context with timeout
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer shutdownCancel()
@@ -303,6 +597,18 @@ func (s *Server) Shutdown() error {
 			}
 		}
 
+		// Stop answering permission prompts once executions have ended
+		if s.permissions != nil {
+			if err := s.permissions.Close(); err != nil {
+				s.logger.Error("Failed to stop permission broker", "error", err)
+			}
+		}
+
+		// Close the audit log once no more handlers run
+		if err := s.auditLog.Close(); err != nil {
+			s.logger.Error("Failed to close audit log", "error", err)
+		}
+
 		// Save all project metadata
 		projects := s.projectManager.GetAllProjects()
 		for _, proj := range projects {
@@ -484,6 +790,7 @@ func (s *Server) publishMetrics() {
 			"execution_p99_ms":      snapshot.Performance.ExecutionDurations.P99.Milliseconds(),
 			"message_throughput_ps": snapshot.Performance.MessageThroughput,
 		},
+		"usage": snapshot.Usage,
 	}
 
 	s.logger.Debug("Server metrics", "data", metrics)
@@ -500,6 +807,7 @@ func (s *Server) logFinalMetrics() {
 		"total_messages", snapshot.Counters.TotalMessages,
 		"total_connections", snapshot.Counters.TotalConnections,
 		"total_errors", snapshot.Counters.TotalErrors,
+		"total_cost_usd", snapshot.Usage.CostUSD,
 		"final_projects", s.projectManager.GetProjectCount(),
 	)
 }
@@ -541,6 +849,11 @@ func (s *Server) RecordExecutionDuration(projectID string, duration time.Duratio
 	s.metricsCollector.RecordExecutionDuration(duration)
 }
 
+// RecordUsage records the tokens and cost of a finished execution
+func (s *Server) RecordUsage(projectID string, usage models.Usage) {
+	s.metricsCollector.RecordUsage(usage)
+}
+
 // GetMetrics returns comprehensive server metrics
 func (s *Server) GetMetrics() map[string]interface{} {
 	snapshot := s.metricsCollector.GetSnapshot()
@@ -582,6 +895,7 @@ func (s *Server) GetMetrics() map[string]interface{} {
 			"execution_p99_ms":      snapshot.Performance.ExecutionDurations.P99.Milliseconds(),
 			"message_throughput_ps": snapshot.Performance.MessageThroughput,
 		},
+		"usage":     snapshot.Usage,
 		"websocket": wsMetrics,
 		"projects": map[string]interface{}{
 			"total": s.projectManager.GetProjectCount(),
@@ -668,3 +982,90 @@ func (s *Server) reloadConfiguration() {
 	// For now, just log that it was requested
 	s.logger.Info("Configuration reload completed (no-op)")
 }
+
+// messageLimits converts the configured rate limits for the WebSocket layer
+func messageLimits(limits config.RateLimitConfig) websocket.MessageLimiterConfig {
+	convert := func(limit config.MessageRateLimit) websocket.MessageLimit {
+		return websocket.MessageLimit{
+			Session:  websocket.MessageRate{PerMinute: limit.Session.PerMinute, Burst: limit.Session.Burst},
+			Identity: websocket.MessageRate{PerMinute: limit.Identity.PerMinute, Burst: limit.Identity.Burst},
+		}
+	}
+
+	converted := websocket.MessageLimiterConfig{
+		Default: convert(limits.Default),
+		Types:   make(map[models.MessageType]websocket.MessageLimit, len(limits.Messages)),
+	}
+	for msgType, limit := range limits.Messages {
+		converted.Types[models.MessageType(msgType)] = convert(limit)
+	}
+	return converted
+}
+
+// redactionPipeline builds the redaction pipeline from the built-in and
+// configured detectors; nil when redaction is disabled
+func redactionPipeline(cfg config.RedactionConfig) (*redact.Pipeline, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	detectors := redact.Builtin(cfg.DisabledRules...)
+	for _, p := range cfg.Patterns {
+		d, err := redact.NewRegexDetector(p.Name, p.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, d)
+	}
+	return redact.NewPipeline(detectors...), nil
+}
+
+// policyProfiles converts configured policy profiles for the executor
+func policyProfiles(profiles map[string]config.PolicyProfile) map[string]executor.PolicyProfile {
+	converted := make(map[string]executor.PolicyProfile, len(profiles))
+	for name, p := range profiles {
+		profile := executor.PolicyProfile{
+			Mode:                 executor.PolicyMode(p.Mode),
+			AllowedTools:         p.AllowedTools,
+			DeniedTools:          p.DeniedTools,
+			AllowedModels:        p.AllowedModels,
+			AllowSkipPermissions: p.AllowSkipPermissions,
+			AllowMCPConfig:       p.AllowMCPConfig,
+			AddDirRoots:          p.AddDirRoots,
+			MaxTimeoutSeconds:    int(p.MaxTimeout.Get() / time.Second),
+		}
+		if s := p.Sandbox; s != nil {
+			profile.Sandbox = &executor.SandboxProfile{
+				IsolateNetwork: s.IsolateNetwork,
+				WritablePaths:  s.WritablePaths,
+				MemoryMB:       s.MemoryMB,
+				CPUs:           s.CPUs,
+				MaxPids:        s.MaxPids,
+			}
+		}
+		converted[name] = profile
+	}
+	return converted
+}
+
+// agentBackends creates the configured agent backends for the executor
+func agentBackends(backends map[string]config.BackendConfig) (map[string]executor.AgentBackend, error) {
+	created := make(map[string]executor.AgentBackend, len(backends))
+	for name, b := range backends {
+		backend, err := executor.NewJSONLBackend(executor.JSONLConfig{
+			Command:        b.Command,
+			Args:           b.Args,
+			ResumeArgs:     b.ResumeArgs,
+			ModelArgs:      b.ModelArgs,
+			TypeField:      b.TypeField,
+			SessionIDField: b.SessionIDField,
+			ResultTypes:    b.ResultTypes,
+			ErrorTypes:     b.ErrorTypes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", name, err)
+		}
+		created[name] = backend
+	}
+	return created, nil
+}
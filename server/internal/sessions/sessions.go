@@ -0,0 +1,377 @@
+// Package sessions keeps a catalogue of the Claude sessions of each project.
+// Every session the project's executions produced is listed with a title,
+// the prompt that started it and the part of the message log it wrote, so
+// that clients can return to an earlier conversation or fork one into a
+// parallel conversation. The catalogue of each project is a file in its
+// data directory.
+package sessions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+const (
+	// FileName is the name of the catalogue in a project's data directory
+	FileName = "sessions.json"
+	// MaxTitleLength caps the titles generated from first prompts, in runes
+	MaxTitleLength = 80
+	// MaxPromptLength caps the first prompts kept, in runes
+	MaxPromptLength = 2000
+)
+
+// Session is a Claude session of a project
+type Session struct {
+	// ID is the session ID Claude reported
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	FirstPrompt string `json:"first_prompt"`
+	// ForkedFrom is the session this one was forked from, if any
+	ForkedFrom string    `json:"forked_from,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// Messages spans the messages the session's executions logged
+	Messages models.MessageRange `json:"messages"`
+}
+
+// Fork is a fork requested for a project's next execution, which continues
+// From in a new session
+type Fork struct {
+	From        string    `json:"from"`
+	Title       string    `json:"title,omitempty"`
+	RequestedBy string    `json:"requested_by,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// Use describes an execution that used a session
+type Use struct {
+	// Prompt is the execution's prompt, which becomes the first prompt of
+	// a new session
+	Prompt string
+	By     string
+	// Messages is the range the execution logged
+	Messages models.MessageRange
+	// ForkedFrom is set when the execution forked the session from another
+	ForkedFrom string
+	// Title names a new session; empty derives it from Prompt
+	Title string
+}
+
+// catalogue is the persisted state of one project
+type catalogue struct {
+	Sessions []Session `json:"sessions"`
+	Fork     *Fork     `json:"fork,omitempty"`
+}
+
+// Manager owns the session catalogues of all projects
+type Manager struct {
+	dataDir     string
+	maxSessions int
+
+	mu         sync.Mutex
+	catalogues map[string]*catalogue
+	now        func() time.Time
+}
+
+// NewManager creates a manager persisting catalogues under dataDir.
+// maxSessions caps the sessions listed per project, dropping the least
+// recently used first; 0 is unlimited.
+func NewManager(dataDir string, maxSessions int) *Manager {
+	return &Manager{
+		dataDir:     dataDir,
+		maxSessions: maxSessions,
+		catalogues:  make(map[string]*catalogue),
+		now:         time.Now,
+	}
+}
+
+// Use records that an execution used a session, adding the session to the
+// catalogue if it is new. A new session forked from the pending fork's
+// source completes the fork.
+func (m *Manager) Use(projectID, sessionID string, use Use) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cat, err := m.load(projectID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := m.now().UTC()
+	index := indexOf(cat.Sessions, sessionID)
+	if index < 0 {
+		title := use.Title
+		if title == "" {
+			title = titleOf(use.Prompt)
+		}
+		cat.Sessions = append(cat.Sessions, Session{
+			ID:          sessionID,
+			Title:       title,
+			FirstPrompt: truncate(use.Prompt, MaxPromptLength),
+			ForkedFrom:  use.ForkedFrom,
+			CreatedBy:   use.By,
+			CreatedAt:   now,
+		})
+		index = len(cat.Sessions) - 1
+		if use.ForkedFrom != "" && cat.Fork != nil && cat.Fork.From == use.ForkedFrom {
+			cat.Fork = nil
+		}
+	}
+
+	session := &cat.Sessions[index]
+	session.LastUsedAt = now
+	session.Messages = merge(session.Messages, use.Messages)
+	used := *session
+
+	m.prune(cat)
+	if err := m.save(projectID, cat); err != nil {
+		return Session{}, err
+	}
+	return used, nil
+}
+
+// Get returns a session of a project
+func (m *Manager) Get(projectID, sessionID string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cat, err := m.load(projectID)
+	if err != nil {
+		return Session{}, err
+	}
+	index := indexOf(cat.Sessions, sessionID)
+	if index < 0 {
+		return Session{}, notFound(projectID, sessionID)
+	}
+	return cat.Sessions[index], nil
+}
+
+// List returns a project's sessions, most recently used first, and its
+// pending fork
+func (m *Manager) List(projectID string) ([]Session, *Fork, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cat, err := m.load(projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessions := append([]Session{}, cat.Sessions...)
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, copyFork(cat.Fork), nil
+}
+
+// RequestFork makes the project's next execution fork the session from
+// into a new session, replacing any fork requested before
+func (m *Manager) RequestFork(projectID, from, title, requestedBy string) (Fork, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cat, err := m.load(projectID)
+	if err != nil {
+		return Fork{}, err
+	}
+	if indexOf(cat.Sessions, from) < 0 {
+		return Fork{}, notFound(projectID, from)
+	}
+
+	fork := Fork{
+		From:        from,
+		Title:       strings.TrimSpace(title),
+		RequestedBy: requestedBy,
+		RequestedAt: m.now().UTC(),
+	}
+	cat.Fork = &fork
+	if err := m.save(projectID, cat); err != nil {
+		return Fork{}, err
+	}
+	return fork, nil
+}
+
+// PendingFork returns the project's requested fork, or nil
+func (m *Manager) PendingFork(projectID string) (*Fork, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cat, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return copyFork(cat.Fork), nil
+}
+
+// CancelFork drops the project's requested fork, if any
+func (m *Manager) CancelFork(projectID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cat, err := m.load(projectID)
+	if err != nil || cat.Fork == nil {
+		return err
+	}
+	cat.Fork = nil
+	return m.save(projectID, cat)
+}
+
+// Forget drops the cached catalogue of a deleted project. The catalogue
+// file is removed with the project's data directory.
+func (m *Manager) Forget(projectID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.catalogues, projectID)
+}
+
+// prune drops the least recently used sessions beyond maxSessions, except
+// the source of a pending fork. Callers hold m.mu.
+func (m *Manager) prune(cat *catalogue) {
+	if m.maxSessions <= 0 || len(cat.Sessions) <= m.maxSessions {
+		return
+	}
+
+	keep := make(map[string]bool)
+	if cat.Fork != nil && indexOf(cat.Sessions, cat.Fork.From) >= 0 {
+		keep[cat.Fork.From] = true
+	}
+	byUse := append([]Session{}, cat.Sessions...)
+	sort.SliceStable(byUse, func(i, j int) bool {
+		return byUse[i].LastUsedAt.After(byUse[j].LastUsedAt)
+	})
+	for _, session := range byUse {
+		if len(keep) >= m.maxSessions {
+			break
+		}
+		keep[session.ID] = true
+	}
+
+	kept := cat.Sessions[:0]
+	for _, session := range cat.Sessions {
+		if keep[session.ID] {
+			kept = append(kept, session)
+		}
+	}
+	cat.Sessions = kept
+}
+
+// path returns the catalogue file of a project
+func (m *Manager) path(projectID string) string {
+	return filepath.Join(m.dataDir, storage.ProjectsDirName, projectID, FileName)
+}
+
+// load returns the project's catalogue, reading it from disk on first use.
+// Callers hold m.mu.
+func (m *Manager) load(projectID string) (*catalogue, error) {
+	if cat, ok := m.catalogues[projectID]; ok {
+		return cat, nil
+	}
+
+	cat := &catalogue{}
+	data, err := os.ReadFile(m.path(projectID))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.NewFileOperationError("read session catalogue", err)
+	default:
+		if err := json.Unmarshal(data, cat); err != nil {
+			return nil, errors.NewJSONParsingError(err)
+		}
+	}
+
+	m.catalogues[projectID] = cat
+	return cat, nil
+}
+
+// save persists the project's catalogue. Callers hold m.mu.
+func (m *Manager) save(projectID string, cat *catalogue) error {
+	if cat.Sessions == nil {
+		cat.Sessions = []Session{}
+	}
+
+	data, err := json.MarshalIndent(cat, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+
+	path := m.path(projectID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.NewFileOperationError("create session catalogue directory", err)
+	}
+	if err := storage.WriteFileAtomic(path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write session catalogue", err)
+	}
+
+	m.catalogues[projectID] = cat
+	return nil
+}
+
+// merge extends a message range by another
+func merge(r, other models.MessageRange) models.MessageRange {
+	if other.Count == 0 {
+		return r
+	}
+	if r.Count == 0 {
+		return other
+	}
+	if other.From.Before(r.From) {
+		r.From = other.From
+	}
+	if other.To.After(r.To) {
+		r.To = other.To
+	}
+	r.Count += other.Count
+	return r
+}
+
+// titleOf derives a title from the first line of a prompt
+func titleOf(prompt string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	return truncate(strings.TrimSpace(line), MaxTitleLength)
+}
+
+// truncate cuts s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// copyFork returns a copy of fork, so callers cannot change the catalogue
+func copyFork(fork *Fork) *Fork {
+	if fork == nil {
+		return nil
+	}
+	copied := *fork
+	return &copied
+}
+
+// indexOf returns the index of the session with id, or -1
+func indexOf(sessions []Session, id string) int {
+	for i, session := range sessions {
+		if session.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// notFound reports an unknown session
+func notFound(projectID, sessionID string) *errors.AppError {
+	return errors.New(errors.CodeSessionNotFound, "session not found").
+		WithDetail("project_id", projectID).
+		WithDetail("session_id", sessionID)
+}
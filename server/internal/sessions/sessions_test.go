@@ -0,0 +1,143 @@
+package sessions
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock makes m's time advance a second per call
+func clock(m *Manager) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func ids(sessions []Session) []string {
+	out := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		out = append(out, session.ID)
+	}
+	return out
+}
+
+func TestManager_Use(t *testing.T) {
+	m := NewManager(t.TempDir(), 0)
+	clock(m)
+	from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	session, err := m.Use("p1", "s1", Use{
+		Prompt:   "  Fix the login bug\nIt fails on empty passwords",
+		By:       "device:phone",
+		Messages: models.MessageRange{From: from, To: from.Add(time.Minute), Count: 4},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Fix the login bug", session.Title)
+	assert.Equal(t, "  Fix the login bug\nIt fails on empty passwords", session.FirstPrompt)
+	assert.Equal(t, "device:phone", session.CreatedBy)
+	assert.Equal(t, 4, session.Messages.Count)
+
+	// Resuming extends the range but keeps the first prompt
+	session, err = m.Use("p1", "s1", Use{
+		Prompt:   "now add a test",
+		By:       "device:laptop",
+		Messages: models.MessageRange{From: from.Add(time.Hour), To: from.Add(2 * time.Hour), Count: 2},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Fix the login bug", session.Title)
+	assert.Equal(t, "device:phone", session.CreatedBy)
+	assert.Equal(t, models.MessageRange{From: from, To: from.Add(2 * time.Hour), Count: 6}, session.Messages)
+	assert.True(t, session.LastUsedAt.After(session.CreatedAt))
+
+	_, err = m.Use("p1", "s2", Use{Prompt: strings.Repeat("x", 200)})
+	require.NoError(t, err)
+
+	sessions, fork, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Nil(t, fork)
+	assert.Equal(t, []string{"s2", "s1"}, ids(sessions))
+	assert.Len(t, []rune(sessions[0].Title), MaxTitleLength)
+	assert.True(t, strings.HasSuffix(sessions[0].Title, "…"))
+
+	// Catalogues are per project
+	sessions, _, err = m.List("p2")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	_, err = m.Get("p2", "s1")
+	assert.True(t, errors.IsCode(err, errors.CodeSessionNotFound))
+}
+
+func TestManager_Fork(t *testing.T) {
+	dataDir := t.TempDir()
+	m := NewManager(dataDir, 0)
+	clock(m)
+
+	_, err := m.RequestFork("p1", "missing", "", "device:phone")
+	assert.True(t, errors.IsCode(err, errors.CodeSessionNotFound))
+
+	_, err = m.Use("p1", "s1", Use{Prompt: "build the parser"})
+	require.NoError(t, err)
+	fork, err := m.RequestFork("p1", "s1", " Try a PEG grammar ", "device:phone")
+	require.NoError(t, err)
+	assert.Equal(t, "s1", fork.From)
+	assert.Equal(t, "Try a PEG grammar", fork.Title)
+
+	// The pending fork survives a restart
+	m = NewManager(dataDir, 0)
+	clock(m)
+	pending, err := m.PendingFork("p1")
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	assert.Equal(t, fork, *pending)
+
+	// The forked session completes the fork
+	session, err := m.Use("p1", "s2", Use{Prompt: "use PEG", ForkedFrom: "s1", Title: pending.Title})
+	require.NoError(t, err)
+	assert.Equal(t, "Try a PEG grammar", session.Title)
+	assert.Equal(t, "s1", session.ForkedFrom)
+
+	pending, err = m.PendingFork("p1")
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+
+	_, err = m.RequestFork("p1", "s2", "", "")
+	require.NoError(t, err)
+	require.NoError(t, m.CancelFork("p1"))
+	_, fork2, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Nil(t, fork2)
+}
+
+func TestManager_Prune(t *testing.T) {
+	m := NewManager(t.TempDir(), 2)
+	clock(m)
+
+	for _, id := range []string{"s1", "s2"} {
+		_, err := m.Use("p1", id, Use{Prompt: id})
+		require.NoError(t, err)
+	}
+	// The source of a pending fork is kept even when least recently used
+	_, err := m.RequestFork("p1", "s1", "", "")
+	require.NoError(t, err)
+	_, err = m.Use("p1", "s3", Use{Prompt: "s3"})
+	require.NoError(t, err)
+
+	sessions, _, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s3", "s1"}, ids(sessions))
+
+	require.NoError(t, m.CancelFork("p1"))
+	_, err = m.Use("p1", "s4", Use{Prompt: "s4"})
+	require.NoError(t, err)
+	sessions, _, err = m.List("p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s4", "s3"}, ids(sessions))
+}
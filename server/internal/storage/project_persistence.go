@@ -63,7 +63,7 @@ func (pp *ProjectPersistence) SaveProjectMetadata(project *models.Project) error
 
 	// Write atomically using temp file + rename
 	metadataPath := filepath.Join(projectDir, MetadataFileName)
-	if err := writeFileAtomic(metadataPath, data, 0o644); err != nil {
+	if err := WriteFileAtomic(metadataPath, data, 0o644); err != nil {
 		return fmt.Errorf("failed to write metadata file: %w", err)
 	}
 
@@ -173,8 +173,8 @@ func (pp *ProjectPersistence) GetProjectDirectory(projectID string) string {
 	return filepath.Join(pp.dataDir, projectID)
 }
 
-// writeFileAtomic writes data to a file atomically using rename
-func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+// WriteFileAtomic writes data to a file atomically using rename
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
 	// Create temp file in same directory
 	dir := filepath.Dir(path)
 	tempFile, err := os.CreateTemp(dir, ".tmp-*")
@@ -247,7 +247,7 @@ func TestCorruptionRecovery(t *testing.T) {
 			t.Fatalf("Failed to create project dir: %v", err)
 		}
 
-		// Create a valid temp file with the naming pattern expected by writeFileAtomic
+		// Create a valid temp file with the naming pattern expected by WriteFileAtomic
 		metadata := project.ToMetadata()
 		data, _ := json.Marshal(metadata)
 		tempPath := filepath.Join(projectDir, ".tmp-123456") // Match the pattern from CreateTemp
@@ -0,0 +1,296 @@
+// Package usage accounts the tokens and cost of executions per project,
+// identity and UTC day, and enforces spending budgets. The ledger is
+// persisted under the data directory so restarting the server does not
+// reset it.
+package usage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+)
+
+const (
+	// DirName is the name of the usage directory under the data directory
+	DirName = "usage"
+
+	// dayFormat identifies a UTC day
+	dayFormat = "2006-01-02"
+)
+
+// Budget names
+const (
+	BudgetProjectDaily    = "project_daily"
+	BudgetProjectMonthly  = "project_monthly"
+	BudgetIdentityDaily   = "identity_daily"
+	BudgetIdentityMonthly = "identity_monthly"
+)
+
+// Config configures a Ledger
+type Config struct {
+	// RetentionDays is the number of UTC days whose usage is kept
+	RetentionDays int
+	// Budgets caps spending in USD; 0 is unlimited
+	Budgets Budgets
+}
+
+// Budgets caps the cost, in USD, of the executions of each project and of
+// each identity per UTC day and month. 0 is unlimited.
+type Budgets struct {
+	ProjectDaily    float64
+	ProjectMonthly  float64
+	IdentityDaily   float64
+	IdentityMonthly float64
+}
+
+// day is the usage of one UTC day
+type day struct {
+	Projects   map[string]models.Usage `json:"projects,omitempty"`
+	Identities map[string]models.Usage `json:"identities,omitempty"`
+}
+
+// ledger is the persisted state of a Ledger
+type ledger struct {
+	// Days holds the usage of each day by date
+	Days map[string]*day `json:"days"`
+}
+
+// DayUsage is the usage of one UTC day
+type DayUsage struct {
+	Date string `json:"date"`
+	models.Usage
+}
+
+// Series is the usage of consecutive days, oldest first
+type Series struct {
+	Total models.Usage `json:"total"`
+	Days  []DayUsage   `json:"days"`
+}
+
+// BudgetStatus is the spending against a budget in its current period
+type BudgetStatus struct {
+	Budget   string    `json:"budget"`
+	LimitUSD float64   `json:"limit_usd"`
+	SpentUSD float64   `json:"spent_usd"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// Exceeded reports whether the budget is used up
+func (b BudgetStatus) Exceeded() bool {
+	return b.SpentUSD >= b.LimitUSD
+}
+
+// Ledger accounts usage per project, identity and UTC day
+type Ledger struct {
+	config Config
+	path   string
+
+	mu     sync.Mutex
+	ledger ledger
+	now    func() time.Time
+}
+
+// NewLedger opens the usage ledger under dataDir
+func NewLedger(dataDir string, config Config) (*Ledger, error) {
+	dir := filepath.Join(dataDir, DirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.NewFileOperationError("create usage directory", err)
+	}
+
+	l := &Ledger{
+		config: config,
+		path:   filepath.Join(dir, "ledger.json"),
+		ledger: ledger{Days: make(map[string]*day)},
+		now:    time.Now,
+	}
+
+	data, err := os.ReadFile(l.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.NewFileOperationError("read usage ledger", err)
+	default:
+		if err := json.Unmarshal(data, &l.ledger); err != nil {
+			return nil, errors.NewJSONParsingError(err)
+		}
+		if l.ledger.Days == nil {
+			l.ledger.Days = make(map[string]*day)
+		}
+	}
+
+	return l, nil
+}
+
+// Record adds the usage of an execution of projectID started by principal
+// to the current day
+func (l *Ledger) Record(projectID, principal string, u models.Usage) error {
+	if u.IsZero() {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := l.today().Format(dayFormat)
+	d := l.ledger.Days[key]
+	if d == nil {
+		d = &day{}
+		l.ledger.Days[key] = d
+	}
+
+	d.Projects = addTo(d.Projects, projectID, u)
+	d.Identities = addTo(d.Identities, principal, u)
+
+	l.prune()
+	return l.save()
+}
+
+// Project returns the usage of a project over the last days days
+func (l *Ledger) Project(projectID string, days int) Series {
+	return l.series(days, func(d *day) models.Usage { return d.Projects[projectID] })
+}
+
+// Identity returns the usage of a principal over the last days days
+func (l *Ledger) Identity(principal string, days int) Series {
+	return l.series(days, func(d *day) models.Usage { return d.Identities[principal] })
+}
+
+// Budgets returns the spending against the configured budgets that apply
+// to an execution of projectID by principal. Without a project only the
+// identity budgets are returned.
+func (l *Ledger) Budgets(projectID, principal string) []BudgetStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	today := l.today()
+	tomorrow := today.AddDate(0, 0, 1)
+	nextMonth := time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	project := func(d *day) models.Usage { return d.Projects[projectID] }
+	identity := func(d *day) models.Usage { return d.Identities[principal] }
+
+	var statuses []BudgetStatus
+	add := func(name string, limit float64, pick func(*day) models.Usage, from, resets time.Time) {
+		if limit <= 0 {
+			return
+		}
+		statuses = append(statuses, BudgetStatus{
+			Budget:   name,
+			LimitUSD: limit,
+			SpentUSD: l.cost(from, pick),
+			ResetsAt: resets,
+		})
+	}
+
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if projectID != "" {
+		add(BudgetProjectDaily, l.config.Budgets.ProjectDaily, project, today, tomorrow)
+		add(BudgetProjectMonthly, l.config.Budgets.ProjectMonthly, project, month, nextMonth)
+	}
+	add(BudgetIdentityDaily, l.config.Budgets.IdentityDaily, identity, today, tomorrow)
+	add(BudgetIdentityMonthly, l.config.Budgets.IdentityMonthly, identity, month, nextMonth)
+	return statuses
+}
+
+// Check fails with BUDGET_EXCEEDED when a budget that applies to an
+// execution of projectID by principal is used up
+func (l *Ledger) Check(projectID, principal string) error {
+	for _, status := range l.Budgets(projectID, principal) {
+		if status.Exceeded() {
+			return errors.NewBudgetExceededError(status.Budget, status.LimitUSD, status.SpentUSD,
+				status.ResetsAt.Sub(l.now()))
+		}
+	}
+	return nil
+}
+
+// series collects the usage of the last days days selected by pick, at
+// most the retained days
+func (l *Ledger) series(days int, pick func(*day) models.Usage) Series {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if days <= 0 {
+		days = 1
+	}
+	if l.config.RetentionDays > 0 && days > l.config.RetentionDays {
+		days = l.config.RetentionDays
+	}
+
+	today := l.today()
+	series := Series{Days: make([]DayUsage, 0, days)}
+	for i := days - 1; i >= 0; i-- {
+		date := today.AddDate(0, 0, -i).Format(dayFormat)
+		entry := DayUsage{Date: date}
+		if d := l.ledger.Days[date]; d != nil {
+			entry.Usage = pick(d)
+		}
+		series.Total.Add(entry.Usage)
+		series.Days = append(series.Days, entry)
+	}
+	return series
+}
+
+// cost returns the cost selected by pick of the days since from. Callers
+// hold l.mu.
+func (l *Ledger) cost(from time.Time, pick func(*day) models.Usage) float64 {
+	since := from.Format(dayFormat)
+
+	var cost float64
+	for key, d := range l.ledger.Days {
+		// Dates in dayFormat sort chronologically
+		if key >= since {
+			cost += pick(d).CostUSD
+		}
+	}
+	return cost
+}
+
+// today returns the start of the current UTC day
+func (l *Ledger) today() time.Time {
+	now := l.now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// prune drops days older than the retention. Callers hold l.mu.
+func (l *Ledger) prune() {
+	if l.config.RetentionDays <= 0 {
+		return
+	}
+
+	cutoff := l.today().AddDate(0, 0, 1-l.config.RetentionDays).Format(dayFormat)
+	for key := range l.ledger.Days {
+		// Dates in dayFormat sort chronologically
+		if key < cutoff {
+			delete(l.ledger.Days, key)
+		}
+	}
+}
+
+// save persists the ledger. Callers hold l.mu.
+func (l *Ledger) save() error {
+	data, err := json.MarshalIndent(l.ledger, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+	if err := storage.WriteFileAtomic(l.path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write usage ledger", err)
+	}
+	return nil
+}
+
+// addTo adds u to the entry of key, creating the map as needed
+func addTo(m map[string]models.Usage, key string, u models.Usage) map[string]models.Usage {
+	if m == nil {
+		m = make(map[string]models.Usage)
+	}
+	total := m[key]
+	total.Add(u)
+	m[key] = total
+	return m
+}
@@ -0,0 +1,109 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLedger(t *testing.T, dataDir string, config Config, now time.Time) *Ledger {
+	l, err := NewLedger(dataDir, config)
+	require.NoError(t, err)
+	l.now = func() time.Time { return now }
+	return l
+}
+
+func TestRecordAndSeries(t *testing.T) {
+	dataDir := t.TempDir()
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+	l := newTestLedger(t, dataDir, Config{RetentionDays: 30}, now)
+
+	require.NoError(t, l.Record("p1", "token:tok-1", models.Usage{InputTokens: 100, OutputTokens: 50, CostUSD: 0.5}))
+	require.NoError(t, l.Record("p1", "device:dev-1", models.Usage{InputTokens: 10, CostUSD: 0.25}))
+	require.NoError(t, l.Record("p2", "token:tok-1", models.Usage{OutputTokens: 5, CostUSD: 1}))
+
+	// Zero usage is not recorded
+	require.NoError(t, l.Record("p3", "token:tok-1", models.Usage{}))
+
+	project := l.Project("p1", 3)
+	require.Len(t, project.Days, 3)
+	assert.Equal(t, "2024-03-08", project.Days[0].Date)
+	assert.Equal(t, "2024-03-10", project.Days[2].Date)
+	assert.True(t, project.Days[0].Usage.IsZero())
+	assert.Equal(t, int64(110), project.Days[2].InputTokens)
+	assert.InDelta(t, 0.75, project.Total.CostUSD, 1e-9)
+
+	identity := l.Identity("token:tok-1", 1)
+	require.Len(t, identity.Days, 1)
+	assert.Equal(t, int64(55), identity.Total.OutputTokens)
+	assert.InDelta(t, 1.5, identity.Total.CostUSD, 1e-9)
+
+	// The ledger survives a restart
+	l = newTestLedger(t, dataDir, Config{RetentionDays: 30}, now)
+	assert.Equal(t, int64(110), l.Project("p1", 1).Total.InputTokens)
+
+	// Reports cover at most the retained days
+	assert.Len(t, l.Project("p1", 365).Days, 30)
+}
+
+func TestRetention(t *testing.T) {
+	dataDir := t.TempDir()
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+	l := newTestLedger(t, dataDir, Config{RetentionDays: 2}, now)
+	require.NoError(t, l.Record("p1", "token:tok-1", models.Usage{CostUSD: 1}))
+
+	// Two days later the first day is dropped on the next record
+	l.now = func() time.Time { return now.AddDate(0, 0, 2) }
+	require.NoError(t, l.Record("p1", "token:tok-1", models.Usage{CostUSD: 2}))
+
+	l = newTestLedger(t, dataDir, Config{}, now.AddDate(0, 0, 2))
+	assert.InDelta(t, 2, l.Project("p1", 5).Total.CostUSD, 1e-9)
+}
+
+func TestBudgets(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+	l := newTestLedger(t, t.TempDir(), Config{
+		RetentionDays: 90,
+		Budgets: Budgets{
+			ProjectDaily:    1,
+			IdentityMonthly: 3,
+		},
+	}, now)
+
+	require.NoError(t, l.Check("p1", "token:tok-1"))
+
+	// Spending of earlier days counts against the month only
+	l.now = func() time.Time { return now.AddDate(0, 0, -1) }
+	require.NoError(t, l.Record("p1", "token:tok-1", models.Usage{CostUSD: 2}))
+	l.now = func() time.Time { return now }
+	require.NoError(t, l.Check("p1", "token:tok-1"))
+
+	require.NoError(t, l.Record("p1", "token:tok-1", models.Usage{CostUSD: 1}))
+	err := l.Check("p1", "token:tok-1")
+	require.True(t, errors.IsCode(err, errors.CodeBudgetExceeded))
+	details := err.(*errors.AppError).Details
+	assert.Equal(t, BudgetProjectDaily, details["budget"])
+	assert.Equal(t, int64(9*time.Hour/time.Millisecond), details["retry_after_ms"])
+
+	// Another project is only held back by the identity's monthly budget
+	err = l.Check("p2", "token:tok-1")
+	require.True(t, errors.IsCode(err, errors.CodeBudgetExceeded))
+	assert.Equal(t, BudgetIdentityMonthly, err.(*errors.AppError).Details["budget"])
+
+	// Other identities can still use other projects
+	assert.NoError(t, l.Check("p2", "token:tok-2"))
+
+	statuses := l.Budgets("", "token:tok-1")
+	require.Len(t, statuses, 1)
+	assert.Equal(t, BudgetIdentityMonthly, statuses[0].Budget)
+	assert.InDelta(t, 3, statuses[0].SpentUSD, 1e-9)
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), statuses[0].ResetsAt)
+
+	// Budgets reset with the month
+	l.now = func() time.Time { return time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC) }
+	assert.NoError(t, l.Check("p1", "token:tok-1"))
+}
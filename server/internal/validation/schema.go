@@ -48,6 +48,7 @@ var MessageSchemas = map[string]Schema{
 					"fallback_model":               {Type: "string"},
 					"add_dirs":                     {Type: "array", Items: &Schema{Type: "string"}},
 					"strict_mcp_config":            {Type: "boolean"},
+					"interactive":                  {Type: "boolean"},
 				},
 			},
 		},
@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+)
+
+// Authenticator verifies the credentials presented on a WebSocket upgrade
+// request. Returning an error rejects the connection before a session exists.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*models.Identity, error)
+}
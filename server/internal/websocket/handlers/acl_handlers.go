@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// ACLHandlers provides handlers for project access control messages
+type ACLHandlers struct {
+	projectMgr *project.Manager
+	log        *logger.Logger
+	audit      *audit.Log
+}
+
+// NewACLHandlers creates new ACL handlers
+func NewACLHandlers(projectMgr *project.Manager, log *logger.Logger) *ACLHandlers {
+	return &ACLHandlers{
+		projectMgr: projectMgr,
+		log:        log,
+	}
+}
+
+// HandleACLGet returns a project's access control list. Any role may read it.
+func (h *ACLHandlers) HandleACLGet(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	projectID, err := aclProjectID(session, data)
+	if err != nil {
+		return err
+	}
+
+	project, err := authorizeProject(h.projectMgr, session, projectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+
+	response := map[string]interface{}{
+		"project_id": project.ID,
+		"acl":        project.GetACL(),
+		"role":       project.RoleOf(session.GetIdentity()),
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectACLGet, response)
+}
+
+// HandleACLUpdate grants or revokes a principal's role on a project. Only
+// owners may change the ACL; an empty role removes the principal's access.
+func (h *ACLHandlers) HandleACLUpdate(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string      `json:"project_id"`
+		Principal string      `json:"principal"`
+		Role      models.Role `json:"role"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid project acl update request")
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	if req.Principal == "" {
+		return errors.New(errors.CodeValidationFailed, "principal is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("principal", req.Principal)
+	event.Set("role", req.Role)
+
+	if _, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner); err != nil {
+		return err
+	}
+
+	project, err := h.projectMgr.SetProjectRole(req.ProjectID, req.Principal, req.Role)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Project ACL changed",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"principal", req.Principal,
+		"role", req.Role,
+		"by", session.GetIdentity().String(),
+	)
+
+	// Drop subscribers that no longer have access
+	h.evictUnauthorized(project)
+
+	response := map[string]interface{}{
+		"project_id": project.ID,
+		"acl":        project.GetACL(),
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectACLUpdate, response)
+}
+
+// evictUnauthorized unsubscribes sessions that lost access to the project so
+// they stop receiving its broadcasts
+func (h *ACLHandlers) evictUnauthorized(project *models.Project) {
+	for _, subscriber := range project.GetSubscribers() {
+		if project.RoleOf(subscriber.GetIdentity()) != "" {
+			continue
+		}
+
+		if err := h.projectMgr.RemoveSubscriber(project.ID, subscriber.ID); err != nil {
+			h.log.Warn("Failed to remove subscriber", "error", err)
+		}
+		if subscriber.GetProject() == project.ID {
+			subscriber.SetProject("")
+		}
+
+		h.log.Info("Removed subscriber after access was revoked",
+			"session_id", subscriber.ID,
+			"project_id", project.ID,
+		)
+
+		if err := websocket.SendSuccess(subscriber, models.MessageTypeProjectLeft, map[string]string{
+			"project_id": project.ID,
+			"reason":     "access_revoked",
+		}); err != nil {
+			h.log.Debug("Failed to notify evicted subscriber", "session_id", subscriber.ID, "error", err)
+		}
+	}
+}
+
+// aclProjectID reads the project ID from the message data, falling back to
+// the session's current project
+func aclProjectID(session *models.Session, data json.RawMessage) (string, error) {
+	var req struct {
+		ProjectID string `json:"project_id"`
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return "", errors.Wrap(err, errors.CodeValidationFailed, "invalid project acl request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return "", errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+
+	return req.ProjectID, nil
+}
+
+// RegisterHandlers registers all ACL handlers with the router
+func (h *ACLHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeProjectACLGet, h.HandleACLGet)
+	router.Register(models.MessageTypeProjectACLUpdate, audited(h.audit, h.log, models.MessageTypeProjectACLUpdate, h.HandleACLUpdate))
+}
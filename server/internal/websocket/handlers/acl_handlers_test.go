@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testOwner    = &models.Identity{ID: "dev-owner", Name: "owner", Method: models.AuthMethodDevice}
+	testObserver = &models.Identity{ID: "dev-observer", Name: "observer", Method: models.AuthMethodDevice}
+	testStranger = &models.Identity{ID: "dev-stranger", Name: "stranger", Method: models.AuthMethodDevice}
+)
+
+type aclTestSetup struct {
+	manager   *project.Manager
+	projects  *ProjectHandlers
+	execution *ExecutionHandlers
+	query     *QueryHandlers
+	acl       *ACLHandlers
+	project   *models.Project
+}
+
+// createACLTestSetup creates a project owned by testOwner on which testObserver is an observer
+func createACLTestSetup(t *testing.T) *aclTestSetup {
+	manager, err := project.NewManager(project.Config{
+		DataDir:     t.TempDir(),
+		MaxProjects: 100,
+		Validator:   validation.NewValidator(),
+	})
+	require.NoError(t, err)
+
+	projectPath := filepath.Join(t.TempDir(), "myproject")
+	require.NoError(t, os.MkdirAll(projectPath, 0o755))
+
+	p, err := manager.CreateProjectWithOwner(projectPath, testOwner)
+	require.NoError(t, err)
+	_, err = manager.SetProjectRole(p.ID, testObserver.Principal(), models.RoleObserver)
+	require.NoError(t, err)
+
+	log := logger.New("debug")
+	broadcaster := NewBroadcaster(DefaultBroadcasterConfig(), log)
+
+	return &aclTestSetup{
+		manager:   manager,
+		projects:  NewProjectHandlers(manager, broadcaster, ProjectServices{}, log),
+		execution: NewExecutionHandlers(manager, nil, broadcaster, ExecutionServices{}, log),
+		query:     NewQueryHandlers(manager, log),
+		acl:       NewACLHandlers(manager, log),
+		project:   p,
+	}
+}
+
+func newIdentitySession(t *testing.T, id string, identity *models.Identity) (*models.Session, *testWebSocketServer) {
+	tws := newTestWebSocketServer(t)
+	t.Cleanup(tws.Close)
+
+	session := models.NewSession(id, tws.GetClientConn())
+	session.SetIdentity(identity)
+	return session, tws
+}
+
+func TestACL_ObserverPermissions(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	session, _ := newIdentitySession(t, "observer-session", testObserver)
+
+	projectData, _ := json.Marshal(map[string]string{"project_id": setup.project.ID})
+
+	// Observers can join and read history
+	require.NoError(t, setup.projects.HandleProjectJoin(ctx, session, projectData))
+	assert.Equal(t, setup.project.ID, session.GetProject())
+	assert.True(t, setup.project.HasSubscriber(session.ID))
+	require.NoError(t, setup.query.HandleGetMessages(ctx, session, projectData))
+
+	// but cannot execute, kill or delete
+	executeData, _ := json.Marshal(map[string]string{"prompt": "hello"})
+	err := setup.execution.HandleExecute(ctx, session, executeData)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	err = setup.execution.HandleAgentKill(ctx, session, projectData)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	err = setup.execution.HandleAgentNewSession(ctx, session, projectData)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	err = setup.projects.HandleProjectDelete(ctx, session, projectData)
+	require.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+	assert.Equal(t, models.RoleOwner, err.(*errors.AppError).Details["required_role"])
+
+	_, err = setup.manager.GetProjectByID(setup.project.ID)
+	assert.NoError(t, err, "project must not be deleted")
+	assert.Equal(t, models.StateIdle, setup.project.State)
+}
+
+func TestACL_NoAccessHidesProject(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	session, tws := newIdentitySession(t, "stranger-session", testStranger)
+
+	projectData, _ := json.Marshal(map[string]string{"project_id": setup.project.ID})
+
+	err := setup.projects.HandleProjectJoin(ctx, session, projectData)
+	assert.True(t, errors.IsCode(err, errors.CodeProjectNotFound))
+	assert.False(t, setup.project.HasSubscriber(session.ID))
+
+	err = setup.query.HandleGetMessages(ctx, session, projectData)
+	assert.True(t, errors.IsCode(err, errors.CodeProjectNotFound))
+
+	// The project is not listed
+	require.NoError(t, setup.projects.HandleProjectList(ctx, session, nil))
+	require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) > 0 }, time.Second, 10*time.Millisecond)
+	response := parseResponse(t, tws.GetReceivedMessages()[0])
+	payload := response["data"].(map[string]interface{})
+	assert.Empty(t, payload["projects"])
+}
+
+func TestACL_ProjectListIncludesRole(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	session, tws := newIdentitySession(t, "observer-session", testObserver)
+
+	require.NoError(t, setup.projects.HandleProjectList(ctx, session, nil))
+	require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) > 0 }, time.Second, 10*time.Millisecond)
+
+	response := parseResponse(t, tws.GetReceivedMessages()[0])
+	projects := response["data"].(map[string]interface{})["projects"].([]interface{})
+	require.Len(t, projects, 1)
+	assert.Equal(t, string(models.RoleObserver), projects[0].(map[string]interface{})["role"])
+}
+
+func TestACLHandlers_Update(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("owner grants executor", func(t *testing.T) {
+		setup := createACLTestSetup(t)
+		owner, _ := newIdentitySession(t, "owner-session", testOwner)
+
+		data, _ := json.Marshal(map[string]string{
+			"project_id": setup.project.ID,
+			"principal":  testStranger.Principal(),
+			"role":       string(models.RoleExecutor),
+		})
+		require.NoError(t, setup.acl.HandleACLUpdate(ctx, owner, data))
+		assert.Equal(t, models.RoleExecutor, setup.project.RoleOf(testStranger))
+	})
+
+	t.Run("observer cannot change acl", func(t *testing.T) {
+		setup := createACLTestSetup(t)
+		observer, _ := newIdentitySession(t, "observer-session", testObserver)
+
+		data, _ := json.Marshal(map[string]string{
+			"project_id": setup.project.ID,
+			"principal":  testObserver.Principal(),
+			"role":       string(models.RoleOwner),
+		})
+		err := setup.acl.HandleACLUpdate(ctx, observer, data)
+		assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+		assert.Equal(t, models.RoleObserver, setup.project.RoleOf(testObserver))
+	})
+
+	t.Run("revoking access evicts subscribers", func(t *testing.T) {
+		setup := createACLTestSetup(t)
+		owner, _ := newIdentitySession(t, "owner-session", testOwner)
+		observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+
+		joinData, _ := json.Marshal(map[string]string{"project_id": setup.project.ID})
+		require.NoError(t, setup.projects.HandleProjectJoin(ctx, observer, joinData))
+
+		data, _ := json.Marshal(map[string]string{
+			"project_id": setup.project.ID,
+			"principal":  testObserver.Principal(),
+			"role":       "",
+		})
+		require.NoError(t, setup.acl.HandleACLUpdate(ctx, owner, data))
+
+		assert.Equal(t, models.Role(""), setup.project.RoleOf(testObserver))
+		assert.False(t, setup.project.HasSubscriber(observer.ID))
+		assert.Empty(t, observer.GetProject())
+
+		// Project state and join confirmation, followed by project_left
+		require.Eventually(t, func() bool { return len(observerWS.GetReceivedMessages()) == 3 }, time.Second, 10*time.Millisecond)
+		response := parseResponse(t, observerWS.GetReceivedMessages()[2])
+		assert.Equal(t, string(models.MessageTypeProjectLeft), response["type"])
+	})
+
+	t.Run("last owner cannot be removed", func(t *testing.T) {
+		setup := createACLTestSetup(t)
+		owner, _ := newIdentitySession(t, "owner-session", testOwner)
+
+		data, _ := json.Marshal(map[string]string{
+			"project_id": setup.project.ID,
+			"principal":  testOwner.Principal(),
+			"role":       "",
+		})
+		err := setup.acl.HandleACLUpdate(ctx, owner, data)
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+		assert.Equal(t, models.RoleOwner, setup.project.RoleOf(testOwner))
+	})
+}
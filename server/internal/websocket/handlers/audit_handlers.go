@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// AuditHandlers provides handlers for querying the audit log
+type AuditHandlers struct {
+	projectMgr *project.Manager
+	audit      *audit.Log
+	log        *logger.Logger
+}
+
+// NewAuditHandlers creates new audit handlers
+func NewAuditHandlers(projectMgr *project.Manager, auditLog *audit.Log, log *logger.Logger) *AuditHandlers {
+	return &AuditHandlers{
+		projectMgr: projectMgr,
+		audit:      auditLog,
+		log:        log,
+	}
+}
+
+// HandleAuditQuery returns audit log entries. Admins may query the whole
+// log; project owners may query the entries of their projects.
+func (h *AuditHandlers) HandleAuditQuery(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Principal string `json:"principal"`
+		Action    string `json:"action"`
+		Since     string `json:"since"` // RFC3339 timestamp
+		Until     string `json:"until"` // RFC3339 timestamp
+		AfterSeq  uint64 `json:"after_seq"`
+		Limit     int    `json:"limit"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid audit query request")
+	}
+
+	if h.audit == nil {
+		return errors.New(errors.CodeInternalError, "audit log is not available")
+	}
+
+	// Non-admins are limited to projects they own
+	if err := requireAdmin(session); err != nil {
+		if req.ProjectID == "" {
+			return err
+		}
+		if _, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner); err != nil {
+			return err
+		}
+	}
+
+	filter := audit.Filter{
+		ProjectID: req.ProjectID,
+		Principal: req.Principal,
+		Action:    req.Action,
+		AfterSeq:  req.AfterSeq,
+		Limit:     req.Limit,
+	}
+
+	for _, t := range []struct {
+		name  string
+		value string
+		dest  *time.Time
+	}{
+		{"since", req.Since, &filter.Since},
+		{"until", req.Until, &filter.Until},
+	} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return errors.New(errors.CodeValidationFailed, "invalid timestamp format, use RFC3339").
+				WithDetail(t.name, t.value)
+		}
+		*t.dest = parsed
+	}
+
+	entries, hasMore, err := h.audit.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	response := map[string]interface{}{
+		"entries":  entries,
+		"has_more": hasMore,
+	}
+	if len(entries) > 0 {
+		response["next_seq"] = entries[len(entries)-1].Seq
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeAuditQuery, response)
+}
+
+// RegisterHandlers registers all audit handlers with the router
+func (h *AuditHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeAuditQuery, h.HandleAuditQuery)
+}
+
+// audited wraps a handler so every call is recorded in the audit log with its
+// outcome. The handler adds the project and details to the event carried by
+// its context (see audit.EventFromContext).
+func audited(auditLog *audit.Log, log *logger.Logger, action models.MessageType, handler websocket.HandlerFunc) websocket.HandlerFunc {
+	if auditLog == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, session *models.Session, data json.RawMessage) error {
+		event := &audit.Event{
+			Action:     string(action),
+			Principal:  session.GetIdentity().String(),
+			SessionID:  session.ID,
+			RemoteAddr: remoteAddr(session),
+		}
+
+		err := handler(audit.WithEvent(ctx, event), session, data)
+		event.SetResult(err)
+
+		if _, recordErr := auditLog.Record(event); recordErr != nil {
+			log.Error("Failed to record audit entry",
+				"action", action,
+				"session_id", session.ID,
+				"error", recordErr,
+			)
+		}
+
+		return err
+	}
+}
+
+// remoteAddr returns the client address of the session, as resolved through
+// trusted proxies, falling back to the connection's peer
+func remoteAddr(session *models.Session) string {
+	if session.RemoteIP != "" {
+		return session.RemoteIP
+	}
+	if session.Conn == nil {
+		return ""
+	}
+	return session.Conn.RemoteAddr().String()
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createAuditTestSetup extends the ACL setup with an audit log and a router
+// carrying the audited handlers
+func createAuditTestSetup(t *testing.T) (*aclTestSetup, *audit.Log, *websocket.MessageRouter) {
+	setup := createACLTestSetup(t)
+
+	auditLog, err := audit.NewLog(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLog.Close() })
+
+	setup.projects.audit = auditLog
+	setup.acl.audit = auditLog
+
+	log := logger.New("debug")
+	router := websocket.NewMessageRouter(log)
+	setup.projects.RegisterHandlers(router)
+	setup.acl.RegisterHandlers(router)
+	NewAuditHandlers(setup.manager, auditLog, log).RegisterHandlers(router)
+
+	return setup, auditLog, router
+}
+
+func routeMessage(ctx context.Context, router *websocket.MessageRouter, session *models.Session, msgType models.MessageType, data interface{}) error {
+	raw, _ := json.Marshal(data)
+	return router.HandleMessage(ctx, session, &models.ClientMessage{Type: msgType, Data: raw})
+}
+
+func TestAudited_RecordsOutcomes(t *testing.T) {
+	ctx := context.Background()
+	setup, auditLog, router := createAuditTestSetup(t)
+	owner, _ := newIdentitySession(t, "owner-session", testOwner)
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+
+	update := map[string]string{
+		"project_id": setup.project.ID,
+		"principal":  testStranger.Principal(),
+		"role":       string(models.RoleObserver),
+	}
+
+	// Allowed and denied ACL changes are both recorded
+	require.NoError(t, routeMessage(ctx, router, owner, models.MessageTypeProjectACLUpdate, update))
+	err := routeMessage(ctx, router, observer, models.MessageTypeProjectACLUpdate, update)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	// Unaudited messages are not
+	require.NoError(t, routeMessage(ctx, router, owner, models.MessageTypeProjectList, nil))
+
+	entries, _, err := auditLog.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, string(models.MessageTypeProjectACLUpdate), entries[0].Action)
+	assert.Equal(t, testOwner.Principal(), entries[0].Principal)
+	assert.Equal(t, "owner-session", entries[0].SessionID)
+	assert.NotEmpty(t, entries[0].RemoteAddr)
+	assert.Equal(t, setup.project.ID, entries[0].ProjectID)
+	assert.Equal(t, audit.OutcomeSuccess, entries[0].Outcome)
+	assert.JSONEq(t, `{"principal":"device:dev-stranger","role":"observer"}`, string(entries[0].Details))
+
+	assert.Equal(t, testObserver.Principal(), entries[1].Principal)
+	assert.Equal(t, audit.OutcomeDenied, entries[1].Outcome)
+	assert.Contains(t, entries[1].Error, "owner role required")
+
+	// Deleting a project is recorded against it
+	require.NoError(t, routeMessage(ctx, router, owner, models.MessageTypeProjectDelete, map[string]string{"project_id": setup.project.ID}))
+	entries, _, err = auditLog.Query(audit.Filter{Action: string(models.MessageTypeProjectDelete)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, setup.project.ID, entries[0].ProjectID)
+
+	_, err = audit.Verify(filepath.Dir(filepath.Dir(auditLog.Path())))
+	assert.NoError(t, err)
+}
+
+func TestAuditHandlers_Query(t *testing.T) {
+	ctx := context.Background()
+	setup, _, router := createAuditTestSetup(t)
+	owner, ownerWS := newIdentitySession(t, "owner-session", testOwner)
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	admin, adminWS := newIdentitySession(t, "admin-session", &models.Identity{ID: "tok-admin", Method: models.AuthMethodToken, Admin: true})
+
+	require.NoError(t, routeMessage(ctx, router, owner, models.MessageTypeProjectACLUpdate, map[string]string{
+		"project_id": setup.project.ID,
+		"principal":  testStranger.Principal(),
+		"role":       string(models.RoleExecutor),
+	}))
+
+	// Admins may query the whole log
+	require.NoError(t, routeMessage(ctx, router, admin, models.MessageTypeAuditQuery, map[string]interface{}{}))
+	require.Eventually(t, func() bool { return len(adminWS.GetReceivedMessages()) > 0 }, time.Second, 10*time.Millisecond)
+	response := parseResponse(t, adminWS.GetReceivedMessages()[0])
+	payload := response["data"].(map[string]interface{})
+	assert.Len(t, payload["entries"], 1)
+	assert.Equal(t, false, payload["has_more"])
+	assert.Equal(t, float64(1), payload["next_seq"])
+
+	// Owners may query their projects only
+	err := routeMessage(ctx, router, owner, models.MessageTypeAuditQuery, map[string]interface{}{})
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.NoError(t, routeMessage(ctx, router, owner, models.MessageTypeAuditQuery, map[string]interface{}{"project_id": setup.project.ID}))
+	// The first message answered the ACL update
+	require.Eventually(t, func() bool { return len(ownerWS.GetReceivedMessages()) == 2 }, time.Second, 10*time.Millisecond)
+	response = parseResponse(t, ownerWS.GetReceivedMessages()[1])
+	assert.Len(t, response["data"].(map[string]interface{})["entries"], 1)
+
+	err = routeMessage(ctx, router, observer, models.MessageTypeAuditQuery, map[string]interface{}{"project_id": setup.project.ID})
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	// Invalid timestamps are rejected
+	err = routeMessage(ctx, router, admin, models.MessageTypeAuditQuery, map[string]interface{}{"since": "yesterday"})
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
+
+func TestAudited_DigestsPrompts(t *testing.T) {
+	ctx := context.Background()
+	setup, auditLog, router := createAuditTestSetup(t)
+	setup.execution.audit = auditLog
+	setup.execution.RegisterHandlers(router)
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+
+	// Refused executions are recorded without their prompt
+	prompt := "deploy with password hunter2"
+	err := routeMessage(ctx, router, observer, models.MessageTypeExecute, map[string]string{"prompt": prompt})
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+	err = routeMessage(ctx, router, observer, models.MessageTypeSendInput, map[string]string{"prompt": prompt})
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	entries, _, err := auditLog.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.NotContains(t, string(entry.Details), "hunter2")
+
+		var details map[string]interface{}
+		require.NoError(t, json.Unmarshal(entry.Details, &details))
+		assert.Len(t, details["prompt_sha256"], 64)
+		assert.Equal(t, float64(len(prompt)), details["prompt_length"])
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+)
+
+// authorizeProject looks up a project and checks that the session's identity
+// holds at least the required role on it. Identities without any access get
+// PROJECT_NOT_FOUND so project existence is not revealed.
+func authorizeProject(projectMgr *project.Manager, session *models.Session, projectID string, required models.Role) (*models.Project, error) {
+	p, err := projectMgr.GetProjectByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRole(session, p, required); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// requireRole checks that the session's identity holds at least the required role
+func requireRole(session *models.Session, p *models.Project, required models.Role) error {
+	role := p.RoleOf(session.GetIdentity())
+	if role == "" {
+		return errors.NewProjectNotFoundError(p.ID)
+	}
+
+	if !role.Includes(required) {
+		return errors.New(errors.CodePermissionDenied, "%s role required for this operation", required).
+			WithDetail("project_id", p.ID).
+			WithDetail("role", role).
+			WithDetail("required_role", required)
+	}
+
+	return nil
+}
+
+// requireAdmin checks that the session's identity is an admin. Sessions on
+// servers without authentication are treated as admins.
+func requireAdmin(session *models.Session) error {
+	identity := session.GetIdentity()
+	if identity != nil && !identity.Admin {
+		return errors.New(errors.CodePermissionDenied, "admin access required for this operation")
+	}
+	return nil
+}
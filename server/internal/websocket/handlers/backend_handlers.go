@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// BackendHandlers provides handlers for choosing a project's agent backend
+type BackendHandlers struct {
+	projectMgr  *project.Manager
+	executor    *executor.ClaudeExecutor
+	execution   *ExecutionHandlers
+	permissions *permission.Broker
+	sessions    *sessions.Manager
+	broadcast   *Broadcaster
+	log         *logger.Logger
+	audit       *audit.Log
+}
+
+// NewBackendHandlers creates new backend handlers. Backends only change while
+// the execution handlers run nothing in the project's directory.
+func NewBackendHandlers(
+	projectMgr *project.Manager,
+	executor *executor.ClaudeExecutor,
+	execution *ExecutionHandlers,
+	permissions *permission.Broker,
+	catalogue *sessions.Manager,
+	broadcast *Broadcaster,
+	log *logger.Logger,
+) *BackendHandlers {
+	return &BackendHandlers{
+		projectMgr:  projectMgr,
+		executor:    executor,
+		execution:   execution,
+		permissions: permissions,
+		sessions:    catalogue,
+		broadcast:   broadcast,
+		log:         log,
+	}
+}
+
+// HandleBackendList returns the agent backends projects may choose, with
+// the features each supports
+func (h *BackendHandlers) HandleBackendList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	return websocket.SendSuccess(session, models.MessageTypeBackendList, map[string]interface{}{
+		"backends":        h.executor.Backends(),
+		"default_backend": executor.ClaudeBackendName,
+	})
+}
+
+// HandleProjectSetBackend chooses the agent backend that runs a project's
+// executions. The project's session belongs to the previous agent, so the
+// next execution starts a new one. The project must not be executing.
+func (h *BackendHandlers) HandleProjectSetBackend(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Backend   string `json:"backend"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid set backend request")
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	// The Claude CLI is what projects without a backend use
+	if req.Backend == executor.ClaudeBackendName {
+		req.Backend = ""
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("backend", req.Backend)
+
+	// Choosing what runs in the project directory requires the owner role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	if req.Backend != "" && !h.executor.HasBackend(req.Backend) {
+		return errors.New(errors.CodeValidationFailed, "unknown agent backend: %s", req.Backend).
+			WithDetail("backend", req.Backend)
+	}
+
+	var updatedProject *models.Project
+	var oldBackend, oldSessionID string
+	err = h.execution.whileIdle(project.ID, "change the agent backend", func() (err error) {
+		oldBackend = project.GetBackend()
+		oldSessionID = project.SessionID
+		if updatedProject, err = h.projectMgr.SetProjectBackend(project.ID, req.Backend); err != nil {
+			return err
+		}
+
+		if oldSessionID != "" {
+			// Tools always allowed in the old conversation must be approved again
+			if h.permissions != nil {
+				h.permissions.Reset(project.ID)
+			}
+			// The old session stays in the catalogue, but is no longer forked
+			if h.sessions != nil {
+				if err := h.sessions.CancelFork(project.ID); err != nil {
+					h.log.Error("Failed to cancel session fork", "project_id", project.ID, "error", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Project agent backend changed",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"old_backend", oldBackend,
+		"new_backend", req.Backend,
+		"by", session.GetIdentity().String(),
+	)
+
+	h.broadcast.BroadcastProjectState(updatedProject)
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectSetBackend, map[string]interface{}{
+		"project_id":     project.ID,
+		"backend":        updatedProject.GetBackend(),
+		"old_backend":    oldBackend,
+		"old_session_id": oldSessionID,
+		"timestamp":      time.Now().Format(time.RFC3339),
+	})
+}
+
+// RegisterHandlers registers all backend handlers with the router
+func (h *BackendHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeBackendList, h.HandleBackendList)
+	router.Register(models.MessageTypeProjectSetBackend, audited(h.audit, h.log, models.MessageTypeProjectSetBackend, h.HandleProjectSetBackend))
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionHandlers_ProjectSetBackend(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	projectID := setup.project.ID
+
+	agent, err := executor.NewJSONLBackend(executor.JSONLConfig{Command: "sh"})
+	require.NoError(t, err)
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Executor: executor.Config{
+			ClaudePath: "sh",
+			Backends:   map[string]executor.AgentBackend{"agent": agent},
+		},
+		Config: Config{Sessions: sessions.NewManager(t.TempDir(), 10)},
+	})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(projectID)
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(projectID)
+	request := func(backend string) json.RawMessage {
+		data, _ := json.Marshal(map[string]string{"backend": backend})
+		return data
+	}
+
+	require.NoError(t, h.Backend.HandleBackendList(ctx, observer, nil))
+
+	require.NoError(t, setup.manager.UpdateProjectSession(projectID, "claude-session"))
+
+	// Only owners choose what runs in the project directory
+	err = h.Backend.HandleProjectSetBackend(ctx, observer, request("agent"))
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+	err = h.Backend.HandleProjectSetBackend(ctx, session, request("missing"))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	// The new agent cannot resume Claude's session
+	require.NoError(t, h.Backend.HandleProjectSetBackend(ctx, session, request("agent")))
+	data := lastResponseData(t, tws, len(tws.GetReceivedMessages()))
+	assert.Equal(t, "agent", data["backend"])
+	assert.Equal(t, "claude-session", data["old_session_id"])
+	assert.Equal(t, "agent", setup.project.GetBackend())
+	assert.Empty(t, setup.project.SessionID)
+
+	// Features the agent lacks are refused
+	require.NoError(t, setup.manager.UpdateProjectSession(projectID, "agent-session"))
+	fork, _ := json.Marshal(map[string]string{"title": "Try again"})
+	err = h.Session.HandleSessionFork(ctx, session, fork)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	execute, _ := json.Marshal(map[string]interface{}{
+		"prompt":  "hello",
+		"options": map[string]interface{}{"allowed_tools": []string{"Read"}},
+	})
+	err = h.Execution.HandleExecute(ctx, session, execute)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	assert.True(t, idle(h.Execution))
+
+	// Choosing claude returns to the default
+	require.NoError(t, h.Backend.HandleProjectSetBackend(ctx, session, request("claude")))
+	assert.Empty(t, setup.project.GetBackend())
+}
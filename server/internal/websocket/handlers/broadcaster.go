@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
+	"github.com/boyd/pocket_agent/server/internal/queue"
 )
 
 // Broadcaster handles broadcasting messages to project subscribers
@@ -55,12 +58,20 @@ func (b *Broadcaster) BroadcastToProject(project *models.Project, msg *models.Se
 	// Set project ID in message
 	msg.ProjectID = project.ID
 
+	b.broadcastEach(project, msg.Type, func(*models.Session) *models.ServerMessage {
+		return msg
+	})
+}
+
+// broadcastEach sends each project subscriber the message messageFor
+// returns for its session
+func (b *Broadcaster) broadcastEach(project *models.Project, msgType models.MessageType, messageFor func(*models.Session) *models.ServerMessage) {
 	// Get snapshot of subscribers to avoid holding lock during broadcast
 	subscribers := b.getSubscriberSnapshot(project)
 
 	b.log.Debug("Broadcasting to project",
 		"project_id", project.ID,
-		"message_type", msg.Type,
+		"message_type", msgType,
 		"subscriber_count", len(subscribers),
 	)
 
@@ -70,7 +81,7 @@ func (b *Broadcaster) BroadcastToProject(project *models.Project, msg *models.Se
 
 	// Broadcast to each subscriber in separate goroutine
 	for sessionID, session := range subscribers {
-		go b.sendToSubscriber(&wg, sessionID, session, msg)
+		go b.sendToSubscriber(&wg, sessionID, session, messageFor(session))
 	}
 
 	// Wait for all broadcasts to complete or timeout
@@ -175,6 +186,12 @@ func (b *Broadcaster) BroadcastClaudeMessage(project *models.Project, claudeMsg
 	if err := json.Unmarshal(claudeMsg.Content, &contentData); err == nil {
 		// Add the Claude message type to the data
 		contentData["type"] = claudeMsg.Type
+		if len(claudeMsg.Redactions) > 0 {
+			contentData["redactions"] = claudeMsg.Redactions
+		}
+		if claudeMsg.WorktreeID != "" {
+			contentData["worktree_id"] = claudeMsg.WorktreeID
+		}
 
 		msg := &models.ServerMessage{
 			Type:      models.MessageTypeAgentMessage,
@@ -197,6 +214,114 @@ func (b *Broadcaster) BroadcastClaudeMessage(project *models.Project, claudeMsg
 	}
 }
 
+// BroadcastQueue broadcasts the project's queued prompts after they changed
+func (b *Broadcaster) BroadcastQueue(project *models.Project, items []queue.Item) {
+	if items == nil {
+		items = []queue.Item{}
+	}
+
+	msg := &models.ServerMessage{
+		Type:      models.MessageTypeQueueUpdated,
+		ProjectID: project.ID,
+		Data: map[string]interface{}{
+			"project_id": project.ID,
+			"items":      items,
+			"timestamp":  time.Now().Format(time.RFC3339),
+		},
+	}
+	b.BroadcastToProject(project, msg)
+}
+
+// BroadcastWorkspaceChanges tells project subscribers which files a run
+// changed; the patch itself is fetched with execution_diff
+func (b *Broadcaster) BroadcastWorkspaceChanges(project *models.Project, executionID, worktreeID string, changes models.WorkspaceChanges) {
+	data := map[string]interface{}{
+		"project_id":   project.ID,
+		"execution_id": executionID,
+		"files":        changes.Files,
+		"additions":    changes.Additions,
+		"deletions":    changes.Deletions,
+		"truncated":    changes.Truncated,
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	if worktreeID != "" {
+		data["worktree_id"] = worktreeID
+	}
+
+	msg := &models.ServerMessage{
+		Type:      models.MessageTypeWorkspaceChanges,
+		ProjectID: project.ID,
+		Data:      data,
+	}
+	b.BroadcastToProject(project, msg)
+}
+
+// BroadcastExecutionStalled warns project subscribers that a run's process
+// wrote no output for a while and is killed unless it writes again
+func (b *Broadcaster) BroadcastExecutionStalled(project *models.Project, executionID string, stall executor.Stall) {
+	data := map[string]interface{}{
+		"project_id":   project.ID,
+		"execution_id": executionID,
+		"silent_since": stall.Since.Format(time.RFC3339),
+		"kill_at":      stall.KillAt.Format(time.RFC3339),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	if stall.WorktreeID != "" {
+		data["worktree_id"] = stall.WorktreeID
+	}
+
+	msg := &models.ServerMessage{
+		Type:      models.MessageTypeExecutionStalled,
+		ProjectID: project.ID,
+		Data:      data,
+	}
+	b.BroadcastToProject(project, msg)
+}
+
+// BroadcastPermissionRequest asks project subscribers to approve a tool use.
+// Only subscribers who may answer, with the executor role, see the tool
+// input; the others learn that a prompt is pending.
+func (b *Broadcaster) BroadcastPermissionRequest(project *models.Project, req permission.Request) {
+	approvable := &models.ServerMessage{
+		Type:      models.MessageTypePermissionRequest,
+		ProjectID: project.ID,
+		Data:      req,
+	}
+
+	summary := req
+	summary.Input = nil
+	pending := &models.ServerMessage{
+		Type:      models.MessageTypePermissionRequest,
+		ProjectID: project.ID,
+		Data:      summary,
+	}
+
+	b.broadcastEach(project, models.MessageTypePermissionRequest, func(session *models.Session) *models.ServerMessage {
+		if project.RoleOf(session.GetIdentity()).Includes(models.RoleExecutor) {
+			return approvable
+		}
+		return pending
+	})
+}
+
+// BroadcastPermissionResolved tells project subscribers a permission request
+// was answered, so clients can dismiss the prompt
+func (b *Broadcaster) BroadcastPermissionResolved(project *models.Project, req permission.Request, res permission.Resolution) {
+	msg := &models.ServerMessage{
+		Type:      models.MessageTypePermissionResolved,
+		ProjectID: project.ID,
+		Data: map[string]interface{}{
+			"project_id": project.ID,
+			"request_id": req.ID,
+			"tool_name":  req.ToolName,
+			"decision":   res.Decision,
+			"by":         res.By,
+			"timestamp":  time.Now().Format(time.RFC3339),
+		},
+	}
+	b.BroadcastToProject(project, msg)
+}
+
 // BroadcastError broadcasts error to project subscribers
 // Requirements: 5.4
 func (b *Broadcaster) BroadcastError(project *models.Project, err error) {
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// DeviceHandlers provides handlers for device pairing and management messages
+type DeviceHandlers struct {
+	auth  *auth.Service
+	log   *logger.Logger
+	audit *audit.Log
+}
+
+// NewDeviceHandlers creates new device handlers. authService may be nil when
+// authentication is disabled, in which case pairing is unavailable.
+func NewDeviceHandlers(authService *auth.Service, log *logger.Logger) *DeviceHandlers {
+	return &DeviceHandlers{
+		auth: authService,
+		log:  log,
+	}
+}
+
+// HandlePair exchanges a one-time pairing code for a long-lived device credential
+func (h *DeviceHandlers) HandlePair(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	if err := h.requireAuth(); err != nil {
+		return err
+	}
+
+	var req struct {
+		Code       string `json:"code"`
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid pair request")
+	}
+
+	if req.Code == "" {
+		return errors.New(errors.CodeValidationFailed, "code is required")
+	}
+	if req.DeviceName == "" {
+		return errors.New(errors.CodeValidationFailed, "device_name is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.Set("device_name", req.DeviceName)
+
+	// Consume the code first so it cannot be replayed even if registration fails
+	if err := h.auth.Pairing().Consume(req.Code); err != nil {
+		h.log.Warn("Pairing attempt rejected", "session_id", session.ID, "error", err)
+		return err
+	}
+
+	credential, device, err := h.auth.Devices().Register(req.DeviceName)
+	if err != nil {
+		return err
+	}
+
+	event.Set("device_id", device.ID)
+
+	// The session is now authenticated as the new device
+	session.SetIdentity(&models.Identity{
+		ID:     device.ID,
+		Name:   device.Name,
+		Method: models.AuthMethodDevice,
+	})
+
+	h.log.Info("Device paired",
+		"session_id", session.ID,
+		"device_id", device.ID,
+		"device_name", device.Name,
+	)
+
+	response := map[string]interface{}{
+		"device":     device.Info(),
+		"credential": credential,
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypePair, response)
+}
+
+// HandleDeviceList lists all paired devices
+func (h *DeviceHandlers) HandleDeviceList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	if err := h.requireAuth(); err != nil {
+		return err
+	}
+
+	devices, err := h.auth.Devices().List()
+	if err != nil {
+		return err
+	}
+
+	identity := session.GetIdentity()
+	current := ""
+	if identity != nil && identity.Method == models.AuthMethodDevice {
+		current = identity.ID
+	}
+
+	// Non-admin identities only see their own device
+	deviceList := make([]auth.DeviceInfo, 0, len(devices))
+	for _, d := range devices {
+		if !canManageDevice(identity, d.ID) {
+			continue
+		}
+		deviceList = append(deviceList, d.Info())
+	}
+
+	response := map[string]interface{}{
+		"devices":        deviceList,
+		"total":          len(deviceList),
+		"current_device": current,
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeDeviceList, response)
+}
+
+// HandleDeviceRename changes the display name of a paired device
+func (h *DeviceHandlers) HandleDeviceRename(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	if err := h.requireAuth(); err != nil {
+		return err
+	}
+
+	var req struct {
+		DeviceID string `json:"device_id"`
+		Name     string `json:"name"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid device rename request")
+	}
+
+	if req.DeviceID == "" {
+		return errors.New(errors.CodeValidationFailed, "device_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.Set("device_id", req.DeviceID)
+	event.Set("name", req.Name)
+
+	if !canManageDevice(session.GetIdentity(), req.DeviceID) {
+		return errors.New(errors.CodePermissionDenied, "only admins can rename other devices").
+			WithDetail("device_id", req.DeviceID)
+	}
+
+	device, err := h.auth.Devices().Rename(req.DeviceID, req.Name)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Device renamed",
+		"session_id", session.ID,
+		"device_id", device.ID,
+		"name", device.Name,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeDeviceRename, device.Info())
+}
+
+// HandleDeviceRevoke revokes a paired device's credential
+func (h *DeviceHandlers) HandleDeviceRevoke(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	if err := h.requireAuth(); err != nil {
+		return err
+	}
+
+	var req struct {
+		DeviceID string `json:"device_id"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid device revoke request")
+	}
+
+	if req.DeviceID == "" {
+		return errors.New(errors.CodeValidationFailed, "device_id is required")
+	}
+
+	audit.EventFromContext(ctx).Set("device_id", req.DeviceID)
+
+	if !canManageDevice(session.GetIdentity(), req.DeviceID) {
+		return errors.New(errors.CodePermissionDenied, "only admins can revoke other devices").
+			WithDetail("device_id", req.DeviceID)
+	}
+
+	device, err := h.auth.Devices().Revoke(req.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Device revoked",
+		"session_id", session.ID,
+		"device_id", device.ID,
+		"by", session.GetIdentity().String(),
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeDeviceRevoke, device.Info())
+}
+
+// requireAuth ensures the auth service is available
+func (h *DeviceHandlers) requireAuth() error {
+	if h.auth == nil {
+		return errors.New(errors.CodeValidationFailed, "device pairing requires authentication to be enabled")
+	}
+	return nil
+}
+
+// canManageDevice reports whether an identity may see or manage a device.
+// Admins (and unauthenticated servers) manage every device; paired devices
+// only manage themselves.
+func canManageDevice(identity *models.Identity, deviceID string) bool {
+	if identity == nil || identity.Admin {
+		return true
+	}
+	return identity.Method == models.AuthMethodDevice && identity.ID == deviceID
+}
+
+// RegisterHandlers registers all device handlers with the router
+func (h *DeviceHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypePair, audited(h.audit, h.log, models.MessageTypePair, h.HandlePair))
+	router.Register(models.MessageTypeDeviceList, h.HandleDeviceList)
+	router.Register(models.MessageTypeDeviceRename, audited(h.audit, h.log, models.MessageTypeDeviceRename, h.HandleDeviceRename))
+	router.Register(models.MessageTypeDeviceRevoke, audited(h.audit, h.log, models.MessageTypeDeviceRevoke, h.HandleDeviceRevoke))
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createDeviceTestSetup(t *testing.T) (*DeviceHandlers, *auth.Service, *models.Session, *testWebSocketServer) {
+	service, err := auth.NewService(t.TempDir())
+	require.NoError(t, err)
+
+	tws := newTestWebSocketServer(t)
+	t.Cleanup(tws.Close)
+
+	session := models.NewSession("test-session", tws.GetClientConn())
+	handler := NewDeviceHandlers(service, logger.New("debug"))
+
+	return handler, service, session, tws
+}
+
+func TestDeviceHandlers_Pair(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("successful pairing", func(t *testing.T) {
+		handler, service, session, tws := createDeviceTestSetup(t)
+		session.SetIdentity(&models.Identity{Name: "pairing", Method: models.AuthMethodPairing})
+
+		code, _, err := service.Pairing().Create(time.Minute)
+		require.NoError(t, err)
+
+		data, _ := json.Marshal(map[string]string{"code": code, "device_name": "Pixel"})
+		require.NoError(t, handler.HandlePair(ctx, session, data))
+
+		// Session is upgraded to the new device identity
+		identity := session.GetIdentity()
+		require.NotNil(t, identity)
+		assert.Equal(t, models.AuthMethodDevice, identity.Method)
+		assert.Equal(t, "Pixel", identity.Name)
+
+		// Response carries a credential that authenticates as the device
+		require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) > 0 }, time.Second, 10*time.Millisecond)
+		response := parseResponse(t, tws.GetReceivedMessages()[0])
+		assert.Equal(t, string(models.MessageTypePair), response["type"])
+		payload := response["data"].(map[string]interface{})
+		credential := payload["credential"].(string)
+
+		device, err := service.Devices().Validate(credential)
+		require.NoError(t, err)
+		assert.Equal(t, identity.ID, device.ID)
+
+		// The code cannot be used twice
+		err = handler.HandlePair(ctx, session, data)
+		assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		handler, _, session, _ := createDeviceTestSetup(t)
+
+		data, _ := json.Marshal(map[string]string{"code": "AAAA-AAAA", "device_name": "Pixel"})
+		err := handler.HandlePair(ctx, session, data)
+		assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	})
+
+	t.Run("missing device name", func(t *testing.T) {
+		handler, _, session, _ := createDeviceTestSetup(t)
+
+		data, _ := json.Marshal(map[string]string{"code": "AAAA-AAAA"})
+		err := handler.HandlePair(ctx, session, data)
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	})
+
+	t.Run("auth disabled", func(t *testing.T) {
+		handler := NewDeviceHandlers(nil, logger.New("debug"))
+		session := createTestSession("test-session")
+
+		data, _ := json.Marshal(map[string]string{"code": "AAAA-AAAA", "device_name": "Pixel"})
+		err := handler.HandlePair(ctx, session, data)
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	})
+}
+
+func TestDeviceHandlers_Manage(t *testing.T) {
+	ctx := context.Background()
+	handler, service, session, tws := createDeviceTestSetup(t)
+
+	_, device, err := service.Devices().Register("tablet")
+	require.NoError(t, err)
+
+	// Rename
+	data, _ := json.Marshal(map[string]string{"device_id": device.ID, "name": "kitchen"})
+	require.NoError(t, handler.HandleDeviceRename(ctx, session, data))
+
+	// List
+	require.NoError(t, handler.HandleDeviceList(ctx, session, nil))
+
+	// Revoke
+	data, _ = json.Marshal(map[string]string{"device_id": device.ID})
+	require.NoError(t, handler.HandleDeviceRevoke(ctx, session, data))
+	assert.False(t, service.Devices().IsActive(device.ID))
+
+	require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) == 3 }, time.Second, 10*time.Millisecond)
+	messages := tws.GetReceivedMessages()
+
+	list := parseResponse(t, messages[1])
+	assert.Equal(t, string(models.MessageTypeDeviceList), list["type"])
+	devices := list["data"].(map[string]interface{})["devices"].([]interface{})
+	require.Len(t, devices, 1)
+	entry := devices[0].(map[string]interface{})
+	assert.Equal(t, "kitchen", entry["name"])
+	assert.NotContains(t, entry, "hash")
+
+	// Unknown devices are rejected
+	data, _ = json.Marshal(map[string]string{"device_id": "missing"})
+	assert.Error(t, handler.HandleDeviceRevoke(ctx, session, data))
+}
+
+func TestDeviceHandlers_NonAdmin(t *testing.T) {
+	ctx := context.Background()
+	handler, service, session, tws := createDeviceTestSetup(t)
+
+	_, own, err := service.Devices().Register("phone")
+	require.NoError(t, err)
+	_, other, err := service.Devices().Register("tablet")
+	require.NoError(t, err)
+
+	session.SetIdentity(&models.Identity{ID: own.ID, Name: own.Name, Method: models.AuthMethodDevice})
+
+	// Devices only see themselves
+	require.NoError(t, handler.HandleDeviceList(ctx, session, nil))
+	require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) == 1 }, time.Second, 10*time.Millisecond)
+	list := parseResponse(t, tws.GetReceivedMessages()[0])
+	devices := list["data"].(map[string]interface{})["devices"].([]interface{})
+	require.Len(t, devices, 1)
+	assert.Equal(t, own.ID, devices[0].(map[string]interface{})["id"])
+
+	// and cannot manage other devices
+	data, _ := json.Marshal(map[string]string{"device_id": other.ID})
+	err = handler.HandleDeviceRevoke(ctx, session, data)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+	assert.True(t, service.Devices().IsActive(other.ID))
+
+	data, _ = json.Marshal(map[string]string{"device_id": other.ID, "name": "mine"})
+	err = handler.HandleDeviceRename(ctx, session, data)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	// Admin tokens manage every device
+	session.SetIdentity(&models.Identity{ID: "tok", Method: models.AuthMethodToken, Admin: true})
+	data, _ = json.Marshal(map[string]string{"device_id": other.ID})
+	require.NoError(t, handler.HandleDeviceRevoke(ctx, session, data))
+	assert.False(t, service.Devices().IsActive(other.ID))
+}
+
+func TestHandlers_CheckIdentity(t *testing.T) {
+	service, err := auth.NewService(t.TempDir())
+	require.NoError(t, err)
+
+	h := &Handlers{verifier: service}
+	session := createTestSession("test-session")
+
+	// Pairing sessions may only pair
+	session.SetIdentity(&models.Identity{Name: "pairing", Method: models.AuthMethodPairing})
+	err = h.checkIdentity(session, &models.ClientMessage{Type: models.MessageTypeProjectList})
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+	assert.NoError(t, h.checkIdentity(session, &models.ClientMessage{Type: models.MessageTypePair}))
+
+	// Revoked credentials stop working on open connections
+	_, token, err := service.Tokens().Issue("cli", 0)
+	require.NoError(t, err)
+	session.SetIdentity(&models.Identity{ID: token.ID, Method: models.AuthMethodToken})
+	assert.NoError(t, h.checkIdentity(session, &models.ClientMessage{Type: models.MessageTypeProjectList}))
+
+	require.NoError(t, service.Tokens().Revoke(token.ID))
+	err = h.checkIdentity(session, &models.ClientMessage{Type: models.MessageTypeProjectList})
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized))
+}
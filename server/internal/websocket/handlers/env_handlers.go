@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/env"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// EnvHandlers provides handlers for project environment messages
+type EnvHandlers struct {
+	projectMgr *project.Manager
+	env        *env.Manager
+	log        *logger.Logger
+	audit      *audit.Log
+}
+
+// NewEnvHandlers creates new environment handlers. A nil manager disables
+// project environments.
+func NewEnvHandlers(projectMgr *project.Manager, envMgr *env.Manager, log *logger.Logger) *EnvHandlers {
+	return &EnvHandlers{
+		projectMgr: projectMgr,
+		env:        envMgr,
+		log:        log,
+	}
+}
+
+// envRequest names a project and optionally a variable, its value or the
+// environment mode
+type envRequest struct {
+	ProjectID string   `json:"project_id"`
+	Name      string   `json:"name"`
+	Value     string   `json:"value"`
+	Secret    bool     `json:"secret"`
+	Mode      env.Mode `json:"mode"`
+}
+
+// parseEnvRequest decodes an environment request, defaulting to the
+// session's project, and fails when project environments are disabled
+func (h *EnvHandlers) parseEnvRequest(session *models.Session, data json.RawMessage) (envRequest, error) {
+	var req envRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, errors.Wrap(err, errors.CodeValidationFailed, "invalid project environment request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return req, errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	if h.env == nil {
+		return req, errors.New(errors.CodeValidationFailed, "project environments are disabled")
+	}
+
+	return req, nil
+}
+
+// HandleEnvGet returns a project's environment. Secret values are never
+// included. Executors may read it, since their executions see it anyway.
+func (h *EnvHandlers) HandleEnvGet(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseEnvRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	environment, err := h.env.Get(project.ID)
+	if err != nil {
+		return err
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectEnvGet, map[string]interface{}{
+		"project_id": project.ID,
+		"mode":       environment.Mode,
+		"variables":  environment.Variables,
+	})
+}
+
+// HandleEnvSet defines or replaces a variable of a project's environment.
+// Only owners may change it; the value is not echoed for secrets.
+func (h *EnvHandlers) HandleEnvSet(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseEnvRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("name", req.Name)
+	event.Set("secret", req.Secret)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	variable, err := h.env.Set(project.ID, req.Name, req.Value, req.Secret, session.GetIdentity().String())
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Project environment variable set",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"name", variable.Name,
+		"secret", variable.Secret,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectEnvSet, map[string]interface{}{
+		"project_id": project.ID,
+		"variable":   variable,
+	})
+}
+
+// HandleEnvUnset removes a variable from a project's environment
+func (h *EnvHandlers) HandleEnvUnset(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseEnvRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.Name == "" {
+		return errors.New(errors.CodeValidationFailed, "name is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("name", req.Name)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.env.Unset(project.ID, req.Name); err != nil {
+		return err
+	}
+
+	h.log.Info("Project environment variable removed",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"name", req.Name,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectEnvUnset, map[string]interface{}{
+		"project_id": project.ID,
+		"name":       req.Name,
+		"status":     "deleted",
+	})
+}
+
+// HandleEnvSetMode chooses whether a project's executions inherit the
+// server's environment or only its allowlisted variables
+func (h *EnvHandlers) HandleEnvSetMode(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseEnvRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("mode", req.Mode)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	environment, err := h.env.SetMode(project.ID, req.Mode)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Project environment mode changed",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"mode", environment.Mode,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectEnvSetMode, map[string]interface{}{
+		"project_id": project.ID,
+		"mode":       environment.Mode,
+		"variables":  environment.Variables,
+	})
+}
+
+// RegisterHandlers registers all environment handlers with the router
+func (h *EnvHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeProjectEnvGet, h.HandleEnvGet)
+	router.Register(models.MessageTypeProjectEnvSet, audited(h.audit, h.log, models.MessageTypeProjectEnvSet, h.HandleEnvSet))
+	router.Register(models.MessageTypeProjectEnvUnset, audited(h.audit, h.log, models.MessageTypeProjectEnvUnset, h.HandleEnvUnset))
+	router.Register(models.MessageTypeProjectEnvSetMode, audited(h.audit, h.log, models.MessageTypeProjectEnvSetMode, h.HandleEnvSetMode))
+}
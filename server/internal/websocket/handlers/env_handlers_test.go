@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/env"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvHandlers(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+
+	// Environments are disabled without a manager
+	disabled := NewEnvHandlers(setup.manager, nil, logger.New("debug"))
+	owner, ownerWS := newIdentitySession(t, "owner-session", testOwner)
+	owner.SetProject(setup.project.ID)
+	err := disabled.HandleEnvGet(ctx, owner, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	envMgr, err := env.NewManager(t.TempDir(), env.Config{})
+	require.NoError(t, err)
+	handler := NewEnvHandlers(setup.manager, envMgr, logger.New("debug"))
+
+	require.NoError(t, handler.HandleEnvSet(ctx, owner, []byte(`{"name":"API_TOKEN","value":"tok-very-secret","secret":true}`)))
+	variable := lastResponseData(t, ownerWS, 1)["variable"].(map[string]interface{})
+	assert.Equal(t, "API_TOKEN", variable["name"])
+	assert.Nil(t, variable["value"])
+	assert.Equal(t, testOwner.String(), variable["updated_by"])
+
+	require.NoError(t, handler.HandleEnvSet(ctx, owner, []byte(`{"name":"REGION","value":"eu-west-1"}`)))
+	lastResponseData(t, ownerWS, 2)
+
+	// Secret values are never returned
+	require.NoError(t, handler.HandleEnvGet(ctx, owner, nil))
+	data := lastResponseData(t, ownerWS, 3)
+	assert.Equal(t, "inherit", data["mode"])
+	variables := data["variables"].([]interface{})
+	require.Len(t, variables, 2)
+	assert.Nil(t, variables[0].(map[string]interface{})["value"])
+	assert.Equal(t, "eu-west-1", variables[1].(map[string]interface{})["value"])
+
+	require.NoError(t, handler.HandleEnvSetMode(ctx, owner, []byte(`{"mode":"allowlist"}`)))
+	assert.Equal(t, "allowlist", lastResponseData(t, ownerWS, 4)["mode"])
+
+	// Observers can neither read nor change the environment
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+	err = handler.HandleEnvGet(ctx, observer, nil)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+	err = handler.HandleEnvSet(ctx, observer, []byte(`{"name":"REGION","value":"us-east-1"}`))
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.NoError(t, handler.HandleEnvUnset(ctx, owner, []byte(`{"name":"API_TOKEN"}`)))
+	assert.Equal(t, "deleted", lastResponseData(t, ownerWS, 5)["status"])
+
+	err = handler.HandleEnvUnset(ctx, owner, []byte(`{"name":"API_TOKEN"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeEnvVarNotFound))
+	err = handler.HandleEnvUnset(ctx, owner, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
@@ -3,38 +3,103 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/boyd/pocket_agent/server/internal/audit"
 	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/history"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
 	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/boyd/pocket_agent/server/internal/usage"
 	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/boyd/pocket_agent/server/internal/worktree"
 )
 
 // ExecutionHandlers provides handlers for execution-related WebSocket messages
 type ExecutionHandlers struct {
-	projectMgr *project.Manager
-	executor   *executor.ClaudeExecutor
-	log        *logger.Logger
-	broadcast  *Broadcaster
+	projectMgr  *project.Manager
+	executor    *executor.ClaudeExecutor
+	log         *logger.Logger
+	broadcast   *Broadcaster
+	audit       *audit.Log
+	queue       *queue.Manager // nil rejects executes while a run is active
+	permissions *permission.Broker
+	history     *history.Store    // nil keeps no execution records
+	worktrees   *worktree.Manager // nil rejects worktree executions
+	sessions    *sessions.Manager // nil keeps no session catalogue
+	recovery    *recovery.Store   // nil stops no processes left running
+	usage       *usage.Ledger     // nil keeps no usage accounts or budgets
+	metrics     UsageMetrics      // nil reports no usage metrics
+
+	// mu serializes starting runs so a project never runs two at once
+	mu sync.Mutex
+	// runs tracks the active run of each project
+	runs map[string]*run
+	// interrupted holds each project's runs the last restart interrupted,
+	// until the project runs again
+	interrupted map[string][]models.Interruption
+}
+
+// run is an execution started by the handlers
+type run struct {
+	// executionID identifies the run's execution record
+	executionID string
+	// startedBy is the principal the run's usage is accounted to
+	startedBy string
+	// killed is set when agent_kill stopped the run, which then ends IDLE
+	// rather than in ERROR
+	killed bool
+	// worktree is the worktree the run executes in, if any
+	worktree *models.Worktree
+	// fork is the session fork the run carries out, if any
+	fork *sessions.Fork
+}
+
+// ExecutionServices are the optional services runs use. A nil service
+// disables what it provides.
+type ExecutionServices struct {
+	Queue       *queue.Manager
+	Permissions *permission.Broker
+	History     *history.Store
+	Worktrees   *worktree.Manager
+	Sessions    *sessions.Manager
+	Recovery    *recovery.Store
+	Usage       *usage.Ledger
+	Metrics     UsageMetrics
 }
 
 // NewExecutionHandlers creates new execution handlers
-func NewExecutionHandlers(projectMgr *project.Manager, executor *executor.ClaudeExecutor, broadcast *Broadcaster, log *logger.Logger) *ExecutionHandlers {
+func NewExecutionHandlers(projectMgr *project.Manager, executor *executor.ClaudeExecutor, broadcast *Broadcaster, services ExecutionServices, log *logger.Logger) *ExecutionHandlers {
 	return &ExecutionHandlers{
-		projectMgr: projectMgr,
-		executor:   executor,
-		log:        log,
-		broadcast:  broadcast,
+		projectMgr:  projectMgr,
+		executor:    executor,
+		log:         log,
+		broadcast:   broadcast,
+		queue:       services.Queue,
+		permissions: services.Permissions,
+		history:     services.History,
+		worktrees:   services.Worktrees,
+		sessions:    services.Sessions,
+		recovery:    services.Recovery,
+		usage:       services.Usage,
+		metrics:     services.Metrics,
+		runs:        make(map[string]*run),
+		interrupted: make(map[string][]models.Interruption),
 	}
 }
 
-// HandleExecute handles Claude execution requests
+// HandleExecute handles Claude execution requests. Prompts sent while the
+// project is executing are queued and started when the run finishes.
 // Requirements: 3.1, 3.2, 3.3, 3.4, 3.5, 3.6
 func (h *ExecutionHandlers) HandleExecute(ctx context.Context, session *models.Session, data json.RawMessage) error {
-	var req executor.ExecuteCommand
+	var req models.ExecuteCommand
 	if err := json.Unmarshal(data, &req); err != nil {
 		return errors.Wrap(err, errors.CodeValidationFailed, "invalid execute request")
 	}
@@ -49,59 +114,381 @@ func (h *ExecutionHandlers) HandleExecute(ctx context.Context, session *models.S
 		return errors.New(errors.CodeValidationFailed, "prompt is required")
 	}
 
+	// Record what was requested, including executions the policy rejects
+	event := audit.EventFromContext(ctx)
+	event.SetProject(projectID)
+	event.SetDigest("prompt", req.Prompt)
+	if req.Options != nil {
+		event.Set("options", req.Options)
+	}
+
 	h.log.Info("Executing Claude command",
 		"session_id", session.ID,
 		"project_id", projectID,
 		"prompt_length", len(req.Prompt),
 	)
 
-	// Get project
-	project, err := h.projectMgr.GetProjectByID(projectID)
+	// Get project; running executions requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, projectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	// Enforce the project's policy profile before changing any state
+	options, err := executeOptions(req)
 	if err != nil {
 		return err
 	}
+	violations, err := h.executor.ApplyPolicy(project, &options)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		event.Set("policy_adjustments", violations)
+	}
 
-	// Update project state to EXECUTING
-	if err := h.projectMgr.UpdateProjectState(projectID, models.StateExecuting); err != nil {
+	// Spent budgets block new executions, queued or not
+	if err := h.checkBudgets(projectID, session.GetIdentity().String()); err != nil {
 		return err
 	}
 
+	response := map[string]interface{}{
+		"project_id": projectID,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	}
+
+	// Report options the policy clamped
+	if len(violations) > 0 {
+		response["policy_adjustments"] = violations
+	}
+
+	// Runs in a worktree start right away, alongside the project's others
+	if worktreeOpts := worktreeOptions(req); worktreeOpts != nil {
+		r, err := h.startInWorktree(project, options, *worktreeOpts, session.GetIdentity().String())
+		if err != nil {
+			return err
+		}
+		event.Set("worktree_id", r.worktree.ID)
+		if r.executionID != "" {
+			event.Set("execution_id", r.executionID)
+			response["execution_id"] = r.executionID
+		}
+
+		response["status"] = "started"
+		response["worktree"] = r.worktree
+		return websocket.SendSuccess(session, models.MessageTypeExecute, response)
+	}
+
+	h.mu.Lock()
+	queued, err := h.busy(projectID)
+	if err != nil {
+		h.mu.Unlock()
+		return err
+	}
+
+	if queued {
+		// Wait behind the active run and earlier prompts
+		item, position, err := h.queue.Push(projectID, req, session.GetIdentity().String())
+		h.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		event.Set("queue_id", item.ID)
+
+		h.log.Info("Queued Claude command",
+			"session_id", session.ID,
+			"project_id", projectID,
+			"queue_id", item.ID,
+			"position", position,
+		)
+
+		// Prompts left over from a restart start right away
+		broadcastQueue(h.queue, h.broadcast, h.log, project)
+		h.drain(projectID)
+
+		response["status"] = "queued"
+		response["queue_id"] = item.ID
+		response["position"] = position
+		return websocket.SendSuccess(session, models.MessageTypeExecute, response)
+	}
+
+	// Update project state to EXECUTING and execute asynchronously
+	r, err := h.start(project, options, session.GetIdentity().String(), "")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if r.executionID != "" {
+		event.Set("execution_id", r.executionID)
+		response["execution_id"] = r.executionID
+	}
+
 	// Broadcast state change to all subscribers
 	h.broadcast.BroadcastProjectState(project)
 
-	// Execute Claude command asynchronously
-	go h.executeClaudeCommand(ctx, session, project, req)
-
 	// Send immediate acknowledgment
-	return websocket.SendSuccess(session, models.MessageTypeExecute, map[string]interface{}{
-		"project_id": projectID,
-		"status":     "started",
-		"timestamp":  time.Now().Format(time.RFC3339),
+	response["status"] = "started"
+	return websocket.SendSuccess(session, models.MessageTypeExecute, response)
+}
+
+// executeOptions converts an execute command into execution options
+func executeOptions(cmd models.ExecuteCommand) (executor.ExecuteOptions, error) {
+	timeout, err := executor.TimeoutFromSeconds(cmd.TimeoutSeconds)
+	if err != nil {
+		return executor.ExecuteOptions{}, err
+	}
+	options := executor.ExecuteOptions{
+		Prompt:  cmd.Prompt,
+		Timeout: timeout,
+	}
+
+	if cmd.Options != nil {
+		options.DangerouslySkipPermissions = cmd.Options.DangerouslySkipPermissions
+		options.AllowedTools = cmd.Options.AllowedTools
+		options.DisallowedTools = cmd.Options.DisallowedTools
+		options.MCPConfig = cmd.Options.MCPConfig
+		options.AppendSystemPrompt = cmd.Options.AppendSystemPrompt
+		options.PermissionMode = cmd.Options.PermissionMode
+		options.Model = cmd.Options.Model
+		options.FallbackModel = cmd.Options.FallbackModel
+		options.AddDirs = cmd.Options.AddDirs
+		options.StrictMCPConfig = cmd.Options.StrictMCPConfig
+		options.Interactive = cmd.Options.Interactive
+	}
+
+	return options, nil
+}
+
+// claudeOptions converts execution options back into the options of an
+// execute command, as recorded in execution records
+func claudeOptions(options executor.ExecuteOptions) *models.ClaudeOptions {
+	var worktree *models.WorktreeOptions
+	if options.Worktree != nil {
+		worktree = &models.WorktreeOptions{Base: options.Worktree.Base, Branch: options.Worktree.Branch}
+	}
+
+	return &models.ClaudeOptions{
+		DangerouslySkipPermissions: options.DangerouslySkipPermissions,
+		AllowedTools:               options.AllowedTools,
+		DisallowedTools:            options.DisallowedTools,
+		MCPConfig:                  options.MCPConfig,
+		AppendSystemPrompt:         options.AppendSystemPrompt,
+		PermissionMode:             options.PermissionMode,
+		Model:                      options.Model,
+		FallbackModel:              options.FallbackModel,
+		AddDirs:                    options.AddDirs,
+		StrictMCPConfig:            options.StrictMCPConfig,
+		Interactive:                options.Interactive,
+		Worktree:                   worktree,
+	}
+}
+
+// busy reports whether a new prompt for the project has to be queued: a run
+// is active or earlier prompts are waiting. Without a queue an active run
+// rejects the prompt. Callers hold h.mu.
+func (h *ExecutionHandlers) busy(projectID string) (bool, error) {
+	if h.queue == nil {
+		if h.runs[projectID] != nil {
+			return false, errors.New(errors.CodeProcessActive, "project already has an active execution").
+				WithDetail("project_id", projectID)
+		}
+		return false, nil
+	}
+
+	if h.runs[projectID] != nil {
+		return true, nil
+	}
+
+	waiting, err := h.queue.Len(projectID)
+	return waiting > 0, err
+}
+
+// whileIdle calls fn unless a run is active in the project's directory, and
+// keeps runs from starting there until fn returns. It fails with
+// PROCESS_ACTIVE while the project is executing; action names what was
+// refused.
+func (h *ExecutionHandlers) whileIdle(projectID, action string, fn func() error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.runs[projectID] != nil {
+		return errors.New(errors.CodeProcessActive, "cannot %s while executing", action).
+			WithDetail("project_id", projectID)
+	}
+	return fn()
+}
+
+// start marks the project as executing, records the execution and runs
+// Claude in the background. Callers hold h.mu.
+func (h *ExecutionHandlers) start(project *models.Project, options executor.ExecuteOptions, startedBy, queueID string) (*run, error) {
+	if err := h.projectMgr.UpdateProjectState(project.ID, models.StateExecuting); err != nil {
+		return nil, err
+	}
+
+	r := &run{startedBy: startedBy}
+	if r.fork = h.pendingFork(project, options); r.fork != nil {
+		options.ForkSession = true
+	}
+	r.executionID = h.record(project, options, startedBy, queueID)
+	options.ExecutionID = r.executionID
+	options.OnStall = h.reportStall(project, r)
+
+	h.runs[project.ID] = r
+	delete(h.interrupted, project.ID)
+	go h.executeClaudeCommand(project, options, r)
+	return r, nil
+}
+
+// reportStall returns the hook that warns the project's subscribers when the
+// run's process stalls
+func (h *ExecutionHandlers) reportStall(project *models.Project, r *run) func(executor.Stall) {
+	return func(stall executor.Stall) {
+		h.broadcast.BroadcastExecutionStalled(project, r.executionID, stall)
+	}
+}
+
+// record records the start of a run and returns its execution ID, or ""
+// when no record is kept. A run without a record is still better than no
+// run.
+func (h *ExecutionHandlers) record(project *models.Project, options executor.ExecuteOptions, startedBy, queueID string) string {
+	if h.history == nil {
+		return ""
+	}
+
+	// Runs in a worktree start a conversation of their own
+	sessionID := project.SessionID
+	if options.Worktree != nil {
+		sessionID = ""
+	}
+
+	rec, err := h.history.Start(history.Record{
+		ProjectID: project.ID,
+		Prompt:    h.executor.RedactText(project, options.Prompt),
+		Options:   claudeOptions(options),
+		StartedBy: startedBy,
+		QueueID:   queueID,
+		Worktree:  options.Worktree,
+		SessionID: sessionID,
 	})
+	if err != nil {
+		h.log.Error("Failed to record execution", "project_id", project.ID, "error", err)
+		return ""
+	}
+	return rec.ID
 }
 
-// executeClaudeCommand runs Claude execution and handles results with streaming
-func (h *ExecutionHandlers) executeClaudeCommand(ctx context.Context, session *models.Session, project *models.Project, req executor.ExecuteCommand) {
-	startTime := time.Now()
+// drain starts the next queued prompt if the project is idle. Prompts the
+// project's policy or budgets reject by now are dropped and reported to
+// subscribers.
+func (h *ExecutionHandlers) drain(projectID string) {
+	if h.queue == nil {
+		return
+	}
 
-	// Build execution options
-	options := executor.ExecuteOptions{
-		Prompt:  req.Prompt,
-		Timeout: h.executor.DefaultTimeout(),
+	h.mu.Lock()
+	project, dropped, started := h.startNext(projectID)
+	h.mu.Unlock()
+
+	if project == nil {
+		return
+	}
+	for _, err := range dropped {
+		h.broadcast.BroadcastError(project, err)
 	}
+	if started || len(dropped) > 0 {
+		broadcastQueue(h.queue, h.broadcast, h.log, project)
+	}
+	if started {
+		h.broadcast.BroadcastProjectState(project)
+	}
+}
 
-	if req.Options != nil {
-		options.DangerouslySkipPermissions = req.Options.DangerouslySkipPermissions
-		options.AllowedTools = req.Options.AllowedTools
-		options.DisallowedTools = req.Options.DisallowedTools
-		options.MCPConfig = req.Options.MCPConfig
-		options.AppendSystemPrompt = req.Options.AppendSystemPrompt
-		options.PermissionMode = req.Options.PermissionMode
-		options.Model = req.Options.Model
-		options.FallbackModel = req.Options.FallbackModel
-		options.AddDirs = req.Options.AddDirs
-		options.StrictMCPConfig = req.Options.StrictMCPConfig
+// startNext pops queued prompts until one starts. Prompts rejected by the
+// project's policy or a spent budget are dropped. Callers hold h.mu.
+func (h *ExecutionHandlers) startNext(projectID string) (project *models.Project, dropped []error, started bool) {
+	if h.runs[projectID] != nil {
+		return nil, nil, false
+	}
+
+	project, err := h.projectMgr.GetProjectByID(projectID)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	for {
+		item, ok, err := h.queue.Pop(projectID)
+		if err != nil {
+			h.log.Error("Failed to read prompt queue", "project_id", projectID, "error", err)
+			return project, dropped, false
+		}
+		if !ok {
+			return project, dropped, false
+		}
+
+		options, err := executeOptions(item.Command)
+		if err == nil {
+			_, err = h.executor.ApplyPolicy(project, &options)
+		}
+		if err != nil {
+			h.log.Warn("Dropped queued Claude command",
+				"project_id", projectID,
+				"queue_id", item.ID,
+				"error", err,
+			)
+			dropped = append(dropped, withQueueID(err, item.ID))
+			continue
+		}
+
+		if err := h.checkBudgets(projectID, item.QueuedBy); err != nil {
+			h.log.Warn("Dropped queued Claude command",
+				"project_id", projectID,
+				"queue_id", item.ID,
+				"error", err,
+			)
+			dropped = append(dropped, withQueueID(err, item.ID))
+			continue
+		}
+
+		r, err := h.start(project, options, item.QueuedBy, item.ID)
+		if err != nil {
+			h.log.Error("Failed to start queued Claude command",
+				"project_id", projectID,
+				"queue_id", item.ID,
+				"error", err,
+			)
+			return project, append(dropped, withQueueID(err, item.ID)), false
+		}
+
+		h.log.Info("Started queued Claude command",
+			"project_id", projectID,
+			"queue_id", item.ID,
+			"queued_by", item.QueuedBy,
+			"execution_id", r.executionID,
+			"waited", time.Since(item.QueuedAt),
+		)
+		return project, dropped, true
 	}
+}
+
+// DrainQueues starts the prompts left queued by a previous server run
+func (h *ExecutionHandlers) DrainQueues() {
+	for _, project := range h.projectMgr.GetAllProjects() {
+		h.drain(project.ID)
+	}
+}
+
+// withQueueID tags the error of a dropped queued prompt with its queue ID
+func withQueueID(err error, queueID string) error {
+	appErr, ok := err.(*errors.AppError)
+	if !ok {
+		appErr = errors.NewInternalError(err)
+	}
+	return appErr.WithDetail("queue_id", queueID)
+}
+
+// executeClaudeCommand runs Claude execution and handles results with streaming
+func (h *ExecutionHandlers) executeClaudeCommand(project *models.Project, options executor.ExecuteOptions, r *run) {
+	startTime := time.Now()
 
 	// Flag to track if session ID was updated
 	var sessionUpdated bool
@@ -162,15 +549,32 @@ func (h *ExecutionHandlers) executeClaudeCommand(ctx context.Context, session *m
 		}
 	}
 
-	// Update project state
+	// Update project state; a killed run ends IDLE
+	h.mu.Lock()
+	killed := r.killed
+	if killed {
+		newState = models.StateIdle
+	}
 	if err := h.projectMgr.UpdateProjectState(project.ID, newState); err != nil {
 		h.log.Error("Failed to update project state", "error", err)
 	}
+	delete(h.runs, project.ID)
+	h.mu.Unlock()
+
+	h.finishRecord(project.ID, r, response, err, killed)
+	h.catalogueSession(project, options, r, response)
+	h.broadcastChanges(project, r, response)
+	if response != nil {
+		h.recordUsage(project.ID, r, response.Usage)
+	}
 
 	// Get updated project and broadcast final state
 	if updatedProject, err := h.projectMgr.GetProjectByID(project.ID); err == nil {
 		h.broadcast.BroadcastProjectState(updatedProject)
 	}
+
+	// Start the next queued prompt
+	h.drain(project.ID)
 }
 
 // HandleAgentNewSession handles session reset requests
@@ -191,19 +595,21 @@ func (h *ExecutionHandlers) HandleAgentNewSession(ctx context.Context, session *
 		return errors.New(errors.CodeValidationFailed, "project_id is required")
 	}
 
+	audit.EventFromContext(ctx).SetProject(projectID)
+
 	h.log.Info("Resetting Claude session",
 		"session_id", session.ID,
 		"project_id", projectID,
 	)
 
-	// Get project
-	project, err := h.projectMgr.GetProjectByID(projectID)
+	// Get project; resetting the conversation requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, projectID, models.RoleExecutor)
 	if err != nil {
 		return err
 	}
 
 	// Check if project is currently executing
-	if project.State == models.StateExecuting {
+	if project.State.Active() {
 		return errors.New(errors.CodeProcessActive, "cannot reset session while executing")
 	}
 
@@ -213,6 +619,18 @@ func (h *ExecutionHandlers) HandleAgentNewSession(ctx context.Context, session *
 		return err
 	}
 
+	// Tools always allowed in the old conversation must be approved again
+	if h.permissions != nil {
+		h.permissions.Reset(projectID)
+	}
+
+	// The old session stays in the catalogue, but is no longer forked
+	if h.sessions != nil {
+		if err := h.sessions.CancelFork(projectID); err != nil {
+			h.log.Error("Failed to cancel session fork", "project_id", projectID, "error", err)
+		}
+	}
+
 	h.log.Info("Claude session reset",
 		"session_id", session.ID,
 		"project_id", projectID,
@@ -242,28 +660,61 @@ func (h *ExecutionHandlers) HandleAgentNewSession(ctx context.Context, session *
 // HandleAgentKill handles process termination requests
 // Requirements: 5.1, 5.2, 5.3, 5.4
 func (h *ExecutionHandlers) HandleAgentKill(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID  string `json:"project_id"`
+		WorktreeID string `json:"worktree_id"`
+	}
+	if len(data) > 0 {
+		json.Unmarshal(data, &req)
+	}
+
 	// Get project ID from session or request
 	projectID := session.GetProject()
 	if projectID == "" {
-		var req struct {
-			ProjectID string `json:"project_id"`
-		}
-		if err := json.Unmarshal(data, &req); err == nil && req.ProjectID != "" {
-			projectID = req.ProjectID
-		}
+		projectID = req.ProjectID
 	}
 
 	if projectID == "" {
 		return errors.New(errors.CodeValidationFailed, "project_id is required")
 	}
 
+	event := audit.EventFromContext(ctx)
+	event.SetProject(projectID)
+	if req.WorktreeID != "" {
+		event.Set("worktree_id", req.WorktreeID)
+	}
+
 	h.log.Info("Killing Claude process",
 		"session_id", session.ID,
 		"project_id", projectID,
+		"worktree_id", req.WorktreeID,
 	)
 
+	// Stopping executions requires the executor role
+	if _, err := authorizeProject(h.projectMgr, session, projectID, models.RoleExecutor); err != nil {
+		return err
+	}
+
+	if req.WorktreeID != "" {
+		return h.killInWorktree(session, projectID, req.WorktreeID)
+	}
+
+	// Mark the run first so it ends IDLE and is recorded as killed
+	h.mu.Lock()
+	killed := h.runs[projectID]
+	if killed != nil {
+		killed.killed = true
+	}
+	h.mu.Unlock()
+
 	// Kill the process
 	if err := h.executor.KillExecution(projectID); err != nil {
+		h.mu.Lock()
+		if killed != nil {
+			killed.killed = false
+		}
+		h.mu.Unlock()
+
 		// Check if it's because no process is active
 		if appErr, ok := err.(*errors.AppError); ok && appErr.Code == errors.CodeProcessNotFound {
 			return errors.New(errors.CodeProcessNotFound, "no active execution for project").
@@ -272,10 +723,15 @@ func (h *ExecutionHandlers) HandleAgentKill(ctx context.Context, session *models
 		return err
 	}
 
-	// Update project state to IDLE
-	if err := h.projectMgr.UpdateProjectState(projectID, models.StateIdle); err != nil {
-		h.log.Error("Failed to update project state after kill", "error", err)
+	// Update project state to IDLE, unless the next queued prompt already
+	// started. A run that is still finishing settles on IDLE itself.
+	h.mu.Lock()
+	if h.runs[projectID] == nil {
+		if err := h.projectMgr.UpdateProjectState(projectID, models.StateIdle); err != nil {
+			h.log.Error("Failed to update project state after kill", "error", err)
+		}
 	}
+	h.mu.Unlock()
 
 	h.log.Info("Claude process killed",
 		"session_id", session.ID,
@@ -301,9 +757,78 @@ func (h *ExecutionHandlers) HandleAgentKill(ctx context.Context, session *models
 	})
 }
 
+// HandleSendInput sends a follow-up turn to the project's interactive
+// execution, or ends its input so it exits after the current turn
+func (h *ExecutionHandlers) HandleSendInput(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Prompt    string `json:"prompt"`
+		Close     bool   `json:"close"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid send_input request")
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	if req.Prompt == "" && !req.Close {
+		return errors.New(errors.CodeValidationFailed, "prompt is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	if req.Prompt != "" {
+		event.SetDigest("prompt", req.Prompt)
+	}
+	if req.Close {
+		event.Set("close", true)
+	}
+
+	// Talking to the agent requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	if req.Prompt != "" {
+		if err := h.executor.SendInput(project, req.Prompt); err != nil {
+			return err
+		}
+	}
+	if req.Close {
+		if err := h.executor.CloseInput(req.ProjectID); err != nil {
+			return err
+		}
+	}
+
+	h.log.Info("Sent input to Claude",
+		"session_id", session.ID,
+		"project_id", req.ProjectID,
+		"prompt_length", len(req.Prompt),
+		"close", req.Close,
+	)
+
+	status := "sent"
+	if req.Close {
+		status = "closed"
+	}
+	return websocket.SendSuccess(session, models.MessageTypeSendInput, map[string]interface{}{
+		"project_id": req.ProjectID,
+		"status":     status,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+}
+
 // RegisterHandlers registers all execution handlers with the router
 func (h *ExecutionHandlers) RegisterHandlers(router *websocket.MessageRouter) {
-	router.Register(models.MessageTypeExecute, h.HandleExecute)
-	router.Register(models.MessageTypeAgentNewSession, h.HandleAgentNewSession)
-	router.Register(models.MessageTypeAgentKill, h.HandleAgentKill)
+	router.Register(models.MessageTypeExecute, audited(h.audit, h.log, models.MessageTypeExecute, h.HandleExecute))
+	router.Register(models.MessageTypeAgentNewSession, audited(h.audit, h.log, models.MessageTypeAgentNewSession, h.HandleAgentNewSession))
+	router.Register(models.MessageTypeAgentKill, audited(h.audit, h.log, models.MessageTypeAgentKill, h.HandleAgentKill))
+	router.Register(models.MessageTypeAgentPause, audited(h.audit, h.log, models.MessageTypeAgentPause, h.HandleAgentPause))
+	router.Register(models.MessageTypeAgentResume, audited(h.audit, h.log, models.MessageTypeAgentResume, h.HandleAgentResume))
+	router.Register(models.MessageTypeSendInput, audited(h.audit, h.log, models.MessageTypeSendInput, h.HandleSendInput))
 }
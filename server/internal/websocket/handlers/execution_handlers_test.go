@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/history"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/workspace"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionHandlers_SendInput(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, promptsFile := promptScript(t)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	// Nothing is running yet
+	err := h.Execution.HandleSendInput(ctx, session, []byte(`{"prompt":"hello"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeProcessNotFound))
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"prompt":  "one",
+		"options": map[string]bool{"interactive": true},
+	})
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, data))
+	require.Eventually(t, func() bool { return h.Execution.executor.IsProjectExecuting(setup.project.ID) }, 5*time.Second, 10*time.Millisecond)
+
+	// Observers cannot talk to the agent
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	projectInput, _ := json.Marshal(map[string]string{"project_id": setup.project.ID, "prompt": "two"})
+	err = h.Execution.HandleSendInput(ctx, observer, projectInput)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.NoError(t, h.Execution.HandleSendInput(ctx, session, []byte(`{"prompt":"two"}`)))
+	assert.Equal(t, "sent", lastResponseData(t, tws, 2)["status"])
+
+	err = h.Execution.HandleSendInput(ctx, session, []byte(`{}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	// Closing the input lets the run finish
+	require.NoError(t, h.Execution.HandleSendInput(ctx, session, []byte(`{"close":true}`)))
+	assert.Equal(t, "closed", lastResponseData(t, tws, 3)["status"])
+
+	require.Eventually(t, func() bool {
+		return stateOf(setup.project) == models.StateIdle && !h.Execution.executor.IsProjectExecuting(setup.project.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	recorded, err := os.ReadFile(promptsFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(recorded)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"text":"one"`)
+	assert.Contains(t, lines[1], `"text":"two"`)
+}
+
+func TestExecutionHandlers_ExecutionRecords(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, _ := promptScript(t)
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	// Records are disabled without a store
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script})
+	err := h.History.HandleExecutionList(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	records := history.NewStore(t.TempDir(), 10)
+	h = createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{ExecutionHistory: records}})
+
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"hello"}`)))
+	executionID, ok := lastResponseData(t, tws, 1)["execution_id"].(string)
+	require.True(t, ok)
+	require.NotEmpty(t, executionID)
+
+	getData, _ := json.Marshal(map[string]string{"execution_id": executionID})
+	require.Eventually(t, func() bool {
+		rec, err := records.Get(setup.project.ID, executionID)
+		return err == nil && rec.Status != history.StatusRunning
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Observers can read the records
+	observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+	require.NoError(t, h.History.HandleExecutionGet(ctx, observer, getData))
+	record := lastResponseData(t, observerWS, 1)
+	assert.Equal(t, executionID, record["id"])
+	assert.Equal(t, string(history.StatusCompleted), record["status"])
+	assert.Equal(t, "hello", record["prompt"])
+	assert.Equal(t, float64(0), record["exit_code"])
+
+	require.NoError(t, h.History.HandleExecutionList(ctx, observer, nil))
+	list := lastResponseData(t, observerWS, 2)
+	executions := list["executions"].([]interface{})
+	require.Len(t, executions, 1)
+	assert.Equal(t, executionID, executions[0].(map[string]interface{})["id"])
+	assert.Equal(t, false, list["has_more"])
+
+	unknown, _ := json.Marshal(map[string]string{"execution_id": uuid.New().String()})
+	err = h.History.HandleExecutionGet(ctx, observer, unknown)
+	assert.True(t, errors.IsCode(err, errors.CodeExecutionNotFound))
+
+	err = h.History.HandleExecutionGet(ctx, observer, []byte(`{}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	require.Eventually(t, func() bool {
+		return stateOf(setup.project) == models.StateIdle && !h.Execution.executor.IsProjectExecuting(setup.project.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func TestExecutionHandlers_WorkspaceChanges(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	require.NoError(t, os.WriteFile(filepath.Join(setup.project.Path, "notes.txt"), []byte("one\ntwo\n"), 0o644))
+
+	// Claude edits a file and creates another
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Script:   "cat > /dev/null\nprintf 'one\\n2\\n' > notes.txt\necho hello > hello.txt\necho '{\"type\":\"result\",\"result\":\"done\"}'\n",
+		Executor: executor.Config{Workspace: workspace.NewCapturer(0)},
+		Config:   Config{ExecutionHistory: history.NewStore(t.TempDir(), 10)},
+	})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+	require.NoError(t, setup.manager.AddSubscriber(setup.project.ID, session))
+
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"hello"}`)))
+
+	// Subscribers are told which files changed
+	received := func(messageType models.MessageType) map[string]interface{} {
+		for _, raw := range tws.GetReceivedMessages() {
+			if msg := parseResponse(t, raw); msg["type"] == string(messageType) {
+				return msg["data"].(map[string]interface{})
+			}
+		}
+		return nil
+	}
+	require.Eventually(t, func() bool { return received(models.MessageTypeWorkspaceChanges) != nil }, 10*time.Second, 50*time.Millisecond)
+	changes := received(models.MessageTypeWorkspaceChanges)
+	executionID := received(models.MessageTypeExecute)["execution_id"].(string)
+	assert.Equal(t, executionID, changes["execution_id"])
+	assert.Equal(t, float64(2), changes["additions"])
+	assert.Equal(t, float64(1), changes["deletions"])
+	files := changes["files"].([]interface{})
+	require.Len(t, files, 2)
+	assert.Equal(t, "hello.txt", files[0].(map[string]interface{})["path"])
+	assert.Equal(t, string(models.FileAdded), files[0].(map[string]interface{})["status"])
+
+	// Observers can fetch the patch, whole or per file
+	observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+	diffData, _ := json.Marshal(map[string]string{"execution_id": executionID})
+	require.NoError(t, h.History.HandleExecutionDiff(ctx, observer, diffData))
+	diff := lastResponseData(t, observerWS, 1)
+	assert.Contains(t, diff["patch"], "+hello\n")
+	assert.Contains(t, diff["patch"], "-two\n+2\n")
+	assert.Len(t, diff["changes"].(map[string]interface{})["files"], 2)
+
+	diffData, _ = json.Marshal(map[string]string{"execution_id": executionID, "path": "notes.txt"})
+	require.NoError(t, h.History.HandleExecutionDiff(ctx, observer, diffData))
+	diff = lastResponseData(t, observerWS, 2)
+	assert.Contains(t, diff["patch"], "-two\n+2\n")
+	assert.NotContains(t, diff["patch"], "hello")
+
+	unknown, _ := json.Marshal(map[string]string{"execution_id": uuid.New().String()})
+	err := h.History.HandleExecutionDiff(ctx, observer, unknown)
+	assert.True(t, errors.IsCode(err, errors.CodeExecutionNotFound))
+
+	require.Eventually(t, func() bool {
+		return stateOf(setup.project) == models.StateIdle && !h.Execution.executor.IsProjectExecuting(setup.project.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+}
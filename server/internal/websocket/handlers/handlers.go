@@ -3,11 +3,23 @@ package handlers
 import (
 	"context"
 
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/env"
+	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/history"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
 	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/boyd/pocket_agent/server/internal/usage"
 	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/boyd/pocket_agent/server/internal/worktree"
 )
 
 // Config contains configuration for all handlers
@@ -18,16 +30,88 @@ type Config struct {
 	BroadcastConfig BroadcasterConfig
 	ClaudePath      string
 	DataDir         string
+	Auth            *auth.Service // nil when authentication is disabled
+	Audit           *audit.Log    // nil disables audit logging
+	// Certificates verifies client certificate identities when
+	// authentication is disabled but a client CA is configured
+	Certificates *auth.CertificateAuthenticator
+
+	// RateLimiter limits inbound messages per session and identity; nil
+	// disables rate limiting
+	RateLimiter *websocket.MessageLimiter
+	// ExecutionQuota caps executions per identity and day; nil disables it
+	ExecutionQuota *quota.Tracker
+	// ExecutionQueue holds prompts sent while a project is executing; nil
+	// rejects them instead
+	ExecutionQueue *queue.Manager
+	// ExecutionHistory records executions; nil keeps no records
+	ExecutionHistory *history.Store
+	// Permissions relays tool permission prompts to clients; nil disables
+	// interactive approval
+	Permissions *permission.Broker
+	// Usage accounts the tokens and cost of executions and enforces
+	// budgets; nil disables both
+	Usage *usage.Ledger
+	// UsageMetrics receives the usage of finished executions; may be nil
+	UsageMetrics UsageMetrics
+	// MaxSchedules caps the scheduled prompts per project; 0 disables them
+	MaxSchedules int
+	// Environment keeps the projects' environment variables; nil disables
+	// project environments
+	Environment *env.Manager
+	// Worktrees runs executions in git worktrees of their project; nil
+	// disables worktree executions
+	Worktrees *worktree.Manager
+	// Sessions catalogues the Claude sessions of each project; nil disables
+	// listing, switching and forking sessions
+	Sessions *sessions.Manager
+	// Recovery records the running processes, so that those a crash left
+	// behind are stopped on the next start; nil stops none
+	Recovery *recovery.Store
 }
 
 // Handlers aggregates all WebSocket handlers
 type Handlers struct {
-	Project   *ProjectHandlers
-	Execution *ExecutionHandlers
-	Query     *QueryHandlers
-	Status    *StatusHandlers
-	Health    *HealthHandlers
-	Broadcast *Broadcaster
+	Project    *ProjectHandlers
+	Execution  *ExecutionHandlers
+	Queue      *QueueHandlers
+	History    *HistoryHandlers
+	Usage      *UsageHandlers
+	Schedule   *ScheduleHandlers
+	Worktree   *WorktreeHandlers
+	Session    *SessionHandlers
+	Backend    *BackendHandlers
+	Query      *QueryHandlers
+	Status     *StatusHandlers
+	Health     *HealthHandlers
+	Device     *DeviceHandlers
+	ACL        *ACLHandlers
+	Policy     *PolicyHandlers
+	Audit      *AuditHandlers
+	Permission *PermissionHandlers
+	Env        *EnvHandlers
+	Broadcast  *Broadcaster
+
+	verifier   IdentityVerifier
+	dispatcher *websocket.MessageDispatcher
+}
+
+// IdentityVerifier reports whether the credentials of an identity were
+// revoked since it authenticated
+type IdentityVerifier interface {
+	Verify(identity *models.Identity) error
+}
+
+// identityVerifier returns the verifier of the configured credentials, or
+// nil when clients are not authenticated
+func identityVerifier(config Config) IdentityVerifier {
+	if config.Auth != nil {
+		return config.Auth
+	}
+	if config.Certificates != nil {
+		return config.Certificates
+	}
+	return nil
 }
 
 // NewHandlers creates all handlers with dependencies
@@ -35,29 +119,119 @@ func NewHandlers(config Config, server ServerStats) *Handlers {
 	// Create broadcaster
 	broadcast := NewBroadcaster(config.BroadcastConfig, config.Logger)
 
-	// Create individual handlers
-	projectHandlers := NewProjectHandlers(config.ProjectManager, broadcast, config.Logger)
-	executionHandlers := NewExecutionHandlers(config.ProjectManager, config.Executor, broadcast, config.Logger)
+	// Create individual handlers; the optional services left nil are
+	// disabled
+	executionHandlers := NewExecutionHandlers(config.ProjectManager, config.Executor, broadcast, ExecutionServices{
+		Queue:       config.ExecutionQueue,
+		Permissions: config.Permissions,
+		History:     config.ExecutionHistory,
+		Worktrees:   config.Worktrees,
+		Sessions:    config.Sessions,
+		Recovery:    config.Recovery,
+		Usage:       config.Usage,
+		Metrics:     config.UsageMetrics,
+	}, config.Logger)
+	// Deleted projects take their state along, and joining clients hear
+	// about the runs a crash interrupted
+	projectHandlers := NewProjectHandlers(config.ProjectManager, broadcast, ProjectServices{
+		Queue:         config.ExecutionQueue,
+		Environment:   config.Environment,
+		Worktrees:     config.Worktrees,
+		Sessions:      config.Sessions,
+		Interruptions: executionHandlers.Interruptions,
+	}, config.Logger)
+	queueHandlers := NewQueueHandlers(config.ProjectManager, config.ExecutionQueue, broadcast, config.Logger)
+	historyHandlers := NewHistoryHandlers(config.ProjectManager, config.ExecutionHistory, config.Logger)
+	usageHandlers := NewUsageHandlers(config.ProjectManager, config.Usage, config.Logger)
+	// Run prompts on a schedule, as long as their creators' credentials and
+	// quota hold
+	scheduleHandlers := NewScheduleHandlers(config.ProjectManager, config.Executor, executionHandlers, broadcast,
+		config.MaxSchedules, identityVerifier(config), config.ExecutionQuota, config.Logger)
+	worktreeHandlers := NewWorktreeHandlers(config.ProjectManager, executionHandlers, config.Worktrees, config.Logger)
+	sessionHandlers := NewSessionHandlers(config.ProjectManager, config.Executor, executionHandlers, config.Sessions,
+		config.Permissions, broadcast, config.Logger)
+	backendHandlers := NewBackendHandlers(config.ProjectManager, config.Executor, executionHandlers, config.Permissions,
+		config.Sessions, broadcast, config.Logger)
 	queryHandlers := NewQueryHandlers(config.ProjectManager, config.Logger)
 	statusHandlers := NewStatusHandlers(config.ProjectManager, config.Executor, broadcast, server, config.Logger)
 	healthHandlers := NewHealthHandlers(config.ClaudePath, config.DataDir, config.Logger)
+	deviceHandlers := NewDeviceHandlers(config.Auth, config.Logger)
+	aclHandlers := NewACLHandlers(config.ProjectManager, config.Logger)
+	policyHandlers := NewPolicyHandlers(config.ProjectManager, config.Executor, config.Logger)
+	auditHandlers := NewAuditHandlers(config.ProjectManager, config.Audit, config.Logger)
+	permissionHandlers := NewPermissionHandlers(config.ProjectManager, config.Executor, config.Permissions, broadcast, config.Logger)
+	envHandlers := NewEnvHandlers(config.ProjectManager, config.Environment, config.Logger)
+
+	// Record security-relevant actions
+	projectHandlers.audit = config.Audit
+	executionHandlers.audit = config.Audit
+	queueHandlers.audit = config.Audit
+	scheduleHandlers.audit = config.Audit
+	worktreeHandlers.audit = config.Audit
+	sessionHandlers.audit = config.Audit
+	backendHandlers.audit = config.Audit
+	deviceHandlers.audit = config.Audit
+	aclHandlers.audit = config.Audit
+	policyHandlers.audit = config.Audit
+	permissionHandlers.audit = config.Audit
+	envHandlers.audit = config.Audit
 
-	return &Handlers{
-		Project:   projectHandlers,
-		Execution: executionHandlers,
-		Query:     queryHandlers,
-		Status:    statusHandlers,
-		Health:    healthHandlers,
-		Broadcast: broadcast,
+	h := &Handlers{
+		Project:    projectHandlers,
+		Execution:  executionHandlers,
+		Queue:      queueHandlers,
+		History:    historyHandlers,
+		Usage:      usageHandlers,
+		Schedule:   scheduleHandlers,
+		Worktree:   worktreeHandlers,
+		Session:    sessionHandlers,
+		Backend:    backendHandlers,
+		Query:      queryHandlers,
+		Status:     statusHandlers,
+		Health:     healthHandlers,
+		Device:     deviceHandlers,
+		ACL:        aclHandlers,
+		Policy:     policyHandlers,
+		Audit:      auditHandlers,
+		Permission: permissionHandlers,
+		Env:        envHandlers,
+		Broadcast:  broadcast,
+		verifier:   identityVerifier(config),
+	}
+
+	// Route messages through rate limits and quotas
+	router := websocket.NewMessageRouter(config.Logger)
+	h.RegisterAll(router)
+	h.dispatcher = websocket.NewMessageDispatcher(router, config.Logger)
+	if config.RateLimiter != nil {
+		h.dispatcher.Use(websocket.RateLimitMiddleware(config.RateLimiter, config.Logger))
 	}
+	if config.ExecutionQuota != nil {
+		h.dispatcher.Use(websocket.QuotaMiddleware(config.ExecutionQuota, models.MessageTypeExecute, config.Logger))
+	}
+
+	return h
 }
 
 // RegisterAll registers all handlers with the router
 func (h *Handlers) RegisterAll(router *websocket.MessageRouter) {
 	h.Project.RegisterHandlers(router)
 	h.Execution.RegisterHandlers(router)
+	h.Queue.RegisterHandlers(router)
+	h.History.RegisterHandlers(router)
+	h.Usage.RegisterHandlers(router)
+	h.Schedule.RegisterHandlers(router)
+	h.Worktree.RegisterHandlers(router)
+	h.Session.RegisterHandlers(router)
+	h.Backend.RegisterHandlers(router)
 	h.Query.RegisterHandlers(router)
 	h.Health.RegisterHandlers(router)
+	h.Device.RegisterHandlers(router)
+	h.ACL.RegisterHandlers(router)
+	h.Policy.RegisterHandlers(router)
+	h.Audit.RegisterHandlers(router)
+	h.Permission.RegisterHandlers(router)
+	h.Env.RegisterHandlers(router)
 }
 
 // Start starts any background tasks (like status broadcasting)
@@ -71,14 +245,31 @@ func (h *Handlers) Stop() {
 	h.Status.Stop()
 }
 
-// HandleMessage implements the MessageHandler interface by using a router
+// HandleMessage implements the MessageHandler interface by dispatching to the
+// registered handlers
 func (h *Handlers) HandleMessage(ctx context.Context, session *models.Session, msg *models.ClientMessage) error {
-	// Create a router and register all handlers
-	router := websocket.NewMessageRouter(h.Project.log)
-	h.RegisterAll(router)
+	if err := h.checkIdentity(session, msg); err != nil {
+		return err
+	}
+
+	return h.dispatcher.HandleMessage(ctx, session, msg)
+}
+
+// checkIdentity rejects messages from sessions whose credentials were revoked
+// after connecting, and restricts pairing sessions to the pair message
+func (h *Handlers) checkIdentity(session *models.Session, msg *models.ClientMessage) error {
+	identity := session.GetIdentity()
+
+	if identity.IsPairing() && msg.Type != models.MessageTypePair {
+		return errors.New(errors.CodeUnauthorized, "pairing connections may only send %s", models.MessageTypePair).
+			WithDetail("message_type", msg.Type)
+	}
+
+	if h.verifier != nil {
+		return h.verifier.Verify(identity)
+	}
 
-	// Route the message
-	return router.HandleMessage(ctx, session, msg)
+	return nil
 }
 
 // OnSessionCleanup implements the MessageHandler interface to clean up session resources
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/boyd/pocket_agent/server/test/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHandlersOptions configures the handlers createTestHandlers builds
+type testHandlersOptions struct {
+	// Script is the body of the mock Claude shell script. Without one the
+	// executor runs Executor.ClaudePath, or /bin/sh.
+	Script string
+	// Executor configures the executor; DefaultTimeout defaults to 10s
+	Executor executor.Config
+	// Config holds the services of the handlers. The project manager,
+	// executor and logger are those of the test.
+	Config Config
+}
+
+// createTestHandlers builds the handlers of the test project with NewHandlers
+func createTestHandlers(t *testing.T, setup *aclTestSetup, opts testHandlersOptions) *Handlers {
+	execCfg := opts.Executor
+	if opts.Script != "" {
+		execCfg.ClaudePath = mocks.WriteClaudeScript(t, opts.Script)
+	}
+	if execCfg.ClaudePath == "" {
+		execCfg.ClaudePath = "/bin/sh"
+	}
+	if execCfg.DefaultTimeout == 0 {
+		execCfg.DefaultTimeout = 10 * time.Second
+	}
+
+	exec, err := executor.NewClaudeExecutor(execCfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = exec.Shutdown(context.Background()) })
+
+	cfg := opts.Config
+	cfg.ProjectManager = setup.manager
+	cfg.Executor = exec
+	cfg.Logger = logger.New("error")
+	cfg.BroadcastConfig = DefaultBroadcasterConfig()
+	return NewHandlers(cfg, nil)
+}
+
+// promptScript returns a mock Claude script that appends each prompt to the
+// returned file and runs for a while
+func promptScript(t *testing.T) (script, promptsFile string) {
+	promptsFile = filepath.Join(t.TempDir(), "prompts.txt")
+	script = "cat >> " + promptsFile + "\necho >> " + promptsFile + "\nsleep 0.5\necho '{\"type\":\"result\",\"result\":\"done\"}'\n"
+	return script, promptsFile
+}
+
+// lastResponseData returns the data of the last message the client received
+func lastResponseData(t *testing.T, tws *testWebSocketServer, count int) map[string]interface{} {
+	require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) >= count }, time.Second, 10*time.Millisecond)
+	messages := tws.GetReceivedMessages()
+	return parseResponse(t, messages[len(messages)-1])["data"].(map[string]interface{})
+}
+
+// stateOf reads a project's state while runs update it
+func stateOf(p *models.Project) models.State {
+	p.RLock()
+	defer p.RUnlock()
+	return p.State
+}
+
+// idle reports whether no run of the handlers is active
+func idle(h *ExecutionHandlers) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.runs) == 0
+}
+
+func TestHandlers_RateLimitsAndQuotas(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+
+	tracker, err := quota.NewTracker(t.TempDir(), quota.Config{Name: "daily_executions", Daily: 1})
+	require.NoError(t, err)
+
+	h := NewHandlers(Config{
+		ProjectManager:  setup.manager,
+		Logger:          logger.New("error"),
+		BroadcastConfig: DefaultBroadcasterConfig(),
+		RateLimiter: websocket.NewMessageLimiter(websocket.MessageLimiterConfig{
+			Default: websocket.MessageLimit{Session: websocket.MessageRate{PerMinute: 1, Burst: 1}},
+			Types: map[models.MessageType]websocket.MessageLimit{
+				models.MessageTypeExecute: {Session: websocket.MessageRate{PerMinute: 60, Burst: 10}},
+			},
+		}),
+		ExecutionQuota: tracker,
+	}, nil)
+
+	session, _ := newIdentitySession(t, "owner-session", testOwner)
+
+	// The second list within a minute is rate limited
+	require.NoError(t, h.HandleMessage(ctx, session, &models.ClientMessage{Type: models.MessageTypeProjectList}))
+	err = h.HandleMessage(ctx, session, &models.ClientMessage{Type: models.MessageTypeProjectList})
+	require.True(t, errors.IsCode(err, errors.CodeRateLimited))
+	assert.Contains(t, err.(*errors.AppError).Details, "retry_after_ms")
+
+	// Failed executions do not count against the daily quota
+	execute := &models.ClientMessage{Type: models.MessageTypeExecute, Data: json.RawMessage(`{"prompt":"hello"}`)}
+	for i := 0; i < 2; i++ {
+		err = h.HandleMessage(ctx, session, execute)
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), "got %v", err)
+	}
+	assert.Equal(t, 0, tracker.Used(testOwner.Principal()))
+}
+
+func TestHandlers_CertificatesWithoutAuth(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+
+	revocationFile := filepath.Join(t.TempDir(), "revoked.txt")
+	revocations, err := auth.NewRevocationList(revocationFile)
+	require.NoError(t, err)
+
+	h := NewHandlers(Config{
+		ProjectManager:  setup.manager,
+		Logger:          logger.New("error"),
+		BroadcastConfig: DefaultBroadcasterConfig(),
+		Certificates:    auth.NewCertificateAuthenticator(revocations),
+	}, nil)
+
+	identity := &models.Identity{ID: "laptop", Method: models.AuthMethodCertificate, Serial: "cafe"}
+	session, _ := newIdentitySession(t, "cert-session", identity)
+	list := &models.ClientMessage{Type: models.MessageTypeProjectList}
+	require.NoError(t, h.HandleMessage(ctx, session, list))
+
+	// Revoked certificates are rejected on open connections
+	require.NoError(t, os.WriteFile(revocationFile, []byte("CA:FE\n"), 0o600))
+	err = h.HandleMessage(ctx, session, list)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized), "got %v", err)
+}
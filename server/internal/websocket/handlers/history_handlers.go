@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/history"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/boyd/pocket_agent/server/internal/workspace"
+)
+
+const (
+	// defaultExecutionListLimit is the page size of execution_list
+	defaultExecutionListLimit = 20
+	// maxExecutionListLimit caps the page size of execution_list
+	maxExecutionListLimit = 100
+)
+
+// HistoryHandlers provides handlers for browsing execution records
+type HistoryHandlers struct {
+	projectMgr *project.Manager
+	history    *history.Store
+	log        *logger.Logger
+}
+
+// NewHistoryHandlers creates new history handlers. A nil store disables
+// execution records.
+func NewHistoryHandlers(projectMgr *project.Manager, store *history.Store, log *logger.Logger) *HistoryHandlers {
+	return &HistoryHandlers{
+		projectMgr: projectMgr,
+		history:    store,
+		log:        log,
+	}
+}
+
+// executionRequest identifies a project's execution records
+type executionRequest struct {
+	ProjectID   string `json:"project_id"`
+	ExecutionID string `json:"execution_id"`
+	Before      string `json:"before"` // RFC3339 timestamp
+	Limit       int    `json:"limit"`
+	Path        string `json:"path"`
+}
+
+// parseExecutionRequest decodes an execution request, defaulting to the
+// session's project
+func parseExecutionRequest(session *models.Session, data json.RawMessage) (executionRequest, error) {
+	var req executionRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, errors.Wrap(err, errors.CodeValidationFailed, "invalid execution request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return req, errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+
+	return req, nil
+}
+
+// requireHistory fails when execution records are disabled
+func (h *HistoryHandlers) requireHistory() error {
+	if h.history == nil {
+		return errors.New(errors.CodeValidationFailed, "execution records are disabled")
+	}
+	return nil
+}
+
+// HandleExecutionList returns a page of a project's executions, newest first
+func (h *HistoryHandlers) HandleExecutionList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseExecutionRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	var before time.Time
+	if req.Before != "" {
+		if before, err = time.Parse(time.RFC3339, req.Before); err != nil {
+			return errors.New(errors.CodeValidationFailed, "invalid before timestamp format").
+				WithDetail("before", req.Before)
+		}
+	}
+
+	switch {
+	case req.Limit <= 0:
+		req.Limit = defaultExecutionListLimit
+	case req.Limit > maxExecutionListLimit:
+		req.Limit = maxExecutionListLimit
+	}
+
+	// Anyone who can read the project can browse its executions
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+	if err := h.requireHistory(); err != nil {
+		return err
+	}
+
+	// Fetch one more to tell whether another page follows
+	records, err := h.history.List(project.ID, before, req.Limit+1)
+	if err != nil {
+		return err
+	}
+	hasMore := len(records) > req.Limit
+	if hasMore {
+		records = records[:req.Limit]
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeExecutionList, map[string]interface{}{
+		"project_id": project.ID,
+		"executions": records,
+		"has_more":   hasMore,
+	})
+}
+
+// HandleExecutionGet returns one execution record
+func (h *HistoryHandlers) HandleExecutionGet(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseExecutionRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.ExecutionID == "" {
+		return errors.New(errors.CodeValidationFailed, "execution_id is required")
+	}
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+	if err := h.requireHistory(); err != nil {
+		return err
+	}
+
+	record, err := h.history.Get(project.ID, req.ExecutionID)
+	if err != nil {
+		return err
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeExecutionGet, record)
+}
+
+// HandleExecutionDiff returns the patch of the changes an execution made,
+// or of the changes to one of its files
+func (h *HistoryHandlers) HandleExecutionDiff(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseExecutionRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.ExecutionID == "" {
+		return errors.New(errors.CodeValidationFailed, "execution_id is required")
+	}
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+	if err := h.requireHistory(); err != nil {
+		return err
+	}
+
+	record, err := h.history.Get(project.ID, req.ExecutionID)
+	if err != nil {
+		return err
+	}
+	patch, err := h.history.Patch(project.ID, req.ExecutionID)
+	if err != nil {
+		return err
+	}
+	if req.Path != "" {
+		patch = workspace.FilePatch(patch, req.Path)
+	}
+
+	response := map[string]interface{}{
+		"project_id":   project.ID,
+		"execution_id": record.ID,
+		"changes":      record.Changes,
+		"patch":        patch,
+	}
+	if req.Path != "" {
+		response["path"] = req.Path
+	}
+	return websocket.SendSuccess(session, models.MessageTypeExecutionDiff, response)
+}
+
+// RegisterHandlers registers all history handlers with the router
+func (h *HistoryHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeExecutionList, h.HandleExecutionList)
+	router.Register(models.MessageTypeExecutionGet, h.HandleExecutionGet)
+	router.Register(models.MessageTypeExecutionDiff, h.HandleExecutionDiff)
+}
+
+// finishRecord records how a run ended
+func (h *ExecutionHandlers) finishRecord(projectID string, r *run, result *executor.ExecuteResult, err error, killed bool) {
+	if h.history == nil || r.executionID == "" {
+		return
+	}
+
+	outcome := history.Outcome{Status: history.StatusCompleted}
+	switch {
+	case killed:
+		outcome.Status = history.StatusKilled
+	case errors.IsCode(err, errors.CodeExecutionTimeout):
+		outcome.Status = history.StatusTimedOut
+	case err != nil:
+		outcome.Status = history.StatusFailed
+	}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	if result != nil {
+		exitCode := result.ExitCode
+		outcome.ExitCode = &exitCode
+		outcome.Stderr = result.Stderr
+		outcome.SessionID = result.SessionID
+		outcome.Messages = result.Logged
+		outcome.Usage = result.Usage
+		outcome.Changes = result.Changes
+		outcome.Patch = result.Patch
+	}
+
+	if _, err := h.history.Finish(projectID, r.executionID, outcome); err != nil {
+		h.log.Error("Failed to record execution result",
+			"project_id", projectID,
+			"execution_id", r.executionID,
+			"error", err,
+		)
+	}
+}
+
+// broadcastChanges tells the project's subscribers what a finished run
+// changed, once its patch can be fetched
+func (h *ExecutionHandlers) broadcastChanges(project *models.Project, r *run, result *executor.ExecuteResult) {
+	if result == nil || result.Changes == nil {
+		return
+	}
+	var worktreeID string
+	if r.worktree != nil {
+		worktreeID = r.worktree.ID
+	}
+	h.broadcast.BroadcastWorkspaceChanges(project, r.executionID, worktreeID, *result.Changes)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// HandleAgentPause stops the project's running execution with SIGSTOP, so
+// what it did so far can be read before it goes on. The project is PAUSED
+// until agent_resume.
+func (h *ExecutionHandlers) HandleAgentPause(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	return h.setPaused(ctx, session, data, true)
+}
+
+// HandleAgentResume continues an execution stopped by agent_pause
+func (h *ExecutionHandlers) HandleAgentResume(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	return h.setPaused(ctx, session, data, false)
+}
+
+// setPaused pauses or resumes the run of a project, or of one of its
+// worktrees
+func (h *ExecutionHandlers) setPaused(ctx context.Context, session *models.Session, data json.RawMessage, paused bool) error {
+	var req struct {
+		ProjectID  string `json:"project_id"`
+		WorktreeID string `json:"worktree_id"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return errors.Wrap(err, errors.CodeValidationFailed, "invalid pause request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	if req.WorktreeID != "" {
+		event.Set("worktree_id", req.WorktreeID)
+	}
+
+	// Controlling executions requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	key := executor.ProcessKey(project.ID, req.WorktreeID)
+	messageType, status, state := models.MessageTypeAgentResume, "resumed", models.StateExecuting
+	if paused {
+		messageType, status, state = models.MessageTypeAgentPause, "paused", models.StatePaused
+	}
+
+	// Holding mu keeps the run from finishing before its state is set
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.runs[key] == nil {
+		if req.WorktreeID != "" {
+			return errors.New(errors.CodeProcessNotFound, "no active execution in worktree").
+				WithDetail("project_id", project.ID).
+				WithDetail("worktree_id", req.WorktreeID)
+		}
+		return errors.New(errors.CodeProcessNotFound, "no active execution for project").
+			WithDetail("project_id", project.ID)
+	}
+
+	if paused {
+		err = h.executor.PauseExecution(key)
+	} else {
+		err = h.executor.ResumeExecution(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Claude execution "+status,
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"worktree_id", req.WorktreeID,
+		"by", session.GetIdentity().String(),
+	)
+
+	// Runs in worktrees leave the project's state alone
+	if req.WorktreeID == "" {
+		if err := h.projectMgr.UpdateProjectState(project.ID, state); err != nil {
+			h.log.Error("Failed to update project state", "error", err)
+		}
+		h.broadcast.BroadcastProjectState(project)
+	}
+
+	response := map[string]interface{}{
+		"project_id": project.ID,
+		"status":     status,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	}
+	if req.WorktreeID != "" {
+		response["worktree_id"] = req.WorktreeID
+	}
+	return websocket.SendSuccess(session, messageType, response)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/test/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionHandlers_PauseResume(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, _ := promptScript(t)
+	// The run's timeout passes only when the clock is advanced
+	clock := mocks.NewClock(time.Now())
+	timeout := 100 * time.Millisecond
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Script:   script,
+		Executor: executor.Config{DefaultTimeout: timeout, Clock: clock},
+	})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+
+	err := h.Execution.HandleAgentPause(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessNotFound))
+
+	execute, _ := json.Marshal(map[string]string{"prompt": "one"})
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, execute))
+	lastResponseData(t, tws, 1)
+
+	// Only executors control the run
+	err = h.Execution.HandleAgentPause(ctx, observer, nil)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.Eventually(t, func() bool { return h.Execution.HandleAgentPause(ctx, session, nil) == nil },
+		5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "paused", lastResponseData(t, tws, 2)["status"])
+	assert.Equal(t, models.StatePaused, stateOf(setup.project))
+
+	// The paused run outlasts its timeout
+	clock.Advance(2 * timeout)
+	assert.Equal(t, models.StatePaused, stateOf(setup.project))
+	err = h.Execution.HandleAgentPause(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	err = setup.manager.DeleteProject(setup.project.ID)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessActive))
+
+	require.NoError(t, h.Execution.HandleAgentResume(ctx, session, nil))
+	assert.Equal(t, "resumed", lastResponseData(t, tws, 3)["status"])
+	// It completes rather than timing out
+	require.Eventually(t, func() bool { return stateOf(setup.project) == models.StateIdle && idle(h.Execution) },
+		5*time.Second, 20*time.Millisecond)
+
+	err = h.Execution.HandleAgentResume(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessNotFound))
+}
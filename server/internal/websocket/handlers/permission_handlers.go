@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// PermissionHandlers relays tool permission prompts between running
+// executions and a project's clients
+type PermissionHandlers struct {
+	projectMgr *project.Manager
+	executor   *executor.ClaudeExecutor
+	broker     *permission.Broker
+	broadcast  *Broadcaster
+	log        *logger.Logger
+	audit      *audit.Log
+}
+
+// NewPermissionHandlers creates new permission handlers and registers them
+// as the broker's notifier. The executor redacts the prompts' tool input.
+func NewPermissionHandlers(projectMgr *project.Manager, executor *executor.ClaudeExecutor, broker *permission.Broker, broadcast *Broadcaster, log *logger.Logger) *PermissionHandlers {
+	h := &PermissionHandlers{
+		projectMgr: projectMgr,
+		executor:   executor,
+		broker:     broker,
+		broadcast:  broadcast,
+		log:        log,
+	}
+	if broker != nil {
+		broker.SetNotifier(h)
+	}
+	return h
+}
+
+// HandlePermissionResponse answers a pending permission request
+func (h *PermissionHandlers) HandlePermissionResponse(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		RequestID string `json:"request_id"`
+		Decision  string `json:"decision"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid permission response")
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	if req.RequestID == "" {
+		return errors.New(errors.CodeValidationFailed, "request_id is required")
+	}
+
+	decision, err := permission.ParseDecision(req.Decision)
+	if err != nil {
+		return errors.NewValidationError("%v", err)
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("request_id", req.RequestID)
+	event.Set("decision", decision)
+
+	// Answering prompts lets tools run, which requires the executor role
+	if _, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor); err != nil {
+		return err
+	}
+
+	if h.broker == nil {
+		return errors.New(errors.CodeValidationFailed, "interactive permissions are disabled")
+	}
+
+	request, err := h.broker.Respond(req.ProjectID, req.RequestID, decision, session.GetIdentity().String())
+	if err != nil {
+		return err
+	}
+	event.Set("tool_name", request.ToolName)
+
+	return websocket.SendSuccess(session, models.MessageTypePermissionResponse, map[string]interface{}{
+		"project_id": req.ProjectID,
+		"request_id": req.RequestID,
+		"tool_name":  request.ToolName,
+		"decision":   decision,
+	})
+}
+
+// PermissionRequested implements permission.Notifier. The tool input may
+// hold commands and file contents, so the project's secrets are removed
+// before it is broadcast.
+func (h *PermissionHandlers) PermissionRequested(req permission.Request) {
+	project, err := h.projectMgr.GetProjectByID(req.ProjectID)
+	if err != nil {
+		return
+	}
+	if req.Input != nil {
+		req.Input = h.executor.RedactValue(project, req.Input).(map[string]interface{})
+	}
+	h.broadcast.BroadcastPermissionRequest(project, req)
+}
+
+// PermissionResolved implements permission.Notifier
+func (h *PermissionHandlers) PermissionResolved(req permission.Request, res permission.Resolution) {
+	if project, err := h.projectMgr.GetProjectByID(req.ProjectID); err == nil {
+		h.broadcast.BroadcastPermissionResolved(project, req, res)
+	}
+}
+
+// RegisterHandlers registers all permission handlers with the router
+func (h *PermissionHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypePermissionResponse, audited(h.audit, h.log, models.MessageTypePermissionResponse, h.HandlePermissionResponse))
+}
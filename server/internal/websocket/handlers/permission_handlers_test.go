@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/env"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// askPermission calls the broker's tool the way the CLI would for a Bash
+// command and returns the raw tool result text
+func askPermission(t *testing.T, broker *permission.Broker, projectID, command string) <-chan string {
+	grant, err := broker.Grant(projectID)
+	require.NoError(t, err)
+	t.Cleanup(grant.Close)
+
+	// The grant's MCP config carries the broker URL and token
+	data, err := os.ReadFile(grant.Args()[3])
+	require.NoError(t, err)
+	var config struct {
+		MCPServers map[string]struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+		} `json:"mcpServers"`
+	}
+	require.NoError(t, json.Unmarshal(data, &config))
+	server := config.MCPServers[permission.MCPServerName]
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "tools/call",
+		"params": map[string]interface{}{
+			"name": permission.ToolName,
+			"arguments": map[string]interface{}{
+				"tool_name": "Bash",
+				"input":     map[string]string{"command": command},
+			},
+		},
+	})
+
+	result := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+		req.Header.Set("Authorization", server.Headers["Authorization"])
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		text, _ := io.ReadAll(resp.Body)
+		result <- string(text)
+	}()
+	return result
+}
+
+// permissionRequestOf waits for a client to receive a permission request
+// and returns its data
+func permissionRequestOf(t *testing.T, tws *testWebSocketServer) map[string]interface{} {
+	var request map[string]interface{}
+	require.Eventually(t, func() bool {
+		for _, msg := range tws.GetReceivedMessages() {
+			if m := parseResponse(t, msg); m["type"] == string(models.MessageTypePermissionRequest) {
+				request = m["data"].(map[string]interface{})
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	return request
+}
+
+func TestPermissionHandlers_Response(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+
+	broker, err := permission.NewBroker(permission.Config{Timeout: 10 * time.Second}, logger.New("error"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = broker.Close() })
+
+	// The executor redacts the project's secrets
+	envMgr, err := env.NewManager(t.TempDir(), env.Config{})
+	require.NoError(t, err)
+	_, err = envMgr.Set(setup.project.ID, "API_TOKEN", "tok-very-secret", true, testOwner.String())
+	require.NoError(t, err)
+	exec, err := executor.NewClaudeExecutor(executor.Config{ClaudePath: "/bin/sh", Environment: envMgr})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = exec.Shutdown(ctx) })
+
+	handler := NewPermissionHandlers(setup.manager, exec, broker, setup.execution.broadcast, logger.New("error"))
+
+	owner, ownerWS := newIdentitySession(t, "owner-session", testOwner)
+	require.NoError(t, setup.manager.AddSubscriber(setup.project.ID, owner))
+	observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+	require.NoError(t, setup.manager.AddSubscriber(setup.project.ID, observer))
+
+	result := askPermission(t, broker, setup.project.ID, "curl -H 'Authorization: tok-very-secret' localhost")
+
+	// Executors are asked, without the project's secrets
+	request := permissionRequestOf(t, ownerWS)
+	assert.Equal(t, "Bash", request["tool_name"])
+	assert.Equal(t, map[string]interface{}{"command": "curl -H 'Authorization: [REDACTED:project_secret]' localhost"}, request["input"])
+
+	// Observers only learn that a prompt is pending
+	pending := permissionRequestOf(t, observerWS)
+	assert.Equal(t, request["request_id"], pending["request_id"])
+	assert.Equal(t, "Bash", pending["tool_name"])
+	assert.NotContains(t, pending, "input")
+
+	response, _ := json.Marshal(map[string]string{
+		"project_id": setup.project.ID,
+		"request_id": request["request_id"].(string),
+		"decision":   "approve",
+	})
+
+	// Observers cannot answer
+	err = handler.HandlePermissionResponse(ctx, observer, response)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.NoError(t, handler.HandlePermissionResponse(ctx, owner, response))
+
+	select {
+	case text := <-result:
+		assert.Contains(t, text, `\"behavior\":\"allow\"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("permission tool did not return")
+	}
+
+	// A request can only be answered once
+	err = handler.HandlePermissionResponse(ctx, owner, response)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionRequestNotFound))
+}
+
+func TestPermissionHandlers_Validation(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	session, _ := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	disabled := NewPermissionHandlers(setup.manager, nil, nil, setup.execution.broadcast, logger.New("error"))
+
+	err := disabled.HandlePermissionResponse(ctx, session, []byte(`{"request_id":"r1","decision":"maybe"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	err = disabled.HandlePermissionResponse(ctx, session, []byte(`{"decision":"approve"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	// Without a broker there is nothing to answer
+	err = disabled.HandlePermissionResponse(ctx, session, []byte(`{"request_id":"r1","decision":"approve"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// PolicyHandlers provides handlers for execution policy messages
+type PolicyHandlers struct {
+	projectMgr *project.Manager
+	executor   *executor.ClaudeExecutor
+	log        *logger.Logger
+	audit      *audit.Log
+}
+
+// NewPolicyHandlers creates new policy handlers
+func NewPolicyHandlers(projectMgr *project.Manager, exec *executor.ClaudeExecutor, log *logger.Logger) *PolicyHandlers {
+	return &PolicyHandlers{
+		projectMgr: projectMgr,
+		executor:   exec,
+		log:        log,
+	}
+}
+
+// HandlePolicyList returns the configured policy profiles and the default
+func (h *PolicyHandlers) HandlePolicyList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	profiles, defaultPolicy := h.executor.Policies()
+
+	response := map[string]interface{}{
+		"policies":       profiles,
+		"default_policy": defaultPolicy,
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypePolicyList, response)
+}
+
+// HandleProjectSetPolicy assigns a policy profile to a project. Only admins
+// may change policies, since owners could otherwise lift their own limits.
+func (h *PolicyHandlers) HandleProjectSetPolicy(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Policy    string `json:"policy"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errors.Wrap(err, errors.CodeValidationFailed, "invalid set policy request")
+	}
+
+	if req.ProjectID == "" {
+		return errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("policy", req.Policy)
+
+	if err := requireAdmin(session); err != nil {
+		return err
+	}
+
+	if req.Policy != "" && !h.executor.HasPolicy(req.Policy) {
+		return errors.New(errors.CodeValidationFailed, "unknown policy profile: %s", req.Policy).
+			WithDetail("policy", req.Policy)
+	}
+
+	project, err := h.projectMgr.SetProjectPolicy(req.ProjectID, req.Policy)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Project policy changed",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"policy", req.Policy,
+		"by", session.GetIdentity().String(),
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeProjectSetPolicy, map[string]string{
+		"project_id": project.ID,
+		"policy":     project.GetPolicy(),
+	})
+}
+
+// RegisterHandlers registers all policy handlers with the router
+func (h *PolicyHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypePolicyList, h.HandlePolicyList)
+	router.Register(models.MessageTypeProjectSetPolicy, audited(h.audit, h.log, models.MessageTypeProjectSetPolicy, h.HandleProjectSetPolicy))
+}
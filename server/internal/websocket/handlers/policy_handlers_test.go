@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyHandlers_SetPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	exec, err := executor.NewClaudeExecutor(executor.Config{
+		ClaudePath: "/bin/sh",
+		Policies: map[string]executor.PolicyProfile{
+			"strict":     {},
+			"permissive": {AllowSkipPermissions: true},
+		},
+		DefaultPolicy: "strict",
+	})
+	require.NoError(t, err)
+
+	setup := createACLTestSetup(t)
+	handler := NewPolicyHandlers(setup.manager, exec, logger.New("debug"))
+
+	t.Run("owners cannot change policy", func(t *testing.T) {
+		session, _ := newIdentitySession(t, "owner-session", testOwner)
+
+		data, _ := json.Marshal(map[string]string{"project_id": setup.project.ID, "policy": "permissive"})
+		err := handler.HandleProjectSetPolicy(ctx, session, data)
+		assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+		assert.Empty(t, setup.project.GetPolicy())
+	})
+
+	t.Run("admin sets policy", func(t *testing.T) {
+		session, _ := newIdentitySession(t, "admin-session", &models.Identity{ID: "tok", Method: models.AuthMethodToken, Admin: true})
+
+		data, _ := json.Marshal(map[string]string{"project_id": setup.project.ID, "policy": "permissive"})
+		require.NoError(t, handler.HandleProjectSetPolicy(ctx, session, data))
+		assert.Equal(t, "permissive", setup.project.GetPolicy())
+
+		// The project policy now overrides the default
+		options := executor.ExecuteOptions{DangerouslySkipPermissions: true}
+		_, err := exec.ApplyPolicy(setup.project, &options)
+		assert.NoError(t, err)
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		session, _ := newIdentitySession(t, "admin-session", nil)
+
+		data, _ := json.Marshal(map[string]string{"project_id": setup.project.ID, "policy": "missing"})
+		err := handler.HandleProjectSetPolicy(ctx, session, data)
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	})
+}
+
+func TestExecutionHandlers_PolicyViolation(t *testing.T) {
+	ctx := context.Background()
+
+	setup := createACLTestSetup(t)
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Executor: executor.Config{
+			Policies:      map[string]executor.PolicyProfile{"strict": {}},
+			DefaultPolicy: "strict",
+		},
+	})
+
+	session, _ := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"prompt":  "hello",
+		"options": map[string]interface{}{"dangerously_skip_permissions": true},
+	})
+	err := h.Execution.HandleExecute(ctx, session, data)
+	assert.True(t, errors.IsCode(err, errors.CodePolicyViolation))
+
+	// The project never left IDLE
+	assert.Equal(t, models.StateIdle, setup.project.State)
+}
+
+func TestExecutionHandlers_TimeoutWithoutPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	setup := createACLTestSetup(t)
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Executor: executor.Config{MaxTimeout: time.Hour},
+	})
+
+	session, _ := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	for name, seconds := range map[string]int{
+		"above the server maximum": 2 * 3600,
+		"too large for a duration": math.MaxInt,
+		"negative":                 -1,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, _ := json.Marshal(map[string]interface{}{"prompt": "hello", "timeout_seconds": seconds})
+			err := h.Execution.HandleExecute(ctx, session, data)
+			assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), "got %v", err)
+			assert.Equal(t, models.StateIdle, setup.project.State)
+		})
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"context"
 	"encoding/json"
 
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/env"
 	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
 	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
 	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/boyd/pocket_agent/server/internal/worktree"
 )
 
 // ProjectHandlers provides handlers for project-related WebSocket messages
@@ -16,14 +21,41 @@ type ProjectHandlers struct {
 	projectMgr *project.Manager
 	log        *logger.Logger
 	broadcast  *Broadcaster
+	audit      *audit.Log
+	queue      *queue.Manager
+	env        *env.Manager
+	worktrees  *worktree.Manager
+	sessions   *sessions.Manager
+	// interruptions returns a project's runs the last restart interrupted;
+	// may be nil
+	interruptions func(projectID string) []models.Interruption
+}
+
+// ProjectServices are the optional services that keep per-project state.
+// Each may be nil.
+type ProjectServices struct {
+	// Queue, Environment, Worktrees and Sessions forget the state of
+	// deleted projects
+	Queue       *queue.Manager
+	Environment *env.Manager
+	Worktrees   *worktree.Manager
+	Sessions    *sessions.Manager
+	// Interruptions returns a project's runs the last restart interrupted,
+	// which clients joining the project are told about
+	Interruptions func(projectID string) []models.Interruption
 }
 
 // NewProjectHandlers creates new project handlers
-func NewProjectHandlers(projectMgr *project.Manager, broadcast *Broadcaster, log *logger.Logger) *ProjectHandlers {
+func NewProjectHandlers(projectMgr *project.Manager, broadcast *Broadcaster, services ProjectServices, log *logger.Logger) *ProjectHandlers {
 	return &ProjectHandlers{
-		projectMgr: projectMgr,
-		log:        log,
-		broadcast:  broadcast,
+		projectMgr:    projectMgr,
+		log:           log,
+		broadcast:     broadcast,
+		queue:         services.Queue,
+		env:           services.Environment,
+		worktrees:     services.Worktrees,
+		sessions:      services.Sessions,
+		interruptions: services.Interruptions,
 	}
 }
 
@@ -42,14 +74,19 @@ func (h *ProjectHandlers) HandleProjectCreate(ctx context.Context, session *mode
 		return errors.New(errors.CodeValidationFailed, "path is required")
 	}
 
+	event := audit.EventFromContext(ctx)
+	event.Set("path", req.Path)
+
 	h.log.Info("Creating project", "session_id", session.ID, "path", req.Path)
 
-	// Create project
-	project, err := h.projectMgr.CreateProject(req.Path)
+	// Create project, making the requesting identity its owner
+	project, err := h.projectMgr.CreateProjectWithOwner(req.Path, session.GetIdentity())
 	if err != nil {
 		return err
 	}
 
+	event.SetProject(project.ID)
+
 	h.log.Info("Project created successfully",
 		"session_id", session.ID,
 		"project_id", project.ID,
@@ -73,12 +110,15 @@ func (h *ProjectHandlers) HandleProjectList(ctx context.Context, session *models
 	h.log.Debug("Listing projects", "session_id", session.ID)
 
 	projects := h.projectMgr.GetAllProjects()
+	identity := session.GetIdentity()
 
 	// Create response with metadata
 	type projectInfo struct {
 		ID         string                 `json:"id"`
 		Path       string                 `json:"path"`
 		State      models.State           `json:"state"`
+		Role       models.Role            `json:"role"`
+		Policy     string                 `json:"policy,omitempty"`
 		SessionID  string                 `json:"session_id,omitempty"`
 		CreatedAt  string                 `json:"created_at"`
 		LastActive string                 `json:"last_active"`
@@ -87,10 +127,18 @@ func (h *ProjectHandlers) HandleProjectList(ctx context.Context, session *models
 
 	projectList := make([]projectInfo, 0, len(projects))
 	for _, p := range projects {
+		// Only list projects the identity has access to
+		role := p.RoleOf(identity)
+		if role == "" {
+			continue
+		}
+
 		info := projectInfo{
 			ID:         p.ID,
 			Path:       p.Path,
 			State:      p.State,
+			Role:       role,
+			Policy:     p.GetPolicy(),
 			SessionID:  p.SessionID,
 			CreatedAt:  p.CreatedAt.Format("2006-01-02T15:04:05Z"),
 			LastActive: p.LastActive.Format("2006-01-02T15:04:05Z"),
@@ -130,10 +178,12 @@ func (h *ProjectHandlers) HandleProjectDelete(ctx context.Context, session *mode
 		return errors.New(errors.CodeValidationFailed, "project_id is required")
 	}
 
+	audit.EventFromContext(ctx).SetProject(req.ProjectID)
+
 	h.log.Info("Deleting project", "session_id", session.ID, "project_id", req.ProjectID)
 
-	// Get project before deletion for broadcast
-	project, err := h.projectMgr.GetProjectByID(req.ProjectID)
+	// Get project before deletion for broadcast; only owners may delete
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleOwner)
 	if err != nil {
 		return err
 	}
@@ -143,6 +193,21 @@ func (h *ProjectHandlers) HandleProjectDelete(ctx context.Context, session *mode
 		return err
 	}
 
+	// Queued prompts, the environment, worktree checkouts and the session
+	// catalogue were deleted with the project's data
+	if h.queue != nil {
+		h.queue.Forget(req.ProjectID)
+	}
+	if h.env != nil {
+		h.env.Forget(req.ProjectID)
+	}
+	if h.worktrees != nil {
+		h.worktrees.Forget(req.ProjectID)
+	}
+	if h.sessions != nil {
+		h.sessions.Forget(req.ProjectID)
+	}
+
 	h.log.Info("Project deleted successfully",
 		"session_id", session.ID,
 		"project_id", req.ProjectID,
@@ -175,8 +240,8 @@ func (h *ProjectHandlers) HandleProjectJoin(ctx context.Context, session *models
 
 	h.log.Info("Joining project", "session_id", session.ID, "project_id", req.ProjectID)
 
-	// Get project
-	project, err := h.projectMgr.GetProjectByID(req.ProjectID)
+	// Get project; observers and above may join
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
 	if err != nil {
 		return err
 	}
@@ -200,11 +265,19 @@ func (h *ProjectHandlers) HandleProjectJoin(ctx context.Context, session *models
 		h.log.Error("Failed to send project state", "error", err)
 	}
 
+	// Tell the client about runs a restart interrupted
+	if h.interruptions != nil {
+		if err := sendInterruptions(session, project.ID, h.interruptions(project.ID)); err != nil {
+			h.log.Error("Failed to send interrupted executions", "error", err)
+		}
+	}
+
 	// Send success confirmation
 	return websocket.SendSuccess(session, models.MessageTypeProjectJoin, map[string]interface{}{
 		"project_id": req.ProjectID,
 		"state":      project.State,
 		"session_id": project.SessionID,
+		"role":       project.RoleOf(session.GetIdentity()),
 	})
 }
 
@@ -251,9 +324,9 @@ func (h *ProjectHandlers) HandleProjectLeave(ctx context.Context, session *model
 
 // RegisterHandlers registers all project handlers with the router
 func (h *ProjectHandlers) RegisterHandlers(router *websocket.MessageRouter) {
-	router.Register(models.MessageTypeProjectCreate, h.HandleProjectCreate)
+	router.Register(models.MessageTypeProjectCreate, audited(h.audit, h.log, models.MessageTypeProjectCreate, h.HandleProjectCreate))
 	router.Register(models.MessageTypeProjectList, h.HandleProjectList)
-	router.Register(models.MessageTypeProjectDelete, h.HandleProjectDelete)
+	router.Register(models.MessageTypeProjectDelete, audited(h.audit, h.log, models.MessageTypeProjectDelete, h.HandleProjectDelete))
 	router.Register(models.MessageTypeProjectJoin, h.HandleProjectJoin)
 	router.Register(models.MessageTypeProjectLeave, h.HandleProjectLeave)
 }
@@ -46,7 +46,7 @@ func createTestSetup(t *testing.T) *testSetup {
 	// Create real broadcaster and handlers
 	log := logger.New("debug")
 	broadcaster := NewBroadcaster(DefaultBroadcasterConfig(), log)
-	handler := NewProjectHandlers(manager, broadcaster, log)
+	handler := NewProjectHandlers(manager, broadcaster, ProjectServices{}, log)
 
 	// Create session with real WebSocket
 	session := models.NewSession("test-session", tws.GetClientConn())
@@ -163,7 +163,7 @@ func TestProjectHandlers_CreateProject(t *testing.T) {
 
 		log := logger.New("debug")
 		broadcaster := NewBroadcaster(DefaultBroadcasterConfig(), log)
-		handler := NewProjectHandlers(manager, broadcaster, log)
+		handler := NewProjectHandlers(manager, broadcaster, ProjectServices{}, log)
 		session := models.NewSession("test-session", tws.GetClientConn())
 
 		// Create projects up to limit
@@ -80,8 +80,8 @@ func (h *QueryHandlers) HandleGetMessages(ctx context.Context, session *models.S
 		"direction", req.Direction,
 	)
 
-	// Get project
-	project, err := h.projectMgr.GetProjectByID(projectID)
+	// Get project; observers and above may read history
+	project, err := authorizeProject(h.projectMgr, session, projectID, models.RoleObserver)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// QueueHandlers provides handlers for the prompts queued behind a project's
+// active run
+type QueueHandlers struct {
+	projectMgr *project.Manager
+	queue      *queue.Manager
+	broadcast  *Broadcaster
+	log        *logger.Logger
+	audit      *audit.Log
+}
+
+// NewQueueHandlers creates new queue handlers. A nil manager disables
+// prompt queuing.
+func NewQueueHandlers(projectMgr *project.Manager, queueMgr *queue.Manager, broadcast *Broadcaster, log *logger.Logger) *QueueHandlers {
+	return &QueueHandlers{
+		projectMgr: projectMgr,
+		queue:      queueMgr,
+		broadcast:  broadcast,
+		log:        log,
+	}
+}
+
+// queueRequest identifies a project's queue and optionally one of its prompts
+type queueRequest struct {
+	ProjectID string `json:"project_id"`
+	QueueID   string `json:"queue_id"`
+	Position  int    `json:"position"`
+	All       bool   `json:"all"`
+}
+
+// parseQueueRequest decodes a queue request, defaulting to the session's project
+func parseQueueRequest(session *models.Session, data json.RawMessage) (queueRequest, error) {
+	var req queueRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, errors.Wrap(err, errors.CodeValidationFailed, "invalid queue request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return req, errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+
+	return req, nil
+}
+
+// requireQueue fails when prompt queuing is disabled
+func (h *QueueHandlers) requireQueue() error {
+	if h.queue == nil {
+		return errors.New(errors.CodeValidationFailed, "prompt queuing is disabled")
+	}
+	return nil
+}
+
+// HandleQueueList returns the prompts queued for a project
+func (h *QueueHandlers) HandleQueueList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseQueueRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	// Anyone who can read the project can see its queue
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+
+	items := []queue.Item{}
+	if h.queue != nil {
+		if items, err = h.queue.List(project.ID); err != nil {
+			return err
+		}
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeQueueList, map[string]interface{}{
+		"project_id": project.ID,
+		"items":      items,
+	})
+}
+
+// HandleQueueMove moves a queued prompt to a new 1-based position
+func (h *QueueHandlers) HandleQueueMove(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseQueueRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	if req.QueueID == "" {
+		return errors.New(errors.CodeValidationFailed, "queue_id is required")
+	}
+	if req.Position < 1 {
+		return errors.New(errors.CodeValidationFailed, "position must be at least 1")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("queue_id", req.QueueID)
+	event.Set("position", req.Position)
+
+	// Reordering prompts requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+	if err := h.requireQueue(); err != nil {
+		return err
+	}
+
+	items, err := h.queue.Move(project.ID, req.QueueID, req.Position)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Moved queued Claude command",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"queue_id", req.QueueID,
+		"position", req.Position,
+	)
+
+	h.broadcast.BroadcastQueue(project, items)
+
+	return websocket.SendSuccess(session, models.MessageTypeQueueMove, map[string]interface{}{
+		"project_id": project.ID,
+		"queue_id":   req.QueueID,
+		"items":      items,
+	})
+}
+
+// HandleQueueCancel removes a queued prompt, or all of them when all is set
+func (h *QueueHandlers) HandleQueueCancel(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseQueueRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	if req.QueueID == "" && !req.All {
+		return errors.New(errors.CodeValidationFailed, "queue_id or all is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	if req.All {
+		event.Set("all", true)
+	} else {
+		event.Set("queue_id", req.QueueID)
+	}
+
+	// Cancelling prompts requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+	if err := h.requireQueue(); err != nil {
+		return err
+	}
+
+	var cancelled []queue.Item
+	if req.All {
+		cancelled, err = h.queue.Clear(project.ID)
+	} else {
+		var item queue.Item
+		if item, err = h.queue.Remove(project.ID, req.QueueID); err == nil {
+			cancelled = []queue.Item{item}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(cancelled))
+	for _, item := range cancelled {
+		ids = append(ids, item.ID)
+	}
+
+	h.log.Info("Cancelled queued Claude commands",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"queue_ids", ids,
+	)
+
+	items := broadcastQueue(h.queue, h.broadcast, h.log, project)
+
+	return websocket.SendSuccess(session, models.MessageTypeQueueCancel, map[string]interface{}{
+		"project_id": project.ID,
+		"cancelled":  ids,
+		"items":      items,
+	})
+}
+
+// RegisterHandlers registers all queue handlers with the router
+func (h *QueueHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeQueueList, h.HandleQueueList)
+	router.Register(models.MessageTypeQueueMove, audited(h.audit, h.log, models.MessageTypeQueueMove, h.HandleQueueMove))
+	router.Register(models.MessageTypeQueueCancel, audited(h.audit, h.log, models.MessageTypeQueueCancel, h.HandleQueueCancel))
+}
+
+// broadcastQueue sends the project's current queue to its subscribers and
+// returns it
+func broadcastQueue(prompts *queue.Manager, broadcast *Broadcaster, log *logger.Logger, project *models.Project) []queue.Item {
+	items, err := prompts.List(project.ID)
+	if err != nil {
+		log.Error("Failed to read prompt queue", "project_id", project.ID, "error", err)
+		return nil
+	}
+
+	broadcast.BroadcastQueue(project, items)
+	return items
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionHandlers_QueuesWhileExecuting(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, promptsFile := promptScript(t)
+	prompts := queue.NewManager(t.TempDir(), 10)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{ExecutionQueue: prompts}})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	execute := func(prompt string) {
+		data, _ := json.Marshal(map[string]string{"prompt": prompt})
+		require.NoError(t, h.Execution.HandleExecute(ctx, session, data))
+	}
+
+	execute("one")
+	assert.Equal(t, "started", lastResponseData(t, tws, 1)["status"])
+	assert.Equal(t, models.StateExecuting, stateOf(setup.project))
+
+	execute("two")
+	response := lastResponseData(t, tws, 2)
+	assert.Equal(t, "queued", response["status"])
+	assert.Equal(t, float64(1), response["position"])
+
+	execute("three")
+	response = lastResponseData(t, tws, 3)
+	assert.Equal(t, float64(2), response["position"])
+	third := response["queue_id"].(string)
+
+	// Move the third prompt ahead of the second
+	data, _ := json.Marshal(map[string]interface{}{"queue_id": third, "position": 1})
+	require.NoError(t, h.Queue.HandleQueueMove(ctx, session, data))
+	items := lastResponseData(t, tws, 4)["items"].([]interface{})
+	require.Len(t, items, 2)
+	assert.Equal(t, third, items[0].(map[string]interface{})["id"])
+
+	// Observers can list the queue but not change it
+	observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+	projectData, _ := json.Marshal(map[string]string{"project_id": setup.project.ID})
+	require.NoError(t, h.Queue.HandleQueueList(ctx, observer, projectData))
+	assert.Len(t, lastResponseData(t, observerWS, 1)["items"], 2)
+
+	cancelAll, _ := json.Marshal(map[string]interface{}{"project_id": setup.project.ID, "all": true})
+	err := h.Queue.HandleQueueCancel(ctx, observer, cancelAll)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	// The queue drains in order once each run finishes
+	require.Eventually(t, func() bool {
+		n, err := prompts.Len(setup.project.ID)
+		return err == nil && n == 0 && stateOf(setup.project) == models.StateIdle && !h.Execution.executor.IsProjectExecuting(setup.project.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	recorded, err := os.ReadFile(promptsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "one\nthree\ntwo\n", string(recorded))
+}
+
+func TestExecutionHandlers_QueueCancel(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, promptsFile := promptScript(t)
+	prompts := queue.NewManager(t.TempDir(), 10)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{ExecutionQueue: prompts}})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	for _, prompt := range []string{"one", "two", "three"} {
+		data, _ := json.Marshal(map[string]string{"prompt": prompt})
+		require.NoError(t, h.Execution.HandleExecute(ctx, session, data))
+	}
+	second := lastResponseData(t, tws, 3)["queue_id"]
+	require.NotNil(t, second)
+
+	// Cancel a single prompt, then an unknown one
+	data, _ := json.Marshal(map[string]interface{}{"queue_id": second})
+	require.NoError(t, h.Queue.HandleQueueCancel(ctx, session, data))
+	response := lastResponseData(t, tws, 4)
+	assert.Equal(t, []interface{}{second}, response["cancelled"])
+	assert.Len(t, response["items"], 1)
+
+	err := h.Queue.HandleQueueCancel(ctx, session, data)
+	assert.True(t, errors.IsCode(err, errors.CodeQueueItemNotFound))
+
+	// Cancel the rest
+	data, _ = json.Marshal(map[string]interface{}{"all": true})
+	require.NoError(t, h.Queue.HandleQueueCancel(ctx, session, data))
+	assert.Empty(t, lastResponseData(t, tws, 5)["items"])
+
+	require.Eventually(t, func() bool {
+		return stateOf(setup.project) == models.StateIdle && !h.Execution.executor.IsProjectExecuting(setup.project.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	recorded, err := os.ReadFile(promptsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", string(recorded))
+}
+
+func TestExecutionHandlers_QueueDisabled(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, _ := promptScript(t)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script})
+
+	session, _ := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	data, _ := json.Marshal(map[string]string{"prompt": "one"})
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, data))
+
+	// Without a queue a second prompt is rejected while the first runs
+	err := h.Execution.HandleExecute(ctx, session, data)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessActive))
+
+	err = h.Queue.HandleQueueCancel(ctx, session, []byte(`{"all":true}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	require.Eventually(t, func() bool {
+		return stateOf(setup.project) == models.StateIdle && !h.Execution.executor.IsProjectExecuting(setup.project.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+)
+
+// reapGrace is how long processes a previous server run left behind get to
+// terminate before they are killed
+const reapGrace = 5 * time.Second
+
+// InterruptExecutions closes the runs a previous server run left behind.
+// Processes that survived it are stopped: their output went to the previous
+// server and cannot be read anymore. Each run is marked interrupted in its
+// record and in the project's message log, and clients joining the project
+// are told about it until the project runs again.
+func (h *ExecutionHandlers) InterruptExecutions() {
+	for _, project := range h.projectMgr.GetAllProjects() {
+		interruptions := h.interruptions(project)
+		if len(interruptions) == 0 {
+			continue
+		}
+
+		for _, intr := range interruptions {
+			h.log.Warn("Execution was interrupted",
+				"project_id", project.ID,
+				"execution_id", intr.ExecutionID,
+				"worktree_id", intr.WorktreeID,
+				"process_killed", intr.ProcessKilled,
+			)
+			h.logInterruption(project, intr)
+		}
+
+		h.mu.Lock()
+		h.interrupted[project.ID] = interruptions
+		h.mu.Unlock()
+	}
+}
+
+// interruptions stops the surviving processes of a project's runs and
+// closes their records, returning the runs
+func (h *ExecutionHandlers) interruptions(project *models.Project) []models.Interruption {
+	now := time.Now().UTC()
+	var interruptions []models.Interruption
+
+	if h.recovery != nil {
+		markers, err := h.recovery.Take(project.ID)
+		if err != nil {
+			h.log.Error("Failed to read running process records", "project_id", project.ID, "error", err)
+		}
+		for _, marker := range markers {
+			killed, err := recovery.Reap(marker, reapGrace)
+			if err != nil {
+				h.log.Error("Failed to stop process left running",
+					"project_id", project.ID,
+					"pid", marker.PID,
+					"error", err,
+				)
+			}
+			interruptions = append(interruptions, models.Interruption{
+				ExecutionID:   marker.ExecutionID,
+				WorktreeID:    marker.WorktreeID,
+				SessionID:     marker.SessionID,
+				StartedAt:     marker.StartedAt,
+				InterruptedAt: now,
+				ProcessKilled: killed,
+			})
+		}
+	}
+
+	if h.history != nil {
+		records, err := h.history.Interrupt(project.ID)
+		if err != nil {
+			h.log.Error("Failed to update interrupted executions", "project_id", project.ID, "error", err)
+		}
+		for _, rec := range records {
+			if indexOfInterruption(interruptions, rec.ID) >= 0 {
+				continue
+			}
+			// The run had no process yet, or its record was lost
+			intr := models.Interruption{
+				ExecutionID:   rec.ID,
+				SessionID:     rec.SessionID,
+				StartedAt:     rec.StartedAt,
+				InterruptedAt: now,
+			}
+			if rec.Worktree != nil {
+				intr.WorktreeID = rec.Worktree.ID
+			}
+			interruptions = append(interruptions, intr)
+		}
+	}
+
+	return interruptions
+}
+
+// indexOfInterruption returns the index of an execution's interruption, or -1
+func indexOfInterruption(interruptions []models.Interruption, executionID string) int {
+	for i, intr := range interruptions {
+		if intr.ExecutionID != "" && intr.ExecutionID == executionID {
+			return i
+		}
+	}
+	return -1
+}
+
+// logInterruption appends an entry for an interrupted run to the project's
+// message log, so that the log shows where the run's messages end
+func (h *ExecutionHandlers) logInterruption(project *models.Project, intr models.Interruption) {
+	// Project snapshots carry no message log
+	project, err := h.projectMgr.GetProjectByID(project.ID)
+	if err != nil || project.MessageLog == nil {
+		return
+	}
+
+	content, _ := json.Marshal(map[string]interface{}{
+		"type":           "system",
+		"subtype":        "interrupted",
+		"execution_id":   intr.ExecutionID,
+		"session_id":     intr.SessionID,
+		"process_killed": intr.ProcessKilled,
+		"message":        "Execution was interrupted by a server restart",
+	})
+	err = project.MessageLog.Append(models.TimestampedMessage{
+		Timestamp: intr.InterruptedAt,
+		Message: models.ClaudeMessage{
+			Type:       "system",
+			Content:    content,
+			WorktreeID: intr.WorktreeID,
+		},
+		Direction: "server",
+	})
+	if err != nil {
+		h.log.Error("Failed to log execution interruption", "project_id", project.ID, "error", err)
+	}
+}
+
+// Interruptions returns a project's runs the last restart interrupted, until
+// the project runs again
+func (h *ExecutionHandlers) Interruptions(projectID string) []models.Interruption {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]models.Interruption(nil), h.interrupted[projectID]...)
+}
+
+// sendInterruptions tells a session joining a project about the project's
+// runs the last restart interrupted
+func sendInterruptions(session *models.Session, projectID string, interruptions []models.Interruption) error {
+	for _, intr := range interruptions {
+		err := session.WriteJSON(models.ServerMessage{
+			Type:      models.MessageTypeExecutionInterrupted,
+			ProjectID: projectID,
+			Data:      intr,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/history"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/platform"
+	"github.com/boyd/pocket_agent/server/internal/recovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionHandlers_InterruptExecutions(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	records := history.NewStore(t.TempDir(), 10)
+	markers := recovery.NewStore(t.TempDir())
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Config: Config{ExecutionHistory: records, Recovery: markers},
+	})
+	projectID := setup.project.ID
+
+	// A run whose process survived the previous server
+	survivor, err := records.Start(history.Record{ProjectID: projectID, Prompt: "long task", SessionID: "s1"})
+	require.NoError(t, err)
+	cmd := exec.Command("sh", "-c", "sleep 30 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() { _ = platform.SignalProcessGroup(cmd.Process.Pid, syscall.SIGKILL) })
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	start, err := platform.ProcessStartTime(cmd.Process.Pid)
+	require.NoError(t, err)
+	require.NoError(t, markers.Mark(projectID, recovery.Marker{
+		ExecutionID:  survivor.ID,
+		SessionID:    "s1",
+		PID:          cmd.Process.Pid,
+		ProcessStart: start,
+		StartedAt:    survivor.StartedAt,
+	}))
+
+	// A run in a worktree that died with the previous server
+	lost, err := records.Start(history.Record{ProjectID: projectID, Prompt: "other task", Worktree: &models.Worktree{ID: "w1"}})
+	require.NoError(t, err)
+
+	h.Execution.InterruptExecutions()
+
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("surviving process was not stopped")
+	}
+	for _, id := range []string{survivor.ID, lost.ID} {
+		rec, err := records.Get(projectID, id)
+		require.NoError(t, err)
+		assert.Equal(t, history.StatusInterrupted, rec.Status)
+	}
+
+	interruptions := h.Execution.Interruptions(projectID)
+	require.Len(t, interruptions, 2)
+	assert.Equal(t, survivor.ID, interruptions[0].ExecutionID)
+	assert.True(t, interruptions[0].ProcessKilled)
+	assert.Equal(t, lost.ID, interruptions[1].ExecutionID)
+	assert.Equal(t, "w1", interruptions[1].WorktreeID)
+	assert.False(t, interruptions[1].ProcessKilled)
+
+	// The message log shows where the runs end
+	logged, err := setup.project.MessageLog.GetMessagesSince(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "server", logged[0].Direction)
+	var content map[string]interface{}
+	require.NoError(t, json.Unmarshal(logged[0].Message.Content, &content))
+	assert.Equal(t, "interrupted", content["subtype"])
+	assert.Equal(t, survivor.ID, content["execution_id"])
+	assert.Equal(t, "w1", logged[1].Message.WorktreeID)
+
+	// Clients joining the project are told
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	data, _ := json.Marshal(map[string]string{"project_id": projectID})
+	require.NoError(t, h.Project.HandleProjectJoin(ctx, session, data))
+	require.Eventually(t, func() bool { return len(tws.GetReceivedMessages()) >= 4 }, time.Second, 10*time.Millisecond)
+	var notified []string
+	for _, msg := range tws.GetReceivedMessages() {
+		resp := parseResponse(t, msg)
+		if resp["type"] == string(models.MessageTypeExecutionInterrupted) {
+			notified = append(notified, resp["data"].(map[string]interface{})["execution_id"].(string))
+		}
+	}
+	assert.Equal(t, []string{survivor.ID, lost.ID}, notified)
+
+	// Nothing is left to recover on the next start
+	h.Execution.InterruptExecutions()
+	logged, err = setup.project.MessageLog.GetMessagesSince(time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, logged, 2)
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+	"github.com/boyd/pocket_agent/server/internal/scheduler"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/google/uuid"
+)
+
+// auditActionScheduleRun is the audit action of runs started by a schedule
+const auditActionScheduleRun = "schedule_run"
+
+// ScheduleHandlers provides handlers for scheduled prompts, and runs them
+// when they come due
+type ScheduleHandlers struct {
+	projectMgr *project.Manager
+	executor   *executor.ClaudeExecutor
+	execution  *ExecutionHandlers
+	broadcast  *Broadcaster
+	log        *logger.Logger
+	audit      *audit.Log
+	// maxSchedules caps the schedules per project; 0 disables schedules
+	maxSchedules int
+	// identities verifies schedule creators before each run; nil when
+	// clients are not authenticated
+	identities IdentityVerifier
+	// quota counts runs against their creator's execution quota; nil
+	// disables it
+	quota *quota.Tracker
+}
+
+// NewScheduleHandlers creates new schedule handlers whose runs start through
+// the execution handlers
+func NewScheduleHandlers(
+	projectMgr *project.Manager,
+	executor *executor.ClaudeExecutor,
+	execution *ExecutionHandlers,
+	broadcast *Broadcaster,
+	maxSchedules int,
+	identities IdentityVerifier,
+	executionQuota *quota.Tracker,
+	log *logger.Logger,
+) *ScheduleHandlers {
+	return &ScheduleHandlers{
+		projectMgr:   projectMgr,
+		executor:     executor,
+		execution:    execution,
+		broadcast:    broadcast,
+		log:          log,
+		maxSchedules: maxSchedules,
+		identities:   identities,
+		quota:        executionQuota,
+	}
+}
+
+// scheduleRequest identifies a project's schedules and optionally describes
+// or names one of them
+type scheduleRequest struct {
+	ProjectID  string                `json:"project_id"`
+	ScheduleID string                `json:"schedule_id"`
+	Cron       string                `json:"cron"`
+	Timezone   string                `json:"timezone"`
+	Prompt     string                `json:"prompt"`
+	Options    *models.ClaudeOptions `json:"options"`
+}
+
+// scheduleView is a schedule with the time it next comes due
+type scheduleView struct {
+	models.Schedule
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// parseScheduleRequest decodes a schedule request, defaulting to the
+// session's project
+func parseScheduleRequest(session *models.Session, data json.RawMessage) (scheduleRequest, error) {
+	var req scheduleRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, errors.Wrap(err, errors.CodeValidationFailed, "invalid schedule request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return req, errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+
+	return req, nil
+}
+
+// requireSchedules fails when scheduled prompts are disabled
+func (h *ScheduleHandlers) requireSchedules() error {
+	if h.maxSchedules <= 0 {
+		return errors.New(errors.CodeValidationFailed, "scheduled prompts are disabled")
+	}
+	return nil
+}
+
+// HandleScheduleCreate adds a scheduled prompt to a project. Its runs execute
+// as the identity that created it.
+func (h *ScheduleHandlers) HandleScheduleCreate(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseScheduleRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("cron", req.Cron)
+	event.SetDigest("prompt", req.Prompt)
+	if req.Options != nil {
+		event.Set("options", req.Options)
+	}
+
+	if err := h.requireSchedules(); err != nil {
+		return err
+	}
+	if req.Prompt == "" {
+		return errors.New(errors.CodeValidationFailed, "prompt is required")
+	}
+	if req.Options != nil && req.Options.Interactive {
+		return errors.New(errors.CodeValidationFailed, "scheduled prompts cannot be interactive")
+	}
+
+	schedule := models.Schedule{
+		ID:       uuid.New().String(),
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Command: models.ExecuteCommand{
+			Prompt:  req.Prompt,
+			Options: req.Options,
+		},
+		CreatedBy: session.GetIdentity(),
+		CreatedAt: time.Now(),
+	}
+	next, err := scheduler.Next(schedule, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Scheduling runs requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	// Reject options the project's policy forbids now rather than at every run
+	options, err := executeOptions(schedule.Command)
+	if err != nil {
+		return err
+	}
+	if _, err := h.executor.ApplyPolicy(project, &options); err != nil {
+		return err
+	}
+
+	if _, err := h.projectMgr.AddProjectSchedule(project.ID, schedule, h.maxSchedules); err != nil {
+		return err
+	}
+	event.Set("schedule_id", schedule.ID)
+
+	h.log.Info("Schedule created",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"schedule_id", schedule.ID,
+		"cron", schedule.Cron,
+		"next_run", next,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeScheduleCreate, map[string]interface{}{
+		"project_id": project.ID,
+		"schedule":   viewSchedule(schedule, time.Now()),
+	})
+}
+
+// HandleScheduleList returns the schedules of a project
+func (h *ScheduleHandlers) HandleScheduleList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseScheduleRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	// Anyone who can read the project can see its schedules
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	schedules := project.GetSchedules()
+	views := make([]scheduleView, 0, len(schedules))
+	for _, schedule := range schedules {
+		views = append(views, viewSchedule(schedule, now))
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeScheduleList, map[string]interface{}{
+		"project_id": project.ID,
+		"schedules":  views,
+	})
+}
+
+// HandleScheduleDelete removes a schedule from a project. Runs it already
+// started or queued are not affected.
+func (h *ScheduleHandlers) HandleScheduleDelete(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := parseScheduleRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.ScheduleID == "" {
+		return errors.New(errors.CodeValidationFailed, "schedule_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("schedule_id", req.ScheduleID)
+
+	// Removing schedules requires the executor role
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	removed, err := h.projectMgr.RemoveProjectSchedule(project.ID, req.ScheduleID)
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Schedule deleted",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"schedule_id", removed.ID,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeScheduleDelete, map[string]interface{}{
+		"project_id":  project.ID,
+		"schedule_id": removed.ID,
+		"status":      "deleted",
+	})
+}
+
+// RunSchedule starts the prompt of a due schedule as the identity that
+// created it, or queues it behind the project's active run. It fails with
+// RESOURCE_LIMIT while the executor runs its maximum of concurrent
+// executions, and with PROCESS_ACTIVE when the project is busy and queuing
+// is disabled; the scheduler retries both later. Its output is broadcast to
+// the project's subscribers like that of any other run.
+func (h *ScheduleHandlers) RunSchedule(projectID string, schedule models.Schedule) (err error) {
+	principal := schedule.CreatedBy.String()
+	event := &audit.Event{
+		Action:    auditActionScheduleRun,
+		Principal: principal,
+		ProjectID: projectID,
+		Details:   map[string]interface{}{"schedule_id": schedule.ID},
+	}
+	defer func() {
+		// Runs that will be retried are recorded once they start
+		if h.audit == nil || scheduler.Retryable(err) {
+			return
+		}
+		event.SetResult(err)
+		if _, recordErr := h.audit.Record(event); recordErr != nil {
+			h.log.Error("Failed to record audit entry",
+				"action", auditActionScheduleRun,
+				"project_id", projectID,
+				"error", recordErr,
+			)
+		}
+	}()
+
+	project, err := h.projectMgr.GetProjectByID(projectID)
+	if err != nil {
+		return err
+	}
+	if schedule.Disabled() {
+		return errors.New(errors.CodeValidationFailed, "schedule is disabled").
+			WithDetail("schedule_id", schedule.ID)
+	}
+
+	// Revoked credentials stop the schedule for good
+	if h.identities != nil {
+		if err := h.identities.Verify(schedule.CreatedBy); err != nil {
+			h.disableSchedule(project, schedule, err)
+			event.Set("disabled", true)
+			return h.rejectSchedule(project, schedule, err)
+		}
+	}
+
+	// Runs stop once their creator loses the executor role
+	if role := project.RoleOf(schedule.CreatedBy); !role.Includes(models.RoleExecutor) {
+		return h.rejectSchedule(project, schedule, errors.New(errors.CodePermissionDenied,
+			"schedule creator no longer holds the executor role").
+			WithDetail("project_id", projectID))
+	}
+
+	options, err := executeOptions(schedule.Command)
+	if err != nil {
+		return h.rejectSchedule(project, schedule, err)
+	}
+	if _, err := h.executor.ApplyPolicy(project, &options); err != nil {
+		return h.rejectSchedule(project, schedule, err)
+	}
+	if err := h.execution.checkBudgets(projectID, principal); err != nil {
+		return h.rejectSchedule(project, schedule, err)
+	}
+
+	// Runs count against their creator's execution quota, like executions
+	// a client starts; a run that does not start gives its use back
+	if h.quota != nil {
+		release, quotaErr := h.quota.Reserve(principal)
+		if quotaErr != nil {
+			return h.rejectSchedule(project, schedule, quotaErr)
+		}
+		defer func() {
+			if err != nil {
+				release()
+			}
+		}()
+	}
+
+	// Runs in a worktree start right away, alongside the project's others
+	if worktreeOpts := worktreeOptions(schedule.Command); worktreeOpts != nil {
+		r, err := h.execution.startInWorktree(project, options, *worktreeOpts, principal)
+		if err != nil {
+			if scheduler.Retryable(err) {
+				return err
+			}
+			return h.rejectSchedule(project, schedule, err)
+		}
+		event.Set("worktree_id", r.worktree.ID)
+		if r.executionID != "" {
+			event.Set("execution_id", r.executionID)
+		}
+		return nil
+	}
+
+	executionID, queueID, err := h.execution.startScheduled(project, schedule.Command, options, principal)
+	if err != nil {
+		return err
+	}
+	if executionID != "" {
+		event.Set("execution_id", executionID)
+	}
+	if queueID != "" {
+		event.Set("queue_id", queueID)
+	}
+	return nil
+}
+
+// startScheduled starts a scheduled command in the project's directory as
+// principal, or queues it behind the project's active run. It fails with
+// RESOURCE_LIMIT while the executor runs its maximum of concurrent
+// executions.
+func (h *ExecutionHandlers) startScheduled(project *models.Project, cmd models.ExecuteCommand, options executor.ExecuteOptions, principal string) (executionID, queueID string, err error) {
+	h.mu.Lock()
+	queued, err := h.busy(project.ID)
+	if err != nil {
+		h.mu.Unlock()
+		return "", "", err
+	}
+
+	if queued {
+		item, _, err := h.queue.Push(project.ID, cmd, principal)
+		h.mu.Unlock()
+		if err != nil {
+			return "", "", err
+		}
+
+		broadcastQueue(h.queue, h.broadcast, h.log, project)
+		h.drain(project.ID)
+		return "", item.ID, nil
+	}
+
+	if limit := h.executor.MaxConcurrentExecutions(); len(h.runs) >= limit {
+		h.mu.Unlock()
+		return "", "", errors.NewResourceLimitError("concurrent executions", limit, len(h.runs))
+	}
+
+	r, err := h.start(project, options, principal, "")
+	h.mu.Unlock()
+	if err != nil {
+		return "", "", err
+	}
+
+	h.broadcast.BroadcastProjectState(project)
+	return r.executionID, "", nil
+}
+
+// disableSchedule keeps a schedule whose creator was revoked from running
+// again; owners may still list and delete it
+func (h *ScheduleHandlers) disableSchedule(project *models.Project, schedule models.Schedule, reason error) {
+	if err := h.projectMgr.DisableProjectSchedule(project.ID, schedule.ID, reason.Error()); err != nil {
+		h.log.Error("Failed to disable schedule",
+			"project_id", project.ID,
+			"schedule_id", schedule.ID,
+			"error", err,
+		)
+	}
+}
+
+// rejectSchedule reports to subscribers a scheduled run that may not start
+func (h *ScheduleHandlers) rejectSchedule(project *models.Project, schedule models.Schedule, err error) error {
+	appErr, ok := err.(*errors.AppError)
+	if !ok {
+		appErr = errors.NewInternalError(err)
+	}
+	h.broadcast.BroadcastError(project, appErr.WithDetail("schedule_id", schedule.ID))
+	return err
+}
+
+// RegisterHandlers registers all schedule handlers with the router
+func (h *ScheduleHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeScheduleCreate, audited(h.audit, h.log, models.MessageTypeScheduleCreate, h.HandleScheduleCreate))
+	router.Register(models.MessageTypeScheduleList, h.HandleScheduleList)
+	router.Register(models.MessageTypeScheduleDelete, audited(h.audit, h.log, models.MessageTypeScheduleDelete, h.HandleScheduleDelete))
+}
+
+// viewSchedule adds the time a schedule next comes due after now
+func viewSchedule(schedule models.Schedule, now time.Time) scheduleView {
+	view := scheduleView{Schedule: schedule}
+	if schedule.Disabled() {
+		return view
+	}
+	if next, err := scheduler.Next(schedule, now); err == nil && !next.IsZero() {
+		view.NextRun = &next
+	}
+	return view
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/auth"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/queue"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionHandlers_Schedules(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, _ := promptScript(t)
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	// Schedules are disabled without a limit
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script})
+	err := h.Schedule.HandleScheduleCreate(ctx, session, []byte(`{"cron":"@daily","prompt":"hello"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	h = createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{MaxSchedules: 2}})
+	create := func(req map[string]interface{}) error {
+		data, _ := json.Marshal(req)
+		return h.Schedule.HandleScheduleCreate(ctx, session, data)
+	}
+
+	for name, req := range map[string]map[string]interface{}{
+		"missing prompt": {"cron": "@daily"},
+		"invalid cron":   {"cron": "every day", "prompt": "hello"},
+		"invalid zone":   {"cron": "@daily", "timezone": "Mars/Olympus", "prompt": "hello"},
+		"interactive":    {"cron": "@daily", "prompt": "hello", "options": map[string]bool{"interactive": true}},
+	} {
+		err := create(req)
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), name)
+	}
+
+	require.NoError(t, create(map[string]interface{}{
+		"cron":     "0 9 * * 1-5",
+		"timezone": "UTC",
+		"prompt":   "run the tests and summarise failures",
+	}))
+	created := lastResponseData(t, tws, 1)["schedule"].(map[string]interface{})
+	scheduleID := created["id"].(string)
+	assert.Equal(t, "0 9 * * 1-5", created["cron"])
+	assert.NotEmpty(t, created["next_run"])
+
+	// Observers can list schedules but not create them
+	observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+	err = h.Schedule.HandleScheduleCreate(ctx, observer, []byte(`{"cron":"@daily","prompt":"hello"}`))
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.NoError(t, h.Schedule.HandleScheduleList(ctx, observer, nil))
+	schedules := lastResponseData(t, observerWS, 1)["schedules"].([]interface{})
+	require.Len(t, schedules, 1)
+	assert.Equal(t, scheduleID, schedules[0].(map[string]interface{})["id"])
+
+	err = h.Schedule.HandleScheduleDelete(ctx, observer, []byte(`{"schedule_id":"`+scheduleID+`"}`))
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	require.NoError(t, h.Schedule.HandleScheduleDelete(ctx, session, []byte(`{"schedule_id":"`+scheduleID+`"}`)))
+	assert.Empty(t, setup.project.GetSchedules())
+
+	err = h.Schedule.HandleScheduleDelete(ctx, session, []byte(`{"schedule_id":"`+scheduleID+`"}`))
+	assert.True(t, errors.IsCode(err, errors.CodeScheduleNotFound))
+}
+
+func TestExecutionHandlers_RunSchedule(t *testing.T) {
+	setup := createACLTestSetup(t)
+	script, promptsFile := promptScript(t)
+	prompts := queue.NewManager(t.TempDir(), 10)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{ExecutionQueue: prompts}})
+
+	schedule := models.Schedule{
+		ID:        "schedule-1",
+		Cron:      "@hourly",
+		Command:   models.ExecuteCommand{Prompt: "scheduled prompt"},
+		CreatedBy: testOwner,
+	}
+
+	// At the executor's limit the run has to wait
+	for i := 0; i < h.Execution.executor.MaxConcurrentExecutions(); i++ {
+		h.Execution.runs[fmt.Sprintf("other-project-%d", i)] = &run{}
+	}
+	err := h.Schedule.RunSchedule(setup.project.ID, schedule)
+	assert.True(t, errors.IsCode(err, errors.CodeResourceLimit))
+	h.Execution.runs = make(map[string]*run)
+
+	// A run in progress queues the next one
+	require.NoError(t, h.Schedule.RunSchedule(setup.project.ID, schedule))
+	assert.Equal(t, models.StateExecuting, stateOf(setup.project))
+	require.NoError(t, h.Schedule.RunSchedule(setup.project.ID, schedule))
+	items, err := prompts.List(setup.project.ID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, testOwner.Principal(), items[0].QueuedBy)
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(promptsFile)
+		return strings.Count(string(data), "scheduled prompt") == 2 && stateOf(setup.project) == models.StateIdle
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Creators who lost the executor role no longer run their schedules
+	schedule.CreatedBy = testObserver
+	err = h.Schedule.RunSchedule(setup.project.ID, schedule)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+}
+
+func TestExecutionHandlers_RunScheduleQuota(t *testing.T) {
+	setup := createACLTestSetup(t)
+	script, promptsFile := promptScript(t)
+
+	tracker, err := quota.NewTracker(t.TempDir(), quota.Config{Name: "daily_executions", Daily: 1})
+	require.NoError(t, err)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{ExecutionQuota: tracker}})
+
+	schedule := models.Schedule{
+		ID:        "schedule-1",
+		Cron:      "@hourly",
+		Command:   models.ExecuteCommand{Prompt: "scheduled prompt"},
+		CreatedBy: testOwner,
+	}
+
+	// A run that has to wait gives its use back
+	for i := 0; i < h.Execution.executor.MaxConcurrentExecutions(); i++ {
+		h.Execution.runs[fmt.Sprintf("other-project-%d", i)] = &run{}
+	}
+	err = h.Schedule.RunSchedule(setup.project.ID, schedule)
+	assert.True(t, errors.IsCode(err, errors.CodeResourceLimit))
+	assert.Equal(t, 0, tracker.Used(testOwner.Principal()))
+	h.Execution.runs = make(map[string]*run)
+
+	// Runs count against their creator's quota
+	require.NoError(t, h.Schedule.RunSchedule(setup.project.ID, schedule))
+	assert.Equal(t, 1, tracker.Used(testOwner.Principal()))
+	require.Eventually(t, func() bool { return idle(h.Execution) }, 10*time.Second, 50*time.Millisecond)
+
+	err = h.Schedule.RunSchedule(setup.project.ID, schedule)
+	assert.True(t, errors.IsCode(err, errors.CodeQuotaExceeded), "got %v", err)
+	data, err := os.ReadFile(promptsFile)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "scheduled prompt"))
+}
+
+func TestExecutionHandlers_RunScheduleRevokedCreator(t *testing.T) {
+	setup := createACLTestSetup(t)
+	script, promptsFile := promptScript(t)
+
+	service, err := auth.NewService(t.TempDir())
+	require.NoError(t, err)
+	auditLog, err := audit.NewLog(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLog.Close() })
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Script: script,
+		Config: Config{
+			Auth:           service,
+			Audit:          auditLog,
+			ExecutionQueue: queue.NewManager(t.TempDir(), 10),
+		},
+	})
+
+	_, token, err := service.Tokens().Issue("ci", 0)
+	require.NoError(t, err)
+	creator := &models.Identity{ID: token.ID, Name: token.Name, Method: models.AuthMethodToken}
+	_, err = setup.manager.SetProjectRole(setup.project.ID, creator.Principal(), models.RoleExecutor)
+	require.NoError(t, err)
+
+	schedule := models.Schedule{
+		ID:        "schedule-1",
+		Cron:      "@hourly",
+		Command:   models.ExecuteCommand{Prompt: "scheduled prompt"},
+		CreatedBy: creator,
+	}
+	_, err = setup.manager.AddProjectSchedule(setup.project.ID, schedule, 10)
+	require.NoError(t, err)
+
+	// Once the creator's token is revoked the run is skipped
+	require.NoError(t, service.Tokens().Revoke(token.ID))
+	err = h.Schedule.RunSchedule(setup.project.ID, schedule)
+	assert.True(t, errors.IsCode(err, errors.CodeUnauthorized), "got %v", err)
+	assert.Equal(t, models.StateIdle, stateOf(setup.project))
+	assert.NoFileExists(t, promptsFile)
+
+	// The schedule is disabled rather than retried
+	schedules := setup.project.GetSchedules()
+	require.Len(t, schedules, 1)
+	assert.True(t, schedules[0].Disabled())
+	err = h.Schedule.RunSchedule(setup.project.ID, schedules[0])
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), "got %v", err)
+
+	// The skipped run is audited as denied
+	entries, _, err := auditLog.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, auditActionScheduleRun, entries[0].Action)
+	assert.Equal(t, creator.Principal(), entries[0].Principal)
+	assert.Equal(t, audit.OutcomeDenied, entries[0].Outcome)
+	assert.Contains(t, string(entries[0].Details), `"disabled":true`)
+}
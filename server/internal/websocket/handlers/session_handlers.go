@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/permission"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// SessionHandlers provides handlers for a project's catalogued sessions
+type SessionHandlers struct {
+	projectMgr  *project.Manager
+	executor    *executor.ClaudeExecutor
+	execution   *ExecutionHandlers
+	sessions    *sessions.Manager
+	permissions *permission.Broker
+	broadcast   *Broadcaster
+	log         *logger.Logger
+	audit       *audit.Log
+}
+
+// NewSessionHandlers creates new session handlers. Sessions only change
+// while the execution handlers run nothing in the project's directory. A
+// nil catalogue disables the handlers.
+func NewSessionHandlers(
+	projectMgr *project.Manager,
+	executor *executor.ClaudeExecutor,
+	execution *ExecutionHandlers,
+	catalogue *sessions.Manager,
+	permissions *permission.Broker,
+	broadcast *Broadcaster,
+	log *logger.Logger,
+) *SessionHandlers {
+	return &SessionHandlers{
+		projectMgr:  projectMgr,
+		executor:    executor,
+		execution:   execution,
+		sessions:    catalogue,
+		permissions: permissions,
+		broadcast:   broadcast,
+		log:         log,
+	}
+}
+
+// sessionRequest identifies a session of a project
+type sessionRequest struct {
+	ProjectID string `json:"project_id"`
+	SessionID string `json:"session_id"`
+	Title     string `json:"title"`
+}
+
+// parseSessionRequest decodes a session request, defaulting to the
+// session's project, and fails when the session catalogue is disabled
+func (h *SessionHandlers) parseSessionRequest(session *models.Session, data json.RawMessage) (sessionRequest, error) {
+	var req sessionRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, errors.Wrap(err, errors.CodeValidationFailed, "invalid session request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return req, errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	if h.sessions == nil {
+		return req, errors.New(errors.CodeValidationFailed, "session catalogue is disabled")
+	}
+
+	return req, nil
+}
+
+// pendingFork returns the fork requested for a run in the project's
+// directory, if the run resumes the fork's source. Callers hold h.mu.
+func (h *ExecutionHandlers) pendingFork(project *models.Project, options executor.ExecuteOptions) *sessions.Fork {
+	if h.sessions == nil || options.Worktree != nil || project.SessionID == "" {
+		return nil
+	}
+	fork, err := h.sessions.PendingFork(project.ID)
+	if err != nil {
+		h.log.Error("Failed to read pending session fork", "project_id", project.ID, "error", err)
+		return nil
+	}
+	if fork == nil || fork.From != project.SessionID {
+		return nil
+	}
+	return fork
+}
+
+// catalogueSession records the session a run in the project's directory
+// used, which is the session it forked when a fork was pending
+func (h *ExecutionHandlers) catalogueSession(project *models.Project, options executor.ExecuteOptions, r *run, result *executor.ExecuteResult) {
+	if h.sessions == nil {
+		return
+	}
+
+	sessionID := project.SessionID
+	if result != nil && result.SessionID != "" {
+		sessionID = result.SessionID
+	}
+	if sessionID == "" {
+		return
+	}
+
+	use := sessions.Use{
+		Prompt: h.executor.RedactText(project, options.Prompt),
+		By:     r.startedBy,
+	}
+	if result != nil {
+		use.Messages = result.Logged
+	}
+	if r.fork != nil && sessionID != r.fork.From {
+		use.ForkedFrom = r.fork.From
+		use.Title = r.fork.Title
+	}
+
+	if _, err := h.sessions.Use(project.ID, sessionID, use); err != nil {
+		h.log.Error("Failed to catalogue session",
+			"project_id", project.ID,
+			"claude_session_id", sessionID,
+			"error", err,
+		)
+	}
+}
+
+// HandleSessionList returns a project's catalogued sessions, most recently
+// used first, along with the active session and any pending fork
+func (h *SessionHandlers) HandleSessionList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseSessionRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	// Anyone who can read the project can see its sessions
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+
+	list, fork, err := h.sessions.List(project.ID)
+	if err != nil {
+		return err
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeSessionList, map[string]interface{}{
+		"project_id":        project.ID,
+		"active_session_id": project.SessionID,
+		"sessions":          list,
+		"fork":              fork,
+	})
+}
+
+// HandleSessionSwitch makes a catalogued session the one the project's next
+// execution resumes. The project must not be executing.
+func (h *SessionHandlers) HandleSessionSwitch(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseSessionRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.SessionID == "" {
+		return errors.New(errors.CodeValidationFailed, "session_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("claude_session_id", req.SessionID)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	var target sessions.Session
+	var oldSessionID string
+	err = h.execution.whileIdle(project.ID, "switch sessions", func() (err error) {
+		if target, err = h.sessions.Get(project.ID, req.SessionID); err != nil {
+			return err
+		}
+		oldSessionID = project.SessionID
+		if err := h.projectMgr.UpdateProjectSession(project.ID, target.ID); err != nil {
+			return err
+		}
+		// A fork requested from the session left behind no longer applies
+		if err := h.sessions.CancelFork(project.ID); err != nil {
+			h.log.Error("Failed to cancel session fork", "project_id", project.ID, "error", err)
+		}
+
+		// Tools always allowed in the old conversation must be approved again
+		if h.permissions != nil {
+			h.permissions.Reset(project.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Claude session switched",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"old_session_id", oldSessionID,
+		"new_session_id", target.ID,
+	)
+
+	if updatedProject, err := h.projectMgr.GetProjectByID(project.ID); err == nil {
+		h.broadcast.BroadcastProjectState(updatedProject)
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeSessionSwitch, map[string]interface{}{
+		"project_id":     project.ID,
+		"session":        target,
+		"old_session_id": oldSessionID,
+		"status":         "switched",
+		"timestamp":      time.Now().Format(time.RFC3339),
+	})
+}
+
+// HandleSessionFork makes the project's next execution continue a catalogued
+// session, the active one by default, in a new session. The source becomes
+// the active session and stays in the catalogue unchanged, so both
+// conversations can be resumed later.
+func (h *SessionHandlers) HandleSessionFork(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseSessionRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+	if req.SessionID == "" {
+		req.SessionID = project.SessionID
+	}
+	if req.SessionID == "" {
+		return errors.New(errors.CodeValidationFailed, "project has no active session to fork")
+	}
+	event.Set("claude_session_id", req.SessionID)
+
+	// Not every agent can fork its sessions
+	name, backend, err := h.executor.BackendFor(project)
+	if err != nil {
+		return err
+	}
+	if err := executor.CheckCapabilities(name, backend.Capabilities(), executor.ExecuteOptions{ForkSession: true}); err != nil {
+		return err
+	}
+
+	var fork sessions.Fork
+	var oldSessionID string
+	err = h.execution.whileIdle(project.ID, "fork a session", func() (err error) {
+		if fork, err = h.sessions.RequestFork(project.ID, req.SessionID, req.Title, session.GetIdentity().String()); err != nil {
+			return err
+		}
+		oldSessionID = project.SessionID
+		if fork.From == oldSessionID {
+			return nil
+		}
+
+		if err := h.projectMgr.UpdateProjectSession(project.ID, fork.From); err != nil {
+			return err
+		}
+		if h.permissions != nil {
+			h.permissions.Reset(project.ID)
+		}
+		if updatedProject, err := h.projectMgr.GetProjectByID(project.ID); err == nil {
+			h.broadcast.BroadcastProjectState(updatedProject)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("Claude session fork requested",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"from_session_id", fork.From,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeSessionFork, map[string]interface{}{
+		"project_id":     project.ID,
+		"fork":           fork,
+		"old_session_id": oldSessionID,
+		"status":         "fork_pending",
+		"timestamp":      time.Now().Format(time.RFC3339),
+	})
+}
+
+// RegisterHandlers registers all session handlers with the router
+func (h *SessionHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeSessionList, h.HandleSessionList)
+	router.Register(models.MessageTypeSessionSwitch, audited(h.audit, h.log, models.MessageTypeSessionSwitch, h.HandleSessionSwitch))
+	router.Register(models.MessageTypeSessionFork, audited(h.audit, h.log, models.MessageTypeSessionFork, h.HandleSessionFork))
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionScript returns a mock Claude script that resumes the session passed
+// with -c, forks it into "forked" with --fork-session and otherwise starts
+// "first". The mock appends its arguments to the returned file.
+func sessionScript(t *testing.T) (script, argsFile string) {
+	argsFile = filepath.Join(t.TempDir(), "args.txt")
+	script = `echo "$@" >> ` + argsFile + `
+cat > /dev/null
+sid=first
+[ "$1" = "-c" ] && sid=$2
+[ "$3" = "--fork-session" ] && sid=forked
+echo '{"type":"system","subtype":"init","session_id":"'$sid'"}'
+echo '{"type":"result","result":"done","session_id":"'$sid'"}'
+`
+	return script, argsFile
+}
+
+func TestExecutionHandlers_SessionCatalogue(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script, argsFile := sessionScript(t)
+	catalogue := sessions.NewManager(t.TempDir(), 10)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: Config{Sessions: catalogue}})
+	projectID := setup.project.ID
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(projectID)
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(projectID)
+
+	execute := func(prompt string) {
+		data, _ := json.Marshal(map[string]string{"prompt": prompt})
+		require.NoError(t, h.Execution.HandleExecute(ctx, session, data))
+		require.Eventually(t, func() bool { return idle(h.Execution) }, 5*time.Second, 10*time.Millisecond)
+	}
+	request := func(fields map[string]string) json.RawMessage {
+		data, _ := json.Marshal(fields)
+		return data
+	}
+
+	execute("Fix the login bug\nIt fails on empty passwords")
+	assert.Equal(t, "first", setup.project.SessionID)
+
+	// Fork the active session; the next run continues it in a new session
+	require.NoError(t, h.Session.HandleSessionFork(ctx, session, request(map[string]string{"title": "Try OAuth instead"})))
+	data := lastResponseData(t, tws, len(tws.GetReceivedMessages()))
+	assert.Equal(t, "fork_pending", data["status"])
+	assert.Equal(t, "first", data["fork"].(map[string]interface{})["from"])
+
+	execute("Replace the login form with OAuth")
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(args), "-c first --fork-session -p")
+	assert.Equal(t, "forked", setup.project.SessionID)
+
+	list, fork, err := catalogue.List(projectID)
+	require.NoError(t, err)
+	assert.Nil(t, fork)
+	require.Len(t, list, 2)
+	assert.Equal(t, "forked", list[0].ID)
+	assert.Equal(t, "Try OAuth instead", list[0].Title)
+	assert.Equal(t, "first", list[0].ForkedFrom)
+	assert.Equal(t, "first", list[1].ID)
+	assert.Equal(t, "Fix the login bug", list[1].Title)
+
+	// Observers can list sessions but not switch them
+	require.NoError(t, h.Session.HandleSessionList(ctx, observer, nil))
+	err = h.Session.HandleSessionSwitch(ctx, observer, request(map[string]string{"session_id": "first"}))
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	// A new session keeps the old ones in the catalogue
+	require.NoError(t, h.Execution.HandleAgentNewSession(ctx, session, nil))
+	assert.Empty(t, setup.project.SessionID)
+
+	require.NoError(t, h.Session.HandleSessionSwitch(ctx, session, request(map[string]string{"session_id": "first"})))
+	data = lastResponseData(t, tws, len(tws.GetReceivedMessages()))
+	assert.Equal(t, "switched", data["status"])
+	assert.Equal(t, "first", setup.project.SessionID)
+
+	execute("Also handle locked accounts")
+	first, err := catalogue.Get(projectID, "first")
+	require.NoError(t, err)
+	assert.Equal(t, "Fix the login bug\nIt fails on empty passwords", first.FirstPrompt)
+
+	err = h.Session.HandleSessionSwitch(ctx, session, request(map[string]string{"session_id": "missing"}))
+	assert.True(t, errors.IsCode(err, errors.CodeSessionNotFound))
+}
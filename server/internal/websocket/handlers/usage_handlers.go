@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/usage"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+)
+
+// defaultUsageReportDays is the number of days usage_report covers by default
+const defaultUsageReportDays = 7
+
+// UsageMetrics receives the usage of finished executions
+type UsageMetrics interface {
+	RecordUsage(projectID string, usage models.Usage)
+}
+
+// UsageHandlers provides handlers for usage reports
+type UsageHandlers struct {
+	projectMgr *project.Manager
+	usage      *usage.Ledger
+	log        *logger.Logger
+}
+
+// NewUsageHandlers creates new usage handlers. A nil ledger disables usage
+// reports.
+func NewUsageHandlers(projectMgr *project.Manager, ledger *usage.Ledger, log *logger.Logger) *UsageHandlers {
+	return &UsageHandlers{
+		projectMgr: projectMgr,
+		usage:      ledger,
+		log:        log,
+	}
+}
+
+// usageScope is the usage of a project or identity
+type usageScope struct {
+	ID string `json:"id"`
+	usage.Series
+}
+
+// HandleUsageReport reports the usage of the caller's identity and, when a
+// project is given or joined, of that project, along with the budgets that
+// apply to them
+func (h *UsageHandlers) HandleUsageReport(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Days      int    `json:"days"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return errors.Wrap(err, errors.CodeValidationFailed, "invalid usage_report request")
+		}
+	}
+	if req.Days < 0 {
+		return errors.New(errors.CodeValidationFailed, "days cannot be negative")
+	}
+	if req.Days == 0 {
+		req.Days = defaultUsageReportDays
+	}
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+
+	if h.usage == nil {
+		return errors.New(errors.CodeValidationFailed, "usage accounting is disabled")
+	}
+
+	principal := session.GetIdentity().String()
+	response := map[string]interface{}{
+		"identity": usageScope{ID: principal, Series: h.usage.Identity(principal, req.Days)},
+	}
+
+	// Anyone who can read a project can see what it costs
+	if req.ProjectID != "" {
+		project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+		if err != nil {
+			return err
+		}
+		response["project"] = usageScope{ID: project.ID, Series: h.usage.Project(project.ID, req.Days)}
+	}
+
+	budgets := h.usage.Budgets(req.ProjectID, principal)
+	if budgets == nil {
+		budgets = []usage.BudgetStatus{}
+	}
+	response["budgets"] = budgets
+
+	return websocket.SendSuccess(session, models.MessageTypeUsageReport, response)
+}
+
+// RegisterHandlers registers all usage handlers with the router
+func (h *UsageHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeUsageReport, h.HandleUsageReport)
+}
+
+// checkBudgets fails when a budget of the project or principal is spent
+func (h *ExecutionHandlers) checkBudgets(projectID, principal string) error {
+	if h.usage == nil {
+		return nil
+	}
+	return h.usage.Check(projectID, principal)
+}
+
+// recordUsage accounts the usage of a finished run
+func (h *ExecutionHandlers) recordUsage(projectID string, r *run, used models.Usage) {
+	if used.IsZero() {
+		return
+	}
+
+	if h.metrics != nil {
+		h.metrics.RecordUsage(projectID, used)
+	}
+	if h.usage == nil {
+		return
+	}
+	if err := h.usage.Record(projectID, r.startedBy, used); err != nil {
+		h.log.Error("Failed to record usage",
+			"project_id", projectID,
+			"execution_id", r.executionID,
+			"error", err,
+		)
+	}
+}
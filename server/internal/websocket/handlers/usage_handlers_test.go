@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/usage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedUsage collects the usage reported to the metrics
+type recordedUsage struct {
+	mu    sync.Mutex
+	total models.Usage
+}
+
+func (r *recordedUsage) RecordUsage(projectID string, u models.Usage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total.Add(u)
+}
+
+func (r *recordedUsage) get() models.Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
+
+func TestExecutionHandlers_UsageAndBudgets(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+
+	script := "cat > /dev/null\necho '{\"type\":\"result\",\"total_cost_usd\":1.5,\"usage\":{\"input_tokens\":100,\"output_tokens\":20}}'\n"
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	// Reports need the ledger
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script})
+	err := h.Usage.HandleUsageReport(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	ledger, err := usage.NewLedger(t.TempDir(), usage.Config{
+		RetentionDays: 30,
+		Budgets:       usage.Budgets{ProjectDaily: 1},
+	})
+	require.NoError(t, err)
+	metrics := &recordedUsage{}
+	h = createTestHandlers(t, setup, testHandlersOptions{
+		Script: script,
+		Config: Config{Usage: ledger, UsageMetrics: metrics},
+	})
+
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"hello"}`)))
+	require.Eventually(t, func() bool {
+		return metrics.get().CostUSD > 0 && stateOf(setup.project) == models.StateIdle
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int64(100), metrics.get().InputTokens)
+
+	require.NoError(t, h.Usage.HandleUsageReport(ctx, session, []byte(`{"days":2}`)))
+	report := lastResponseData(t, tws, 2)
+	project := report["project"].(map[string]interface{})
+	assert.Equal(t, setup.project.ID, project["id"])
+	assert.Len(t, project["days"], 2)
+	assert.Equal(t, 1.5, project["total"].(map[string]interface{})["cost_usd"])
+	identity := report["identity"].(map[string]interface{})
+	assert.Equal(t, session.GetIdentity().String(), identity["id"])
+	assert.Equal(t, float64(20), identity["total"].(map[string]interface{})["output_tokens"])
+	budgets := report["budgets"].([]interface{})
+	require.Len(t, budgets, 1)
+	assert.Equal(t, usage.BudgetProjectDaily, budgets[0].(map[string]interface{})["budget"])
+
+	// The spent budget blocks the next execution
+	err = h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"again"}`))
+	require.True(t, errors.IsCode(err, errors.CodeBudgetExceeded))
+	assert.Equal(t, 1.5, err.(*errors.AppError).Details["spent_usd"])
+
+	// Observers can read the project's usage
+	observer, _ := newIdentitySession(t, "observer-session", testObserver)
+	projectReport, _ := json.Marshal(map[string]string{"project_id": setup.project.ID})
+	assert.NoError(t, h.Usage.HandleUsageReport(ctx, observer, projectReport))
+
+	unknown, _ := json.Marshal(map[string]string{"project_id": uuid.New().String()})
+	err = h.Usage.HandleUsageReport(ctx, session, unknown)
+	assert.True(t, errors.IsCode(err, errors.CodeProjectNotFound))
+}
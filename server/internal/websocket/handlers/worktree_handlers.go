@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/audit"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/project"
+	"github.com/boyd/pocket_agent/server/internal/websocket"
+	"github.com/boyd/pocket_agent/server/internal/worktree"
+)
+
+// WorktreeHandlers provides handlers for the worktrees runs executed in
+type WorktreeHandlers struct {
+	projectMgr *project.Manager
+	execution  *ExecutionHandlers
+	worktrees  *worktree.Manager
+	log        *logger.Logger
+	audit      *audit.Log
+}
+
+// NewWorktreeHandlers creates new worktree handlers. Merges are refused
+// while the execution handlers run in the project's directory. A nil
+// manager disables worktree executions.
+func NewWorktreeHandlers(projectMgr *project.Manager, execution *ExecutionHandlers, worktrees *worktree.Manager, log *logger.Logger) *WorktreeHandlers {
+	return &WorktreeHandlers{
+		projectMgr: projectMgr,
+		execution:  execution,
+		worktrees:  worktrees,
+		log:        log,
+	}
+}
+
+// worktreeRequest identifies a project's worktree
+type worktreeRequest struct {
+	ProjectID  string `json:"project_id"`
+	WorktreeID string `json:"worktree_id"`
+}
+
+// parseWorktreeRequest decodes a worktree request, defaulting to the
+// session's project, and fails when worktree executions are disabled
+func (h *WorktreeHandlers) parseWorktreeRequest(session *models.Session, data json.RawMessage) (worktreeRequest, error) {
+	var req worktreeRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, errors.Wrap(err, errors.CodeValidationFailed, "invalid worktree request")
+		}
+	}
+
+	if req.ProjectID == "" {
+		req.ProjectID = session.GetProject()
+	}
+	if req.ProjectID == "" {
+		return req, errors.New(errors.CodeValidationFailed, "project_id is required")
+	}
+	if h.worktrees == nil {
+		return req, errors.New(errors.CodeValidationFailed, "worktree executions are disabled")
+	}
+
+	return req, nil
+}
+
+// worktreeOptions returns the worktree an execute command asks for, if any
+func worktreeOptions(cmd models.ExecuteCommand) *models.WorktreeOptions {
+	if cmd.Options == nil {
+		return nil
+	}
+	return cmd.Options.Worktree
+}
+
+// startInWorktree checks out a new worktree for a run and starts the run in
+// it. Runs in worktrees neither queue nor change the project's state, so
+// they run alongside the project's other runs.
+func (h *ExecutionHandlers) startInWorktree(project *models.Project, options executor.ExecuteOptions, opts models.WorktreeOptions, startedBy string) (*run, error) {
+	if h.worktrees == nil {
+		return nil, errors.New(errors.CodeValidationFailed, "worktree executions are disabled")
+	}
+	if options.Interactive {
+		return nil, errors.New(errors.CodeValidationFailed, "worktree executions cannot be interactive")
+	}
+
+	// Leave no worktree behind for a run that could not start anyway
+	h.mu.Lock()
+	if limit := h.executor.MaxConcurrentExecutions(); len(h.runs) >= limit {
+		h.mu.Unlock()
+		return nil, errors.NewResourceLimitError("concurrent executions", limit, len(h.runs))
+	}
+	h.mu.Unlock()
+
+	wt, err := h.worktrees.Create(project.ID, project.Path, opts, startedBy)
+	if err != nil {
+		return nil, err
+	}
+	options.Worktree = &wt
+
+	r := &run{startedBy: startedBy, worktree: &wt}
+	if r.executionID = h.record(project, options, startedBy, ""); r.executionID != "" {
+		wt.ExecutionID = r.executionID
+		if err := h.worktrees.Attach(project.ID, wt.ID, r.executionID); err != nil {
+			h.log.Error("Failed to record worktree execution", "project_id", project.ID, "worktree_id", wt.ID, "error", err)
+		}
+	}
+	options.ExecutionID = r.executionID
+	options.OnStall = h.reportStall(project, r)
+
+	h.log.Info("Starting Claude command in worktree",
+		"project_id", project.ID,
+		"worktree_id", wt.ID,
+		"branch", wt.Branch,
+		"base_commit", wt.BaseCommit,
+		"execution_id", r.executionID,
+	)
+
+	h.mu.Lock()
+	h.runs[executor.ProcessKey(project.ID, wt.ID)] = r
+	delete(h.interrupted, project.ID)
+	h.mu.Unlock()
+
+	go h.executeInWorktree(project, options, r)
+	return r, nil
+}
+
+// executeInWorktree runs a run in its worktree and streams its output to
+// the project's subscribers. The worktree is kept for merging or discarding.
+func (h *ExecutionHandlers) executeInWorktree(project *models.Project, options executor.ExecuteOptions, r *run) {
+	startTime := time.Now()
+	wt := r.worktree
+
+	response, err := h.executor.ExecuteWithCallback(project, options, func(msg models.ClaudeMessage) {
+		h.broadcast.BroadcastClaudeMessage(project, msg)
+	})
+
+	if err != nil {
+		h.log.Error("Claude execution in worktree failed",
+			"project_id", project.ID,
+			"worktree_id", wt.ID,
+			"error", err,
+			"duration", time.Since(startTime),
+		)
+		h.broadcast.BroadcastError(project, withWorktreeID(err, wt.ID))
+	} else {
+		h.log.Info("Claude execution in worktree completed",
+			"project_id", project.ID,
+			"worktree_id", wt.ID,
+			"duration", time.Since(startTime),
+		)
+	}
+
+	h.mu.Lock()
+	killed := r.killed
+	delete(h.runs, executor.ProcessKey(project.ID, wt.ID))
+	h.mu.Unlock()
+
+	if err := h.worktrees.Release(project.ID, wt.ID); err != nil {
+		h.log.Error("Failed to release worktree", "project_id", project.ID, "worktree_id", wt.ID, "error", err)
+	}
+	h.finishRecord(project.ID, r, response, err, killed)
+	h.broadcastChanges(project, r, response)
+	if response != nil {
+		h.recordUsage(project.ID, r, response.Usage)
+	}
+}
+
+// killInWorktree stops the run in a project's worktree. The worktree is
+// kept.
+func (h *ExecutionHandlers) killInWorktree(session *models.Session, projectID, worktreeID string) error {
+	key := executor.ProcessKey(projectID, worktreeID)
+
+	// Mark the run first so it is recorded as killed
+	h.mu.Lock()
+	killed := h.runs[key]
+	if killed != nil {
+		killed.killed = true
+	}
+	h.mu.Unlock()
+
+	if err := h.executor.KillExecution(key); err != nil {
+		h.mu.Lock()
+		if killed != nil {
+			killed.killed = false
+		}
+		h.mu.Unlock()
+
+		if errors.IsCode(err, errors.CodeProcessNotFound) {
+			return errors.New(errors.CodeProcessNotFound, "no active execution in worktree").
+				WithDetail("project_id", projectID).
+				WithDetail("worktree_id", worktreeID)
+		}
+		return err
+	}
+
+	h.log.Info("Claude process in worktree killed",
+		"session_id", session.ID,
+		"project_id", projectID,
+		"worktree_id", worktreeID,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeAgentKill, map[string]interface{}{
+		"project_id":  projectID,
+		"worktree_id": worktreeID,
+		"status":      "killed",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// HandleWorktreeList returns a project's worktrees
+func (h *WorktreeHandlers) HandleWorktreeList(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseWorktreeRequest(session, data)
+	if err != nil {
+		return err
+	}
+
+	// Anyone who can read the project can see its worktrees
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleObserver)
+	if err != nil {
+		return err
+	}
+
+	worktrees, err := h.worktrees.List(project.ID)
+	if err != nil {
+		return err
+	}
+
+	return websocket.SendSuccess(session, models.MessageTypeWorktreeList, map[string]interface{}{
+		"project_id": project.ID,
+		"worktrees":  worktrees,
+	})
+}
+
+// HandleWorktreeMerge merges the branch of a worktree whose run ended into
+// the project's current branch, then removes the worktree. The project's
+// directory must not be in use by a run.
+func (h *WorktreeHandlers) HandleWorktreeMerge(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseWorktreeRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.WorktreeID == "" {
+		return errors.New(errors.CodeValidationFailed, "worktree_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("worktree_id", req.WorktreeID)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	// Hold off runs in the project's directory while the merge changes it
+	var wt models.Worktree
+	var commit string
+	err = h.execution.whileIdle(project.ID, "merge a worktree", func() (err error) {
+		wt, commit, err = h.worktrees.Merge(project.ID, req.WorktreeID, project.Path)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	event.Set("branch", wt.Branch)
+	event.Set("commit", commit)
+
+	h.log.Info("Worktree merged",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"worktree_id", wt.ID,
+		"branch", wt.Branch,
+		"commit", commit,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeWorktreeMerge, map[string]interface{}{
+		"project_id": project.ID,
+		"worktree":   wt,
+		"commit":     commit,
+		"status":     "merged",
+	})
+}
+
+// HandleWorktreeDiscard removes a worktree whose run ended, along with its
+// branch and changes
+func (h *WorktreeHandlers) HandleWorktreeDiscard(ctx context.Context, session *models.Session, data json.RawMessage) error {
+	req, err := h.parseWorktreeRequest(session, data)
+	if err != nil {
+		return err
+	}
+	if req.WorktreeID == "" {
+		return errors.New(errors.CodeValidationFailed, "worktree_id is required")
+	}
+
+	event := audit.EventFromContext(ctx)
+	event.SetProject(req.ProjectID)
+	event.Set("worktree_id", req.WorktreeID)
+
+	project, err := authorizeProject(h.projectMgr, session, req.ProjectID, models.RoleExecutor)
+	if err != nil {
+		return err
+	}
+
+	wt, err := h.worktrees.Discard(project.ID, req.WorktreeID, project.Path)
+	if err != nil {
+		return err
+	}
+	event.Set("branch", wt.Branch)
+
+	h.log.Info("Worktree discarded",
+		"session_id", session.ID,
+		"project_id", project.ID,
+		"worktree_id", wt.ID,
+		"branch", wt.Branch,
+	)
+
+	return websocket.SendSuccess(session, models.MessageTypeWorktreeDiscard, map[string]interface{}{
+		"project_id":  project.ID,
+		"worktree_id": wt.ID,
+		"status":      "discarded",
+	})
+}
+
+// CleanupWorktrees reconciles the worktrees of all projects with the disk,
+// removing the stale ones a previous server run left behind
+func (h *WorktreeHandlers) CleanupWorktrees() {
+	if h.worktrees == nil {
+		return
+	}
+	for _, project := range h.projectMgr.GetAllProjects() {
+		stale, err := h.worktrees.Cleanup(project.ID, project.Path)
+		if err != nil {
+			h.log.Error("Failed to clean up worktrees", "project_id", project.ID, "error", err)
+		}
+		for _, wt := range stale {
+			h.log.Warn("Removed stale worktree", "project_id", project.ID, "worktree_id", wt.ID)
+		}
+	}
+}
+
+// RegisterHandlers registers all worktree handlers with the router
+func (h *WorktreeHandlers) RegisterHandlers(router *websocket.MessageRouter) {
+	router.Register(models.MessageTypeWorktreeList, h.HandleWorktreeList)
+	router.Register(models.MessageTypeWorktreeMerge, audited(h.audit, h.log, models.MessageTypeWorktreeMerge, h.HandleWorktreeMerge))
+	router.Register(models.MessageTypeWorktreeDiscard, audited(h.audit, h.log, models.MessageTypeWorktreeDiscard, h.HandleWorktreeDiscard))
+}
+
+// withWorktreeID tags the error of a run in a worktree with the worktree's ID
+func withWorktreeID(err error, worktreeID string) error {
+	appErr, ok := err.(*errors.AppError)
+	if !ok {
+		appErr = errors.NewInternalError(err)
+	}
+	return appErr.WithDetail("worktree_id", worktreeID)
+}
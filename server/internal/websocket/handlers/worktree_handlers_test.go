@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/executor"
+	"github.com/boyd/pocket_agent/server/internal/history"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/worktree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// worktreeScript makes the test project a git repository and returns a mock
+// Claude script that writes a file named after its working directory, then
+// runs the given shell commands
+func worktreeScript(t *testing.T, setup *aclTestSetup, then string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := setup.project.Path
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@example.com"},
+		{"commit", "--quiet", "--allow-empty", "-m", "initial"},
+	} {
+		out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	return "cat > /dev/null\necho run > \"$(basename \"$PWD\").txt\"\n" + then + "\n"
+}
+
+// worktreeConfig gives handlers execution records and worktrees
+func worktreeConfig(t *testing.T) Config {
+	return Config{
+		ExecutionHistory: history.NewStore(t.TempDir(), 10),
+		Worktrees:        worktree.NewManager(t.TempDir(), 5),
+	}
+}
+
+func TestExecutionHandlers_WorktreeMerge(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	cfg := worktreeConfig(t)
+	h := createTestHandlers(t, setup, testHandlersOptions{
+		Script: worktreeScript(t, setup, "sleep 0.3\necho '{\"type\":\"result\",\"result\":\"done\"}'"),
+		Config: cfg,
+	})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+	execute := func(options map[string]interface{}) error {
+		data, _ := json.Marshal(map[string]interface{}{"prompt": "try something", "options": options})
+		return h.Execution.HandleExecute(ctx, session, data)
+	}
+
+	require.NoError(t, execute(map[string]interface{}{"worktree": map[string]string{"branch": "experiment"}}))
+	data := lastResponseData(t, tws, 1)
+	assert.Equal(t, "started", data["status"])
+	executionID := data["execution_id"].(string)
+	wt := data["worktree"].(map[string]interface{})
+	worktreeID := wt["id"].(string)
+	assert.Equal(t, "experiment", wt["branch"])
+	assert.Equal(t, executionID, wt["execution_id"])
+
+	// A run in the project's directory starts alongside instead of queuing
+	require.NoError(t, execute(nil))
+	assert.Equal(t, "started", lastResponseData(t, tws, 2)["status"])
+
+	// Neither can be merged while running
+	mergeReq := []byte(`{"worktree_id":"` + worktreeID + `"}`)
+	err := h.Worktree.HandleWorktreeMerge(ctx, session, mergeReq)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessActive))
+
+	require.Eventually(t, func() bool { return idle(h.Execution) }, 5*time.Second, 20*time.Millisecond)
+
+	// The run in the worktree left the project's directory alone
+	assert.FileExists(t, filepath.Join(setup.project.Path, "myproject.txt"))
+	assert.NoFileExists(t, filepath.Join(setup.project.Path, worktreeID+".txt"))
+
+	// Observers can list worktrees but not merge them
+	observer, observerWS := newIdentitySession(t, "observer-session", testObserver)
+	observer.SetProject(setup.project.ID)
+	require.NoError(t, h.Worktree.HandleWorktreeList(ctx, observer, nil))
+	worktrees := lastResponseData(t, observerWS, 1)["worktrees"].([]interface{})
+	require.Len(t, worktrees, 1)
+	assert.Equal(t, string(models.WorktreeReady), worktrees[0].(map[string]interface{})["status"])
+	err = h.Worktree.HandleWorktreeMerge(ctx, observer, mergeReq)
+	assert.True(t, errors.IsCode(err, errors.CodePermissionDenied))
+
+	// The execution record names the worktree
+	rec, err := cfg.ExecutionHistory.Get(setup.project.ID, executionID)
+	require.NoError(t, err)
+	require.NotNil(t, rec.Worktree)
+	assert.Equal(t, worktreeID, rec.Worktree.ID)
+	assert.Equal(t, "experiment", rec.Options.Worktree.Branch)
+
+	require.NoError(t, h.Worktree.HandleWorktreeMerge(ctx, session, mergeReq))
+	data = lastResponseData(t, tws, 3)
+	assert.Equal(t, "merged", data["status"])
+	assert.NotEmpty(t, data["commit"])
+	assert.FileExists(t, filepath.Join(setup.project.Path, worktreeID+".txt"))
+
+	err = h.Worktree.HandleWorktreeMerge(ctx, session, mergeReq)
+	assert.True(t, errors.IsCode(err, errors.CodeWorktreeNotFound))
+}
+
+func TestExecutionHandlers_WorktreeKillAndDiscard(t *testing.T) {
+	ctx := context.Background()
+	setup := createACLTestSetup(t)
+	script := worktreeScript(t, setup, "sleep 0.3")
+	cfg := worktreeConfig(t)
+	h := createTestHandlers(t, setup, testHandlersOptions{Script: script, Config: cfg})
+
+	session, tws := newIdentitySession(t, "owner-session", testOwner)
+	session.SetProject(setup.project.ID)
+
+	// Worktree runs are not interactive
+	err := h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"hello","options":{"interactive":true,"worktree":{}}}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+
+	require.NoError(t, h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"hello","options":{"worktree":{}}}`)))
+	data := lastResponseData(t, tws, 1)
+	worktreeID := data["worktree"].(map[string]interface{})["id"].(string)
+	worktreeReq := []byte(`{"worktree_id":"` + worktreeID + `"}`)
+	assert.False(t, h.Execution.executor.IsProjectExecuting(setup.project.ID))
+
+	// Killing the project's own run does not reach the worktree's
+	err = h.Execution.HandleAgentKill(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessNotFound))
+	assert.True(t, h.Execution.executor.IsProjectExecuting(executor.ProcessKey(setup.project.ID, worktreeID)))
+
+	require.Eventually(t, func() bool { return idle(h.Execution) }, 5*time.Second, 20*time.Millisecond)
+	err = h.Execution.HandleAgentKill(ctx, session, worktreeReq)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessNotFound))
+
+	require.NoError(t, h.Worktree.HandleWorktreeDiscard(ctx, session, worktreeReq))
+	assert.Equal(t, "discarded", lastResponseData(t, tws, 2)["status"])
+	worktrees, err := cfg.Worktrees.List(setup.project.ID)
+	require.NoError(t, err)
+	assert.Empty(t, worktrees)
+
+	// Without a manager worktrees are disabled
+	h = createTestHandlers(t, setup, testHandlersOptions{Script: script})
+	err = h.Execution.HandleExecute(ctx, session, []byte(`{"prompt":"hello","options":{"worktree":{}}}`))
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+	err = h.Worktree.HandleWorktreeList(ctx, session, nil)
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed))
+}
@@ -0,0 +1,238 @@
+package websocket
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+)
+
+// defaultLimitKey is the bucket shared by message types without their own limit
+const defaultLimitKey = "*"
+
+// pruneInterval is how often full buckets are dropped
+const pruneInterval = time.Minute
+
+// MessageRate is a token bucket: up to Burst messages at once, refilled at
+// PerMinute. A zero PerMinute leaves messages unlimited.
+type MessageRate struct {
+	PerMinute float64
+	Burst     int
+}
+
+// MessageLimit limits a message type per session and per identity. The
+// identity bucket is shared by all connections of the same principal, or
+// of the same client IP when clients are not authenticated.
+type MessageLimit struct {
+	Session  MessageRate
+	Identity MessageRate
+}
+
+// MessageLimiterConfig configures a MessageLimiter
+type MessageLimiterConfig struct {
+	// Default applies to message types without their own entry in Types;
+	// those types share one bucket
+	Default MessageLimit
+	Types   map[models.MessageType]MessageLimit
+}
+
+// MessageLimiter rate limits inbound messages with token buckets per session
+// and per identity
+type MessageLimiter struct {
+	config MessageLimiterConfig
+
+	// idle is how long the slowest bucket takes to refill from empty
+	idle time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// tokenBucket holds the tokens of one key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMessageLimiter creates a new message limiter
+func NewMessageLimiter(config MessageLimiterConfig) *MessageLimiter {
+	l := &MessageLimiter{
+		config:  config,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+
+	limits := []MessageLimit{config.Default}
+	for _, limit := range config.Types {
+		limits = append(limits, limit)
+	}
+	for _, limit := range limits {
+		for _, rate := range []MessageRate{limit.Session, limit.Identity} {
+			if d := rate.refillTime(); d > l.idle {
+				l.idle = d
+			}
+		}
+	}
+
+	return l
+}
+
+// burst returns the bucket capacity, at least one message
+func (r MessageRate) burst() float64 {
+	return math.Max(1, float64(r.Burst))
+}
+
+// refillTime returns how long an empty bucket takes to fill
+func (r MessageRate) refillTime() time.Duration {
+	if r.PerMinute <= 0 {
+		return 0
+	}
+	return time.Duration(r.burst() / r.PerMinute * float64(time.Minute))
+}
+
+// Allow takes a token for the message from the session's and the identity's
+// buckets. When either is empty no token is taken and a RATE_LIMITED error
+// reports how long to wait.
+func (l *MessageLimiter) Allow(session *models.Session, msgType models.MessageType) error {
+	limit, ok := l.config.Types[msgType]
+	typeKey := string(msgType)
+	if !ok {
+		limit = l.config.Default
+		typeKey = defaultLimitKey
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	checks := []struct {
+		scope  string
+		key    string
+		rate   MessageRate
+		bucket *tokenBucket
+	}{
+		{"session", "session:" + session.ID + ":" + typeKey, limit.Session, nil},
+		{"identity", "identity:" + limitKey(session) + ":" + typeKey, limit.Identity, nil},
+	}
+
+	// Check both buckets before taking from either
+	for i := range checks {
+		c := &checks[i]
+		if c.rate.PerMinute <= 0 {
+			continue
+		}
+		c.bucket = l.bucket(c.key, c.rate, now)
+		if c.bucket.tokens < 1 {
+			wait := time.Duration((1 - c.bucket.tokens) / c.rate.PerMinute * float64(time.Minute))
+			return errors.NewRateLimitError(c.scope, wait).
+				WithDetail("message_type", msgType)
+		}
+	}
+
+	for _, c := range checks {
+		if c.bucket != nil {
+			c.bucket.tokens--
+		}
+	}
+	return nil
+}
+
+// bucket returns the refilled bucket for key, creating a full one if needed
+func (l *MessageLimiter) bucket(key string, rate MessageRate, now time.Time) *tokenBucket {
+	burst := rate.burst()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.last)
+	b.tokens = math.Min(burst, b.tokens+elapsed.Minutes()*rate.PerMinute)
+	b.last = now
+	return b
+}
+
+// prune drops buckets that have refilled since their last use; a missing
+// bucket is equivalent to a full one
+func (l *MessageLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limitKey returns the key of the identity bucket and quota of the
+// session. Sessions without an identity are keyed by client IP, so clients
+// of a server without authentication do not share one anonymous limit.
+func limitKey(session *models.Session) string {
+	identity := session.GetIdentity()
+	if identity == nil && session.RemoteIP != "" {
+		return "ip:" + session.RemoteIP
+	}
+	return identity.String()
+}
+
+// RateLimitMiddleware rejects messages over the limiter's rates
+func RateLimitMiddleware(limiter *MessageLimiter, log *logger.Logger) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, session *models.Session, msg *models.ClientMessage) error {
+			if err := limiter.Allow(session, msg.Type); err != nil {
+				log.Warn("Message rate limited",
+					"session_id", session.ID,
+					"identity", limitKey(session),
+					"type", msg.Type,
+				)
+				return err
+			}
+
+			return next.HandleMessage(ctx, session, msg)
+		})
+	}
+}
+
+// QuotaMiddleware counts messages of msgType against the sender's quota,
+// which anonymous senders have per client IP. A message whose handler fails
+// gives its use back.
+func QuotaMiddleware(tracker *quota.Tracker, msgType models.MessageType, log *logger.Logger) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, session *models.Session, msg *models.ClientMessage) error {
+			if msg.Type != msgType {
+				return next.HandleMessage(ctx, session, msg)
+			}
+
+			principal := limitKey(session)
+			release, err := tracker.Reserve(principal)
+			if err != nil {
+				log.Warn("Quota exceeded",
+					"session_id", session.ID,
+					"identity", principal,
+					"type", msg.Type,
+				)
+				return err
+			}
+
+			if err := next.HandleMessage(ctx, session, msg); err != nil {
+				release()
+				return err
+			}
+			return nil
+		})
+	}
+}
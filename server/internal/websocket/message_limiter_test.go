@@ -0,0 +1,199 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/quota"
+)
+
+// newLimitedSession creates a session for identity
+func newLimitedSession(id string, identity *models.Identity) *models.Session {
+	session := models.NewSession(id, nil)
+	session.SetIdentity(identity)
+	return session
+}
+
+func TestMessageLimiterSessionBucket(t *testing.T) {
+	limiter := NewMessageLimiter(MessageLimiterConfig{
+		Default: MessageLimit{Session: MessageRate{PerMinute: 60, Burst: 2}},
+	})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	session := newLimitedSession("session-1", nil)
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(session, models.MessageTypeProjectList); err != nil {
+			t.Fatalf("message %d should be allowed: %v", i+1, err)
+		}
+	}
+
+	err := limiter.Allow(session, models.MessageTypeGetMessages)
+	if !errors.IsCode(err, errors.CodeRateLimited) {
+		t.Fatalf("expected RATE_LIMITED, got %v", err)
+	}
+	details := err.(*errors.AppError).Details
+	if details["scope"] != "session" || details["retry_after_ms"] != int64(1000) {
+		t.Errorf("unexpected details: %v", details)
+	}
+
+	// Other sessions have their own bucket
+	if err := limiter.Allow(newLimitedSession("session-2", nil), models.MessageTypeProjectList); err != nil {
+		t.Errorf("other session should be allowed: %v", err)
+	}
+
+	// Tokens refill over time
+	now = now.Add(time.Second)
+	if err := limiter.Allow(session, models.MessageTypeProjectList); err != nil {
+		t.Errorf("message should be allowed after refill: %v", err)
+	}
+}
+
+func TestMessageLimiterIdentityBucket(t *testing.T) {
+	limiter := NewMessageLimiter(MessageLimiterConfig{
+		Types: map[models.MessageType]MessageLimit{
+			models.MessageTypeExecute: {
+				Session:  MessageRate{PerMinute: 60, Burst: 2},
+				Identity: MessageRate{PerMinute: 1, Burst: 3},
+			},
+		},
+	})
+	identity := &models.Identity{ID: "tok-1", Method: models.AuthMethodToken}
+	first := newLimitedSession("session-1", identity)
+	second := newLimitedSession("session-2", identity)
+
+	// Connections of the same identity share its bucket
+	for _, session := range []*models.Session{first, first, second} {
+		if err := limiter.Allow(session, models.MessageTypeExecute); err != nil {
+			t.Fatalf("message should be allowed: %v", err)
+		}
+	}
+	err := limiter.Allow(second, models.MessageTypeExecute)
+	if !errors.IsCode(err, errors.CodeRateLimited) || err.(*errors.AppError).Details["scope"] != "identity" {
+		t.Fatalf("expected identity RATE_LIMITED, got %v", err)
+	}
+
+	// Types without a limit are not limited
+	for i := 0; i < 10; i++ {
+		if err := limiter.Allow(first, models.MessageTypeProjectList); err != nil {
+			t.Fatalf("unlimited message should be allowed: %v", err)
+		}
+	}
+
+	// A rejected message takes no token from the session bucket
+	other := NewMessageLimiter(MessageLimiterConfig{
+		Default: MessageLimit{
+			Session:  MessageRate{PerMinute: 60, Burst: 1},
+			Identity: MessageRate{PerMinute: 1, Burst: 1},
+		},
+	})
+	blocked := newLimitedSession("session-3", identity)
+	if err := other.Allow(newLimitedSession("session-4", identity), models.MessageTypeProjectList); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Allow(blocked, models.MessageTypeProjectList); err == nil {
+		t.Fatal("expected identity limit")
+	}
+	if tokens := other.buckets["session:session-3:*"].tokens; tokens != 1 {
+		t.Errorf("expected session bucket untouched, got %v tokens", tokens)
+	}
+}
+
+func TestMessageLimiterAnonymousBucketPerIP(t *testing.T) {
+	limiter := NewMessageLimiter(MessageLimiterConfig{
+		Default: MessageLimit{Identity: MessageRate{PerMinute: 1, Burst: 1}},
+	})
+	anonymous := func(id, ip string) *models.Session {
+		session := newLimitedSession(id, nil)
+		session.RemoteIP = ip
+		return session
+	}
+
+	// Without authentication, connections from one IP share a bucket
+	if err := limiter.Allow(anonymous("session-1", "192.0.2.1"), models.MessageTypeProjectList); err != nil {
+		t.Fatal(err)
+	}
+	err := limiter.Allow(anonymous("session-2", "192.0.2.1"), models.MessageTypeProjectList)
+	if !errors.IsCode(err, errors.CodeRateLimited) {
+		t.Fatalf("expected identity RATE_LIMITED, got %v", err)
+	}
+
+	// Other IPs are not limited by it
+	if err := limiter.Allow(anonymous("session-3", "192.0.2.2"), models.MessageTypeProjectList); err != nil {
+		t.Errorf("other IP should be allowed: %v", err)
+	}
+}
+
+func TestMessageLimiterPrunesIdleBuckets(t *testing.T) {
+	limiter := NewMessageLimiter(MessageLimiterConfig{
+		Default: MessageLimit{Session: MessageRate{PerMinute: 60, Burst: 5}},
+	})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		limiter.Allow(newLimitedSession(generateSessionID(), nil), models.MessageTypeProjectList)
+	}
+	if len(limiter.buckets) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(limiter.buckets))
+	}
+
+	now = now.Add(2 * pruneInterval)
+	limiter.Allow(newLimitedSession("fresh", nil), models.MessageTypeProjectList)
+	if len(limiter.buckets) != 1 {
+		t.Errorf("expected idle buckets to be pruned, got %d", len(limiter.buckets))
+	}
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	tracker, err := quota.NewTracker(t.TempDir(), quota.Config{Name: "daily_executions", Daily: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fail bool
+	handler := QuotaMiddleware(tracker, models.MessageTypeExecute, logger.New("error"))(
+		MessageHandlerFunc(func(ctx context.Context, session *models.Session, msg *models.ClientMessage) error {
+			if fail {
+				return errors.New(errors.CodeValidationFailed, "invalid")
+			}
+			return nil
+		}),
+	)
+
+	ctx := context.Background()
+	session := newLimitedSession("session-1", nil)
+	execute := &models.ClientMessage{Type: models.MessageTypeExecute}
+
+	// Failed executions give their use back
+	fail = true
+	if err := handler.HandleMessage(ctx, session, execute); !errors.IsCode(err, errors.CodeValidationFailed) {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	fail = false
+	if err := handler.HandleMessage(ctx, session, execute); err != nil {
+		t.Fatalf("expected execution within quota, got %v", err)
+	}
+	if err := handler.HandleMessage(ctx, session, execute); !errors.IsCode(err, errors.CodeQuotaExceeded) {
+		t.Fatalf("expected QUOTA_EXCEEDED, got %v", err)
+	}
+
+	// Other message types are not counted
+	if err := handler.HandleMessage(ctx, session, &models.ClientMessage{Type: models.MessageTypeProjectList}); err != nil {
+		t.Errorf("expected other messages to pass, got %v", err)
+	}
+
+	// Anonymous clients have a quota per IP
+	remote := newLimitedSession("session-2", nil)
+	remote.RemoteIP = "192.0.2.1"
+	if err := handler.HandleMessage(ctx, remote, execute); err != nil {
+		t.Fatalf("expected another IP within quota, got %v", err)
+	}
+	if used := tracker.Used("ip:192.0.2.1"); used != 1 {
+		t.Errorf("expected the IP's use to be counted, got %d", used)
+	}
+}
@@ -185,6 +185,8 @@ func ValidationMiddleware() Middleware {
 				models.MessageTypeProjectDelete,
 				models.MessageTypeAgentNewSession,
 				models.MessageTypeAgentKill,
+				models.MessageTypeAgentPause,
+				models.MessageTypeAgentResume,
 				models.MessageTypeProjectLeave,
 				models.MessageTypeGetMessages:
 				// These require an active project
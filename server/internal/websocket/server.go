@@ -3,17 +3,22 @@ package websocket
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/boyd/pocket_agent/server/internal/certs"
 	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/netutil"
 	"github.com/gorilla/websocket"
 )
 
@@ -26,6 +31,19 @@ type Config struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 
+	// Certificates serves the server certificate when set, so rotations
+	// take effect without a restart; /health then reports its fingerprints
+	Certificates *certs.Manager
+
+	// Client certificate (mTLS) settings; only used when TLS is enabled.
+	// ClientCAFile enables verification of client certificates against the
+	// CA bundle, RequireClientCert rejects handshakes without one, and
+	// VerifyClientCert runs additional checks (e.g. revocation) on the
+	// verified leaf certificate.
+	ClientCAFile      string
+	RequireClientCert bool
+	VerifyClientCert  func(cert *x509.Certificate) error
+
 	// Connection settings
 	MaxConnections      int
 	MaxConnectionsPerIP int
@@ -34,9 +52,17 @@ type Config struct {
 	PongTimeout         time.Duration
 
 	// Security settings
+	// AllowedOrigins lists browser origins as "*", scheme://host[:port] or
+	// scheme://*.domain[:port] (see netutil.OriginPattern)
 	AllowedOrigins []string
+	// TrustedProxies lists the proxies whose forwarded headers identify the
+	// client; requests from other peers are attributed to their own address
+	TrustedProxies []*net.IPNet
 	RateLimitPerIP int // connections per minute
 
+	// Subprotocols lists the application subprotocols the server negotiates
+	Subprotocols []string
+
 	// Message settings
 	MaxMessageSize int64
 	BufferSize     int
@@ -74,6 +100,12 @@ type Server struct {
 	totalConnections  int64
 	metricsProvider   MetricsProvider
 
+	// Authentication
+	authenticator Authenticator
+
+	// Origin checks
+	allowedOrigins []netutil.OriginPattern
+
 	// Rate limiting
 	connRateLimiter *RateLimiter
 	ipConnections   sync.Map // map[string]int
@@ -103,11 +135,22 @@ func NewServer(config Config, handler MessageHandler, log *logger.Logger) *Serve
 		connRateLimiter: NewRateLimiter(config.RateLimitPerIP, time.Minute),
 	}
 
+	// Invalid patterns are rejected by config validation; skip any left
+	for _, origin := range config.AllowedOrigins {
+		pattern, err := netutil.ParseOriginPattern(origin)
+		if err != nil {
+			log.Warn("Ignoring invalid allowed origin", "origin", origin, "error", err)
+			continue
+		}
+		s.allowedOrigins = append(s.allowedOrigins, pattern)
+	}
+
 	// Configure upgrader
 	s.upgrader = websocket.Upgrader{
 		ReadBufferSize:  config.BufferSize,
 		WriteBufferSize: config.BufferSize,
 		CheckOrigin:     s.checkOrigin,
+		Subprotocols:    config.Subprotocols,
 		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
 			s.log.Error("WebSocket upgrade error",
 				"status", status,
@@ -125,6 +168,12 @@ func (s *Server) SetMetricsProvider(provider MetricsProvider) {
 	s.metricsProvider = provider
 }
 
+// SetAuthenticator sets the authenticator used on upgrade requests.
+// When no authenticator is set, connections are accepted without credentials.
+func (s *Server) SetAuthenticator(authenticator Authenticator) {
+	s.authenticator = authenticator
+}
+
 // Start starts the WebSocket server
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
@@ -140,22 +189,22 @@ func (s *Server) Start() error {
 
 	// Configure TLS if certificates are provided
 	if s.config.TLSCert != "" && s.config.TLSKey != "" {
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			},
+		tlsConfig, err := s.TLSConfig()
+		if err != nil {
+			return err
 		}
 		s.httpServer.TLSConfig = tlsConfig
 
 		s.log.Info("Starting WebSocket server with TLS",
 			"port", s.config.Port,
 			"cert", s.config.TLSCert,
+			"client_ca", s.config.ClientCAFile,
+			"client_cert_required", s.config.RequireClientCert,
 		)
 
+		if s.config.Certificates != nil {
+			return s.httpServer.ListenAndServeTLS("", "")
+		}
 		return s.httpServer.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
 	}
 
@@ -166,6 +215,61 @@ func (s *Server) Start() error {
 	return s.httpServer.ListenAndServe()
 }
 
+// TLSConfig builds the listener TLS configuration, including client
+// certificate verification when a client CA bundle is configured
+func (s *Server) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		},
+	}
+
+	if s.config.Certificates != nil {
+		tlsConfig.GetCertificate = s.config.Certificates.GetCertificate
+	}
+
+	if s.config.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(s.config.ClientCAFile)
+	if err != nil {
+		return nil, errors.NewFileOperationError("read client CA bundle", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(errors.CodeValidationFailed, "no certificates found in client CA bundle: %s", s.config.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if s.config.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if verify := s.config.VerifyClientCert; verify != nil {
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+				return nil
+			}
+			if err := verify(cs.VerifiedChains[0][0]); err != nil {
+				s.log.Warn("Rejected client certificate",
+					"subject", cs.VerifiedChains[0][0].Subject.String(),
+					"error", err,
+				)
+				return err
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
 // Stop gracefully stops the server
 func (s *Server) Stop(timeout time.Duration) error {
 	s.log.Info("Stopping WebSocket server")
@@ -229,6 +333,23 @@ func (s *Server) HandleUpgrade(w http.ResponseWriter, r *http.Request) (*models.
 			WithDetail("limit", s.config.MaxConnectionsPerIP)
 	}
 
+	// Authenticate before upgrading so no session is created for unknown clients
+	var identity *models.Identity
+	if s.authenticator != nil {
+		id, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			s.log.Warn("Rejected unauthenticated connection",
+				"remote", clientIP,
+				"error", err,
+			)
+			if errors.GetCode(err) != errors.CodeUnauthorized {
+				err = errors.Wrap(err, errors.CodeUnauthorized, "authentication failed")
+			}
+			return nil, err
+		}
+		identity = id
+	}
+
 	// Upgrade connection
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -248,6 +369,8 @@ func (s *Server) HandleUpgrade(w http.ResponseWriter, r *http.Request) (*models.
 	// Create session
 	sessionID := generateSessionID()
 	session := models.NewSession(sessionID, conn)
+	session.RemoteIP = clientIP
+	session.SetIdentity(identity)
 
 	// Store session
 	s.sessions.Store(sessionID, session)
@@ -265,6 +388,7 @@ func (s *Server) HandleUpgrade(w http.ResponseWriter, r *http.Request) (*models.
 	s.log.Info("WebSocket connection established",
 		"session_id", sessionID,
 		"remote", clientIP,
+		"identity", identity.String(),
 		"active_connections", atomic.LoadInt64(&s.activeConnections),
 	)
 
@@ -288,6 +412,9 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			status = http.StatusTooManyRequests
 		case errors.CodeWebSocketError:
 			status = http.StatusBadRequest
+		case errors.CodeUnauthorized:
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pocket-agent"`)
 		}
 		http.Error(w, appErr.Message, status)
 		return
@@ -447,11 +574,8 @@ func (s *Server) cleanupSession(session *models.Session) {
 	}
 
 	// Clean up IP connection count
-	if conn := session.Conn; conn != nil {
-		if addr := conn.RemoteAddr(); addr != nil {
-			ip := extractIP(addr.String())
-			s.decrementIPConnections(ip)
-		}
+	if session.RemoteIP != "" {
+		s.decrementIPConnections(session.RemoteIP)
 	}
 
 	s.log.Info("WebSocket connection closed",
@@ -467,14 +591,8 @@ func (s *Server) checkOrigin(r *http.Request) bool {
 		return true // Allow connections without origin (e.g., native apps)
 	}
 
-	// Check against allowed origins
-	for _, allowed := range s.config.AllowedOrigins {
-		if allowed == "*" {
-			return true
-		}
-		if strings.HasPrefix(origin, allowed) {
-			return true
-		}
+	if netutil.MatchOrigin(s.allowedOrigins, origin) {
+		return true
 	}
 
 	s.log.Warn("Rejected connection from unauthorized origin",
@@ -485,23 +603,52 @@ func (s *Server) checkOrigin(r *http.Request) bool {
 	return false
 }
 
-// getClientIP extracts client IP from request
+// getClientIP extracts client IP from request. Forwarded headers are only
+// honored when the peer is a trusted proxy; X-Forwarded-For is walked from
+// the right, skipping trusted proxies, so clients cannot spoof their address
+// by sending the header themselves.
 func (s *Server) getClientIP(r *http.Request) string {
+	remoteIP := extractIP(r.RemoteAddr)
+	if !s.isTrustedProxy(remoteIP) {
+		return remoteIP
+	}
+
 	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				// Unparseable hops end the trusted chain
+				break
+			}
+			if !s.isTrustedProxy(hop) || i == 0 {
+				return hop
+			}
 		}
 	}
 
 	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
 		return xri
 	}
 
 	// Fall back to RemoteAddr
-	return extractIP(r.RemoteAddr)
+	return remoteIP
+}
+
+// isTrustedProxy reports whether ip belongs to a configured trusted proxy
+func (s *Server) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range s.config.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }
 
 // extractIP extracts IP address from address string
@@ -547,9 +694,19 @@ func (s *Server) getIPConnectionCount(ip string) int {
 
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]interface{}{
+		"status":      "healthy",
+		"connections": atomic.LoadInt64(&s.activeConnections),
+	}
+
+	// Publish the pinned fingerprints, including one staged by a rotation
+	if s.config.Certificates != nil {
+		health["tls"] = s.config.Certificates.Status()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"healthy","connections":%d}`, atomic.LoadInt64(&s.activeConnections))
+	json.NewEncoder(w).Encode(health)
 }
 
 // GetMetrics returns server metrics
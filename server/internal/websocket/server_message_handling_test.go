@@ -45,7 +45,7 @@ func TestMessageHandling(t *testing.T) {
 	broadcaster := handlers.NewBroadcaster(broadcasterConfig, log)
 
 	// Create real handlers
-	projectHandlers := handlers.NewProjectHandlers(projectMgr, broadcaster, log)
+	projectHandlers := handlers.NewProjectHandlers(projectMgr, broadcaster, handlers.ProjectServices{}, log)
 	queryHandlers := handlers.NewQueryHandlers(projectMgr, log)
 
 	// Create router and register handlers
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -256,6 +257,48 @@ func TestOriginValidation(t *testing.T) {
 			requestOrigin:  "http://localhost:3000",
 			expectAllow:    true,
 		},
+		{
+			name:           "Block origin extending an allowed host",
+			allowedOrigins: []string{"https://good.com"},
+			requestOrigin:  "https://good.com.evil.io",
+			expectAllow:    false,
+		},
+		{
+			name:           "Block scheme mismatch",
+			allowedOrigins: []string{"https://good.com"},
+			requestOrigin:  "http://good.com",
+			expectAllow:    false,
+		},
+		{
+			name:           "Block port mismatch",
+			allowedOrigins: []string{"http://localhost:3000"},
+			requestOrigin:  "http://localhost:3001",
+			expectAllow:    false,
+		},
+		{
+			name:           "Allow wildcard subdomain",
+			allowedOrigins: []string{"https://*.good.com"},
+			requestOrigin:  "https://app.good.com",
+			expectAllow:    true,
+		},
+		{
+			name:           "Block wildcard lookalike",
+			allowedOrigins: []string{"https://*.good.com"},
+			requestOrigin:  "https://evilgood.com",
+			expectAllow:    false,
+		},
+		{
+			name:           "Reject own host without allowed origins",
+			allowedOrigins: nil,
+			requestOrigin:  "http://example.com",
+			expectAllow:    false,
+		},
+		{
+			name:           "Reject cross origin without allowed origins",
+			allowedOrigins: nil,
+			requestOrigin:  "http://evil.com",
+			expectAllow:    false,
+		},
 		{
 			name:           "Allow no origin header",
 			allowedOrigins: []string{"http://localhost"},
@@ -286,6 +329,77 @@ func TestOriginValidation(t *testing.T) {
 	}
 }
 
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		trusted    []*net.IPNet
+		remoteAddr string
+		headers    map[string]string
+		expectIP   string
+	}{
+		{
+			name:       "Use remote address without headers",
+			trusted:    []*net.IPNet{proxies},
+			remoteAddr: "203.0.113.7:5000",
+			expectIP:   "203.0.113.7",
+		},
+		{
+			name:       "Ignore forwarded headers from untrusted peers",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"},
+			expectIP:   "203.0.113.7",
+		},
+		{
+			name:       "Honor X-Forwarded-For from trusted proxy",
+			trusted:    []*net.IPNet{proxies},
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expectIP:   "198.51.100.1",
+		},
+		{
+			name:       "Skip trusted hops but not spoofed ones",
+			trusted:    []*net.IPNet{proxies},
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.1, 10.0.0.3"},
+			expectIP:   "198.51.100.1",
+		},
+		{
+			name:       "Honor X-Real-IP from trusted proxy",
+			trusted:    []*net.IPNet{proxies},
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.2"},
+			expectIP:   "198.51.100.2",
+		},
+		{
+			name:       "Ignore invalid forwarded addresses",
+			trusted:    []*net.IPNet{proxies},
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"X-Forwarded-For": "not-an-ip"},
+			expectIP:   "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.TrustedProxies = tt.trusted
+			server := NewServer(config, &mockHandler{}, logger.New("debug"))
+
+			req := httptest.NewRequest("GET", "/ws", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			if ip := server.getClientIP(req); ip != tt.expectIP {
+				t.Errorf("Expected %s, got %s", tt.expectIP, ip)
+			}
+		})
+	}
+}
+
 func TestRateLimiting(t *testing.T) {
 	config := DefaultConfig()
 	config.RateLimitPerIP = 2 // 2 connections per minute
@@ -0,0 +1,244 @@
+package websocket
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/certs"
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/logger"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues client certificates for mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// certAuthenticator maps the verified client certificate to an identity
+type certAuthenticator struct{}
+
+func (certAuthenticator) Authenticate(r *http.Request) (*models.Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New(errors.CodeUnauthorized, "client certificate required")
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	return &models.Identity{ID: cert.Subject.CommonName, Method: models.AuthMethodCertificate}, nil
+}
+
+func startMTLSServer(t *testing.T, config Config) (*Server, string) {
+	server := NewServer(config, &minimalHandler{}, logger.New("debug"))
+	server.SetAuthenticator(certAuthenticator{})
+
+	tlsConfig, err := server.TLSConfig()
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(server.handleWebSocket))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return server, "wss" + strings.TrimPrefix(ts.URL, "https") + "/ws"
+}
+
+func mtlsDialer(cert *tls.Certificate) *websocket.Dialer {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true} // server uses the httptest certificate
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	return &websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: 5 * time.Second}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	t.Run("server_only", func(t *testing.T) {
+		server := NewServer(DefaultConfig(), &minimalHandler{}, logger.New("debug"))
+		tlsConfig, err := server.TLSConfig()
+		require.NoError(t, err)
+		assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+		assert.Nil(t, tlsConfig.ClientCAs)
+	})
+
+	t.Run("optional_client_cert", func(t *testing.T) {
+		config := DefaultConfig()
+		config.ClientCAFile = caFile
+		server := NewServer(config, &minimalHandler{}, logger.New("debug"))
+		tlsConfig, err := server.TLSConfig()
+		require.NoError(t, err)
+		assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+		assert.NotNil(t, tlsConfig.ClientCAs)
+	})
+
+	t.Run("invalid_bundle", func(t *testing.T) {
+		badFile := filepath.Join(t.TempDir(), "bad.pem")
+		require.NoError(t, os.WriteFile(badFile, []byte("not a certificate"), 0o600))
+
+		config := DefaultConfig()
+		config.ClientCAFile = badFile
+		server := NewServer(config, &minimalHandler{}, logger.New("debug"))
+		_, err := server.TLSConfig()
+		assert.Error(t, err)
+	})
+}
+
+func TestWebSocketUpgradeMTLS(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	revoked := map[int64]bool{12: true}
+	config := DefaultConfig()
+	config.ClientCAFile = caFile
+	config.RequireClientCert = true
+	config.VerifyClientCert = func(cert *x509.Certificate) error {
+		if revoked[cert.SerialNumber.Int64()] {
+			return errors.New(errors.CodeUnauthorized, "client certificate has been revoked")
+		}
+		return nil
+	}
+	server, wsURL := startMTLSServer(t, config)
+
+	t.Run("accept_valid_certificate", func(t *testing.T) {
+		cert := ca.issue(t, "laptop", 10)
+		conn, _, err := mtlsDialer(&cert).Dial(wsURL, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var session *models.Session
+		require.Eventually(t, func() bool {
+			server.sessions.Range(func(_, value interface{}) bool {
+				session = value.(*models.Session)
+				return false
+			})
+			return session != nil
+		}, time.Second, 10*time.Millisecond)
+
+		identity := session.GetIdentity()
+		require.NotNil(t, identity)
+		assert.Equal(t, "laptop", identity.ID)
+		assert.Equal(t, models.AuthMethodCertificate, identity.Method)
+	})
+
+	t.Run("reject_missing_certificate", func(t *testing.T) {
+		conn, _, err := mtlsDialer(nil).Dial(wsURL, nil)
+		require.Error(t, err)
+		assert.Nil(t, conn)
+	})
+
+	t.Run("reject_untrusted_certificate", func(t *testing.T) {
+		cert := newTestCA(t).issue(t, "intruder", 11)
+		conn, _, err := mtlsDialer(&cert).Dial(wsURL, nil)
+		require.Error(t, err)
+		assert.Nil(t, conn)
+	})
+
+	t.Run("reject_revoked_certificate", func(t *testing.T) {
+		cert := ca.issue(t, "stolen", 12)
+		conn, _, err := mtlsDialer(&cert).Dial(wsURL, nil)
+		require.Error(t, err)
+		assert.Nil(t, conn)
+	})
+}
+
+func TestServerCertificateManager(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	fingerprint, err := certs.Generate(certFile, keyFile, []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+
+	manager, err := certs.NewManager(certFile, keyFile, logger.New("error"))
+	require.NoError(t, err)
+
+	config := DefaultConfig()
+	config.TLSCert = certFile
+	config.TLSKey = keyFile
+	config.Certificates = manager
+	server := NewServer(config, nil, logger.New("error"))
+
+	// Handshakes are served by the manager
+	tlsConfig, err := server.TLSConfig()
+	require.NoError(t, err)
+	require.NotNil(t, tlsConfig.GetCertificate)
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+
+	// /health publishes the fingerprint clients pin
+	rec := httptest.NewRecorder()
+	server.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var health struct {
+		Status string       `json:"status"`
+		TLS    certs.Status `json:"tls"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &health))
+	assert.Equal(t, "healthy", health.Status)
+	assert.Equal(t, fingerprint, health.TLS.Fingerprint)
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/boyd/pocket_agent/server/internal/errors"
 	"github.com/boyd/pocket_agent/server/internal/logger"
 	"github.com/boyd/pocket_agent/server/internal/models"
 	"github.com/gorilla/websocket"
@@ -250,6 +251,93 @@ func TestWebSocketUpgradeConcurrency(t *testing.T) {
 	assert.LessOrEqual(t, int(successCount), config.MaxConnections)
 }
 
+// TestWebSocketUpgradeAuthentication tests that the authenticator gates the upgrade
+func TestWebSocketUpgradeAuthentication(t *testing.T) {
+	config := DefaultConfig()
+	config.Subprotocols = []string{"pocket-agent"}
+	handler := &minimalHandler{}
+	log := logger.New("debug")
+	server := NewServer(config, handler, log)
+	server.SetAuthenticator(&staticAuthenticator{
+		token:    "secret",
+		identity: &models.Identity{ID: "token-1", Name: "phone", Method: models.AuthMethodToken},
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	t.Run("reject_missing_credentials", func(t *testing.T) {
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.Error(t, err)
+		assert.Nil(t, conn)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+		assert.Equal(t, int64(0), server.GetMetrics()["total_connections"])
+	})
+
+	t.Run("reject_invalid_credentials", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer wrong")
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		require.Error(t, err)
+		assert.Nil(t, conn)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("accept_header_token", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer secret")
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var session *models.Session
+		require.Eventually(t, func() bool {
+			server.sessions.Range(func(_, value interface{}) bool {
+				session = value.(*models.Session)
+				return false
+			})
+			return session != nil
+		}, time.Second, 10*time.Millisecond)
+
+		identity := session.GetIdentity()
+		require.NotNil(t, identity)
+		assert.Equal(t, "token-1", identity.ID)
+	})
+
+	t.Run("accept_subprotocol_token", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"pocket-agent", "pocket-agent.bearer.secret"}}
+		conn, _, err := dialer.Dial(wsURL, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// The server must echo the application subprotocol, never the token
+		assert.Equal(t, "pocket-agent", conn.Subprotocol())
+	})
+}
+
+// staticAuthenticator accepts a single bearer token for testing
+type staticAuthenticator struct {
+	token    string
+	identity *models.Identity
+}
+
+func (a *staticAuthenticator) Authenticate(r *http.Request) (*models.Identity, error) {
+	if r.Header.Get("Authorization") == "Bearer "+a.token {
+		return a.identity, nil
+	}
+	for _, proto := range websocket.Subprotocols(r) {
+		if proto == "pocket-agent.bearer."+a.token {
+			return a.identity, nil
+		}
+	}
+	return nil, errors.New(errors.CodeUnauthorized, "invalid access token")
+}
+
 // minimalHandler is a lightweight handler for testing
 type minimalHandler struct {
 	mu               sync.Mutex
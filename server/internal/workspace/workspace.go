@@ -0,0 +1,445 @@
+// Package workspace captures what an execution changed in its working
+// directory. A snapshot is taken before and after the execution: inside a
+// git repository it is a git tree of the working tree, written with a
+// temporary index so the repository's own index is left alone; elsewhere it
+// is a manifest of file hashes. Comparing two snapshots gives the changed
+// files with their line counts and a unified diff.
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	// MaxFiles caps the files of a manifest; larger directories outside git
+	// are not captured
+	MaxFiles = 20000
+	// MaxTextSize is the size up to which the content of a file outside git
+	// is kept, so that a diff can be shown for it
+	MaxTextSize = 1 << 20
+	// MaxTextTotal caps the content kept for all files of a manifest
+	MaxTextTotal = 32 << 20
+)
+
+// Capturer takes snapshots and compares them
+type Capturer struct {
+	maxPatchSize int64
+}
+
+// NewCapturer creates a capturer whose patches are cut at maxPatchSize
+// bytes
+func NewCapturer(maxPatchSize int64) *Capturer {
+	return &Capturer{maxPatchSize: maxPatchSize}
+}
+
+// Snapshot is the state of a directory at one point in time
+type Snapshot struct {
+	dir string
+	// tree is the git tree of the directory's repository, if it has one
+	tree string
+	// files is the manifest of a directory outside git
+	files map[string]file
+}
+
+// file is one entry of a manifest
+type file struct {
+	hash   [sha256.Size]byte
+	mode   fs.FileMode
+	binary bool
+	// content is kept for text files while the manifest is small enough
+	content []byte
+	kept    bool
+}
+
+// Snapshot records the current state of dir
+func (c *Capturer) Snapshot(dir string) (*Snapshot, error) {
+	if _, err := git(dir, nil, "rev-parse", "--show-toplevel"); err == nil {
+		tree, err := writeTree(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &Snapshot{dir: dir, tree: tree}, nil
+	}
+
+	files, err := manifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{dir: dir, files: files}, nil
+}
+
+// Changes compares the directory of before with its state when before was
+// taken, and returns the changed files and their unified diff
+func (c *Capturer) Changes(before *Snapshot) (models.WorkspaceChanges, string, error) {
+	after, err := c.Snapshot(before.dir)
+	if err != nil {
+		return models.WorkspaceChanges{}, "", err
+	}
+
+	var changes models.WorkspaceChanges
+	var patch string
+	switch {
+	case before.tree != "" && after.tree != "":
+		changes, patch, err = diffTrees(before.dir, before.tree, after.tree)
+	case before.files != nil && after.files != nil:
+		changes, patch = diffManifests(before.files, after.files)
+	default:
+		err = fmt.Errorf("%s changed between a git repository and a plain directory", before.dir)
+	}
+	if err != nil {
+		return models.WorkspaceChanges{}, "", err
+	}
+
+	for _, f := range changes.Files {
+		changes.Additions += f.Additions
+		changes.Deletions += f.Deletions
+	}
+	if c.maxPatchSize > 0 && int64(len(patch)) > c.maxPatchSize {
+		patch = truncate(patch, c.maxPatchSize)
+		changes.Truncated = true
+	}
+	return changes, patch, nil
+}
+
+// writeTree writes the working tree of dir's repository, including
+// untracked files that are not ignored, as a git tree. Files outside dir
+// are taken from the repository's index.
+func writeTree(dir string) (string, error) {
+	index, err := os.CreateTemp("", "pocket-agent-index-*")
+	if err != nil {
+		return "", err
+	}
+	path := index.Name()
+	index.Close()
+	os.Remove(path)
+	defer os.Remove(path)
+
+	// Start from the repository's index, whose file stats spare git from
+	// hashing unchanged files again
+	env := []string{"GIT_INDEX_FILE=" + path}
+	repoIndex, err := git(dir, nil, "rev-parse", "--path-format=absolute", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	if data, err := os.ReadFile(strings.TrimSpace(repoIndex)); err == nil {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return "", err
+		}
+	} else if _, err := git(dir, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		if _, err := git(dir, env, "read-tree", "HEAD"); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := git(dir, env, "add", "--all", "--", "."); err != nil {
+		return "", err
+	}
+	tree, err := git(dir, env, "write-tree")
+	return strings.TrimSpace(tree), err
+}
+
+// diffTrees compares two git trees within dir
+func diffTrees(dir, before, after string) (models.WorkspaceChanges, string, error) {
+	changes := models.WorkspaceChanges{Files: []models.FileChange{}}
+	if before == after {
+		return changes, "", nil
+	}
+	args := []string{"diff", "--no-color", "--no-ext-diff", "--no-renames", "--relative"}
+
+	statuses, err := git(dir, nil, append(args, "--name-status", "-z", before, after, "--", ".")...)
+	if err != nil {
+		return changes, "", err
+	}
+	fields := strings.Split(strings.TrimSuffix(statuses, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		changes.Files = append(changes.Files, models.FileChange{
+			Path:   fields[i+1],
+			Status: fileStatus(fields[i]),
+		})
+	}
+
+	numstat, err := git(dir, nil, append(args, "--numstat", "-z", before, after, "--", ".")...)
+	if err != nil {
+		return changes, "", err
+	}
+	stats := make(map[string][2]int)
+	binary := make(map[string]bool)
+	for _, entry := range strings.Split(strings.TrimSuffix(numstat, "\x00"), "\x00") {
+		parts := strings.SplitN(entry, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "-" {
+			binary[parts[2]] = true
+			continue
+		}
+		additions, _ := strconv.Atoi(parts[0])
+		deletions, _ := strconv.Atoi(parts[1])
+		stats[parts[2]] = [2]int{additions, deletions}
+	}
+	for i := range changes.Files {
+		f := &changes.Files[i]
+		f.Additions, f.Deletions = stats[f.Path][0], stats[f.Path][1]
+		f.Binary = binary[f.Path]
+	}
+
+	patch, err := git(dir, nil, append(args, before, after, "--", ".")...)
+	if err != nil {
+		return changes, "", err
+	}
+	return changes, patch, nil
+}
+
+// fileStatus maps a git status letter to a change status
+func fileStatus(letter string) models.FileChangeStatus {
+	switch letter {
+	case "A":
+		return models.FileAdded
+	case "D":
+		return models.FileDeleted
+	default:
+		return models.FileModified
+	}
+}
+
+// manifest hashes the files below dir. Version control directories are
+// skipped, and symlinks are recorded by their target.
+func manifest(dir string) (map[string]file, error) {
+	files := make(map[string]file)
+	var kept int64
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" || entry.Name() == ".hg" || entry.Name() == ".svn" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(files) >= MaxFiles {
+			return fmt.Errorf("%s has more than %d files", dir, MaxFiles)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f := file{mode: info.Mode()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			f.hash = sha256.Sum256([]byte(target))
+		case info.Mode().IsRegular():
+			if err := f.read(path, kept); os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			kept += int64(len(f.content))
+		default:
+			return nil
+		}
+		files[filepath.ToSlash(rel)] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// read hashes the regular file at path and keeps its content if it is
+// text, small enough, and the manifest has kept less than MaxTextTotal
+func (f *file) read(path string, kept int64) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var head bytes.Buffer
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h, &limitedBuffer{&head, MaxTextSize + 1}), r); err != nil {
+		return err
+	}
+	copy(f.hash[:], h.Sum(nil))
+
+	content := head.Bytes()
+	f.binary = bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+	if !f.binary && len(content) <= MaxTextSize && kept+int64(len(content)) <= MaxTextTotal {
+		f.content, f.kept = content, true
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first n bytes written to it and discards the rest
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	n   int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.n - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// diffManifests compares two manifests of the same directory
+func diffManifests(before, after map[string]file) (models.WorkspaceChanges, string) {
+	paths := make([]string, 0, len(after))
+	for path, f := range after {
+		if old, ok := before[path]; !ok || old.hash != f.hash || old.mode != f.mode {
+			paths = append(paths, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := models.WorkspaceChanges{Files: []models.FileChange{}}
+	var patch strings.Builder
+	for _, path := range paths {
+		old, hadOld := before[path]
+		cur, hasCur := after[path]
+
+		change := models.FileChange{Path: path, Status: models.FileModified}
+		fmt.Fprintf(&patch, "diff --git a/%s b/%s\n", path, path)
+		fromFile, toFile := "a/"+path, "b/"+path
+		switch {
+		case !hadOld:
+			change.Status = models.FileAdded
+			fromFile = "/dev/null"
+			fmt.Fprintf(&patch, "new file mode %s\n", gitMode(cur.mode))
+		case !hasCur:
+			change.Status = models.FileDeleted
+			toFile = "/dev/null"
+			fmt.Fprintf(&patch, "deleted file mode %s\n", gitMode(old.mode))
+		case old.mode != cur.mode:
+			fmt.Fprintf(&patch, "old mode %s\nnew mode %s\n", gitMode(old.mode), gitMode(cur.mode))
+		}
+
+		// Without both contents only the fact of the change is known
+		if old.binary || cur.binary || (hadOld && !old.kept) || (hasCur && !cur.kept) {
+			change.Binary = old.binary || cur.binary
+			switch {
+			case old.hash == cur.hash:
+			case change.Binary:
+				fmt.Fprintf(&patch, "Binary files %s and %s differ\n", fromFile, toFile)
+			default:
+				fmt.Fprintf(&patch, "Files %s and %s differ\n", fromFile, toFile)
+			}
+			changes.Files = append(changes.Files, change)
+			continue
+		}
+
+		diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(old.content),
+			B:        splitLines(cur.content),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		})
+		for i, line := range strings.SplitAfter(diff, "\n") {
+			if i < 2 {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(line, "+"):
+				change.Additions++
+			case strings.HasPrefix(line, "-"):
+				change.Deletions++
+			}
+		}
+		patch.WriteString(diff)
+		changes.Files = append(changes.Files, change)
+	}
+	return changes, patch.String()
+}
+
+// splitLines splits content into lines that each end with a newline
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+// gitMode formats a file mode the way git shows it in patches
+func gitMode(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "120000"
+	case mode&0o111 != 0:
+		return "100755"
+	default:
+		return "100644"
+	}
+}
+
+// truncate cuts patch to at most size bytes, ending at a line break
+func truncate(patch string, size int64) string {
+	patch = patch[:size]
+	if i := strings.LastIndexByte(patch, '\n'); i >= 0 {
+		return patch[:i+1]
+	}
+	return ""
+}
+
+// FilePatch returns the part of patch that changes path
+func FilePatch(patch, path string) string {
+	header := "diff --git a/" + path + " b/" + path + "\n"
+	start := strings.Index(patch, header)
+	if start < 0 || (start > 0 && patch[start-1] != '\n') {
+		return ""
+	}
+	end := strings.Index(patch[start+len(header):], "\ndiff --git ")
+	if end < 0 {
+		return patch[start:]
+	}
+	return patch[start : start+len(header)+end+1]
+}
+
+// git runs git in dir with extra environment variables and returns its
+// output
+func git(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,132 @@
+package workspace
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// write creates or replaces a file below dir
+func write(t *testing.T, dir, name, content string) {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+// byPath indexes changed files by their path
+func byPath(changes models.WorkspaceChanges) map[string]models.FileChange {
+	files := make(map[string]models.FileChange)
+	for _, f := range changes.Files {
+		files[f.Path] = f
+	}
+	return files
+}
+
+func TestCapturer_GitRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	run := func(args ...string) {
+		_, err := git(repo, nil, args...)
+		require.NoError(t, err)
+	}
+	run("init", "--quiet")
+	run("config", "user.name", "Test")
+	run("config", "user.email", "test@example.com")
+	write(t, repo, ".gitignore", "*.log\n")
+	write(t, repo, "app/main.go", "package main\n\nfunc main() {}\n")
+	write(t, repo, "app/old.go", "package main\n")
+	write(t, repo, "README.md", "hello\n")
+	run("add", ".")
+	run("commit", "--quiet", "-m", "initial")
+
+	// Uncommitted changes from before the execution are not its own
+	write(t, repo, "app/wip.go", "package main\n")
+
+	c := NewCapturer(0)
+	project := filepath.Join(repo, "app")
+	before, err := c.Snapshot(project)
+	require.NoError(t, err)
+
+	write(t, project, "main.go", "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n")
+	write(t, project, "new.go", "package main\n\nvar x = 1\n")
+	write(t, project, "debug.log", "ignored\n")
+	write(t, repo, "README.md", "outside the project\n")
+	require.NoError(t, os.Remove(filepath.Join(project, "old.go")))
+
+	changes, patch, err := c.Changes(before)
+	require.NoError(t, err)
+	files := byPath(changes)
+	require.Len(t, files, 3)
+	assert.Equal(t, models.FileChange{Path: "main.go", Status: models.FileModified, Additions: 3, Deletions: 1}, files["main.go"])
+	assert.Equal(t, models.FileChange{Path: "new.go", Status: models.FileAdded, Additions: 3}, files["new.go"])
+	assert.Equal(t, models.FileChange{Path: "old.go", Status: models.FileDeleted, Deletions: 1}, files["old.go"])
+	assert.Equal(t, 6, changes.Additions)
+	assert.Equal(t, 2, changes.Deletions)
+	assert.Contains(t, patch, "+\tprintln(\"hi\")\n")
+	assert.Contains(t, FilePatch(patch, "new.go"), "+var x = 1\n")
+	assert.NotContains(t, FilePatch(patch, "new.go"), "main.go")
+
+	// The repository's index is left alone
+	status, err := git(repo, nil, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.NotContains(t, status, "A ")
+}
+
+func TestCapturer_PlainDirectory(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, "notes.txt", "one\ntwo\nthree\n")
+	write(t, dir, "gone.txt", "bye\n")
+	write(t, dir, "image.bin", "\x00\x01\x02")
+
+	c := NewCapturer(0)
+	before, err := c.Snapshot(dir)
+	require.NoError(t, err)
+
+	// Nothing changed yet
+	changes, patch, err := c.Changes(before)
+	require.NoError(t, err)
+	assert.Empty(t, changes.Files)
+	assert.Empty(t, patch)
+
+	write(t, dir, "notes.txt", "one\n2\nthree\n")
+	write(t, dir, "sub/added.txt", "new")
+	write(t, dir, "image.bin", "\x00\x01\x03")
+	require.NoError(t, os.Remove(filepath.Join(dir, "gone.txt")))
+
+	changes, patch, err = c.Changes(before)
+	require.NoError(t, err)
+	files := byPath(changes)
+	require.Len(t, files, 4)
+	assert.Equal(t, models.FileChange{Path: "notes.txt", Status: models.FileModified, Additions: 1, Deletions: 1}, files["notes.txt"])
+	assert.Equal(t, models.FileChange{Path: "sub/added.txt", Status: models.FileAdded, Additions: 1}, files["sub/added.txt"])
+	assert.Equal(t, models.FileChange{Path: "gone.txt", Status: models.FileDeleted, Deletions: 1}, files["gone.txt"])
+	assert.True(t, files["image.bin"].Binary)
+
+	assert.Equal(t, "diff --git a/notes.txt b/notes.txt\n--- a/notes.txt\n+++ b/notes.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n",
+		FilePatch(patch, "notes.txt"))
+	assert.Contains(t, FilePatch(patch, "sub/added.txt"), "--- /dev/null\n+++ b/sub/added.txt\n")
+	assert.Contains(t, patch, "Binary files a/image.bin and b/image.bin differ\n")
+}
+
+func TestCapturer_TruncatesPatch(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCapturer(100)
+	before, err := c.Snapshot(dir)
+	require.NoError(t, err)
+
+	write(t, dir, "big.txt", strings.Repeat("a line of text\n", 50))
+	changes, patch, err := c.Changes(before)
+	require.NoError(t, err)
+	assert.True(t, changes.Truncated)
+	assert.LessOrEqual(t, len(patch), 100)
+	assert.True(t, strings.HasSuffix(patch, "\n"))
+	assert.Equal(t, 50, changes.Additions)
+}
@@ -0,0 +1,467 @@
+// Package worktree runs executions in git worktrees of their project. Each
+// worktree is checked out on a new branch in the project's data directory,
+// so several executions can change the same repository without touching its
+// working tree. A worktree stays until it is merged into the project's
+// current branch or discarded. The worktrees of each project are listed in a
+// file in its data directory.
+package worktree
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/boyd/pocket_agent/server/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// FileName is the name of the worktree list in a project's data directory
+	FileName = "worktrees.json"
+	// DirName is the directory of worktree checkouts in a project's data
+	// directory
+	DirName = "worktrees"
+	// BranchPrefix prefixes the names of generated branches
+	BranchPrefix = "pocket-agent/"
+)
+
+// Manager owns the worktrees of all projects
+type Manager struct {
+	dataDir      string
+	maxWorktrees int
+
+	mu        sync.Mutex
+	worktrees map[string][]models.Worktree
+	now       func() time.Time
+}
+
+// NewManager creates a manager keeping worktrees under dataDir. maxWorktrees
+// caps the worktrees per project; 0 is unlimited.
+func NewManager(dataDir string, maxWorktrees int) *Manager {
+	return &Manager{
+		dataDir:      dataDir,
+		maxWorktrees: maxWorktrees,
+		worktrees:    make(map[string][]models.Worktree),
+		now:          time.Now,
+	}
+}
+
+// Create checks out a new branch from opts.Base in a new worktree of the
+// repository at repo. The worktree starts out running.
+func (m *Manager) Create(projectID, repo string, opts models.WorktreeOptions, createdBy string) (models.Worktree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, err := m.load(projectID)
+	if err != nil {
+		return models.Worktree{}, err
+	}
+	if m.maxWorktrees > 0 && len(worktrees) >= m.maxWorktrees {
+		return models.Worktree{}, errors.NewResourceLimitError("worktrees", m.maxWorktrees, len(worktrees)).
+			WithDetail("project_id", projectID)
+	}
+
+	// The project may be a subdirectory of its repository
+	if _, err := git(repo, "rev-parse", "--show-toplevel"); err != nil {
+		return models.Worktree{}, errors.New(errors.CodeValidationFailed, "project is not a git repository").
+			WithDetail("project_id", projectID)
+	}
+	prefix, err := git(repo, "rev-parse", "--show-prefix")
+	if err != nil {
+		return models.Worktree{}, errors.Wrap(err, errors.CodeInternalError, "failed to locate project in its repository")
+	}
+
+	base := opts.Base
+	if base == "" {
+		base = "HEAD"
+	}
+	if strings.HasPrefix(base, "-") {
+		return models.Worktree{}, errors.NewValidationError("invalid worktree base: %s", base)
+	}
+	commit, err := git(repo, "rev-parse", "--verify", "--quiet", base+"^{commit}")
+	if err != nil {
+		return models.Worktree{}, errors.NewValidationError("unknown worktree base: %s", base)
+	}
+
+	id := uuid.New().String()
+	branch := opts.Branch
+	if branch == "" {
+		branch = BranchPrefix + id[:8]
+	}
+	if err := checkBranch(repo, branch); err != nil {
+		return models.Worktree{}, err
+	}
+
+	path, err := m.checkout(projectID, id)
+	if err != nil {
+		return models.Worktree{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return models.Worktree{}, errors.NewFileOperationError("create worktree directory", err)
+	}
+	if _, err := git(repo, "worktree", "add", "-b", branch, path, commit); err != nil {
+		return models.Worktree{}, errors.NewFileOperationError("create worktree", err)
+	}
+
+	wt := models.Worktree{
+		ID:         id,
+		Branch:     branch,
+		Base:       base,
+		BaseCommit: commit,
+		Path:       path,
+		Dir:        filepath.Join(path, prefix),
+		Status:     models.WorktreeRunning,
+		CreatedBy:  createdBy,
+		CreatedAt:  m.now().UTC(),
+	}
+	if err := m.save(projectID, append(worktrees, wt)); err != nil {
+		m.remove(repo, wt)
+		return models.Worktree{}, err
+	}
+	return wt, nil
+}
+
+// Attach records the execution that runs in a worktree
+func (m *Manager) Attach(projectID, id, executionID string) error {
+	return m.update(projectID, id, func(wt *models.Worktree) {
+		wt.ExecutionID = executionID
+	})
+}
+
+// Release marks a worktree's execution as ended
+func (m *Manager) Release(projectID, id string) error {
+	return m.update(projectID, id, func(wt *models.Worktree) {
+		wt.Status = models.WorktreeReady
+	})
+}
+
+// Get returns a worktree of a project
+func (m *Manager) Get(projectID, id string) (models.Worktree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, err := m.load(projectID)
+	if err != nil {
+		return models.Worktree{}, err
+	}
+	index := indexOf(worktrees, id)
+	if index < 0 {
+		return models.Worktree{}, notFound(projectID, id)
+	}
+	return worktrees[index], nil
+}
+
+// List returns a project's worktrees, oldest first
+func (m *Manager) List(projectID string) ([]models.Worktree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]models.Worktree{}, worktrees...), nil
+}
+
+// Merge commits what a worktree's execution left uncommitted and merges its
+// branch into the current branch of the repository at repo. The worktree
+// and its branch are removed afterwards. A merge that fails, for example on
+// a conflict, is aborted and leaves the worktree in place.
+func (m *Manager) Merge(projectID, id, repo string) (models.Worktree, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, index, err := m.idle(projectID, id)
+	if err != nil {
+		return models.Worktree{}, "", err
+	}
+	wt := worktrees[index]
+
+	if err := commitAll(wt.Path, fmt.Sprintf("Changes of execution %s", wt.ExecutionID)); err != nil {
+		return models.Worktree{}, "", errors.NewFileOperationError("commit worktree changes", err)
+	}
+
+	args := append(identity(repo), "merge", "--no-ff", "--no-edit", wt.Branch)
+	if output, err := git(repo, args...); err != nil {
+		git(repo, "merge", "--abort")
+		return models.Worktree{}, "", errors.New(errors.CodeWorktreeMergeFailed, "failed to merge worktree branch %s", wt.Branch).
+			WithDetail("project_id", projectID).
+			WithDetail("worktree_id", id).
+			WithDetail("output", output)
+	}
+	commit, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		return models.Worktree{}, "", errors.Wrap(err, errors.CodeInternalError, "failed to read merge commit")
+	}
+
+	remaining := append(append([]models.Worktree{}, worktrees[:index]...), worktrees[index+1:]...)
+	if err := m.save(projectID, remaining); err != nil {
+		return models.Worktree{}, "", err
+	}
+	m.remove(repo, wt)
+	return wt, commit, nil
+}
+
+// Discard removes a worktree and its branch with all their changes
+func (m *Manager) Discard(projectID, id, repo string) (models.Worktree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, index, err := m.idle(projectID, id)
+	if err != nil {
+		return models.Worktree{}, err
+	}
+	wt := worktrees[index]
+
+	remaining := append(append([]models.Worktree{}, worktrees[:index]...), worktrees[index+1:]...)
+	if err := m.save(projectID, remaining); err != nil {
+		return models.Worktree{}, err
+	}
+	m.remove(repo, wt)
+	return wt, nil
+}
+
+// Cleanup reconciles a project's worktrees with the disk on startup, when
+// no execution of a previous server run is tracked. Worktrees whose
+// execution was interrupted are kept for merging or discarding. Listed
+// worktrees whose checkout is gone are dropped, and checkouts that are not
+// listed are removed. It returns the dropped and removed worktrees.
+func (m *Manager) Cleanup(projectID, repo string) ([]models.Worktree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, err := m.load(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept, stale []models.Worktree
+	listed := make(map[string]bool)
+	for _, wt := range worktrees {
+		if _, err := os.Stat(wt.Path); err != nil {
+			stale = append(stale, wt)
+			continue
+		}
+		wt.Status = models.WorktreeReady
+		kept = append(kept, wt)
+		listed[wt.ID] = true
+	}
+
+	// Checkouts of creations a crash cut short
+	dir, err := m.checkout(projectID, "")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.NewFileOperationError("list worktrees", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || listed[entry.Name()] {
+			continue
+		}
+		wt := models.Worktree{ID: entry.Name(), Path: filepath.Join(dir, entry.Name())}
+		m.remove(repo, wt)
+		stale = append(stale, wt)
+	}
+
+	// Let git forget checkouts that no longer exist
+	git(repo, "worktree", "prune")
+
+	if len(stale) > 0 || len(kept) > 0 {
+		if err := m.save(projectID, kept); err != nil {
+			return stale, err
+		}
+	}
+	return stale, nil
+}
+
+// Forget drops the cached worktrees of a deleted project. Their checkouts
+// are removed with the project's data directory; their branches are kept.
+func (m *Manager) Forget(projectID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.worktrees, projectID)
+}
+
+// update changes a worktree and persists the change
+func (m *Manager) update(projectID, id string, change func(*models.Worktree)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worktrees, err := m.load(projectID)
+	if err != nil {
+		return err
+	}
+	index := indexOf(worktrees, id)
+	if index < 0 {
+		return notFound(projectID, id)
+	}
+
+	updated := append([]models.Worktree{}, worktrees...)
+	change(&updated[index])
+	return m.save(projectID, updated)
+}
+
+// idle returns a project's worktrees and the index of one whose execution
+// has ended. Callers hold m.mu.
+func (m *Manager) idle(projectID, id string) ([]models.Worktree, int, error) {
+	worktrees, err := m.load(projectID)
+	if err != nil {
+		return nil, 0, err
+	}
+	index := indexOf(worktrees, id)
+	if index < 0 {
+		return nil, 0, notFound(projectID, id)
+	}
+	if worktrees[index].Status == models.WorktreeRunning {
+		return nil, 0, errors.New(errors.CodeProcessActive, "worktree execution is still running").
+			WithDetail("project_id", projectID).
+			WithDetail("worktree_id", id)
+	}
+	return worktrees, index, nil
+}
+
+// remove deletes a worktree's checkout and branch. Failures are ignored;
+// git forgets missing checkouts with the next prune.
+func (m *Manager) remove(repo string, wt models.Worktree) {
+	if _, err := git(repo, "worktree", "remove", "--force", wt.Path); err != nil {
+		os.RemoveAll(wt.Path)
+		git(repo, "worktree", "prune")
+	}
+	if wt.Branch != "" {
+		git(repo, "branch", "-D", wt.Branch)
+	}
+}
+
+// checkout returns the absolute checkout directory of a project's worktree;
+// git resolves relative paths against the repository
+func (m *Manager) checkout(projectID, id string) (string, error) {
+	dir, err := filepath.Abs(filepath.Join(m.dataDir, storage.ProjectsDirName, projectID, DirName, id))
+	if err != nil {
+		return "", errors.NewFileOperationError("resolve worktree directory", err)
+	}
+	return dir, nil
+}
+
+// path returns the worktree list of a project
+func (m *Manager) path(projectID string) string {
+	return filepath.Join(m.dataDir, storage.ProjectsDirName, projectID, FileName)
+}
+
+// load returns the project's worktrees, reading them from disk on first
+// use. Callers hold m.mu.
+func (m *Manager) load(projectID string) ([]models.Worktree, error) {
+	if worktrees, ok := m.worktrees[projectID]; ok {
+		return worktrees, nil
+	}
+
+	var worktrees []models.Worktree
+	data, err := os.ReadFile(m.path(projectID))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.NewFileOperationError("read worktrees", err)
+	default:
+		if err := json.Unmarshal(data, &worktrees); err != nil {
+			return nil, errors.NewJSONParsingError(err)
+		}
+	}
+
+	m.worktrees[projectID] = worktrees
+	return worktrees, nil
+}
+
+// save persists the project's worktrees. Callers hold m.mu.
+func (m *Manager) save(projectID string, worktrees []models.Worktree) error {
+	if worktrees == nil {
+		worktrees = []models.Worktree{}
+	}
+
+	data, err := json.MarshalIndent(worktrees, "", "  ")
+	if err != nil {
+		return errors.NewJSONParsingError(err)
+	}
+
+	path := m.path(projectID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.NewFileOperationError("create worktree directory", err)
+	}
+	if err := storage.WriteFileAtomic(path, data, 0o600); err != nil {
+		return errors.NewFileOperationError("write worktrees", err)
+	}
+
+	m.worktrees[projectID] = worktrees
+	return nil
+}
+
+// checkBranch fails unless name is a valid name for a new branch
+func checkBranch(repo, name string) error {
+	if strings.HasPrefix(name, "-") {
+		return errors.NewValidationError("invalid branch name: %s", name)
+	}
+	if _, err := git(repo, "check-ref-format", "--branch", name); err != nil {
+		return errors.NewValidationError("invalid branch name: %s", name)
+	}
+	if _, err := git(repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+		return errors.NewValidationError("branch already exists: %s", name)
+	}
+	return nil
+}
+
+// commitAll commits all changes in dir, if there are any
+func commitAll(dir, message string) error {
+	if _, err := git(dir, "add", "--all"); err != nil {
+		return err
+	}
+	if _, err := git(dir, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	args := append(identity(dir), "commit", "--no-verify", "-m", message)
+	_, err := git(dir, args...)
+	return err
+}
+
+// identity supplies a committer for repositories that have none configured
+func identity(dir string) []string {
+	if email, err := git(dir, "config", "user.email"); err == nil && email != "" {
+		return nil
+	}
+	return []string{"-c", "user.name=Pocket Agent", "-c", "user.email=pocket-agent@localhost"}
+}
+
+// git runs git in dir and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if err != nil {
+		return output, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, output)
+	}
+	return output, nil
+}
+
+// indexOf returns the index of the worktree with id, or -1
+func indexOf(worktrees []models.Worktree, id string) int {
+	for i, wt := range worktrees {
+		if wt.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// notFound reports an unknown worktree
+func notFound(projectID, id string) *errors.AppError {
+	return errors.New(errors.CodeWorktreeNotFound, "worktree not found").
+		WithDetail("project_id", projectID).
+		WithDetail("worktree_id", id)
+}
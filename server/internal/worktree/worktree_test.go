@@ -0,0 +1,184 @@
+package worktree
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/boyd/pocket_agent/server/internal/errors"
+	"github.com/boyd/pocket_agent/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRepo creates a git repository with one commit
+func newRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	run := func(args ...string) {
+		_, err := git(repo, args...)
+		require.NoError(t, err)
+	}
+	run("init", "--quiet", "--initial-branch=main")
+	run("config", "user.name", "Test")
+	run("config", "user.email", "test@example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "README.md"), []byte("hello\n"), 0o644))
+	run("add", "README.md")
+	run("commit", "--quiet", "-m", "initial")
+	return repo
+}
+
+func TestManager_CreateAndMerge(t *testing.T) {
+	repo := newRepo(t)
+	m := NewManager(t.TempDir(), 0)
+
+	wt, err := m.Create("p1", repo, models.WorktreeOptions{}, "device:phone")
+	require.NoError(t, err)
+	assert.Equal(t, models.WorktreeRunning, wt.Status)
+	assert.Equal(t, "HEAD", wt.Base)
+	assert.Contains(t, wt.Branch, BranchPrefix)
+	assert.Equal(t, wt.Path, wt.Dir)
+	assert.FileExists(t, filepath.Join(wt.Dir, "README.md"))
+	require.NoError(t, m.Attach("p1", wt.ID, "exec-1"))
+
+	// The execution's changes stay out of the project's working tree
+	require.NoError(t, os.WriteFile(filepath.Join(wt.Dir, "feature.txt"), []byte("feature\n"), 0o644))
+	assert.NoFileExists(t, filepath.Join(repo, "feature.txt"))
+
+	// Running worktrees can be neither merged nor discarded
+	_, _, err = m.Merge("p1", wt.ID, repo)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessActive))
+	_, err = m.Discard("p1", wt.ID, repo)
+	assert.True(t, errors.IsCode(err, errors.CodeProcessActive))
+
+	require.NoError(t, m.Release("p1", wt.ID))
+	merged, commit, err := m.Merge("p1", wt.ID, repo)
+	require.NoError(t, err)
+	assert.Equal(t, "exec-1", merged.ExecutionID)
+	assert.NotEmpty(t, commit)
+
+	// The changes are merged and the worktree and its branch are gone
+	assert.FileExists(t, filepath.Join(repo, "feature.txt"))
+	assert.NoDirExists(t, wt.Path)
+	_, err = git(repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+wt.Branch)
+	assert.Error(t, err)
+
+	worktrees, err := m.List("p1")
+	require.NoError(t, err)
+	assert.Empty(t, worktrees)
+}
+
+func TestManager_MergeConflict(t *testing.T) {
+	repo := newRepo(t)
+	m := NewManager(t.TempDir(), 0)
+
+	wt, err := m.Create("p1", repo, models.WorktreeOptions{Branch: "experiment"}, "device:phone")
+	require.NoError(t, err)
+	require.NoError(t, m.Release("p1", wt.ID))
+	assert.Equal(t, "experiment", wt.Branch)
+
+	// Both sides change the same line
+	require.NoError(t, os.WriteFile(filepath.Join(wt.Dir, "README.md"), []byte("worktree\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "README.md"), []byte("project\n"), 0o644))
+	_, err = git(repo, "commit", "--quiet", "-am", "project change")
+	require.NoError(t, err)
+
+	_, _, err = m.Merge("p1", wt.ID, repo)
+	assert.True(t, errors.IsCode(err, errors.CodeWorktreeMergeFailed))
+
+	// The merge is aborted and the worktree kept
+	status, err := git(repo, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.Empty(t, status)
+	assert.DirExists(t, wt.Path)
+
+	discarded, err := m.Discard("p1", wt.ID, repo)
+	require.NoError(t, err)
+	assert.Equal(t, wt.ID, discarded.ID)
+	assert.NoDirExists(t, wt.Path)
+	_, err = git(repo, "rev-parse", "--verify", "--quiet", "refs/heads/experiment")
+	assert.Error(t, err)
+
+	_, err = m.Discard("p1", wt.ID, repo)
+	assert.True(t, errors.IsCode(err, errors.CodeWorktreeNotFound))
+}
+
+func TestManager_CreateValidation(t *testing.T) {
+	repo := newRepo(t)
+	m := NewManager(t.TempDir(), 1)
+
+	_, err := m.Create("p1", t.TempDir(), models.WorktreeOptions{}, "")
+	assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), "not a repository")
+
+	for name, opts := range map[string]models.WorktreeOptions{
+		"unknown base":   {Base: "no-such-branch"},
+		"option base":    {Base: "--all"},
+		"invalid branch": {Branch: "bad..name"},
+		"option branch":  {Branch: "-b"},
+		"existing":       {Branch: "main"},
+	} {
+		_, err := m.Create("p1", repo, opts, "")
+		assert.True(t, errors.IsCode(err, errors.CodeValidationFailed), name)
+	}
+
+	_, err = m.Create("p1", repo, models.WorktreeOptions{Base: "main"}, "")
+	require.NoError(t, err)
+	_, err = m.Create("p1", repo, models.WorktreeOptions{}, "")
+	assert.True(t, errors.IsCode(err, errors.CodeResourceLimit))
+}
+
+func TestManager_Subdirectory(t *testing.T) {
+	repo := newRepo(t)
+	project := filepath.Join(repo, "app")
+	require.NoError(t, os.MkdirAll(project, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(project, "main.go"), []byte("package main\n"), 0o644))
+	_, err := git(repo, "add", "app")
+	require.NoError(t, err)
+	_, err = git(repo, "commit", "--quiet", "-m", "app")
+	require.NoError(t, err)
+
+	wt, err := NewManager(t.TempDir(), 0).Create("p1", project, models.WorktreeOptions{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(wt.Path, "app"), wt.Dir)
+	assert.FileExists(t, filepath.Join(wt.Dir, "main.go"))
+}
+
+func TestManager_Cleanup(t *testing.T) {
+	repo := newRepo(t)
+	dataDir := t.TempDir()
+	m := NewManager(dataDir, 0)
+
+	interrupted, err := m.Create("p1", repo, models.WorktreeOptions{}, "")
+	require.NoError(t, err)
+	vanished, err := m.Create("p1", repo, models.WorktreeOptions{}, "")
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(vanished.Path))
+
+	// A checkout that never made it into the list
+	orphan, err := m.checkout("p1", "orphan")
+	require.NoError(t, err)
+	_, err = git(repo, "worktree", "add", "--detach", orphan)
+	require.NoError(t, err)
+
+	// A restarted server reconciles the list with the disk
+	restarted := NewManager(dataDir, 0)
+	stale, err := restarted.Cleanup("p1", repo)
+	require.NoError(t, err)
+	assert.Len(t, stale, 2)
+	assert.NoDirExists(t, orphan)
+
+	worktrees, err := restarted.List("p1")
+	require.NoError(t, err)
+	require.Len(t, worktrees, 1)
+	assert.Equal(t, interrupted.ID, worktrees[0].ID)
+	assert.Equal(t, models.WorktreeReady, worktrees[0].Status)
+
+	list, err := git(repo, "worktree", "list", "--porcelain")
+	require.NoError(t, err)
+	assert.NotContains(t, list, vanished.Path)
+	assert.NotContains(t, list, orphan)
+}
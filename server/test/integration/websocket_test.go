@@ -299,7 +299,7 @@ func TestClaudeExecution(t *testing.T) {
 				Port:    0,
 				Execution: config.ExecutionConfig{
 					ClaudeBinaryPath: claudePath,
-					CommandTimeout:   config.Duration{timeout},
+					CommandTimeout:   config.Duration{Duration: timeout},
 					MaxProjects:      100,
 				},
 			}
@@ -835,7 +835,7 @@ func createTestServer(t *testing.T, dataDir, claudePath string) http.Handler {
 		Port:    0,
 		Execution: config.ExecutionConfig{
 			ClaudeBinaryPath: claudePath,
-			CommandTimeout:   config.Duration{5 * time.Second},
+			CommandTimeout:   config.Duration{Duration: 5 * time.Second},
 			MaxProjects:      100,
 		},
 	}
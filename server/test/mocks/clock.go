@@ -0,0 +1,78 @@
+package mocks
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a clock for tests that only moves when advanced. It stands in
+// for the executor's clock, so timeouts pass without waiting for them.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*clockTimer
+}
+
+// clockTimer is a function waiting for the clock to reach its time
+type clockTimer struct {
+	at   time.Time
+	f    func()
+	done bool
+}
+
+// NewClock creates a clock that reads now until advanced
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f once the clock advanced by d. The returned function
+// cancels the call, reporting whether it was still pending.
+func (c *Clock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &clockTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		pending := !timer.done
+		timer.done = true
+		return pending
+	}
+}
+
+// Advance moves the clock forward by d and calls the functions that became
+// due, earliest first, before it returns
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*clockTimer
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		switch {
+		case timer.done:
+		case timer.at.After(c.now):
+			pending = append(pending, timer)
+		default:
+			timer.done = true
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, timer := range due {
+		timer.f()
+	}
+}
@@ -0,0 +1,20 @@
+package mocks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// WriteClaudeScript writes a shell script standing in for the Claude CLI and
+// returns its path. The script runs body, which reads the prompt from stdin
+// and answers with stream-json on stdout, the way the CLI does.
+func WriteClaudeScript(t testing.TB, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("Failed to write mock Claude script: %v", err)
+	}
+	return path
+}